@@ -0,0 +1,334 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"zikichombo.org/sio"
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// entryInfo describes an entry for the entries and devices commands.
+type entryInfo struct {
+	Name       string
+	Default    bool `json:",omitempty"`
+	Available  bool
+	HasDevices bool         `json:",omitempty"`
+	Devices    []*devInfo   `json:",omitempty"`
+	Probe      []*probeInfo `json:",omitempty"` // entries without devices
+	Err        string       `json:",omitempty"`
+}
+
+// devInfo describes the capabilities of a libsio.Dev, with rates in Hz.
+type devInfo struct {
+	Id           uint64
+	UID          string `json:",omitempty"`
+	Name         string
+	In           *capsInfo `json:",omitempty"`
+	Out          *capsInfo `json:",omitempty"`
+	IsDefaultIn  bool
+	IsDefaultOut bool
+	IsDefaultSys bool
+	Probe        []*probeInfo `json:",omitempty"`
+	Err          string       `json:",omitempty"`
+}
+
+// capsInfo describes the capabilities of one direction of a device, with
+// rates in Hz.
+type capsInfo struct {
+	MinChannels int
+	MaxChannels int
+	Layouts     [][]string   `json:",omitempty"`
+	Rates       []float64    `json:",omitempty"`
+	RateRanges  [][2]float64 `json:",omitempty"`
+	Codecs      []string
+	MinPeriod   int           `json:",omitempty"`
+	MaxPeriod   int           `json:",omitempty"`
+	MinLatency  time.Duration `json:",omitempty"`
+	MaxLatency  time.Duration `json:",omitempty"`
+}
+
+// probeInfo is the result of opening and closing a stream.
+type probeInfo struct {
+	Kind     string
+	Codec    string
+	Channels int
+	Rate     float64
+	Err      string `json:",omitempty"`
+}
+
+func entries(args []string, w io.Writer) error {
+	fs := flags("entries", "")
+	js := fs.Bool("json", false, "write JSON")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	var res []*entryInfo
+	def := true
+	for _, name := range sio.EntryNames() {
+		ei := &entryInfo{Name: name}
+		if _, err := sio.ConnectTo(name, nil); err == nil {
+			ei.Available = true
+			ei.Default = def
+			def = false
+			sio.Disconnect()
+		}
+		res = append(res, ei)
+	}
+	if *js {
+		return writeJSON(w, res)
+	}
+	for _, ei := range res {
+		var notes []string
+		if !ei.Available {
+			notes = append(notes, "unavailable")
+		}
+		if ei.Default {
+			notes = append(notes, "default")
+		}
+		if len(notes) == 0 {
+			fmt.Fprintf(w, "%s\n", ei.Name)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t(%s)\n", ei.Name, strings.Join(notes, ", "))
+	}
+	return nil
+}
+
+func devices(args []string, w io.Writer) error {
+	fs := flags("devices", "")
+	name := fs.String("entry", "", "entry `name`")
+	js := fs.Bool("json", false, "write JSON")
+	probe := fs.Bool("probe", false, "open and close a source and a sink of each device with each sample codec")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	e, err := connect(*name)
+	if err != nil {
+		return err
+	}
+	defer sio.Disconnect()
+	ei := scan(e, *probe)
+	if *js {
+		return writeJSON(w, ei)
+	}
+	writeEntry(w, ei)
+	return nil
+}
+
+// scan returns the devices of e, probing them if probe is true.
+func scan(e host.Entry, probe bool) *entryInfo {
+	ei := &entryInfo{Name: e.Name(), Available: true, HasDevices: e.HasDevices()}
+	if !ei.HasDevices {
+		if probe {
+			ei.Probe = probeDev(e, nil)
+		}
+		return ei
+	}
+	res, err := e.ScanDevices()
+	if err != nil {
+		ei.Err = err.Error()
+		return ei
+	}
+	for _, r := range res {
+		if r.Dev == nil {
+			di := &devInfo{}
+			if r.E != nil {
+				di.Err = r.E.Error()
+			}
+			ei.Devices = append(ei.Devices, di)
+			continue
+		}
+		di := toInfo(r.Dev)
+		if r.E != nil {
+			di.Err = r.E.Error()
+		} else if probe {
+			di.Probe = probeDev(e, r.Dev)
+		}
+		ei.Devices = append(ei.Devices, di)
+	}
+	return ei
+}
+
+func toInfo(d *libsio.Dev) *devInfo {
+	di := &devInfo{
+		Id:           d.Id,
+		UID:          d.UID,
+		Name:         d.Name,
+		In:           toCapsInfo(&d.In),
+		Out:          toCapsInfo(&d.Out),
+		IsDefaultIn:  d.IsDefaultIn,
+		IsDefaultOut: d.IsDefaultOut,
+		IsDefaultSys: d.IsDefaultSys}
+	return di
+}
+
+// toCapsInfo returns nil if c describes an unsupported direction.
+func toCapsInfo(c *libsio.Caps) *capsInfo {
+	if !c.Supported() {
+		return nil
+	}
+	ci := &capsInfo{
+		MinChannels: c.MinChannels,
+		MaxChannels: c.MaxChannels,
+		Codecs:      []string{},
+		MinPeriod:   c.MinPeriod,
+		MaxPeriod:   c.MaxPeriod,
+		MinLatency:  c.MinLatency,
+		MaxLatency:  c.MaxLatency}
+	for _, l := range c.Layouts {
+		ci.Layouts = append(ci.Layouts, []string(l))
+	}
+	for _, r := range c.Rates {
+		ci.Rates = append(ci.Rates, r.Float64())
+	}
+	for _, r := range c.RateRanges {
+		ci.RateRanges = append(ci.RateRanges, [2]float64{r.Min.Float64(), r.Max.Float64()})
+	}
+	for _, co := range c.Codecs {
+		ci.Codecs = append(ci.Codecs, co.String())
+	}
+	return ci
+}
+
+// probeDev opens and closes sources and sinks on d, which is nil for
+// entries without devices, with each sample codec of the direction.  The
+// form is the supported form closest to the default form of e.
+func probeDev(e host.Entry, d *libsio.Dev) []*probeInfo {
+	b := e.DefaultBufSize()
+	var res []*probeInfo
+	try := func(kind string, c *libsio.Caps, open func(sound.Form, sample.Codec) (io.Closer, error)) {
+		cos := c.Codecs
+		if len(cos) == 0 {
+			cos = []sample.Codec{e.DefaultSampleCodec()}
+		}
+		for _, co := range cos {
+			v, _, err := c.Closest(e.DefaultForm(), co)
+			if err != nil {
+				v = e.DefaultForm()
+			}
+			p := &probeInfo{Kind: kind, Codec: co.String(), Channels: v.Channels(), Rate: v.SampleRate().Float64()}
+			if err == nil {
+				var cl io.Closer
+				cl, err = open(v, co)
+				if err == nil {
+					err = cl.Close()
+				}
+			}
+			if err != nil {
+				p.Err = err.Error()
+			}
+			res = append(res, p)
+		}
+	}
+	in, out := &libsio.Caps{}, &libsio.Caps{}
+	if d != nil {
+		in, out = &d.In, &d.Out
+	}
+	if e.CanOpenSource() && (d == nil || in.Supported()) {
+		try("source", in, func(v sound.Form, co sample.Codec) (io.Closer, error) {
+			src, _, err := e.OpenSource(d, v, co, b)
+			return src, err
+		})
+	}
+	if e.CanOpenSink() && (d == nil || out.Supported()) {
+		try("sink", out, func(v sound.Form, co sample.Codec) (io.Closer, error) {
+			snk, _, err := e.OpenSink(d, v, co, b)
+			return snk, err
+		})
+	}
+	return res
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+func writeEntry(w io.Writer, ei *entryInfo) {
+	fmt.Fprintf(w, "entry %s\n", ei.Name)
+	if ei.Err != "" {
+		fmt.Fprintf(w, "\terror: %s\n", ei.Err)
+		return
+	}
+	if !ei.HasDevices {
+		fmt.Fprintf(w, "\tno devices\n")
+		writeProbe(w, "\t", ei.Probe)
+		return
+	}
+	for _, di := range ei.Devices {
+		if di.Name == "" && di.Err != "" {
+			fmt.Fprintf(w, "\terror: %s\n", di.Err)
+			continue
+		}
+		fmt.Fprintf(w, "\t%q\n", di.Name)
+		if di.UID != "" && di.UID != di.Name {
+			fmt.Fprintf(w, "\t\tuid: %s\n", di.UID)
+		}
+		writeCaps(w, "in", di.In)
+		writeCaps(w, "out", di.Out)
+		var defs []string
+		if di.IsDefaultIn {
+			defs = append(defs, "in")
+		}
+		if di.IsDefaultOut {
+			defs = append(defs, "out")
+		}
+		if di.IsDefaultSys {
+			defs = append(defs, "system")
+		}
+		if len(defs) > 0 {
+			fmt.Fprintf(w, "\t\tdefault: %s\n", strings.Join(defs, ", "))
+		}
+		if di.Err != "" {
+			fmt.Fprintf(w, "\t\terror: %s\n", di.Err)
+		}
+		writeProbe(w, "\t\t", di.Probe)
+	}
+}
+
+func writeCaps(w io.Writer, dir string, ci *capsInfo) {
+	if ci == nil {
+		fmt.Fprintf(w, "\t\t%s: none\n", dir)
+		return
+	}
+	var rates []string
+	for _, r := range ci.Rates {
+		rates = append(rates, fmt.Sprintf("%g", r))
+	}
+	for _, r := range ci.RateRanges {
+		rates = append(rates, fmt.Sprintf("%g-%g", r[0], r[1]))
+	}
+	fmt.Fprintf(w, "\t\t%s: %d-%d channels, rates %s Hz\n", dir, ci.MinChannels, ci.MaxChannels, strings.Join(rates, " "))
+	fmt.Fprintf(w, "\t\t\tcodecs: %s\n", strings.Join(ci.Codecs, " "))
+	if ci.MaxPeriod > 0 {
+		fmt.Fprintf(w, "\t\t\tperiod: %d-%d frames\n", ci.MinPeriod, ci.MaxPeriod)
+	}
+	if ci.MaxLatency > 0 {
+		fmt.Fprintf(w, "\t\t\tlatency: %s-%s\n", ci.MinLatency, ci.MaxLatency)
+	}
+}
+
+func writeProbe(w io.Writer, indent string, ps []*probeInfo) {
+	for _, p := range ps {
+		res := "ok"
+		if p.Err != "" {
+			res = p.Err
+		}
+		fmt.Fprintf(w, "%sprobe %s %s %dch %gHz: %s\n", indent, p.Kind, p.Codec, p.Channels, p.Rate, res)
+	}
+}
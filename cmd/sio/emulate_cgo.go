@@ -0,0 +1,40 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build cgo
+
+package main
+
+import (
+	"time"
+
+	"zikichombo.org/sio/soak"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// soakEmulated soaks a stream of emulated callbacks lasting cfg.Duration.
+func soakEmulated(v sound.Form, co sample.Codec, b int, input bool, jitter time.Duration, cfg *soak.Config) (*soak.Report, error) {
+	d := cfg.Duration
+	if d <= 0 {
+		d = 24 * time.Hour
+	}
+	// the run ends with the emulation.
+	c := *cfg
+	c.Duration = 0
+	cb, errC := soak.Emulate(v, co, b, input, d, jitter)
+	var rep *soak.Report
+	var err error
+	if input {
+		rep, err = soak.RunSource(cb, &c)
+	} else {
+		rep, err = soak.RunSink(cb, &c)
+	}
+	if err != nil {
+		// the callback thread can't be stopped, so cb is leaked.
+		return rep, err
+	}
+	err = <-errC
+	cb.Close()
+	return rep, err
+}
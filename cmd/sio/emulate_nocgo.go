@@ -0,0 +1,19 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build !cgo
+
+package main
+
+import (
+	"errors"
+	"time"
+
+	"zikichombo.org/sio/soak"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+func soakEmulated(v sound.Form, co sample.Codec, b int, input bool, jitter time.Duration, cfg *soak.Config) (*soak.Report, error) {
+	return nil, errors.New("callback emulation needs cgo")
+}
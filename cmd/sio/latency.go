@@ -0,0 +1,86 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"zikichombo.org/sio"
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/latency"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+)
+
+func measureLatency(args []string, w io.Writer) error {
+	fs := flags("latency", "")
+	name := fs.String("entry", "", "entry `name`")
+	in := fs.String("in", "", "input device `name`")
+	out := fs.String("out", "", "output device `name`")
+	rate := fs.Int("rate", 0, "sample rate (default of the entry)")
+	channels := fs.Int("channels", 0, "channels (default of the entry)")
+	var co codecFlag
+	fs.Var(&co, "codec", "sample codec (default of the entry)")
+	b := fs.Int("b", 0, "buffer size in frames (default of the entry)")
+	sig := fs.String("signal", "chirp", "test signal, chirp or mls")
+	var cfg latency.Config
+	fs.IntVar(&cfg.Len, "len", 4096, "test signal length in frames")
+	fs.IntVar(&cfg.Runs, "runs", 10, "number of runs")
+	fs.DurationVar(&cfg.MaxLatency, "max", 500*time.Millisecond, "maximum latency")
+	fs.IntVar(&cfg.InChannel, "inchannel", 0, "input channel captured")
+	loop := fs.Int("loopback", -1, "measure an in-process loopback with this delay in `frames` instead of an entry")
+	js := fs.Bool("json", false, "write JSON")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	var err error
+	if cfg.Signal, err = latency.ParseSignal(*sig); err != nil {
+		return err
+	}
+	var e host.Entry
+	if *loop >= 0 {
+		e = latency.NewLoopback(*loop)
+	} else {
+		if e, err = connect(*name); err != nil {
+			return err
+		}
+		defer sio.Disconnect()
+	}
+	ind, err := findDev(e, *in, e.DefaultInputDev)
+	if err != nil {
+		return err
+	}
+	outd, err := findDev(e, *out, e.DefaultOutputDev)
+	if err != nil {
+		return err
+	}
+	v := e.DefaultForm()
+	r, c := v.SampleRate(), v.Channels()
+	if *rate > 0 {
+		r = freq.T(*rate) * freq.Hertz
+	}
+	if *channels > 0 {
+		c = *channels
+	}
+	if *b <= 0 {
+		*b = e.DefaultBufSize()
+	}
+	res, err := latency.Run(e, ind, outd, sound.NewForm(r, c), co.get(e.DefaultSampleCodec()), *b, &cfg)
+	if err != nil && err != latency.ErrNotFound {
+		return err
+	}
+	if *js {
+		if werr := writeJSON(w, res); werr != nil {
+			return werr
+		}
+		return err
+	}
+	for i, l := range res.Latencies {
+		fmt.Fprintf(w, "run %d: %v\n", i, l)
+	}
+	fmt.Fprintln(w, res)
+	return err
+}
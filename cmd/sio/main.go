@@ -0,0 +1,218 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Command sio lists the entries and devices of the host, and plays and
+// records sound with them.
+//
+// Usage:
+//
+//	sio entries [-json]
+//	sio devices [-entry name] [-json] [-probe]
+//	sio play [-entry name] [-dev name | -follow] [-codec c] [-b frames] [-dither shape] [-guard mode] file
+//	sio record [-entry name] [-dev name | -follow] [-rate hz] [-channels n] [-codec c] [-b frames] [-d duration] file
+//	sio latency [-entry name] [-in name] [-out name] [-signal chirp|mls] [-runs n] [-loopback frames]
+//	sio soak [-entry name] [-dev name] [-play] [-d duration] [-cpu n] [-alloc bytes] [-goroutines n] [-emulate] [-jitter d]
+//	sio serve [-entry name] addr
+//
+// Without -entry, the first entry of the host which connects is used.
+// Devices are selected by UID, name or name pattern, see host.FindDev, and
+// the default device of the entry is used without -dev.  With -follow,
+// play and record follow the default device when it changes, see package
+// zikichombo.org/sio/follow.
+//
+// Files whose name ends in ".wav" have a WAV header, other files are raw
+// PCM whose form and sample codec are given by the flags -frate,
+// -fchannels and -fcodec.  The file "-" is standard input or output.  Play
+// and record don't convert forms: the device is opened with the form of
+// the file, or the file is written with the form of the device, except that
+// with -follow data is converted for default devices lacking that form.
+//
+// Latency plays a test signal on an output device while capturing an input
+// device, which should be connected to it with a loopback cable, and
+// reports the round trip latency, see package zikichombo.org/sio/latency.
+// With -loopback, an in-process loopback entry is measured instead.
+//
+// Soak runs a source, or a sink with -play, under load and reports
+// histograms of call times, schedule slip and missed deadlines, see package
+// zikichombo.org/sio/soak.  With -emulate, a stream of emulated callbacks
+// with the given jitter is run instead of an entry.
+//
+// Serve exports the entry over TCP for use with the "Remote" entry, see
+// package zikichombo.org/sio/remote.
+//
+// Command sio is part of http://zikichombo.org
+package main /* import "zikichombo.org/sio/cmd/sio" */
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"zikichombo.org/sio"
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound/sample"
+
+	_ "zikichombo.org/sio/rtp"
+	_ "zikichombo.org/sio/sndio"
+)
+
+const usage = `usage: sio <command> [flags] [args]
+
+Commands:
+	entries     list the entries of the host
+	devices     list, and with -probe try, the devices of an entry
+	play        play a WAV or raw PCM file
+	record      record to a WAV or raw PCM file
+	latency     measure the round trip latency of an entry
+	soak        run a stream for a long time under load and report glitches
+	serve       serve an entry over TCP for the Remote entry
+
+Run "sio <command> -h" for the flags of a command.
+`
+
+// errUsage is returned when a command is misused, after the usage has been
+// printed.
+var errUsage = errors.New("usage")
+
+var errFollowDev = errors.New("-follow and -dev are exclusive")
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("sio: ")
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		switch err {
+		case flag.ErrHelp:
+			return
+		case errUsage:
+			os.Exit(2)
+		}
+		log.Fatal(err)
+	}
+}
+
+// run runs the command given by args, writing its output to w.
+func run(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return errUsage
+	}
+	switch args[0] {
+	case "entries":
+		return entries(args[1:], w)
+	case "devices":
+		return devices(args[1:], w)
+	case "play":
+		return play(args[1:], w)
+	case "record":
+		return record(args[1:], w)
+	case "latency":
+		return measureLatency(args[1:], w)
+	case "soak":
+		return runSoak(args[1:], w)
+	case "serve":
+		return serve(args[1:], w)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(w, usage)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "sio: unknown command %q\n%s", args[0], usage)
+	return errUsage
+}
+
+// flags creates the flag set of the command name.
+func flags(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: sio %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses args with fs and checks that n arguments remain.  It
+// returns flag.ErrHelp if help was requested.
+func parse(fs *flag.FlagSet, args []string, n int) error {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return errUsage
+	}
+	if fs.NArg() != n {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// connect connects to the entry name, or to the first entry which connects
+// if name is empty.  The caller should call sio.Disconnect when done.
+func connect(name string) (host.Entry, error) {
+	if name != "" {
+		e, err := sio.ConnectTo(name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("entry %s: %s", name, err)
+		}
+		return e, nil
+	}
+	for _, name := range sio.EntryNames() {
+		if e, err := sio.ConnectTo(name, nil); err == nil {
+			return e, nil
+		}
+	}
+	return nil, host.ErrNoEntryAvailable
+}
+
+// findDev returns the device of e identified by name as in host.FindDev,
+// or def if name is empty.
+func findDev(e host.Entry, name string, def func() *libsio.Dev) (*libsio.Dev, error) {
+	if !e.HasDevices() {
+		if name != "" {
+			return nil, fmt.Errorf("entry %s has no devices", e.Name())
+		}
+		return nil, nil
+	}
+	if name == "" {
+		return def(), nil
+	}
+	d, err := host.FindDev(e, name)
+	if err != nil {
+		return nil, fmt.Errorf("entry %s: device %q: %s", e.Name(), name, err)
+	}
+	return d, nil
+}
+
+// codecFlag is a flag naming a sample codec.
+type codecFlag struct {
+	co  sample.Codec
+	set bool
+}
+
+func (f *codecFlag) String() string {
+	if !f.set {
+		return ""
+	}
+	return f.co.String()
+}
+
+func (f *codecFlag) Set(s string) error {
+	for _, co := range sample.Codecs {
+		if co.String() == s {
+			f.co, f.set = co, true
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown sample codec %s", s)
+}
+
+// get returns the codec of f, or def if it wasn't set.
+func (f *codecFlag) get(def sample.Codec) sample.Codec {
+	if !f.set {
+		return def
+	}
+	return f.co
+}
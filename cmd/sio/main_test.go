@@ -0,0 +1,182 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"zikichombo.org/sio/latency"
+	"zikichombo.org/sio/pipe"
+	"zikichombo.org/sio/soak"
+	"zikichombo.org/sound/sample"
+)
+
+func TestEntries(t *testing.T) {
+	var buf bytes.Buffer
+	if err := run([]string{"entries", "-json"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var res []*entryInfo
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, ei := range res {
+		if ei.Name == "Pipe" {
+			found = ei.Available
+		}
+	}
+	if !found {
+		t.Errorf("Pipe not available in %s", buf.String())
+	}
+}
+
+func TestDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in")
+	if err := ioutil.WriteFile(in, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := pipe.Default.AddPath(&pipe.Path{Name: "probe", In: in, Out: filepath.Join(dir, "out")})
+	defer pipe.Default.RemovePath(d)
+
+	var buf bytes.Buffer
+	if err := run([]string{"devices", "-entry", "Pipe", "-json", "-probe"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var ei entryInfo
+	if err := json.Unmarshal(buf.Bytes(), &ei); err != nil {
+		t.Fatal(err)
+	}
+	var di *devInfo
+	for _, x := range ei.Devices {
+		if x.Name == "probe" {
+			di = x
+		}
+	}
+	if di == nil {
+		t.Fatalf("no probe device in %s", buf.String())
+	}
+	if di.In == nil || di.Out == nil || di.In.MaxChannels == 0 || di.Out.MaxChannels == 0 || len(di.In.Codecs) == 0 {
+		t.Fatalf("capabilities %+v", di)
+	}
+	if n := len(di.In.Codecs) + len(di.Out.Codecs); len(di.Probe) != n {
+		t.Errorf("got %d probes for %d codecs", len(di.Probe), n)
+	}
+	for _, p := range di.Probe {
+		if p.Err != "" {
+			t.Errorf("probe %s %s: %s", p.Kind, p.Codec, p.Err)
+		}
+	}
+
+	buf.Reset()
+	if err := run([]string{"devices", "-entry", "Pipe"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"probe"`) {
+		t.Errorf("text output lacks device:\n%s", buf.String())
+	}
+}
+
+// TestRecordPlay records from a raw file to a WAV file and plays that
+// to another raw file, through devices of the Pipe entry.
+func TestRecordPlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const nF = 1000
+	d := make([]float64, nF)
+	for i := range d {
+		d[i] = float64(i%200-100) / 128
+	}
+	raw := make([]byte, nF*2)
+	sample.SInt16L.Encode(raw, d)
+	in, out, wav := filepath.Join(dir, "in"), filepath.Join(dir, "out"), filepath.Join(dir, "rec.wav")
+	if err := ioutil.WriteFile(in, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ind := pipe.Default.AddPath(&pipe.Path{Name: "in", In: in})
+	defer pipe.Default.RemovePath(ind)
+	outd := pipe.Default.AddPath(&pipe.Path{Name: "out", Out: out})
+	defer pipe.Default.RemovePath(outd)
+
+	var buf bytes.Buffer
+	err = run([]string{"record", "-entry", "Pipe", "-dev", "in", "-rate", "8000",
+		"-channels", "1", "-codec", "SInt16L", "-b", "128", "-d", "100ms", wav}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = run([]string{"play", "-entry", "Pipe", "-dev", "out", "-codec", "SInt16L", "-b", "64", wav}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, raw[:800*2]) {
+		t.Errorf("played %d bytes, differing from the first 1600 recorded", len(got))
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := run([]string{"devices", "extra"}, &buf); err != errUsage {
+		t.Errorf("got %v, want errUsage", err)
+	}
+	if err := run([]string{"play", "-codec", "nope", "f"}, &buf); err != errUsage {
+		t.Errorf("got %v, want errUsage", err)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	var buf bytes.Buffer
+	err := run([]string{"latency", "-loopback", "441", "-rate", "44100", "-runs", "3", "-max", "50ms", "-json"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res latency.Result
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Latencies) != 3 || res.Mean < 9900*time.Microsecond || res.Mean > 10100*time.Microsecond {
+		t.Errorf("got %s, want 3 runs of 10ms", &res)
+	}
+}
+
+func TestSoak(t *testing.T) {
+	in := filepath.Join(os.TempDir(), "sio-soak")
+	if err := ioutil.WriteFile(in, make([]byte, 8000*2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(in)
+	d := pipe.Default.AddPath(&pipe.Path{Name: "soak", In: in})
+	defer pipe.Default.RemovePath(d)
+	var buf bytes.Buffer
+	err := run([]string{"soak", "-entry", "Pipe", "-dev", "soak", "-rate", "8000", "-channels", "1",
+		"-codec", "SInt16L", "-b", "100", "-d", "10s", "-cpu", "1", "-json"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rep soak.Report
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Calls != 80 || rep.Frames != 8000 {
+		t.Errorf("got %d calls, %d frames", rep.Calls, rep.Frames)
+	}
+}
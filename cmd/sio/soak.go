@@ -0,0 +1,123 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package main
+
+import (
+	"io"
+	"time"
+
+	"zikichombo.org/sio"
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sio/soak"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+func runSoak(args []string, w io.Writer) error {
+	fs := flags("soak", "")
+	name := fs.String("entry", "", "entry `name`")
+	dev := fs.String("dev", "", "device `name`")
+	play := fs.Bool("play", false, "soak a sink rather than a source")
+	rate := fs.Int("rate", 0, "sample rate (default of the entry)")
+	channels := fs.Int("channels", 0, "channels (default of the entry)")
+	var co codecFlag
+	fs.Var(&co, "codec", "sample codec (default of the entry)")
+	b := fs.Int("b", 0, "buffer size in frames (default of the entry)")
+	var cfg soak.Config
+	fs.DurationVar(&cfg.Duration, "d", time.Minute, "duration, 0 to run until interrupted")
+	fs.BoolVar(&cfg.Raw, "raw", false, "receive raw packets, counting gaps")
+	fs.IntVar(&cfg.Load.CPU, "cpu", 0, "goroutines loading the CPU")
+	fs.IntVar(&cfg.Load.Alloc, "alloc", 0, "`bytes` of garbage allocated per second")
+	fs.IntVar(&cfg.Load.Goroutines, "goroutines", 0, "goroutines waking periodically")
+	fs.DurationVar(&cfg.Load.Wake, "wake", time.Millisecond, "wake period of goroutines")
+	emu := fs.Bool("emulate", false, "soak a stream of emulated callbacks rather than an entry")
+	jitter := fs.Duration("jitter", 0, "jitter of emulated callbacks")
+	js := fs.Bool("json", false, "write JSON")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	go func() {
+		<-interrupt()
+		close(stop)
+	}()
+	cfg.Stop = stop
+
+	var rep *soak.Report
+	var err error
+	if *emu {
+		if *rate <= 0 {
+			*rate = 48000
+		}
+		if *channels <= 0 {
+			*channels = 2
+		}
+		if *b <= 0 {
+			*b = 256
+		}
+		v := sound.NewForm(freq.T(*rate)*freq.Hertz, *channels)
+		cfg.BufSize = *b
+		rep, err = soakEmulated(v, co.get(sample.SInt16L), *b, !*play, *jitter, &cfg)
+	} else {
+		e, cerr := connect(*name)
+		if cerr != nil {
+			return cerr
+		}
+		defer sio.Disconnect()
+		def := e.DefaultInputDev
+		if *play {
+			def = e.DefaultOutputDev
+		}
+		d, derr := findDev(e, *dev, def)
+		if derr != nil {
+			return derr
+		}
+		v := e.DefaultForm()
+		r, c := v.SampleRate(), v.Channels()
+		if *rate > 0 {
+			r = freq.T(*rate) * freq.Hertz
+		}
+		if *channels > 0 {
+			c = *channels
+		}
+		v = sound.NewForm(r, c)
+		if *b <= 0 {
+			*b = e.DefaultBufSize()
+		}
+		cfg.BufSize = *b
+		rep, err = soakEntry(e, d, v, co.get(e.DefaultSampleCodec()), *b, *play, &cfg)
+	}
+	if rep != nil {
+		var werr error
+		if *js {
+			werr = writeJSON(w, rep)
+		} else {
+			werr = rep.Write(w)
+		}
+		if err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// soakEntry soaks a source or sink of e on d.
+func soakEntry(e host.Entry, d *libsio.Dev, v sound.Form, co sample.Codec, b int, play bool, cfg *soak.Config) (*soak.Report, error) {
+	if play {
+		snk, _, err := e.OpenSink(d, v, co, b)
+		if err != nil {
+			return nil, err
+		}
+		defer snk.Close()
+		return soak.RunSink(snk, cfg)
+	}
+	src, _, err := e.OpenSource(d, v, co, b)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return soak.RunSource(src, cfg)
+}
@@ -0,0 +1,267 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"zikichombo.org/sio"
+	"zikichombo.org/sio/dither"
+	"zikichombo.org/sio/follow"
+	"zikichombo.org/sio/guard"
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sio/pipe"
+	"zikichombo.org/sio/remote"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// fileFlags are the flags giving the format of raw PCM files.
+type fileFlags struct {
+	rate     *int
+	channels *int
+	co       codecFlag
+}
+
+func (f *fileFlags) register(fs *flag.FlagSet) {
+	f.rate = fs.Int("frate", 44100, "sample rate of raw files")
+	f.channels = fs.Int("fchannels", 2, "channels of raw files")
+	fs.Var(&f.co, "fcodec", "sample codec of files (default SInt16L)")
+}
+
+func isWAV(file string) bool {
+	return strings.EqualFold(filepath.Ext(file), ".wav")
+}
+
+func play(args []string, w io.Writer) error {
+	fs := flags("play", "file")
+	name := fs.String("entry", "", "entry `name`")
+	dev := fs.String("dev", "", "device `name`")
+	var co codecFlag
+	fs.Var(&co, "codec", "sample codec of the device (default of the entry)")
+	b := fs.Int("b", 0, "buffer size in frames (default of the entry)")
+	fol := fs.Bool("follow", false, "follow the default device when it changes")
+	dth := fs.String("dither", "", "dither integer sample codecs with noise `shape` flat, first, second or e-weighted")
+	grd := fs.String("guard", "", "guard the output, muting it on NaNs or DC, in `mode` clip or limit")
+	var ff fileFlags
+	ff.register(fs)
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	if *fol && *dev != "" {
+		return errFollowDev
+	}
+	var opts host.SinkOptions
+	if *dth != "" {
+		shape, err := dither.ParseShape(*dth)
+		if err != nil {
+			return err
+		}
+		opts.Dither = &dither.Config{Shape: shape}
+	}
+	if *grd != "" {
+		mode, err := guard.ParseMode(*grd)
+		if err != nil {
+			return err
+		}
+		opts.Guard = &guard.Config{Mode: mode}
+	}
+	file := fs.Arg(0)
+	wav := isWAV(file)
+	v := sound.NewForm(freq.T(*ff.rate)*freq.Hertz, *ff.channels)
+	fco := ff.co.get(sample.SInt16L)
+	if wav {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		v, fco, err = pipe.ReadWAVHeader(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+	}
+	e, err := connect(*name)
+	if err != nil {
+		return err
+	}
+	defer sio.Disconnect()
+	if *b <= 0 {
+		*b = e.DefaultBufSize()
+	}
+	pe := pipe.NewEntry()
+	pd := pe.AddPath(&pipe.Path{Name: file, In: file, WAV: wav})
+	src, _, err := pe.OpenSource(pd, v, fco, *b)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	var snk sound.Sink
+	dco := co.get(e.DefaultSampleCodec())
+	if *fol {
+		snk, err = follow.NewSink(e, v, dco, *b, nil)
+	} else {
+		var d *libsio.Dev
+		if d, err = findDev(e, *dev, e.DefaultOutputDev); err != nil {
+			return err
+		}
+		snk, _, err = e.OpenSink(d, v, dco, *b)
+	}
+	if err != nil {
+		return err
+	}
+	snk = opts.Apply(snk, dco)
+	if err := copyFrames(snk, src, -1, *b, interrupt()); err != nil {
+		snk.Close()
+		return err
+	}
+	return snk.Close()
+}
+
+func record(args []string, w io.Writer) error {
+	fs := flags("record", "file")
+	name := fs.String("entry", "", "entry `name`")
+	dev := fs.String("dev", "", "device `name`")
+	rate := fs.Int("rate", 0, "sample rate (default of the entry)")
+	channels := fs.Int("channels", 0, "channels (default of the entry)")
+	var co codecFlag
+	fs.Var(&co, "codec", "sample codec of the device (default of the entry)")
+	b := fs.Int("b", 0, "buffer size in frames (default of the entry)")
+	dur := fs.Duration("d", 5*time.Second, "duration, 0 to record until interrupted")
+	fol := fs.Bool("follow", false, "follow the default device when it changes")
+	var ff fileFlags
+	ff.register(fs)
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	if *fol && *dev != "" {
+		return errFollowDev
+	}
+	file := fs.Arg(0)
+	e, err := connect(*name)
+	if err != nil {
+		return err
+	}
+	defer sio.Disconnect()
+	v := e.DefaultForm()
+	r, c := v.SampleRate(), v.Channels()
+	if *rate > 0 {
+		r = freq.T(*rate) * freq.Hertz
+	}
+	if *channels > 0 {
+		c = *channels
+	}
+	v = sound.NewForm(r, c)
+	if *b <= 0 {
+		*b = e.DefaultBufSize()
+	}
+	var src sound.Source
+	if *fol {
+		src, err = follow.NewSource(e, v, co.get(e.DefaultSampleCodec()), *b, nil)
+	} else {
+		var d *libsio.Dev
+		if d, err = findDev(e, *dev, e.DefaultInputDev); err != nil {
+			return err
+		}
+		src, _, err = e.OpenSource(d, v, co.get(e.DefaultSampleCodec()), *b)
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	pe := pipe.NewEntry()
+	pd := pe.AddPath(&pipe.Path{Name: file, Out: file, WAV: isWAV(file)})
+	snk, _, err := pe.OpenSink(pd, v, ff.co.get(sample.SInt16L), *b)
+	if err != nil {
+		return err
+	}
+	n := int64(-1)
+	if *dur > 0 {
+		n = int64(dur.Seconds()*v.SampleRate().Float64() + 0.5)
+	}
+	if err := copyFrames(snk, src, n, *b, interrupt()); err != nil {
+		snk.Close()
+		return err
+	}
+	return snk.Close()
+}
+
+func serve(args []string, w io.Writer) error {
+	fs := flags("serve", "addr")
+	name := fs.String("entry", "", "entry `name`")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	e, err := connect(*name)
+	if err != nil {
+		return err
+	}
+	defer sio.Disconnect()
+	srv := remote.NewServer(e)
+	stop := interrupt()
+	go func() {
+		<-stop
+		srv.Close()
+	}()
+	fmt.Fprintf(w, "serving %s on %s\n", e.Name(), fs.Arg(0))
+	if err := srv.ListenAndServe(fs.Arg(0)); err != remote.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// interrupt returns a channel which receives on os.Interrupt.
+func interrupt() <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	return c
+}
+
+// copyFrames copies n frames, or until the end if n < 0, from src to dst in
+// buffers of b frames.  It stops without error when stop receives.
+func copyFrames(dst sound.Sink, src sound.Source, n int64, b int, stop <-chan os.Signal) error {
+	nC := src.Channels()
+	buf := make([]float64, b*nC)
+	part := make([]float64, b*nC)
+	var done int64
+	for n < 0 || done < n {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		k, err := src.Receive(buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n >= 0 && int64(k) > n-done {
+			k = int(n - done)
+		}
+		d := buf
+		if k < b {
+			// the channels of a partial buffer are b apart.
+			d = part[:k*nC]
+			for c := 0; c < nC; c++ {
+				copy(d[c*k:(c+1)*k], buf[c*b:c*b+k])
+			}
+		}
+		if err := dst.Send(d); err != nil {
+			return err
+		}
+		done += int64(k)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package dither
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Shape selects a noise shaping filter.
+type Shape int
+
+const (
+	// Flat applies TPDF dither without noise shaping.
+	Flat Shape = iota
+	// FirstOrder shapes the error with 1 - z^-1, rising 6dB per octave.
+	FirstOrder
+	// SecondOrder shapes the error with (1 - z^-1)^2, rising 12dB per
+	// octave.
+	SecondOrder
+	// EWeighted shapes the error with the 5 tap filter of Lipshitz,
+	// Vanderkooy and Wannamaker, weighted to the threshold of hearing at
+	// 44.1kHz.
+	EWeighted
+)
+
+// filters holds the error feedback coefficients of each Shape.
+var filters = [...][]float64{
+	Flat:        nil,
+	FirstOrder:  {1},
+	SecondOrder: {2, -1},
+	EWeighted:   {2.033, -2.165, 1.959, -1.590, 0.6149}}
+
+var shapeNames = [...]string{"flat", "first", "second", "e-weighted"}
+
+func (s Shape) String() string {
+	if s < 0 || int(s) >= len(shapeNames) {
+		return fmt.Sprintf("Shape(%d)", int(s))
+	}
+	return shapeNames[s]
+}
+
+// ParseShape returns the Shape whose String is s.
+func ParseShape(s string) (Shape, error) {
+	for i, n := range shapeNames {
+		if n == s {
+			return Shape(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown noise shape %q", s)
+}
+
+// Config configures a Ditherer.  A nil *Config is flat TPDF dither.
+type Config struct {
+	// Shape is the noise shaping filter.
+	Shape Shape
+	// Seed seeds the noise, so that output is reproducible.
+	Seed int64
+}
+
+// Ditherer dithers and noise shapes channel deinterleaved data for a
+// sample codec.
+type Ditherer struct {
+	nC    int
+	scale float64   // value of full scale in LSBs
+	h     []float64 // error feedback coefficients
+	err   []float64 // per channel, the last len(h) errors, most recent first
+	rnd   *rand.Rand
+}
+
+// New creates a Ditherer for nC channels encoded with co.  New returns nil
+// if co is a float sample codec, as these need no dither.
+func New(co sample.Codec, nC int, cfg *Config) *Ditherer {
+	if co.IsFloat() {
+		return nil
+	}
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Shape < 0 || int(c.Shape) >= len(filters) {
+		c.Shape = Flat
+	}
+	h := filters[c.Shape]
+	return &Ditherer{
+		nC:    nC,
+		scale: float64(int64(1)<<uint(8*co.Bytes()-1) - 1),
+		h:     h,
+		err:   make([]float64, nC*len(h)),
+		rnd:   rand.New(rand.NewSource(c.Seed))}
+}
+
+// Process dithers d in place.  d is channel deinterleaved with
+// len(d)/nC frames, nC being the number of channels of dd.  NaN and
+// infinite samples become 0.
+func (dd *Ditherer) Process(d []float64) {
+	nC := dd.nC
+	nF := len(d) / nC
+	nH := len(dd.h)
+	for c := 0; c < nC; c++ {
+		es := dd.err[c*nH : (c+1)*nH]
+		for f := 0; f < nF; f++ {
+			w := d[c*nF+f] * dd.scale
+			for i, h := range dd.h {
+				w -= h * es[i]
+			}
+			if math.IsNaN(w) || math.IsInf(w, 0) {
+				d[c*nF+f] = 0
+				continue
+			}
+			q := math.Floor(w + dd.rnd.Float64() - dd.rnd.Float64() + 0.5)
+			if nH > 0 {
+				copy(es[1:], es[:nH-1])
+				es[0] = q - w
+			}
+			if q > dd.scale {
+				q = dd.scale
+			} else if q < -dd.scale {
+				q = -dd.scale
+			}
+			d[c*nF+f] = q / dd.scale
+		}
+	}
+}
+
+// Reset clears the noise shaping history.
+func (dd *Ditherer) Reset() {
+	for i := range dd.err {
+		dd.err[i] = 0
+	}
+}
+
+type sink struct {
+	sound.Sink
+	dd  *Ditherer
+	buf []float64
+}
+
+// NewSink returns a sound.Sink which dithers data for co before sending it
+// to snk.  If co is a float sample codec, NewSink returns snk.
+func NewSink(snk sound.Sink, co sample.Codec, cfg *Config) sound.Sink {
+	dd := New(co, snk.Channels(), cfg)
+	if dd == nil {
+		return snk
+	}
+	return &sink{Sink: snk, dd: dd}
+}
+
+func (s *sink) Send(d []float64) error {
+	if len(d)%s.dd.nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	if cap(s.buf) < len(d) {
+		s.buf = make([]float64, len(d))
+	}
+	s.buf = s.buf[:len(d)]
+	copy(s.buf, d)
+	s.dd.Process(s.buf)
+	return s.Sink.Send(s.buf)
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package dither
+
+import (
+	"math"
+	"testing"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+const lsb = 1.0 / 32767
+
+// errSum dithers n samples of value v for SInt16L and returns the sum of
+// the errors in LSBs.
+func errSum(t *testing.T, s Shape, v float64, n int) float64 {
+	dd := New(sample.SInt16L, 1, &Config{Shape: s, Seed: 1})
+	d := make([]float64, n)
+	for i := range d {
+		d[i] = v
+	}
+	dd.Process(d)
+	sum := 0.0
+	for i, x := range d {
+		q := x / lsb
+		if math.Abs(q-math.Floor(q+0.5)) > 1e-6 {
+			t.Fatalf("%s: sample %d off the grid: %f", s, i, q)
+		}
+		e := q - v/lsb
+		if math.Abs(e) > 20 {
+			t.Fatalf("%s: sample %d error %f LSB", s, i, e)
+		}
+		sum += e
+	}
+	return sum
+}
+
+func TestDither(t *testing.T) {
+	n := 100000
+	// flat dither is unbiased where rounding isn't.
+	if mean := errSum(t, Flat, 0.3*lsb, n) / float64(n); math.Abs(mean) > 0.02 {
+		t.Errorf("flat: mean error %f LSB", mean)
+	}
+	// the shaped error of first and second order filters has no DC.
+	for _, s := range []Shape{FirstOrder, SecondOrder} {
+		if sum := errSum(t, s, 0.3*lsb, n); math.Abs(sum) > 5 {
+			t.Errorf("%s: error sum %f LSB", s, sum)
+		}
+	}
+	errSum(t, EWeighted, 0.3*lsb, n)
+}
+
+func TestClip(t *testing.T) {
+	dd := New(sample.SInt16L, 2, &Config{Shape: EWeighted})
+	d := []float64{1.5, 1, -1, -2, math.NaN(), math.Inf(1)}
+	dd.Process(d)
+	for i, exp := range []float64{1, 1, -1, -1, 0, 0} {
+		if d[i] != exp {
+			t.Errorf("sample %d: got %f, want %f", i, d[i], exp)
+		}
+	}
+}
+
+func TestParseShape(t *testing.T) {
+	for s := Flat; s <= EWeighted; s++ {
+		if p, err := ParseShape(s.String()); err != nil || p != s {
+			t.Errorf("%s: got %s, %v", s, p, err)
+		}
+	}
+	if _, err := ParseShape("nope"); err == nil {
+		t.Error("parsed nope")
+	}
+}
+
+type capSink struct {
+	sound.Form
+	d []float64
+}
+
+func (s *capSink) Close() error { return nil }
+
+func (s *capSink) Send(d []float64) error {
+	s.d = append(s.d, d...)
+	return nil
+}
+
+func TestSink(t *testing.T) {
+	snk := &capSink{Form: sound.StereoCd()}
+	if NewSink(snk, sample.SFloat32L, nil) != sound.Sink(snk) {
+		t.Error("float sink dithered")
+	}
+	ds := NewSink(snk, sample.SInt16L, nil)
+	d := []float64{0.1, 0.2, 0.3, 0.4}
+	if err := ds.Send(d); err != nil {
+		t.Fatal(err)
+	}
+	if d[0] != 0.1 {
+		t.Error("sent data modified")
+	}
+	for i, x := range snk.d {
+		if math.Abs(x-d[i]) > 2*lsb {
+			t.Errorf("sample %d: got %f, want about %f", i, x, d[i])
+		}
+	}
+	if err := ds.Send(d[:3]); err != sound.ErrChannelAlignment {
+		t.Errorf("got %v", err)
+	}
+}
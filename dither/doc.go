@@ -0,0 +1,23 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package dither provides TPDF dither and noise shaping for output to
+// integer sample codecs.
+//
+// Without dither, encoding float64 samples to a 16 bit integer sample codec
+// rounds each sample independently, so that the error is correlated with
+// the signal and low level material sounds distorted.  A Ditherer adds
+// triangular probability density (TPDF) noise of 2 LSB peak to peak before
+// rounding, which makes the error independent of the signal, and may shape
+// the spectrum of the error with an error feedback filter, moving it to
+// frequencies where it is less audible.
+//
+// A Ditherer rounds samples to the grid of its sample codec, full scale
+// being the largest value of the integer type, so that sample.Codec.Encode
+// preserves them exactly.  Dither is not applied for float sample codecs.
+//
+// Sinks are usually dithered via host.SinkOptions, which applies a
+// Ditherer as the last stage before the entry encodes the data.
+//
+// Package dither is part of http://zikichombo.org
+package dither /* import "zikichombo.org/sio/dither" */
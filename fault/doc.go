@@ -0,0 +1,19 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package fault provides a host.Entry which injects faults into another.
+//
+// An Entry decorates a host.Entry and, according to its Rules, makes opens
+// fail, makes Receive, Send and SendReceive stall, drops buffers, reports
+// xruns, disconnects devices and ends streams early.  Rules fire on a
+// schedule of calls, at random, or both.  Every injected fault is recorded
+// as an Event, so that tests can assert how application code coped.
+//
+// Streams opened by an Entry implement
+//
+//	interface { Xruns() int64 }
+//
+// counting the xruns injected into them.
+//
+// Package fault is part of http://zikichombo.org
+package fault /* import "zikichombo.org/sio/fault" */
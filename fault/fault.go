@@ -0,0 +1,417 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package fault
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// ErrInjected is the default error of OpenError faults.
+var ErrInjected = errors.New("fault: injected error")
+
+// ErrDisconnected is returned by streams on a disconnected device, and by
+// opens on one.
+var ErrDisconnected = errors.New("fault: device disconnected")
+
+// Kind is a kind of fault.
+type Kind int
+
+const (
+	// OpenError makes an open fail with the Err of the Rule.
+	OpenError Kind = iota
+
+	// Stall delays a call by the Stall of the Rule.
+	Stall
+
+	// Drop silently loses a buffer: a source receives the next buffer
+	// in its place, a sink doesn't play it and a duplex stream captures
+	// silence.
+	Drop
+
+	// Xrun loses a buffer as Drop, except that a sink is late by the
+	// duration of the buffer instead, and reports it in the xrun count
+	// of the stream.
+	Xrun
+
+	// Disconnect disconnects the device of a stream: subscribers to
+	// device notifications receive a DeviceDisconnect, the stream fails
+	// with ErrDisconnected, and the device can't be opened until
+	// reconnected.
+	Disconnect
+
+	// EOF ends a stream prematurely with io.EOF.
+	EOF
+)
+
+var kindNames = [...]string{"OpenError", "Stall", "Drop", "Xrun", "Disconnect", "EOF"}
+
+func (k Kind) String() string {
+	if k >= 0 && int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Op is a set of operations on which a Rule may fire.
+type Op int
+
+const (
+	// OpOpen is OpenSource, OpenSink and OpenDuplex.
+	OpOpen Op = 1 << iota
+	// OpReceive is Receive of sources.
+	OpReceive
+	// OpSend is Send of sinks.
+	OpSend
+	// OpDuplex is SendReceive of duplex streams.
+	OpDuplex
+
+	opIO = OpReceive | OpSend | OpDuplex
+)
+
+// Rule configures a fault.  A Rule counts the calls of its operations
+// across the streams of an Entry, and fires on the At'th, then every Every
+// calls, and at random with probability Prob on any call.
+type Rule struct {
+	// Kind is the kind of fault.
+	Kind Kind
+
+	// Ops are the operations on which the rule fires.  If 0, OpOpen for
+	// OpenError and the stream operations otherwise.
+	Ops Op
+
+	// At is the first call, counting from 1, on which the rule fires,
+	// 0 for none.
+	At int
+
+	// Every is the number of calls between firings after At, or from
+	// the start if At is 0.  0 means the rule fires only At.
+	Every int
+
+	// Prob is the probability of firing on each call.
+	Prob float64
+
+	// Max bounds the number of firings if not 0.
+	Max int
+
+	// Stall is the duration of Stall faults, 100ms by default.
+	Stall time.Duration
+
+	// Err is the error of OpenError faults, ErrInjected by default.
+	Err error
+}
+
+func (r *Rule) ops() Op {
+	if r.Ops != 0 {
+		return r.Ops
+	}
+	if r.Kind == OpenError {
+		return OpOpen
+	}
+	return opIO
+}
+
+// Event records an injected fault.
+type Event struct {
+	Time time.Time
+	Kind Kind
+	// Op is the name of the method in which the fault was injected.
+	Op string
+	// Call is the count of calls of the Rule when it fired.
+	Call int
+	// Dev is the device, nil for entries without devices.
+	Dev *libsio.Dev
+}
+
+func (v *Event) String() string {
+	dev := "<nil>"
+	if v.Dev != nil {
+		dev = fmt.Sprintf("%q", v.Dev.Name)
+	}
+	return fmt.Sprintf("%s %s in %s call %d on %s", v.Time.Format("15:04:05.000000"), v.Kind, v.Op, v.Call, dev)
+}
+
+// Entry is a host.Entry injecting faults into the host.Entry it wraps.
+type Entry struct {
+	host.Entry
+
+	// Logger, if not nil, logs each injected fault.
+	Logger *log.Logger
+
+	mu    sync.Mutex
+	rules []Rule
+	calls []int
+	fired []int
+	rnd   *rand.Rand
+	log   []Event
+	gone  map[*libsio.Dev]bool
+	subs  host.Notifier
+	fwd   map[chan<- *host.DevChange]bool // subscribed to the wrapped entry
+}
+
+// New creates an Entry wrapping e with the rules rs.
+func New(e host.Entry, rs ...Rule) *Entry {
+	f := &Entry{Entry: e, rnd: rand.New(rand.NewSource(1)),
+		gone: make(map[*libsio.Dev]bool),
+		fwd:  make(map[chan<- *host.DevChange]bool)}
+	f.SetRules(rs...)
+	return f
+}
+
+// Seed seeds the random source of the rules.
+func (f *Entry) Seed(seed int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rnd.Seed(seed)
+}
+
+// SetRules replaces the rules of f, resetting their call counts.
+func (f *Entry) SetRules(rs ...Rule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append([]Rule(nil), rs...)
+	f.calls = make([]int, len(rs))
+	f.fired = make([]int, len(rs))
+}
+
+// Log returns the events of the faults injected so far.
+func (f *Entry) Log() []Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Event(nil), f.log...)
+}
+
+// Count returns the number of faults of kind k injected so far.
+func (f *Entry) Count(k Kind) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for i := range f.log {
+		if f.log[i].Kind == k {
+			n++
+		}
+	}
+	return n
+}
+
+// faults counts a call of op named name on d and returns the rules which
+// fire, logging them.
+func (f *Entry) faults(op Op, name string, d *libsio.Dev) []Rule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var res []Rule
+	for i := range f.rules {
+		r := &f.rules[i]
+		if r.ops()&op == 0 {
+			continue
+		}
+		f.calls[i]++
+		n := f.calls[i]
+		if r.Max > 0 && f.fired[i] >= r.Max {
+			continue
+		}
+		fire := n == r.At
+		if r.Every > 0 && n > r.At && (n-r.At)%r.Every == 0 {
+			fire = true
+		}
+		if r.Prob > 0 && f.rnd.Float64() < r.Prob {
+			fire = true
+		}
+		if !fire {
+			continue
+		}
+		f.fired[i]++
+		res = append(res, *r)
+		f.record(r.Kind, name, n, d)
+	}
+	return res
+}
+
+// record logs a fault.  f.mu is held.
+func (f *Entry) record(k Kind, name string, call int, d *libsio.Dev) {
+	f.log = append(f.log, Event{Time: time.Now(), Kind: k, Op: name, Call: call, Dev: d})
+	if f.Logger != nil {
+		f.Logger.Printf("fault: %s", &f.log[len(f.log)-1])
+	}
+}
+
+// Disconnect disconnects d as a Disconnect fault, notifying subscribers.
+func (f *Entry) Disconnect(d *libsio.Dev) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record(Disconnect, "Disconnect", 0, d)
+	f.disconnect(d)
+}
+
+// disconnect disconnects d.  f.mu is held.
+func (f *Entry) disconnect(d *libsio.Dev) {
+	if f.gone[d] {
+		return
+	}
+	f.gone[d] = true
+	f.subs.Notify(&host.DevChange{Sense: host.DeviceDisconnect, Dev: d})
+}
+
+// Reconnect reconnects a device disconnected by a fault, notifying
+// subscribers.  Streams which failed remain failed.
+func (f *Entry) Reconnect(d *libsio.Dev) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.gone[d] {
+		return
+	}
+	delete(f.gone, d)
+	f.subs.Notify(&host.DevChange{Sense: host.DeviceConnect, Dev: d})
+}
+
+func (f *Entry) isGone(d *libsio.Dev) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.gone[d]
+}
+
+// open applies the faults of an open of d.
+func (f *Entry) open(name string, d *libsio.Dev) error {
+	if f.isGone(d) {
+		return ErrDisconnected
+	}
+	for _, r := range f.faults(OpOpen, name, d) {
+		switch r.Kind {
+		case OpenError:
+			if r.Err != nil {
+				return r.Err
+			}
+			return ErrInjected
+		case Stall:
+			time.Sleep(r.stall())
+		case Disconnect:
+			f.mu.Lock()
+			f.disconnect(d)
+			f.mu.Unlock()
+			return ErrDisconnected
+		}
+	}
+	return nil
+}
+
+func (r *Rule) stall() time.Duration {
+	if r.Stall > 0 {
+		return r.Stall
+	}
+	return 100 * time.Millisecond
+}
+
+func (f *Entry) OpenSource(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Source, time.Time, error) {
+	var t time.Time
+	if err := f.open("OpenSource", d); err != nil {
+		return nil, t, err
+	}
+	src, t, err := f.Entry.OpenSource(d, v, co, b)
+	if err != nil {
+		return nil, t, err
+	}
+	return &source{Source: src, s: stream{f: f, d: d, v: v}}, t, nil
+}
+
+func (f *Entry) OpenSink(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Sink, *time.Time, error) {
+	if err := f.open("OpenSink", d); err != nil {
+		return nil, nil, err
+	}
+	snk, t, err := f.Entry.OpenSink(d, v, co, b)
+	if err != nil {
+		return nil, t, err
+	}
+	return &sink{Sink: snk, s: stream{f: f, d: d, v: v}}, t, nil
+}
+
+func (f *Entry) OpenDuplex(d *libsio.Dev, iv, ov sound.Form, co sample.Codec, b int) (sound.Duplex, time.Time, *time.Time, error) {
+	var t time.Time
+	if err := f.open("OpenDuplex", d); err != nil {
+		return nil, t, nil, err
+	}
+	dpx, ti, to, err := f.Entry.OpenDuplex(d, iv, ov, co, b)
+	if err != nil {
+		return nil, ti, to, err
+	}
+	return &duplex{Duplex: dpx, s: stream{f: f, d: d, v: iv}}, ti, to, nil
+}
+
+// Devices returns the devices of the wrapped entry which aren't
+// disconnected by a fault.
+func (f *Entry) Devices() []*libsio.Dev {
+	ds := f.Entry.Devices()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := make([]*libsio.Dev, 0, len(ds))
+	for _, d := range ds {
+		if !f.gone[d] {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// DefaultInputDev returns the default input device of the wrapped entry,
+// or if a fault disconnected it, the first connected device which can
+// input.
+func (f *Entry) DefaultInputDev() *libsio.Dev {
+	return f.dflt(f.Entry.DefaultInputDev(), (*libsio.Dev).CanInput)
+}
+
+// DefaultOutputDev is as DefaultInputDev for output.
+func (f *Entry) DefaultOutputDev() *libsio.Dev {
+	return f.dflt(f.Entry.DefaultOutputDev(), (*libsio.Dev).CanOutput)
+}
+
+// DefaultDuplexDev is as DefaultInputDev for duplex.
+func (f *Entry) DefaultDuplexDev() *libsio.Dev {
+	return f.dflt(f.Entry.DefaultDuplexDev(), (*libsio.Dev).CanDuplex)
+}
+
+func (f *Entry) dflt(d *libsio.Dev, can func(*libsio.Dev) bool) *libsio.Dev {
+	if d == nil || !f.isGone(d) {
+		return d
+	}
+	for _, o := range f.Devices() {
+		if can(o) {
+			return o
+		}
+	}
+	return nil
+}
+
+// DevicesNotify subscribes c to injected disconnections and to the
+// notifications of the wrapped entry.  If the wrapped entry fails to
+// subscribe c, for example with host.ErrUnsupported, c only receives
+// injected notifications.
+func (f *Entry) DevicesNotify(c chan<- *host.DevChange) error {
+	fwd := f.Entry.DevicesNotify(c) == nil
+	f.mu.Lock()
+	if fwd {
+		f.fwd[c] = true
+	}
+	f.mu.Unlock()
+	f.subs.Add(c)
+	return nil
+}
+
+func (f *Entry) DevicesNotifyClose(c chan<- *host.DevChange) {
+	f.subs.Remove(c)
+	f.mu.Lock()
+	fwd := f.fwd[c]
+	delete(f.fwd, c)
+	f.mu.Unlock()
+	if fwd {
+		f.Entry.DevicesNotifyClose(c)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package fault
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/latency"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sio/pipe"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+var form = sound.NewForm(8000*freq.Hertz, 1)
+
+// pipeEntry returns a pipe entry with a device reading frames numbered
+// from 0 and writing to a file.
+func pipeEntry(t *testing.T) (*pipe.Entry, *libsio.Dev, func()) {
+	dir, err := ioutil.TempDir("", "fault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := make([]float64, 1000)
+	for i := range d {
+		d[i] = float64(i) / 1024
+	}
+	buf := make([]byte, len(d)*8)
+	sample.SFloat64L.Encode(buf, d)
+	in := filepath.Join(dir, "in")
+	if err := ioutil.WriteFile(in, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := pipe.NewEntry()
+	dev := e.AddPath(&pipe.Path{Name: "dev", In: in, Out: filepath.Join(dir, "out")})
+	return e, dev, func() { os.RemoveAll(dir) }
+}
+
+func TestOpenError(t *testing.T) {
+	e, d, done := pipeEntry(t)
+	defer done()
+	f := New(e, Rule{Kind: OpenError, At: 2})
+	for i := 1; i <= 3; i++ {
+		src, _, err := f.OpenSource(d, form, sample.SFloat64L, 10)
+		if i == 2 {
+			if err != ErrInjected {
+				t.Errorf("open %d: got %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		src.Close()
+	}
+	log := f.Log()
+	if len(log) != 1 || log[0].Kind != OpenError || log[0].Op != "OpenSource" || log[0].Call != 2 || log[0].Dev != d {
+		t.Errorf("log %v", log)
+	}
+}
+
+func TestSource(t *testing.T) {
+	e, d, done := pipeEntry(t)
+	defer done()
+	f := New(e, Rule{Kind: Drop, At: 2}, Rule{Kind: Xrun, At: 4}, Rule{Kind: EOF, At: 6})
+	src, _, err := f.OpenSource(d, form, sample.SFloat64L, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	buf := make([]float64, 10)
+	// packets received by calls 1 to 5, after a drop and an xrun.
+	for i, p := range []int{0, 2, 3, 5, 6} {
+		if _, err := src.Receive(buf); err != nil {
+			t.Fatal(err)
+		}
+		if got := int(buf[0]*1024 + 0.5); got != p*10 {
+			t.Errorf("call %d: got frame %d, want %d", i+1, got, p*10)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := src.Receive(buf); err != io.EOF {
+			t.Errorf("got %v, want io.EOF", err)
+		}
+	}
+	if n := src.(interface{ Xruns() int64 }).Xruns(); n != 1 {
+		t.Errorf("%d xruns", n)
+	}
+	if f.Count(Drop) != 1 || f.Count(Xrun) != 1 || f.Count(EOF) != 1 {
+		t.Errorf("log %v", f.Log())
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	e, d, done := pipeEntry(t)
+	defer done()
+	f := New(e, Rule{Kind: Disconnect, Ops: OpSend, At: 3})
+	c := make(chan *host.DevChange, 4)
+	if err := f.DevicesNotify(c); err != nil {
+		t.Fatal(err)
+	}
+	defer f.DevicesNotifyClose(c)
+	snk, _, err := f.OpenSink(d, form, sample.SFloat64L, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snk.Close()
+	buf := make([]float64, 10)
+	for i := 1; i <= 4; i++ {
+		err := snk.Send(buf)
+		if i < 3 && err != nil {
+			t.Fatal(err)
+		}
+		if i >= 3 && err != ErrDisconnected {
+			t.Errorf("send %d: got %v", i, err)
+		}
+	}
+	if ch := <-c; ch.Sense != host.DeviceDisconnect || ch.Dev != d {
+		t.Errorf("got %v", ch)
+	}
+	if len(f.Devices()) != 0 {
+		t.Errorf("disconnected device listed")
+	}
+	if _, _, err := f.OpenSink(d, form, sample.SFloat64L, 10); err != ErrDisconnected {
+		t.Errorf("open got %v", err)
+	}
+	f.Reconnect(d)
+	if ch := <-c; ch.Sense != host.DeviceConnect || ch.Dev != d {
+		t.Errorf("got %v", ch)
+	}
+	snk2, _, err := f.OpenSink(d, form, sample.SFloat64L, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snk2.Close()
+}
+
+func TestRandom(t *testing.T) {
+	f := New(latency.NewLoopback(0), Rule{Kind: Stall, Prob: 0.3, Stall: time.Microsecond}, Rule{Kind: Xrun, Every: 10})
+	f.Seed(7)
+	dpx, _, _, err := f.OpenDuplex(nil, form, form, sample.SFloat32L, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dpx.Close()
+	out, in := make([]float64, 16), make([]float64, 16)
+	for i := range out {
+		out[i] = 1
+	}
+	for i := 1; i <= 100; i++ {
+		if _, err := dpx.SendReceive(out, in); err != nil {
+			t.Fatal(err)
+		}
+		if lost := in[0] == 0; lost != (i%10 == 0) {
+			t.Errorf("call %d: lost %t", i, lost)
+		}
+	}
+	if n := f.Count(Stall); n < 15 || n > 45 {
+		t.Errorf("%d stalls", n)
+	}
+	if n := dpx.(interface{ Xruns() int64 }).Xruns(); n != 10 {
+		t.Errorf("%d xruns", n)
+	}
+}
+
+// noNotify is an entry which doesn't support device notifications.
+type noNotify struct {
+	*pipe.Entry
+	t *testing.T
+}
+
+func (e *noNotify) DevicesNotify(c chan<- *host.DevChange) error {
+	return host.ErrUnsupported
+}
+
+func (e *noNotify) DevicesNotifyClose(c chan<- *host.DevChange) {
+	e.t.Errorf("closed notifications which were not subscribed")
+}
+
+func TestNotifyInjectedOnly(t *testing.T) {
+	e, d, done := pipeEntry(t)
+	defer done()
+	f := New(&noNotify{Entry: e, t: t}, Rule{Kind: Disconnect, Ops: OpReceive, At: 1})
+	c := make(chan *host.DevChange, 4)
+	if err := f.DevicesNotify(c); err != nil {
+		t.Fatal(err)
+	}
+	src, _, err := f.OpenSource(d, form, sample.SFloat64L, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if _, err := src.Receive(make([]float64, 10)); err != ErrDisconnected {
+		t.Errorf("got %v, want ErrDisconnected", err)
+	}
+	select {
+	case ch := <-c:
+		if ch.Sense != host.DeviceDisconnect || ch.Dev != d {
+			t.Errorf("got %v", ch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for disconnection")
+	}
+	f.DevicesNotifyClose(c)
+}
@@ -0,0 +1,150 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package fault
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+)
+
+// stream holds the fault state of a stream.
+type stream struct {
+	f     *Entry
+	d     *libsio.Dev
+	v     sound.Form
+	mu    sync.Mutex
+	err   error // sticky error after EOF and Disconnect faults
+	xruns int64
+}
+
+// Xruns returns the number of xruns injected into the stream.
+func (s *stream) Xruns() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.xruns
+}
+
+func (s *stream) failed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil && s.f.isGone(s.d) {
+		s.err = ErrDisconnected
+	}
+	return s.err
+}
+
+// faults returns the kinds of the faults of a call of op, after applying
+// stalls, EOF and disconnections, and the error with which the call fails,
+// if any.
+func (s *stream) faults(op Op, name string) (drop, xrun bool, err error) {
+	if err := s.failed(); err != nil {
+		return false, false, err
+	}
+	for _, r := range s.f.faults(op, name, s.d) {
+		switch r.Kind {
+		case Stall:
+			time.Sleep(r.stall())
+		case Drop:
+			drop = true
+		case Xrun:
+			xrun = true
+			s.mu.Lock()
+			s.xruns++
+			s.mu.Unlock()
+		case EOF:
+			s.fail(io.EOF)
+		case Disconnect:
+			s.f.mu.Lock()
+			s.f.disconnect(s.d)
+			s.f.mu.Unlock()
+			s.fail(ErrDisconnected)
+		}
+	}
+	return drop, xrun, s.failed()
+}
+
+func (s *stream) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// duration returns the duration of the frames of d.
+func (s *stream) duration(d []float64) time.Duration {
+	nF := len(d) / s.v.Channels()
+	return time.Duration(float64(nF) * float64(time.Second) / s.v.SampleRate().Float64())
+}
+
+type source struct {
+	sound.Source
+	s stream
+}
+
+func (src *source) Xruns() int64 {
+	return src.s.Xruns()
+}
+
+func (src *source) Receive(d []float64) (int, error) {
+	drop, xrun, err := src.s.faults(OpReceive, "Receive")
+	if err != nil {
+		return 0, err
+	}
+	if drop || xrun {
+		if _, err := src.Source.Receive(d); err != nil {
+			return 0, err
+		}
+	}
+	return src.Source.Receive(d)
+}
+
+type sink struct {
+	sound.Sink
+	s stream
+}
+
+func (snk *sink) Xruns() int64 {
+	return snk.s.Xruns()
+}
+
+func (snk *sink) Send(d []float64) error {
+	drop, xrun, err := snk.s.faults(OpSend, "Send")
+	if err != nil {
+		return err
+	}
+	if xrun {
+		time.Sleep(snk.s.duration(d))
+	} else if drop {
+		return nil
+	}
+	return snk.Sink.Send(d)
+}
+
+type duplex struct {
+	sound.Duplex
+	s stream
+}
+
+func (x *duplex) Xruns() int64 {
+	return x.s.Xruns()
+}
+
+func (x *duplex) SendReceive(out, in []float64) (int, error) {
+	drop, xrun, err := x.s.faults(OpDuplex, "SendReceive")
+	if err != nil {
+		return 0, err
+	}
+	n, err := x.Duplex.SendReceive(out, in)
+	if drop || xrun {
+		for i := range in {
+			in[i] = 0
+		}
+	}
+	return n, err
+}
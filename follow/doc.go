@@ -0,0 +1,24 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package follow provides sound.Sources and sound.Sinks which follow the
+// default device of a host.Entry.
+//
+// A Sink plays to the default output device and a Source captures from the
+// default input device.  When the default changes, for example when
+// headphones are plugged in, they open a stream on the new default device
+// and move to it between two calls to Send or Receive, so that the caller
+// sees no error.
+//
+// Changes are detected by means of host.Entry.DevicesNotify, or by polling
+// if the entry doesn't support notifications.  Streams on the new device
+// are opened with the supported form and sample codec closest to those
+// requested, and the data is converted between the two forms as in
+// libsio.Conv.  Where the old device is still working, the move is a short
+// crossfade; otherwise the new device fades in.
+//
+// If Send or Receive fails on the current device, they wait a short while
+// for the default to move to another device before returning the error.
+//
+// Package follow is part of http://zikichombo.org
+package follow /* import "zikichombo.org/sio/follow" */
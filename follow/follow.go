@@ -0,0 +1,246 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package follow
+
+import (
+	"errors"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// ErrClosed is returned by Send and Receive once a stream is closed.
+var ErrClosed = errors.New("stream closed")
+
+const (
+	// DefaultPoll is the default of Config.Poll.
+	DefaultPoll = 500 * time.Millisecond
+	// DefaultGrace is the default of Config.Grace.
+	DefaultGrace = time.Second
+)
+
+// Config configures how a stream follows the default device.  A nil
+// *Config uses the defaults.
+type Config struct {
+	// Poll is the interval at which the default device is checked when the
+	// entry doesn't support device notifications.  0 means DefaultPoll.
+	Poll time.Duration
+
+	// Fade is the length of the crossfade between devices, in frames of the
+	// stream.  0 means one buffer, negative values disable fading.
+	Fade int
+
+	// Grace is how long a failed Send or Receive waits for the default to
+	// move to another device before returning the error.  0 means
+	// DefaultGrace.
+	Grace time.Duration
+}
+
+func (c *Config) withDefaults(b int) Config {
+	var res Config
+	if c != nil {
+		res = *c
+	}
+	if res.Poll <= 0 {
+		res.Poll = DefaultPoll
+	}
+	if res.Fade == 0 {
+		res.Fade = b
+	}
+	if res.Fade < 0 {
+		res.Fade = 0
+	}
+	if res.Grace <= 0 {
+		res.Grace = DefaultGrace
+	}
+	return res
+}
+
+// sameDev returns whether a and b are the same device, by identity or by
+// UID.
+func sameDev(a, b *libsio.Dev) bool {
+	if a == b {
+		return true
+	}
+	if a == nil || b == nil {
+		return false
+	}
+	return a.UID != "" && a.UID == b.UID
+}
+
+func sameForm(a, b sound.Form) bool {
+	return a.Channels() == b.Channels() && a.SampleRate() == b.SampleRate()
+}
+
+// closest returns the form and sample codec closest to v and co supported
+// by c, or v and co if c is unknown.
+func closest(c *libsio.Caps, v sound.Form, co sample.Codec) (sound.Form, sample.Codec) {
+	if !c.Supported() {
+		return v, co
+	}
+	w, wco, err := c.Closest(v, co)
+	if err != nil {
+		return v, co
+	}
+	return w, wco
+}
+
+// target is a stream opened on a device, together with the state needed to
+// convert between the form of the device and that of the caller.
+type target struct {
+	dev  *libsio.Dev
+	v    sound.Form   // form of the device stream
+	cv   *libsio.Conv // nil if the forms are the same
+	snk  sound.Sink
+	src  sound.Source
+	dbuf []float64 // channel deinterleaved, form v
+	fifo []float64 // source only: interleaved frames in the caller's form
+	err  error     // source only: error deferred until fifo is empty
+}
+
+func (t *target) close() error {
+	if t.snk != nil {
+		return t.snk.Close()
+	}
+	return t.src.Close()
+}
+
+// watcher watches the default device of an entry and, when it changes,
+// opens a target on the new default and delivers it on nextC.
+type watcher struct {
+	e        host.Entry
+	dflt     func() *libsio.Dev
+	open     func(*libsio.Dev) (*target, error)
+	poll     time.Duration
+	cur      *libsio.Dev          // device of the last target opened
+	devC     chan *host.DevChange // nil if polling
+	nextC    chan *target
+	kickC    chan struct{}
+	doneC    chan struct{}
+	loopDone chan struct{}
+}
+
+func newWatcher(e host.Entry, dflt func() *libsio.Dev, open func(*libsio.Dev) (*target, error), cur *libsio.Dev, poll time.Duration) *watcher {
+	w := &watcher{
+		e:        e,
+		dflt:     dflt,
+		open:     open,
+		poll:     poll,
+		cur:      cur,
+		nextC:    make(chan *target, 1),
+		kickC:    make(chan struct{}, 1),
+		doneC:    make(chan struct{}),
+		loopDone: make(chan struct{})}
+	c := make(chan *host.DevChange, 8)
+	if err := e.DevicesNotify(c); err == nil {
+		w.devC = c
+	}
+	go w.run()
+	return w
+}
+
+func (w *watcher) run() {
+	defer close(w.loopDone)
+	var tick <-chan time.Time
+	if w.devC != nil {
+		defer w.e.DevicesNotifyClose(w.devC)
+	} else {
+		t := time.NewTicker(w.poll)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-w.devC:
+		case <-tick:
+		case <-w.kickC:
+		case <-w.doneC:
+			return
+		}
+		w.check()
+	}
+}
+
+// check opens a target on the default device if it changed.  A target
+// which hasn't been taken yet is replaced.
+func (w *watcher) check() {
+	d := w.dflt()
+	if d == nil || sameDev(d, w.cur) {
+		return
+	}
+	t, err := w.open(d)
+	if err != nil {
+		// try again on the next change or poll.
+		return
+	}
+	w.cur = d
+	select {
+	case old := <-w.nextC:
+		old.close()
+	default:
+	}
+	w.nextC <- t
+}
+
+// kick asks the watcher to check the default device now.
+func (w *watcher) kick() {
+	select {
+	case w.kickC <- struct{}{}:
+	default:
+	}
+}
+
+// wait waits up to d for a new target.
+func (w *watcher) wait(d time.Duration) *target {
+	w.kick()
+	tm := time.NewTimer(d)
+	defer tm.Stop()
+	select {
+	case t := <-w.nextC:
+		return t
+	case <-tm.C:
+		return nil
+	case <-w.doneC:
+		return nil
+	}
+}
+
+// next returns a new target if one is ready.
+func (w *watcher) next() *target {
+	select {
+	case t := <-w.nextC:
+		return t
+	default:
+		return nil
+	}
+}
+
+func (w *watcher) close() {
+	close(w.doneC)
+	<-w.loopDone
+	select {
+	case t := <-w.nextC:
+		t.close()
+	default:
+	}
+}
+
+// ramp returns the gain of the new device at frame f of a crossfade of n
+// frames.
+func ramp(f, n int) float64 {
+	if f >= n {
+		return 1
+	}
+	return float64(f) / float64(n)
+}
+
+func grow(d []float64, n int) []float64 {
+	if cap(d) < n {
+		return make([]float64, n)
+	}
+	return d[:n]
+}
@@ -0,0 +1,263 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package follow
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"zikichombo.org/sio/fault"
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sio/pipe"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+var form = sound.NewForm(8000*freq.Hertz, 1)
+
+// pollEntry hides the device notifications of a pipe entry.
+type pollEntry struct {
+	*pipe.Entry
+}
+
+func (e pollEntry) DevicesNotify(c chan<- *host.DevChange) error {
+	return host.ErrUnsupported
+}
+
+type fixture struct {
+	t    *testing.T
+	dir  string
+	e    *pipe.Entry
+	a, b *libsio.Dev
+}
+
+// newFixture returns a pipe entry with devices a and b, whose inputs hold
+// samples of value 1 and 2.
+func newFixture(t *testing.T) *fixture {
+	dir, err := ioutil.TempDir("", "follow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := &fixture{t: t, dir: dir, e: pipe.NewEntry()}
+	x.a = x.e.AddPath(&pipe.Path{Name: "a", In: x.input("a.in", 1), Out: filepath.Join(dir, "a.out")})
+	x.b = x.e.AddPath(&pipe.Path{Name: "b", In: x.input("b.in", 2), Out: filepath.Join(dir, "b.out")})
+	return x
+}
+
+func (x *fixture) input(name string, v float64) string {
+	d := make([]float64, 1000)
+	for i := range d {
+		d[i] = v
+	}
+	buf := make([]byte, len(d)*8)
+	sample.SFloat64L.Encode(buf, d)
+	p := filepath.Join(x.dir, name)
+	if err := ioutil.WriteFile(p, buf, 0644); err != nil {
+		x.t.Fatal(err)
+	}
+	return p
+}
+
+func (x *fixture) output(name string) []float64 {
+	buf, err := ioutil.ReadFile(filepath.Join(x.dir, name))
+	if err != nil {
+		x.t.Fatal(err)
+	}
+	d := make([]float64, len(buf)/8)
+	sample.SFloat64L.Decode(d, buf)
+	return d
+}
+
+func (x *fixture) done() {
+	os.RemoveAll(x.dir)
+}
+
+// waitNext waits until w has a target ready.
+func waitNext(t *testing.T, w *watcher) {
+	for i := 0; len(w.nextC) == 0; i++ {
+		if i == 1000 {
+			t.Fatal("timeout waiting for default device change")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func ones(n int) []float64 {
+	d := make([]float64, n)
+	for i := range d {
+		d[i] = 1
+	}
+	return d
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func testSink(t *testing.T, x *fixture, e host.Entry, cfg *Config) {
+	s, err := NewSink(e, form, sample.SFloat64L, 10, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := ones(10)
+	for i := 0; i < 3; i++ {
+		if err := s.Send(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	x.e.RemovePath(x.a)
+	waitNext(t, s.w)
+	for i := 0; i < 3; i++ {
+		if err := s.Send(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Dev() != x.b || s.Switches() != 1 {
+		t.Errorf("on %s after %d switches", s.Dev(), s.Switches())
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	a, b := x.output("a.out"), x.output("b.out")
+	if len(a) != 40 || len(b) != 30 {
+		t.Fatalf("got %d and %d samples", len(a), len(b))
+	}
+	for i := range b {
+		exp := 1.0
+		if i < 10 {
+			exp = float64(i) / 10
+			if !near(a[30+i]+b[i], 1) {
+				t.Errorf("crossfade frame %d: %f + %f", i, a[30+i], b[i])
+			}
+		}
+		if !near(b[i], exp) {
+			t.Errorf("frame %d: got %f, want %f", i, b[i], exp)
+		}
+	}
+}
+
+func TestSink(t *testing.T) {
+	x := newFixture(t)
+	defer x.done()
+	testSink(t, x, x.e, nil)
+}
+
+func TestSinkPoll(t *testing.T) {
+	x := newFixture(t)
+	defer x.done()
+	testSink(t, x, pollEntry{x.e}, &Config{Poll: 5 * time.Millisecond})
+}
+
+func TestSinkConvert(t *testing.T) {
+	x := newFixture(t)
+	defer x.done()
+	x.b.Out = libsio.Caps{
+		MinChannels: 1,
+		MaxChannels: 1,
+		Rates:       []freq.T{16000 * freq.Hertz},
+		Codecs:      []sample.Codec{sample.SFloat64L}}
+	v := sound.NewForm(8000*freq.Hertz, 2)
+	s, err := NewSink(x.e, v, sample.SFloat64L, 10, &Config{Fade: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	x.e.RemovePath(x.a)
+	waitNext(t, s.w)
+	d := ones(20)
+	for i := 0; i < 10; i++ {
+		if err := s.Send(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+	b := x.output("b.out")
+	if len(b) < 195 || len(b) > 200 {
+		t.Errorf("got %d frames, want about 200", len(b))
+	}
+	for i, v := range b {
+		if !near(v, 1) {
+			t.Fatalf("frame %d: got %f", i, v)
+		}
+	}
+}
+
+func TestSinkDisconnect(t *testing.T) {
+	x := newFixture(t)
+	defer x.done()
+	f := fault.New(x.e, fault.Rule{Kind: fault.Disconnect, Ops: fault.OpSend, At: 3})
+	s, err := NewSink(f, form, sample.SFloat64L, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := ones(10)
+	for i := 0; i < 4; i++ {
+		if err := s.Send(d); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	if s.Dev() != x.b {
+		t.Errorf("on %s", s.Dev())
+	}
+	s.Close()
+	if b := x.output("b.out"); len(b) != 20 || b[0] != 0 || b[19] != 1 {
+		t.Errorf("got %v", b)
+	}
+}
+
+func TestSource(t *testing.T) {
+	x := newFixture(t)
+	defer x.done()
+	s, err := NewSource(x.e, form, sample.SFloat64L, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	d := make([]float64, 10)
+	check := func(exp func(int) float64) {
+		n, err := s.Receive(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 10 {
+			t.Fatalf("got %d frames", n)
+		}
+		for i, v := range d {
+			if !near(v, exp(i)) {
+				t.Errorf("frame %d: got %f, want %f", i, v, exp(i))
+			}
+		}
+	}
+	check(func(int) float64 { return 1 })
+	x.e.RemovePath(x.a)
+	waitNext(t, s.w)
+	check(func(i int) float64 { return 1 + float64(i)/10 })
+	check(func(int) float64 { return 2 })
+	if s.Dev() != x.b || s.Switches() != 1 {
+		t.Errorf("on %s after %d switches", s.Dev(), s.Switches())
+	}
+}
+
+func TestClose(t *testing.T) {
+	x := newFixture(t)
+	defer x.done()
+	s, err := NewSink(x.e, form, sample.SFloat64L, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x.e.RemovePath(x.a)
+	waitNext(t, s.w)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(ones(10)); err != ErrClosed {
+		t.Errorf("got %v, want ErrClosed", err)
+	}
+}
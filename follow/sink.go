@@ -0,0 +1,226 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package follow
+
+import (
+	"sync"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Sink is a sound.Sink which plays to the default output device of an
+// entry, following it when it changes.
+type Sink struct {
+	sound.Form
+	e   host.Entry
+	co  sample.Codec
+	b   int
+	cfg Config
+	w   *watcher
+
+	// used only by Send, and by Close under mu.
+	cur, old *target
+	fade     int       // frames of the current crossfade done.
+	buf      []float64 // faded copy of the data sent.
+
+	mu       sync.Mutex
+	closed   bool
+	switches int
+}
+
+// NewSink opens a Sink in form v with sample codec co and buffer size b on
+// the default output device of e.
+func NewSink(e host.Entry, v sound.Form, co sample.Codec, b int, cfg *Config) (*Sink, error) {
+	if !e.CanOpenSink() {
+		return nil, host.ErrUnsupported
+	}
+	s := &Sink{
+		Form: v,
+		e:    e,
+		co:   co,
+		b:    b,
+		cfg:  cfg.withDefaults(b)}
+	s.fade = s.cfg.Fade
+	d := e.DefaultOutputDev()
+	t, err := s.open(d)
+	if err != nil {
+		return nil, err
+	}
+	s.cur = t
+	s.w = newWatcher(e, e.DefaultOutputDev, s.open, d, s.cfg.Poll)
+	return s, nil
+}
+
+// open opens a sink on d in the supported form closest to that of s.  It
+// is called by the watcher goroutine.
+func (s *Sink) open(d *libsio.Dev) (*target, error) {
+	v, co, b := sound.Form(s), s.co, s.b
+	if d != nil {
+		v, co = closest(&d.Out, v, co)
+		b = d.Out.ClosestPeriod(b)
+	}
+	snk, _, err := s.e.OpenSink(d, v, co, b)
+	if err != nil {
+		return nil, err
+	}
+	t := &target{dev: d, v: v, snk: snk}
+	if !sameForm(s, v) {
+		t.cv = libsio.NewConv(s, v)
+	}
+	return t, nil
+}
+
+// Dev returns the device currently played to.
+func (s *Sink) Dev() *libsio.Dev {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cur.dev
+}
+
+// Switches returns the number of times s moved to another device.
+func (s *Sink) Switches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.switches
+}
+
+// Send is as in sound.Sink.Send.
+func (s *Sink) Send(d []float64) error {
+	if len(d)%s.Channels() != 0 {
+		return sound.ErrChannelAlignment
+	}
+	if t := s.w.next(); t != nil {
+		if err := s.move(t, true); err != nil {
+			return err
+		}
+	}
+	for {
+		err := s.send(d)
+		if err == nil {
+			return nil
+		}
+		if s.isClosed() {
+			return ErrClosed
+		}
+		t := s.w.wait(s.cfg.Grace)
+		if t == nil {
+			return err
+		}
+		if err := s.move(t, false); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Sink) send(d []float64) error {
+	n := s.cfg.Fade
+	if s.fade >= n {
+		return write(s.cur, d)
+	}
+	nC := s.Channels()
+	nF := len(d) / nC
+	s.buf = grow(s.buf, len(d))
+	for c := 0; c < nC; c++ {
+		for f := 0; f < nF; f++ {
+			s.buf[c*nF+f] = d[c*nF+f] * ramp(s.fade+f, n)
+		}
+	}
+	if err := write(s.cur, s.buf); err != nil {
+		return err
+	}
+	if s.old != nil {
+		for c := 0; c < nC; c++ {
+			for f := 0; f < nF; f++ {
+				s.buf[c*nF+f] = d[c*nF+f] * (1 - ramp(s.fade+f, n))
+			}
+		}
+		if err := write(s.old, s.buf); err != nil {
+			s.dropOld()
+		}
+	}
+	s.fade += nF
+	if s.fade >= n {
+		s.dropOld()
+	}
+	return nil
+}
+
+// write sends d, in the form of the caller, to t.
+func write(t *target, d []float64) error {
+	if t.cv == nil {
+		return t.snk.Send(d)
+	}
+	x := t.cv.Convert(d)
+	nC := t.v.Channels()
+	nF := len(x) / nC
+	if nF == 0 {
+		return nil
+	}
+	t.dbuf = grow(t.dbuf, len(x))
+	for f := 0; f < nF; f++ {
+		for c := 0; c < nC; c++ {
+			t.dbuf[c*nF+f] = x[f*nC+c]
+		}
+	}
+	return t.snk.Send(t.dbuf)
+}
+
+// move makes t the current target.  If fade is true, the current target is
+// kept for a crossfade, otherwise it is closed and t fades in.
+func (s *Sink) move(t *target, fade bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		t.close()
+		return ErrClosed
+	}
+	if s.old != nil {
+		s.old.close()
+		s.old = nil
+	}
+	if fade && s.cfg.Fade > 0 {
+		s.old = s.cur
+	} else {
+		s.cur.close()
+	}
+	s.cur = t
+	s.fade = 0
+	s.switches++
+	return nil
+}
+
+func (s *Sink) dropOld() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.old != nil && !s.closed {
+		s.old.close()
+	}
+	s.old = nil
+}
+
+func (s *Sink) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Close closes s and the streams it has open.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	cur, old := s.cur, s.old
+	s.mu.Unlock()
+	s.w.close()
+	if old != nil {
+		old.close()
+	}
+	return cur.close()
+}
@@ -0,0 +1,279 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package follow
+
+import (
+	"sync"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Source is a sound.Source which captures from the default input device of
+// an entry, following it when it changes.
+type Source struct {
+	sound.Form
+	e   host.Entry
+	co  sample.Codec
+	b   int
+	cfg Config
+	w   *watcher
+
+	// used only by Receive, and by Close under mu.
+	cur, old *target
+	fade     int // frames of the current crossfade done.
+
+	mu       sync.Mutex
+	closed   bool
+	switches int
+}
+
+// NewSource opens a Source in form v with sample codec co and buffer size
+// b on the default input device of e.
+func NewSource(e host.Entry, v sound.Form, co sample.Codec, b int, cfg *Config) (*Source, error) {
+	if !e.CanOpenSource() {
+		return nil, host.ErrUnsupported
+	}
+	s := &Source{
+		Form: v,
+		e:    e,
+		co:   co,
+		b:    b,
+		cfg:  cfg.withDefaults(b)}
+	s.fade = s.cfg.Fade
+	d := e.DefaultInputDev()
+	t, err := s.open(d)
+	if err != nil {
+		return nil, err
+	}
+	s.cur = t
+	s.w = newWatcher(e, e.DefaultInputDev, s.open, d, s.cfg.Poll)
+	return s, nil
+}
+
+// open opens a source on d in the supported form closest to that of s.  It
+// is called by the watcher goroutine.
+func (s *Source) open(d *libsio.Dev) (*target, error) {
+	v, co, b := sound.Form(s), s.co, s.b
+	if d != nil {
+		v, co = closest(&d.In, v, co)
+		b = d.In.ClosestPeriod(b)
+	}
+	src, _, err := s.e.OpenSource(d, v, co, b)
+	if err != nil {
+		return nil, err
+	}
+	t := &target{dev: d, v: v, src: src, dbuf: make([]float64, b*v.Channels())}
+	if !sameForm(s, v) {
+		t.cv = libsio.NewConv(v, s)
+	}
+	return t, nil
+}
+
+// Dev returns the device currently captured from.
+func (s *Source) Dev() *libsio.Dev {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cur.dev
+}
+
+// Switches returns the number of times s moved to another device.
+func (s *Source) Switches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.switches
+}
+
+// Receive is as in sound.Source.Receive.
+func (s *Source) Receive(d []float64) (int, error) {
+	nC := s.Channels()
+	if len(d)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	if t := s.w.next(); t != nil {
+		if err := s.move(t, true); err != nil {
+			return 0, err
+		}
+	}
+	for {
+		n, err := s.receive(d)
+		if err == nil {
+			return n, nil
+		}
+		if s.isClosed() {
+			return 0, ErrClosed
+		}
+		t := s.w.wait(s.cfg.Grace)
+		if t == nil {
+			return 0, err
+		}
+		if err := s.move(t, false); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (s *Source) receive(d []float64) (int, error) {
+	nC := s.Channels()
+	nF := len(d) / nC
+	n, err := s.fill(s.cur, nF)
+	if err != nil {
+		return 0, err
+	}
+	N := s.cfg.Fade
+	s.take(s.cur, d, n, nF)
+	if s.fade >= N {
+		return n, nil
+	}
+	if s.old != nil {
+		m, err := s.fill(s.old, n)
+		if err != nil {
+			s.dropOld()
+		} else {
+			s.add(s.old, d, m, nF)
+		}
+	}
+	s.fade += n
+	if s.fade >= N {
+		s.dropOld()
+	}
+	return n, nil
+}
+
+// fill reads from t until its fifo holds nF frames, or t has no more
+// frames for now, and returns the number of frames available, at most nF.
+func (s *Source) fill(t *target, nF int) (int, error) {
+	nC := s.Channels()
+	for len(t.fifo) < nF*nC && t.err == nil {
+		n, err := t.src.Receive(t.dbuf)
+		if n > 0 {
+			s.push(t, n)
+		}
+		if err != nil {
+			t.err = err
+			break
+		}
+		if n < len(t.dbuf)/t.v.Channels() {
+			break
+		}
+	}
+	n := len(t.fifo) / nC
+	if n == 0 {
+		err := t.err
+		t.err = nil
+		return 0, err
+	}
+	if n > nF {
+		n = nF
+	}
+	return n, nil
+}
+
+// push converts the first n frames of t.dbuf to the form of s and appends
+// them to t.fifo.
+func (s *Source) push(t *target, n int) {
+	dC := t.v.Channels()
+	stride := len(t.dbuf) / dC
+	if t.cv == nil {
+		for f := 0; f < n; f++ {
+			for c := 0; c < dC; c++ {
+				t.fifo = append(t.fifo, t.dbuf[c*stride+f])
+			}
+		}
+		return
+	}
+	d := t.dbuf
+	if n < stride {
+		for c := 1; c < dC; c++ {
+			copy(d[c*n:(c+1)*n], d[c*stride:c*stride+n])
+		}
+		d = d[:n*dC]
+	}
+	t.fifo = append(t.fifo, t.cv.Convert(d)...)
+}
+
+// take moves n frames from t.fifo to d, whose channel stride is nF,
+// fading them in during a crossfade.
+func (s *Source) take(t *target, d []float64, n, nF int) {
+	nC := s.Channels()
+	N := s.cfg.Fade
+	for f := 0; f < n; f++ {
+		g := ramp(s.fade+f, N)
+		for c := 0; c < nC; c++ {
+			d[c*nF+f] = t.fifo[f*nC+c] * g
+		}
+	}
+	t.fifo = t.fifo[:copy(t.fifo, t.fifo[n*nC:])]
+}
+
+// add fades out n frames of t into d, whose channel stride is nF.
+func (s *Source) add(t *target, d []float64, n, nF int) {
+	nC := s.Channels()
+	N := s.cfg.Fade
+	for f := 0; f < n; f++ {
+		g := 1 - ramp(s.fade+f, N)
+		for c := 0; c < nC; c++ {
+			d[c*nF+f] += t.fifo[f*nC+c] * g
+		}
+	}
+	t.fifo = t.fifo[:copy(t.fifo, t.fifo[n*nC:])]
+}
+
+// move makes t the current target.  If fade is true, the current target is
+// kept for a crossfade, otherwise it is closed and t fades in.
+func (s *Source) move(t *target, fade bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		t.close()
+		return ErrClosed
+	}
+	if s.old != nil {
+		s.old.close()
+		s.old = nil
+	}
+	if fade && s.cfg.Fade > 0 {
+		s.old = s.cur
+	} else {
+		s.cur.close()
+	}
+	s.cur = t
+	s.fade = 0
+	s.switches++
+	return nil
+}
+
+func (s *Source) dropOld() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.old != nil && !s.closed {
+		s.old.close()
+	}
+	s.old = nil
+}
+
+func (s *Source) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Close closes s and the streams it has open.
+func (s *Source) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	cur, old := s.cur, s.old
+	s.mu.Unlock()
+	s.w.close()
+	if old != nil {
+		old.close()
+	}
+	return cur.close()
+}
@@ -0,0 +1,20 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package guard provides an output guard which protects speakers and ears
+// from broken signals sent to a sink.
+//
+// A guard Sink either hard clips samples to a ceiling or, as a true peak
+// limiter, attenuates the signal just enough to keep its peaks, including
+// those between samples, under the ceiling.  It mutes the output at once,
+// and until Unmute is called, if it is sent NaN or infinite samples or a
+// sustained DC offset, which usually indicate a bug upstream.
+//
+// Clipped and limited samples and mutes are counted, see Stats, and
+// reported as Events.
+//
+// Sinks are usually guarded via host.SinkOptions, so that any entry
+// benefits from a guard.
+//
+// Package guard is part of http://zikichombo.org
+package guard /* import "zikichombo.org/sio/guard" */
@@ -0,0 +1,360 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package guard
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"zikichombo.org/sound"
+)
+
+// Mode selects how a Sink keeps samples under its ceiling.
+type Mode int
+
+const (
+	// Clip hard clips samples to the ceiling.
+	Clip Mode = iota
+	// Limit attenuates the signal so that its true peak stays under the
+	// ceiling, delaying it by the lookahead.
+	Limit
+)
+
+var modeNames = [...]string{"clip", "limit"}
+
+func (m Mode) String() string {
+	if m < 0 || int(m) >= len(modeNames) {
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+	return modeNames[m]
+}
+
+// ParseMode returns the Mode whose String is s.
+func ParseMode(s string) (Mode, error) {
+	for i, n := range modeNames {
+		if n == s {
+			return Mode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown guard mode %q", s)
+}
+
+const (
+	// DefaultLookahead is the default of Config.Lookahead.
+	DefaultLookahead = time.Millisecond
+	// DefaultRelease is the default of Config.Release.
+	DefaultRelease = 50 * time.Millisecond
+	// DefaultDC is the default of Config.DC.
+	DefaultDC = 0.25
+	// DefaultDCWindow is the default of Config.DCWindow.
+	DefaultDCWindow = 100 * time.Millisecond
+)
+
+// Config configures a Sink.  A nil *Config hard clips at full scale with
+// the default DC detection.
+type Config struct {
+	Mode Mode
+
+	// Ceiling is the largest absolute value of output samples.  0 means
+	// 1, full scale.
+	Ceiling float64
+
+	// Lookahead is the delay of the limiter, during which it reduces the
+	// gain ahead of a peak.  0 means DefaultLookahead.
+	Lookahead time.Duration
+
+	// Release is the time constant with which the limiter restores the
+	// gain after a peak.  0 means DefaultRelease.
+	Release time.Duration
+
+	// DC is the absolute mean value of a channel over DCWindow above which
+	// the output is muted.  0 means DefaultDC, negative values disable DC
+	// detection.
+	DC float64
+
+	// DCWindow is the time constant of the mean used for DC detection.  0
+	// means DefaultDCWindow.
+	DCWindow time.Duration
+
+	// Events, if not nil, receives Events.  Events are dropped if Events
+	// isn't ready.
+	Events chan<- *Event
+}
+
+func (c *Config) withDefaults() Config {
+	var res Config
+	if c != nil {
+		res = *c
+	}
+	if res.Ceiling <= 0 {
+		res.Ceiling = 1
+	}
+	if res.Lookahead <= 0 {
+		res.Lookahead = DefaultLookahead
+	}
+	if res.Release <= 0 {
+		res.Release = DefaultRelease
+	}
+	if res.DC == 0 {
+		res.DC = DefaultDC
+	}
+	if res.DCWindow <= 0 {
+		res.DCWindow = DefaultDCWindow
+	}
+	return res
+}
+
+// EventKind is the kind of an Event.
+type EventKind int
+
+const (
+	// Clipped indicates samples were hard clipped.
+	Clipped EventKind = iota
+	// Limited indicates frames were attenuated by the limiter.
+	Limited
+	// Muted indicates the output was muted.
+	Muted
+)
+
+var kindNames = [...]string{"clipped", "limited", "muted"}
+
+func (k EventKind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("EventKind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// Event reports what a Sink did to the data of one call to Send.
+type Event struct {
+	Kind EventKind
+	// Frame is the index of the first frame of the Send.
+	Frame int64
+	// Count is the number of samples clipped, or of frames limited.
+	Count int
+	// Reason is why the output was muted: "NaN", "Inf" or "DC".
+	Reason string
+}
+
+func (ev *Event) String() string {
+	if ev.Kind == Muted {
+		return fmt.Sprintf("%s at frame %d: %s", ev.Kind, ev.Frame, ev.Reason)
+	}
+	return fmt.Sprintf("%s %d at frame %d", ev.Kind, ev.Count, ev.Frame)
+}
+
+// Stats are the counters of a Sink.
+type Stats struct {
+	// Frames is the number of frames sent.
+	Frames int64
+	// Clipped is the number of samples hard clipped.
+	Clipped int64
+	// Limited is the number of frames attenuated by the limiter.
+	Limited int64
+	// NonFinite is the number of NaN or infinite samples.
+	NonFinite int64
+	// Mutes is the number of times the output was muted.
+	Mutes int64
+	// Muted is true while the output is muted.
+	Muted bool
+}
+
+// Sink is a guarded sound.Sink.
+type Sink struct {
+	sound.Sink
+	cfg Config
+	nC  int
+	buf []float64 // channel deinterleaved copy of the data sent.
+	dc  []float64 // per channel mean
+	dcA float64   // coefficient of the mean
+	lim *limiter  // nil for Clip
+
+	mu     sync.Mutex
+	stats  Stats
+	start  *time.Time // start time of the guarded sink, see Start
+	startT time.Time
+}
+
+// NewSink returns a Sink guarding snk.
+func NewSink(snk sound.Sink, cfg *Config) *Sink {
+	c := cfg.withDefaults()
+	nC := snk.Channels()
+	sr := snk.SampleRate().Float64()
+	s := &Sink{
+		Sink: snk,
+		cfg:  c,
+		nC:   nC,
+		dc:   make([]float64, nC),
+		dcA:  1 - math.Exp(-1/(c.DCWindow.Seconds()*sr))}
+	if c.Mode == Limit {
+		s.lim = newLimiter(nC, sr, &c)
+	}
+	return s
+}
+
+// Stats returns the counters of s.
+func (s *Sink) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Delay returns the delay of the data sent to s, that of the limiter, or
+// 0 when clipping.
+func (s *Sink) Delay() time.Duration {
+	if s.lim == nil {
+		return 0
+	}
+	return time.Duration(s.lim.delay()) * s.SampleRate().Period()
+}
+
+// Start returns the start time of s, the time of the first frame sent to
+// s, from the start time t of the guarded sink as returned by
+// host.Entry.OpenSink.  It is t plus the Delay of s, and is set as t is,
+// by the first Send which sets t.
+func (s *Sink) Start(t *time.Time) *time.Time {
+	if s.lim == nil || t == nil {
+		return t
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start = t
+	return &s.startT
+}
+
+// Unmute unmutes the output after a mute, resetting the state of the
+// guard.
+func (s *Sink) Unmute() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Muted = false
+	for i := range s.dc {
+		s.dc[i] = 0
+	}
+	if s.lim != nil {
+		s.lim.reset()
+	}
+}
+
+// Send guards the data in d, which isn't modified, and sends it.
+func (s *Sink) Send(d []float64) error {
+	nC := s.nC
+	if len(d)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	nF := len(d) / nC
+	if cap(s.buf) < len(d) {
+		s.buf = make([]float64, len(d))
+	}
+	buf := s.buf[:len(d)]
+	copy(buf, d)
+	s.mu.Lock()
+	frame := s.stats.Frames
+	s.stats.Frames += int64(nF)
+	if !s.stats.Muted {
+		if reason := s.check(buf, nF); reason != "" {
+			s.stats.Muted = true
+			s.stats.Mutes++
+			s.event(&Event{Kind: Muted, Frame: frame, Reason: reason})
+		}
+	}
+	if s.stats.Muted {
+		for i := range buf {
+			buf[i] = 0
+		}
+	} else if s.lim != nil {
+		n := s.lim.process(buf, nF)
+		s.stats.Limited += int64(n)
+		if n > 0 {
+			s.event(&Event{Kind: Limited, Frame: frame, Count: n})
+		}
+	}
+	if n := s.clip(buf); n > 0 {
+		s.stats.Clipped += int64(n)
+		s.event(&Event{Kind: Clipped, Frame: frame, Count: n})
+	}
+	s.mu.Unlock()
+	if err := s.Sink.Send(buf); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.start != nil && s.startT.IsZero() && !s.start.IsZero() {
+		s.startT = s.start.Add(s.Delay())
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// check counts non finite samples and tracks the mean of each channel,
+// returning why the output should be muted, if it should.
+func (s *Sink) check(d []float64, nF int) string {
+	reason := ""
+	for c := 0; c < s.nC; c++ {
+		m := s.dc[c]
+		for _, v := range d[c*nF : (c+1)*nF] {
+			if math.IsNaN(v) {
+				s.stats.NonFinite++
+				reason = "NaN"
+				continue
+			}
+			if math.IsInf(v, 0) {
+				s.stats.NonFinite++
+				reason = "Inf"
+				continue
+			}
+			m += (v - m) * s.dcA
+		}
+		s.dc[c] = m
+		if reason == "" && s.cfg.DC > 0 && math.Abs(m) > s.cfg.DC {
+			reason = "DC"
+		}
+	}
+	return reason
+}
+
+// clip clips d to the ceiling and returns the number of clipped samples.
+func (s *Sink) clip(d []float64) int {
+	ceil := s.cfg.Ceiling
+	n := 0
+	for i, v := range d {
+		if v > ceil {
+			d[i] = ceil
+			n++
+		} else if v < -ceil {
+			d[i] = -ceil
+			n++
+		}
+	}
+	return n
+}
+
+// event sends ev without blocking.
+func (s *Sink) event(ev *Event) {
+	if s.cfg.Events == nil {
+		return
+	}
+	select {
+	case s.cfg.Events <- ev:
+	default:
+	}
+}
+
+// Close sends the data remaining in the limiter and closes the underlying
+// sink.
+func (s *Sink) Close() error {
+	if s.lim != nil {
+		s.mu.Lock()
+		n := s.lim.delay()
+		buf := make([]float64, n*s.nC)
+		if !s.stats.Muted {
+			s.lim.process(buf, n)
+			s.clip(buf)
+		}
+		s.mu.Unlock()
+		s.Sink.Send(buf)
+	}
+	return s.Sink.Close()
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package guard
+
+import (
+	"math"
+	"testing"
+
+	"zikichombo.org/sound"
+)
+
+type capSink struct {
+	sound.Form
+	d      []float64
+	closed bool
+}
+
+func (s *capSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func (s *capSink) Send(d []float64) error {
+	s.d = append(s.d, d...)
+	return nil
+}
+
+func TestClip(t *testing.T) {
+	evs := make(chan *Event, 4)
+	snk := &capSink{Form: sound.StereoCd()}
+	g := NewSink(snk, &Config{Ceiling: 0.5, Events: evs})
+	d := []float64{0.1, 0.7, -0.9, 0.2}
+	if err := g.Send(d); err != nil {
+		t.Fatal(err)
+	}
+	if d[1] != 0.7 {
+		t.Error("sent data modified")
+	}
+	for i, exp := range []float64{0.1, 0.5, -0.5, 0.2} {
+		if snk.d[i] != exp {
+			t.Errorf("sample %d: got %f, want %f", i, snk.d[i], exp)
+		}
+	}
+	if st := g.Stats(); st.Clipped != 2 || st.Frames != 2 || st.Muted {
+		t.Errorf("got %+v", st)
+	}
+	if ev := <-evs; ev.Kind != Clipped || ev.Count != 2 {
+		t.Errorf("got %s", ev)
+	}
+	if err := g.Send(d[:3]); err != sound.ErrChannelAlignment {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestMute(t *testing.T) {
+	evs := make(chan *Event, 4)
+	snk := &capSink{Form: sound.MonoCd()}
+	g := NewSink(snk, &Config{Events: evs})
+	g.Send([]float64{0.1, math.NaN(), 0.2})
+	g.Send([]float64{0.3, 0.4})
+	for i, x := range snk.d {
+		if x != 0 {
+			t.Errorf("sample %d: got %f", i, x)
+		}
+	}
+	if st := g.Stats(); !st.Muted || st.Mutes != 1 || st.NonFinite != 1 {
+		t.Errorf("got %+v", st)
+	}
+	if ev := <-evs; ev.Kind != Muted || ev.Reason != "NaN" {
+		t.Errorf("got %s", ev)
+	}
+	g.Unmute()
+	g.Send([]float64{0.3})
+	if x := snk.d[len(snk.d)-1]; x != 0.3 {
+		t.Errorf("unmuted: got %f", x)
+	}
+
+	// a DC offset mutes within about its window.
+	g = NewSink(snk, &Config{Events: evs})
+	dc := make([]float64, 44100)
+	for i := range dc {
+		dc[i] = 0.5
+	}
+	g.Send(dc)
+	if ev := <-evs; ev.Kind != Muted || ev.Reason != "DC" {
+		t.Errorf("got %s", ev)
+	}
+	// unless disabled.
+	g = NewSink(snk, &Config{DC: -1})
+	g.Send(dc)
+	if g.Stats().Muted {
+		t.Error("muted with DC detection disabled")
+	}
+}
+
+func sine(n int, amp, f float64) []float64 {
+	d := make([]float64, n)
+	for i := range d {
+		d[i] = amp * math.Sin(2*math.Pi*f*float64(i)/44100)
+	}
+	return d
+}
+
+func TestLimit(t *testing.T) {
+	snk := &capSink{Form: sound.MonoCd()}
+	g := NewSink(snk, &Config{Mode: Limit, Ceiling: 0.8})
+	dl := g.lim.delay()
+	// quiet signals pass through delayed.
+	d := sine(1000, 0.5, 440)
+	for i := 0; i < len(d); i += 100 {
+		g.Send(d[i : i+100])
+	}
+	for i := dl; i < len(d); i++ {
+		if snk.d[i] != d[i-dl] {
+			t.Fatalf("sample %d: got %f, want %f", i, snk.d[i], d[i-dl])
+		}
+	}
+	if st := g.Stats(); st.Limited != 0 || st.Clipped != 0 {
+		t.Errorf("got %+v", st)
+	}
+
+	// loud ones are limited without clipping, including a sine near
+	// nyquist whose samples miss its peaks.
+	for _, f := range []float64{440, 11025} {
+		snk.d = nil
+		g = NewSink(snk, &Config{Mode: Limit, Ceiling: 0.8})
+		d = sine(4410, 2, f)
+		for i := 0; i < len(d); i += 441 {
+			g.Send(d[i : i+441])
+		}
+		if err := g.Close(); err != nil || !snk.closed {
+			t.Errorf("close: %v", err)
+		}
+		if len(snk.d) != len(d)+dl {
+			t.Errorf("%f Hz: got %d samples, want %d", f, len(snk.d), len(d)+dl)
+		}
+		st := g.Stats()
+		if st.Clipped != 0 || st.Limited == 0 {
+			t.Errorf("%f Hz: got %+v", f, st)
+		}
+		pk := 0.0
+		for i := 0; i+3 < len(snk.d); i++ {
+			x := snk.d[i : i+4]
+			if p := math.Max(math.Abs(x[1]), segPeak(x[0], x[1], x[2], x[3])); p > pk {
+				pk = p
+			}
+		}
+		if pk > 0.8+1e-9 {
+			t.Errorf("%f Hz: true peak %f", f, pk)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for m := Clip; m <= Limit; m++ {
+		if p, err := ParseMode(m.String()); err != nil || p != m {
+			t.Errorf("%s: got %s, %v", m, p, err)
+		}
+	}
+	if _, err := ParseMode("nope"); err == nil {
+		t.Error("parsed nope")
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package guard
+
+import "math"
+
+// margin keeps rounding errors in the gains from exceeding the ceiling.
+const margin = 1 - 1e-9
+
+// limiter is a lookahead true peak limiter.
+//
+// The true peak around a frame is estimated from the frame and 4 times
+// oversampled cubic interpolations of the segments on either side of it,
+// which needs 2 frames beyond it.  The gain each frame requires is then
+// delayed by the lookahead of l frames: the gain applied to a frame is the
+// average over l+1 frames of the minimum over l+1 frames of the required
+// gains, which never exceeds the gain the frame requires and ramps down
+// smoothly ahead of a peak.  The release is a one pole filter towards 1
+// bounded by that average.
+type limiter struct {
+	nC   int
+	l    int
+	ceil float64
+	rel  float64
+
+	hist []float64 // last 4 input samples per channel
+	seg  []float64 // true peak of the last segment per channel
+	dl   []float64 // delay line of l frames, interleaved
+	dlp  int
+	req  []float64 // required gains, ring of l+1
+	mins []float64 // minima of req, ring of l+1
+	rp   int
+	sum  float64 // sum of mins
+	g    float64
+}
+
+func newLimiter(nC int, sr float64, c *Config) *limiter {
+	l := int(math.Floor(c.Lookahead.Seconds()*sr + 0.5))
+	if l < 1 {
+		l = 1
+	}
+	m := &limiter{
+		nC:   nC,
+		l:    l,
+		ceil: c.Ceiling,
+		rel:  1 - math.Exp(-1/(c.Release.Seconds()*sr)),
+		hist: make([]float64, 4*nC),
+		seg:  make([]float64, nC),
+		dl:   make([]float64, l*nC),
+		req:  make([]float64, l+1),
+		mins: make([]float64, l+1)}
+	m.reset()
+	return m
+}
+
+// delay returns the delay in frames of the limiter.
+func (m *limiter) delay() int {
+	return m.l + 2
+}
+
+func (m *limiter) reset() {
+	for i := range m.hist {
+		m.hist[i] = 0
+	}
+	for i := range m.seg {
+		m.seg[i] = 0
+	}
+	for i := range m.dl {
+		m.dl[i] = 0
+	}
+	for i := range m.req {
+		m.req[i] = 1
+		m.mins[i] = 1
+	}
+	m.dlp, m.rp = 0, 0
+	m.sum = float64(m.l + 1)
+	m.g = 1
+}
+
+// process limits the channel deinterleaved nF frames in d in place and
+// returns the number of frames attenuated.
+func (m *limiter) process(d []float64, nF int) int {
+	nC, l := m.nC, m.l
+	n := 0
+	for f := 0; f < nF; f++ {
+		// the frame 2 frames back is the one now entering the delay line.
+		pk := 0.0
+		for c := 0; c < nC; c++ {
+			h := m.hist[4*c : 4*c+4]
+			x := d[c*nF+f]
+			s := segPeak(h[1], h[2], h[3], x)
+			p := math.Max(m.seg[c], s)
+			if a := math.Abs(h[2]); a > p {
+				p = a
+			}
+			m.seg[c] = s
+			if p > pk {
+				pk = p
+			}
+			copy(h, h[1:])
+			h[3] = x
+			i := m.dlp*nC + c
+			d[c*nF+f], m.dl[i] = m.dl[i], h[1]
+		}
+		m.dlp++
+		if m.dlp == l {
+			m.dlp = 0
+		}
+		req := 1.0
+		if pk > m.ceil {
+			req = m.ceil / pk * margin
+		}
+		m.req[m.rp] = req
+		mn := req
+		for _, r := range m.req {
+			if r < mn {
+				mn = r
+			}
+		}
+		m.sum += mn - m.mins[m.rp]
+		m.mins[m.rp] = mn
+		m.rp++
+		if m.rp == l+1 {
+			// recompute the sum so its rounding errors don't accumulate.
+			m.rp, m.sum = 0, 0
+			for _, v := range m.mins {
+				m.sum += v
+			}
+		}
+		g := m.g + (1-m.g)*m.rel
+		if avg := m.sum / float64(l+1); avg < g {
+			g = avg
+		}
+		m.g = g
+		if g >= 1 {
+			continue
+		}
+		n++
+		for c := 0; c < nC; c++ {
+			d[c*nF+f] *= g
+		}
+	}
+	return n
+}
+
+// segPeak returns the absolute peak of the cubic interpolation between x1
+// and x2 at 1/4, 1/2 and 3/4.
+func segPeak(x0, x1, x2, x3 float64) float64 {
+	p := 0.0
+	for _, t := range [...]float64{0.25, 0.5, 0.75} {
+		v := catmullRom(x0, x1, x2, x3, t)
+		if v < 0 {
+			v = -v
+		}
+		if v > p {
+			p = v
+		}
+	}
+	return p
+}
+
+func catmullRom(x0, x1, x2, x3, t float64) float64 {
+	a := -0.5*x0 + 1.5*x1 - 1.5*x2 + 0.5*x3
+	b := x0 - 2.5*x1 + 2*x2 - 0.5*x3
+	c := -0.5*x0 + 0.5*x2
+	return ((a*t+b)*t+c)*t + x1
+}
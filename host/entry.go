@@ -134,6 +134,9 @@ type Entry interface {
 	//
 	// DevicesNotify returns ErrUnsupported if the entry does not support
 	// notifications.
+	//
+	// Entries shouldn't drop DeviceConnect and DeviceDisconnect changes
+	// when c isn't ready, see Notifier.
 	DevicesNotify(c chan<- *DevChange) error
 
 	// DevicesNotifyClose stops sending device notifications on c.
@@ -154,12 +157,15 @@ type DevScanResult struct {
 }
 
 // DevChangeSense indicates whether a DevChange
-// is a connection or a disconnection.
+// is a connection, a disconnection or a routing change.
 type DevChangeSense int
 
 const (
 	DeviceConnect DevChangeSense = iota
 	DeviceDisconnect
+	// DeviceRouteChange indicates the routing of a connected device
+	// changed, such as when JACK ports are connected or disconnected.
+	DeviceRouteChange
 )
 
 // DevChange describes an event related to
@@ -276,7 +282,8 @@ var hMu sync.Mutex
 var eMu sync.Mutex
 var theEntry Entry
 
-// Connect opens the default entry for the host.
+// Connect opens the default entry for the host, which is the first entry
+// named in Names() which is registered.
 //
 // Connect returns ErrNoEntryAvailable if there are no entries for the host.
 //
@@ -291,11 +298,18 @@ var theEntry Entry
 // implementation.  If pkgSel is nil, Connect acts as though
 // the function body were "return true".
 func Connect(pkgSel func(string) bool) (Entry, error) {
-	nms := Names()
-	if len(nms) == 0 {
-		return nil, ErrNoEntryAvailable
+	for _, nm := range Names() {
+		if isRegistered(nm, pkgSel) {
+			return ConnectTo(nm, pkgSel)
+		}
 	}
-	return ConnectTo(nms[0], pkgSel)
+	return nil, ErrNoEntryAvailable
+}
+
+func isRegistered(name string, pkgSel func(string) bool) bool {
+	hMu.Lock()
+	defer hMu.Unlock()
+	return findEntry(entries[name], pkgSel) != nil
 }
 
 // ConnectTo connects to the named entry.
@@ -349,9 +363,28 @@ func findEntry(s []*entry, pkgSel func(string) bool) Entry {
 	return nil
 }
 
+// portableNames names entry points which do not depend on the host sound
+// system and so are available on all hosts.  They follow the host specific
+// names in Names() so that the default entry remains host specific.  A
+// host whose own sound system is portable, such as sndio on OpenBSD,
+// names it among its host specific names.
+var portableNames = [...]string{"RTP", "sndio", "Pipe", "Remote"}
+
 // Names names the sound system entry points for the host.
 func Names() []string {
-	res := make([]string, len(names))
+	res := make([]string, len(names), len(names)+len(portableNames))
 	copy(res, names[:])
+	for _, p := range portableNames {
+		found := false
+		for _, nm := range names {
+			if nm == p {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, p)
+		}
+	}
 	return res
 }
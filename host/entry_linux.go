@@ -3,4 +3,4 @@
 
 package host
 
-var names = [...]string{"Linux -- ALSACGO", "Linux -- ALSAGO", "Linux -- PulseAudio"}
+var names = [...]string{"Linux -- ALSACGO", "Linux -- ALSAGO", "Linux -- PulseAudio", "Linux -- JACK", "Linux -- PipeWire", "Linux -- SHM"}
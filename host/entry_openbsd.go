@@ -3,4 +3,4 @@
 
 package host
 
-var names = [...]string{}
+var names = [...]string{"sndio"}
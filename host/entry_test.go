@@ -0,0 +1,46 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package host
+
+import "testing"
+
+type namedEntry struct {
+	NullEntry
+	name string
+}
+
+func (e *namedEntry) Name() string {
+	return e.name
+}
+
+func TestConnect(t *testing.T) {
+	nms := Names()
+	last := nms[len(nms)-1]
+	if _, err := Connect(nil); err != ErrNoEntryAvailable {
+		t.Fatalf("expected ErrNoEntryAvailable, got %v", err)
+	}
+	e := &namedEntry{name: last}
+	if err := RegisterEntry(e); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(entries, last)
+	c, err := Connect(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Disconnect()
+	if c != e {
+		t.Errorf("connected to %s, expected %s", c.Name(), last)
+	}
+}
+
+func TestNamesUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, nm := range Names() {
+		if seen[nm] {
+			t.Errorf("%s named twice", nm)
+		}
+		seen[nm] = true
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package hosttest checks that a host.Entry conforms to the contract of
+// host.Entry.
+//
+// Test runs a battery of subtests on an Entry:
+//
+//   - Defaults: the name, default form and buffer size are valid.
+//   - Devices: without devices, Devices and the default devices are nil;
+//     with them, Devices is cached and contains the default devices.
+//   - Unsupported: opens which the Can methods deny fail with
+//     host.ErrUnsupported.
+//   - Source, Sink, Duplex: streams open with the default form and codec,
+//     and other combinations open or fail cleanly; streams have the
+//     requested form, exchange data, check alignment, report start times
+//     close to the time of opening, and fail after Close.
+//   - Cycles: repeated opens and closes succeed with non decreasing start
+//     times.
+//   - ConcurrentClose: Close while another goroutine exchanges data, and
+//     two concurrent Closes, return, as do the blocked calls.
+//   - Notify: subscriptions to device notifications can be made and
+//     cancelled in any order.
+//
+// Porters may call Test from a test of their entry, as the tests of the
+// entries of this module do.
+//
+// Package hosttest is part of http://zikichombo.org
+package hosttest /* import "zikichombo.org/sio/host/hosttest" */
@@ -0,0 +1,701 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package hosttest
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Config configures Test.  Zero fields take the defaults given below.
+type Config struct {
+	// Forms are tried in addition to the default form of the entry and
+	// its mono variant.
+	Forms []sound.Form
+
+	// Codecs are tried in addition to the default sample codec of the
+	// entry.
+	Codecs []sample.Codec
+
+	// BufSize is the buffer size of streams, the default buffer size of
+	// the entry by default.
+	BufSize int
+
+	// Buffers is the number of buffers exchanged by streams, 4 by
+	// default.
+	Buffers int
+
+	// Cycles is the number of open and close cycles, 3 by default.
+	Cycles int
+
+	// Timeout bounds each operation, 5s by default.
+	Timeout time.Duration
+
+	// Slack is the tolerance of start times, 1s by default.
+	Slack time.Duration
+}
+
+func (c *Config) defaults(e host.Entry) {
+	if c.BufSize <= 0 {
+		c.BufSize = e.DefaultBufSize()
+	}
+	if c.Buffers <= 0 {
+		c.Buffers = 4
+	}
+	if c.Cycles <= 0 {
+		c.Cycles = 3
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.Slack <= 0 {
+		c.Slack = time.Second
+	}
+}
+
+// Test runs the conformance subtests on e.
+func Test(t *testing.T, e host.Entry, cfg *Config) {
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	c.defaults(e)
+	x := &tester{e: e, cfg: c}
+	t.Run("Defaults", x.defaults)
+	t.Run("Devices", x.devices)
+	t.Run("Unsupported", x.unsupported)
+	t.Run("Source", x.source)
+	t.Run("Sink", x.sink)
+	t.Run("Duplex", x.duplex)
+	t.Run("Cycles", x.cycles)
+	t.Run("ConcurrentClose", x.concurrentClose)
+	t.Run("Notify", x.notify)
+}
+
+type tester struct {
+	e   host.Entry
+	cfg Config
+}
+
+// within runs f, failing t if it doesn't return within the timeout.
+func (x *tester) within(t *testing.T, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(x.cfg.Timeout):
+		t.Fatalf("%s didn't return within %s", what, x.cfg.Timeout)
+	}
+}
+
+func (x *tester) defaults(t *testing.T) {
+	e := x.e
+	if e.Name() == "" {
+		t.Errorf("empty name")
+	}
+	v := e.DefaultForm()
+	if v == nil || v.Channels() <= 0 || v.SampleRate() <= 0 {
+		t.Errorf("invalid default form %v", v)
+	}
+	if e.DefaultBufSize() <= 0 {
+		t.Errorf("invalid default buffer size %d", e.DefaultBufSize())
+	}
+}
+
+func (x *tester) devices(t *testing.T) {
+	e := x.e
+	defs := map[string]*libsio.Dev{
+		"DefaultInputDev":  e.DefaultInputDev(),
+		"DefaultOutputDev": e.DefaultOutputDev(),
+		"DefaultDuplexDev": e.DefaultDuplexDev()}
+	if !e.HasDevices() {
+		if ds := e.Devices(); len(ds) != 0 {
+			t.Errorf("Devices returned %d devices without HasDevices", len(ds))
+		}
+		for name, d := range defs {
+			if d != nil {
+				t.Errorf("%s returned %q without HasDevices", name, d.Name)
+			}
+		}
+		return
+	}
+	var res []*host.DevScanResult
+	var err error
+	x.within(t, "ScanDevices", func() {
+		res, err = e.ScanDevices()
+	})
+	if err != nil {
+		t.Errorf("ScanDevices: %v", err)
+	}
+	for _, r := range res {
+		if r == nil || (r.Dev == nil && r.E == nil) {
+			t.Errorf("ScanDevices returned an empty result")
+		}
+	}
+	ds := e.Devices()
+	again := e.Devices()
+	if len(ds) != len(again) {
+		t.Fatalf("Devices returned %d then %d devices", len(ds), len(again))
+	}
+	in := make(map[*libsio.Dev]bool)
+	for i, d := range ds {
+		if d == nil {
+			t.Fatalf("Devices returned a nil device")
+		}
+		if again[i] != d {
+			t.Errorf("Devices isn't cached: device %d changed", i)
+		}
+		in[d] = true
+	}
+	for name, d := range defs {
+		if d != nil && !in[d] {
+			t.Errorf("%s returned %q, which isn't in Devices", name, d.Name)
+		}
+	}
+}
+
+// dev returns the device for opens in a direction, nil without devices.
+func (x *tester) dev(def func() *libsio.Dev, ok func(*libsio.Dev) bool) (*libsio.Dev, bool) {
+	if !x.e.HasDevices() {
+		return nil, true
+	}
+	if d := def(); d != nil {
+		return d, true
+	}
+	for _, d := range x.e.Devices() {
+		if ok(d) {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+func (x *tester) inDev() (*libsio.Dev, bool) {
+	return x.dev(x.e.DefaultInputDev, (*libsio.Dev).CanInput)
+}
+
+func (x *tester) outDev() (*libsio.Dev, bool) {
+	return x.dev(x.e.DefaultOutputDev, (*libsio.Dev).CanOutput)
+}
+
+func (x *tester) duplexDev() (*libsio.Dev, bool) {
+	return x.dev(x.e.DefaultDuplexDev, (*libsio.Dev).CanDuplex)
+}
+
+// combos returns the forms and codecs to try with the capabilities cs,
+// the first being the default form and codec of the entry made
+// supported.
+func (x *tester) combos(cs ...*libsio.Caps) ([]sound.Form, []sample.Codec) {
+	v, co := x.e.DefaultForm(), x.e.DefaultSampleCodec()
+	for _, c := range cs {
+		if c != nil && c.Supported() {
+			v, co, _ = c.Closest(v, co)
+		}
+	}
+	forms := []sound.Form{v}
+	if v.Channels() != 1 {
+		forms = append(forms, sound.NewForm(v.SampleRate(), 1))
+	}
+	forms = append(forms, x.cfg.Forms...)
+	return forms, append([]sample.Codec{co}, x.cfg.Codecs...)
+}
+
+// inCaps and outCaps return the capabilities of d, nil without devices.
+func inCaps(d *libsio.Dev) *libsio.Caps {
+	if d == nil {
+		return nil
+	}
+	return &d.In
+}
+
+func outCaps(d *libsio.Dev) *libsio.Caps {
+	if d == nil {
+		return nil
+	}
+	return &d.Out
+}
+
+func (x *tester) unsupported(t *testing.T) {
+	e := x.e
+	v, co, b := e.DefaultForm(), e.DefaultSampleCodec(), x.cfg.BufSize
+	if !e.CanOpenSource() {
+		d, _ := x.inDev()
+		if _, _, err := e.OpenSource(d, v, co, b); err != host.ErrUnsupported {
+			t.Errorf("OpenSource without CanOpenSource: got %v, want ErrUnsupported", err)
+		}
+	}
+	if !e.CanOpenSink() {
+		d, _ := x.outDev()
+		if _, _, err := e.OpenSink(d, v, co, b); err != host.ErrUnsupported {
+			t.Errorf("OpenSink without CanOpenSink: got %v, want ErrUnsupported", err)
+		}
+	}
+	if !e.CanOpenDuplex() {
+		d, _ := x.duplexDev()
+		if _, _, _, err := e.OpenDuplex(d, v, v, co, b); err != host.ErrUnsupported {
+			t.Errorf("OpenDuplex without CanOpenDuplex: got %v, want ErrUnsupported", err)
+		}
+	}
+}
+
+// checkForm checks that the stream s has the form v.
+func checkForm(t *testing.T, s, v sound.Form) {
+	t.Helper()
+	if s.Channels() != v.Channels() || s.SampleRate() != v.SampleRate() {
+		t.Errorf("stream form %d channels %s, want %d channels %s",
+			s.Channels(), s.SampleRate(), v.Channels(), v.SampleRate())
+	}
+}
+
+// checkStart checks a start time reported for a stream opened after
+// before.
+func (x *tester) checkStart(t *testing.T, what string, start, before time.Time) {
+	t.Helper()
+	if start.IsZero() {
+		t.Errorf("%s: zero start time", what)
+		return
+	}
+	if start.Before(before.Add(-x.cfg.Slack)) {
+		t.Errorf("%s: start time %s before opening", what, before.Sub(start))
+	}
+	if after := time.Now().Add(x.cfg.Slack); start.After(after) {
+		t.Errorf("%s: start time %s in the future", what, start.Sub(after))
+	}
+}
+
+func comboName(v sound.Form, co sample.Codec) string {
+	return fmt.Sprintf("%dch %s %s", v.Channels(), v.SampleRate(), co)
+}
+
+func (x *tester) source(t *testing.T) {
+	e := x.e
+	if !e.CanOpenSource() {
+		t.Skip("entry can't open sources")
+	}
+	d, ok := x.inDev()
+	if !ok {
+		t.Skip("no input device")
+	}
+	forms, codecs := x.combos(inCaps(d))
+	b := x.cfg.BufSize
+	for i, v := range forms {
+		for j, co := range codecs {
+			name := comboName(v, co)
+			before := time.Now()
+			var src sound.Source
+			var start time.Time
+			var err error
+			x.within(t, "OpenSource", func() {
+				src, start, err = e.OpenSource(d, v, co, b)
+			})
+			if err != nil {
+				if i == 0 && j == 0 {
+					t.Errorf("OpenSource %s (default): %v", name, err)
+				} else {
+					t.Logf("OpenSource %s: %v", name, err)
+				}
+				continue
+			}
+			checkForm(t, src, v)
+			x.receive(t, name, src)
+			x.checkStart(t, name, start, before)
+			x.closeSource(t, name, src)
+		}
+	}
+}
+
+// receive receives buffers from src.
+func (x *tester) receive(t *testing.T, name string, src sound.Source) {
+	t.Helper()
+	b, nC := x.cfg.BufSize, src.Channels()
+	buf := make([]float64, b*nC)
+	for i := 0; i < x.cfg.Buffers; i++ {
+		var n int
+		var err error
+		x.within(t, "Receive", func() {
+			n, err = src.Receive(buf)
+		})
+		if err == io.EOF {
+			x.within(t, "Receive after io.EOF", func() {
+				_, err = src.Receive(buf)
+			})
+			if err == nil {
+				t.Errorf("%s: Receive after io.EOF returned no error", name)
+			}
+			return
+		}
+		if err != nil {
+			t.Errorf("%s: Receive: %v", name, err)
+			return
+		}
+		if n <= 0 || n > b {
+			t.Errorf("%s: Receive returned %d frames for a buffer of %d", name, n, b)
+		}
+	}
+	if nC > 1 {
+		var err error
+		x.within(t, "Receive", func() {
+			_, err = src.Receive(buf[:len(buf)-1])
+		})
+		if err == nil {
+			t.Errorf("%s: Receive accepted a buffer of %d samples for %d channels", name, len(buf)-1, nC)
+		}
+	}
+}
+
+// closeSource closes src, checking that Receive then fails and that
+// Close may be called again.
+func (x *tester) closeSource(t *testing.T, name string, src sound.Source) {
+	t.Helper()
+	var err error
+	x.within(t, "Close", func() {
+		err = src.Close()
+	})
+	if err != nil {
+		t.Errorf("%s: Close: %v", name, err)
+	}
+	buf := make([]float64, x.cfg.BufSize*src.Channels())
+	x.within(t, "Receive after Close", func() {
+		_, err = src.Receive(buf)
+	})
+	if err == nil {
+		t.Errorf("%s: Receive after Close returned no error", name)
+	}
+	x.within(t, "second Close", func() {
+		src.Close()
+	})
+}
+
+func (x *tester) sink(t *testing.T) {
+	e := x.e
+	if !e.CanOpenSink() {
+		t.Skip("entry can't open sinks")
+	}
+	d, ok := x.outDev()
+	if !ok {
+		t.Skip("no output device")
+	}
+	forms, codecs := x.combos(outCaps(d))
+	b := x.cfg.BufSize
+	for i, v := range forms {
+		for j, co := range codecs {
+			name := comboName(v, co)
+			before := time.Now()
+			var snk sound.Sink
+			var start *time.Time
+			var err error
+			x.within(t, "OpenSink", func() {
+				snk, start, err = e.OpenSink(d, v, co, b)
+			})
+			if err != nil {
+				if i == 0 && j == 0 {
+					t.Errorf("OpenSink %s (default): %v", name, err)
+				} else {
+					t.Logf("OpenSink %s: %v", name, err)
+				}
+				continue
+			}
+			checkForm(t, snk, v)
+			if start == nil {
+				t.Errorf("%s: nil start time", name)
+			}
+			if x.send(t, name, snk) && start != nil {
+				x.checkStart(t, name, *start, before)
+			}
+			x.closeSink(t, name, snk)
+		}
+	}
+}
+
+// send sends buffers of silence to snk, and returns whether the first
+// succeeded.
+func (x *tester) send(t *testing.T, name string, snk sound.Sink) bool {
+	t.Helper()
+	b, nC := x.cfg.BufSize, snk.Channels()
+	buf := make([]float64, b*nC)
+	for i := 0; i < x.cfg.Buffers; i++ {
+		var err error
+		x.within(t, "Send", func() {
+			err = snk.Send(buf)
+		})
+		if err != nil {
+			t.Errorf("%s: Send: %v", name, err)
+			return i > 0
+		}
+	}
+	if nC > 1 {
+		var err error
+		x.within(t, "Send", func() {
+			err = snk.Send(buf[:len(buf)-1])
+		})
+		if err == nil {
+			t.Errorf("%s: Send accepted a buffer of %d samples for %d channels", name, len(buf)-1, nC)
+		}
+	}
+	return true
+}
+
+// closeSink closes snk, checking that Send then fails and that Close may
+// be called again.
+func (x *tester) closeSink(t *testing.T, name string, snk sound.Sink) {
+	t.Helper()
+	var err error
+	x.within(t, "Close", func() {
+		err = snk.Close()
+	})
+	if err != nil {
+		t.Errorf("%s: Close: %v", name, err)
+	}
+	buf := make([]float64, x.cfg.BufSize*snk.Channels())
+	x.within(t, "Send after Close", func() {
+		err = snk.Send(buf)
+	})
+	if err == nil {
+		t.Errorf("%s: Send after Close returned no error", name)
+	}
+	x.within(t, "second Close", func() {
+		snk.Close()
+	})
+}
+
+func (x *tester) duplex(t *testing.T) {
+	e := x.e
+	if !e.CanOpenDuplex() {
+		t.Skip("entry can't open duplex streams")
+	}
+	d, ok := x.duplexDev()
+	if !ok {
+		t.Skip("no duplex device")
+	}
+	forms, codecs := x.combos(inCaps(d), outCaps(d))
+	b := x.cfg.BufSize
+	for i, v := range forms {
+		for j, co := range codecs {
+			name := comboName(v, co)
+			before := time.Now()
+			var dpx sound.Duplex
+			var in time.Time
+			var out *time.Time
+			var err error
+			x.within(t, "OpenDuplex", func() {
+				dpx, in, out, err = e.OpenDuplex(d, v, v, co, b)
+			})
+			if err != nil {
+				if i == 0 && j == 0 {
+					t.Errorf("OpenDuplex %s (default): %v", name, err)
+				} else {
+					t.Logf("OpenDuplex %s: %v", name, err)
+				}
+				continue
+			}
+			if dpx.InChannels() != v.Channels() || dpx.OutChannels() != v.Channels() {
+				t.Errorf("%s: duplex channels in %d out %d", name, dpx.InChannels(), dpx.OutChannels())
+			}
+			if out == nil {
+				t.Errorf("%s: nil output start time", name)
+			}
+			x.sendReceive(t, name, dpx)
+			x.checkStart(t, name+" input", in, before)
+			if out != nil {
+				x.checkStart(t, name+" output", *out, before)
+			}
+			x.within(t, "Close", func() {
+				err = dpx.Close()
+			})
+			if err != nil {
+				t.Errorf("%s: Close: %v", name, err)
+			}
+			x.within(t, "second Close", func() {
+				dpx.Close()
+			})
+		}
+	}
+}
+
+func (x *tester) sendReceive(t *testing.T, name string, dpx sound.Duplex) {
+	t.Helper()
+	b := x.cfg.BufSize
+	out := make([]float64, b*dpx.OutChannels())
+	in := make([]float64, b*dpx.InChannels())
+	for i := 0; i < x.cfg.Buffers; i++ {
+		var n int
+		var err error
+		x.within(t, "SendReceive", func() {
+			n, err = dpx.SendReceive(out, in)
+		})
+		if err != nil {
+			t.Errorf("%s: SendReceive: %v", name, err)
+			return
+		}
+		if n <= 0 || n > b {
+			t.Errorf("%s: SendReceive returned %d frames for a buffer of %d", name, n, b)
+		}
+	}
+	var err error
+	x.within(t, "SendReceive", func() {
+		_, err = dpx.SendReceive(out, in[:len(in)-dpx.InChannels()])
+	})
+	if err == nil {
+		t.Errorf("%s: SendReceive accepted buffers of different frames", name)
+	}
+}
+
+func (x *tester) cycles(t *testing.T) {
+	e := x.e
+	b := x.cfg.BufSize
+	if d, ok := x.inDev(); ok && e.CanOpenSource() {
+		forms, codecs := x.combos(inCaps(d))
+		var last time.Time
+		for i := 0; i < x.cfg.Cycles; i++ {
+			before := time.Now()
+			src, start, err := e.OpenSource(d, forms[0], codecs[0], b)
+			if err != nil {
+				t.Fatalf("OpenSource cycle %d: %v", i, err)
+			}
+			x.receive(t, "source", src)
+			x.checkStart(t, "source", start, before)
+			if start.Before(last) {
+				t.Errorf("source cycle %d: start time went back by %s", i, last.Sub(start))
+			}
+			last = start
+			x.closeSource(t, "source", src)
+		}
+	}
+	if d, ok := x.outDev(); ok && e.CanOpenSink() {
+		forms, codecs := x.combos(outCaps(d))
+		var last time.Time
+		for i := 0; i < x.cfg.Cycles; i++ {
+			before := time.Now()
+			snk, start, err := e.OpenSink(d, forms[0], codecs[0], b)
+			if err != nil {
+				t.Fatalf("OpenSink cycle %d: %v", i, err)
+			}
+			if x.send(t, "sink", snk) && start != nil {
+				x.checkStart(t, "sink", *start, before)
+				if start.Before(last) {
+					t.Errorf("sink cycle %d: start time went back by %s", i, last.Sub(*start))
+				}
+				last = *start
+			}
+			x.closeSink(t, "sink", snk)
+		}
+	}
+}
+
+// closeDuring calls io while another goroutine closes c twice
+// concurrently, checking that everything returns.
+func (x *tester) closeDuring(t *testing.T, what string, c sound.Closer, io func() error) {
+	t.Helper()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for io() == nil {
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	x.within(t, "concurrent Close of "+what, func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.Close()
+			}()
+		}
+		wg.Wait()
+	})
+	select {
+	case <-stopped:
+	case <-time.After(x.cfg.Timeout):
+		t.Errorf("%s I/O didn't fail within %s of Close", what, x.cfg.Timeout)
+	}
+}
+
+func (x *tester) concurrentClose(t *testing.T) {
+	e := x.e
+	b := x.cfg.BufSize
+	if d, ok := x.inDev(); ok && e.CanOpenSource() {
+		forms, codecs := x.combos(inCaps(d))
+		src, _, err := e.OpenSource(d, forms[0], codecs[0], b)
+		if err != nil {
+			t.Fatalf("OpenSource: %v", err)
+		}
+		buf := make([]float64, b*src.Channels())
+		x.closeDuring(t, "source", src, func() error {
+			_, err := src.Receive(buf)
+			return err
+		})
+	}
+	if d, ok := x.outDev(); ok && e.CanOpenSink() {
+		forms, codecs := x.combos(outCaps(d))
+		snk, _, err := e.OpenSink(d, forms[0], codecs[0], b)
+		if err != nil {
+			t.Fatalf("OpenSink: %v", err)
+		}
+		buf := make([]float64, b*snk.Channels())
+		x.closeDuring(t, "sink", snk, func() error {
+			return snk.Send(buf)
+		})
+	}
+	if d, ok := x.duplexDev(); ok && e.CanOpenDuplex() {
+		forms, codecs := x.combos(inCaps(d), outCaps(d))
+		v := forms[0]
+		dpx, _, _, err := e.OpenDuplex(d, v, v, codecs[0], b)
+		if err != nil {
+			t.Fatalf("OpenDuplex: %v", err)
+		}
+		out := make([]float64, b*dpx.OutChannels())
+		in := make([]float64, b*dpx.InChannels())
+		x.closeDuring(t, "duplex", dpx, func() error {
+			_, err := dpx.SendReceive(out, in)
+			return err
+		})
+	}
+}
+
+func (x *tester) notify(t *testing.T) {
+	e := x.e
+	a := make(chan *host.DevChange, 8)
+	b := make(chan *host.DevChange, 8)
+	var errA, errB error
+	x.within(t, "DevicesNotify", func() {
+		errA = e.DevicesNotify(a)
+		errB = e.DevicesNotify(b)
+	})
+	if errA != nil && errA != host.ErrUnsupported {
+		t.Errorf("DevicesNotify: %v", errA)
+	}
+	if errA != errB {
+		t.Errorf("DevicesNotify returned %v then %v", errA, errB)
+	}
+	x.within(t, "DevicesNotifyClose", func() {
+		// in the order of subscription, then again, then never
+		// subscribed.
+		e.DevicesNotifyClose(a)
+		e.DevicesNotifyClose(b)
+		e.DevicesNotifyClose(a)
+		e.DevicesNotifyClose(make(chan *host.DevChange))
+	})
+	x.within(t, "DevicesNotify", func() {
+		errA = e.DevicesNotify(a)
+		errB = e.DevicesNotify(b)
+	})
+	x.within(t, "DevicesNotifyClose", func() {
+		// in reverse order.
+		e.DevicesNotifyClose(b)
+		e.DevicesNotifyClose(a)
+	})
+}
@@ -0,0 +1,14 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package hosttest
+
+import (
+	"testing"
+
+	"zikichombo.org/sio/host"
+)
+
+func TestNullEntry(t *testing.T) {
+	Test(t, &host.NullEntry{}, nil)
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package host
+
+import (
+	"errors"
+	"regexp"
+
+	"zikichombo.org/sio/libsio"
+)
+
+var (
+	// ErrNoDevice is returned by FindDev when no device matches.
+	ErrNoDevice = errors.New("no such device")
+	// ErrAmbiguousDevice is returned by FindDev when a pattern matches
+	// several devices.
+	ErrAmbiguousDevice = errors.New("ambiguous device")
+)
+
+// DevByUID returns the device of e whose UID is uid, or nil if there is
+// none.
+func DevByUID(e Entry, uid string) *libsio.Dev {
+	if uid == "" || !e.HasDevices() {
+		return nil
+	}
+	for _, d := range e.Devices() {
+		if d.UID == uid {
+			return d
+		}
+	}
+	return nil
+}
+
+// DevByName returns the first device of e named name, or nil if there is
+// none.
+func DevByName(e Entry, name string) *libsio.Dev {
+	if !e.HasDevices() {
+		return nil
+	}
+	for _, d := range e.Devices() {
+		if d.Name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// DevsMatching returns the devices of e whose name matches the regular
+// expression pattern.
+func DevsMatching(e Entry, pattern string) ([]*libsio.Dev, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if !e.HasDevices() {
+		return nil, nil
+	}
+	var res []*libsio.Dev
+	for _, d := range e.Devices() {
+		if re.MatchString(d.Name) {
+			res = append(res, d)
+		}
+	}
+	return res, nil
+}
+
+// FindDev returns the device of e identified by s, which is tried as a
+// UID, then as an exact name and last as a name pattern for DevsMatching.
+// A pattern must match exactly one device.
+//
+// FindDev returns ErrNoDevice or ErrAmbiguousDevice if s doesn't identify
+// a device.
+func FindDev(e Entry, s string) (*libsio.Dev, error) {
+	if d := DevByUID(e, s); d != nil {
+		return d, nil
+	}
+	if d := DevByName(e, s); d != nil {
+		return d, nil
+	}
+	ds, err := DevsMatching(e, s)
+	switch {
+	case err != nil || len(ds) == 0:
+		return nil, ErrNoDevice
+	case len(ds) > 1:
+		return nil, ErrAmbiguousDevice
+	}
+	return ds[0], nil
+}
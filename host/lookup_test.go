@@ -0,0 +1,57 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package host
+
+import (
+	"testing"
+
+	"zikichombo.org/sio/libsio"
+)
+
+type devEntry struct {
+	NullEntry
+	devs []*libsio.Dev
+}
+
+func (e *devEntry) HasDevices() bool       { return true }
+func (e *devEntry) Devices() []*libsio.Dev { return e.devs }
+
+func TestFindDev(t *testing.T) {
+	e := &devEntry{devs: []*libsio.Dev{
+		{Name: "hw:0,0", UID: "hw:0000:00:1f.3,0"},
+		{Name: "USB Audio", UID: "hw:1-1.2:1.0,0"},
+		{Name: "USB Audio 2"},
+		{Name: "hw:0000:00:1f.3,0"}}}
+	for _, c := range []struct {
+		s    string
+		want int
+		err  error
+	}{
+		{"hw:0000:00:1f.3,0", 0, nil},
+		{"hw:1-1.2:1.0,0", 1, nil},
+		{"USB Audio", 1, nil},
+		{"Audio 2$", 2, nil},
+		{"USB", -1, ErrAmbiguousDevice},
+		{"nope", -1, ErrNoDevice},
+		{"(", -1, ErrNoDevice},
+	} {
+		d, err := FindDev(e, c.s)
+		if err != c.err {
+			t.Errorf("%q: got error %v, want %v", c.s, err, c.err)
+			continue
+		}
+		if c.want >= 0 && d != e.devs[c.want] {
+			t.Errorf("%q: got %v, want %v", c.s, d, e.devs[c.want])
+		}
+	}
+	if d := DevByUID(e, ""); d != nil {
+		t.Errorf("empty UID matched %v", d)
+	}
+	if ds, err := DevsMatching(e, "^USB"); err != nil || len(ds) != 2 {
+		t.Errorf("got %v, %v", ds, err)
+	}
+	if _, err := DevsMatching(&NullEntry{}, "x"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package host
+
+import "sync"
+
+// Notifier keeps the subscribers to the device notifications of an Entry
+// and delivers DevChanges to them.  Entries implement DevicesNotify and
+// DevicesNotifyClose with Add and Remove, and call Notify when devices
+// change.  The zero value of a Notifier has no subscribers.
+//
+// Notify doesn't block.  The changes of each subscriber are queued and
+// sent in order by a goroutine of the subscriber, so that a subscriber
+// which isn't ready doesn't delay the entry or other subscribers, nor
+// miss a DeviceConnect or DeviceDisconnect.  A DeviceRouteChange of a
+// device whose route change hasn't been delivered yet is dropped, as it
+// carries no more information.
+type Notifier struct {
+	mu   sync.Mutex
+	subs []*subscriber
+}
+
+type subscriber struct {
+	c    chan<- *DevChange
+	wake chan struct{}
+	quit chan struct{}
+	done chan struct{}
+
+	mu    sync.Mutex
+	queue []*DevChange
+}
+
+// Add subscribes c and returns the number of subscribers.
+func (n *Notifier) Add(c chan<- *DevChange) int {
+	s := &subscriber{
+		c:    c,
+		wake: make(chan struct{}, 1),
+		quit: make(chan struct{}),
+		done: make(chan struct{})}
+	go s.serve()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.subs = append(n.subs, s)
+	return len(n.subs)
+}
+
+// Remove unsubscribes c, discarding the changes not yet sent to it, and
+// returns the number of remaining subscribers and whether c was
+// subscribed.  Nothing is sent on c once Remove returns.
+func (n *Notifier) Remove(c chan<- *DevChange) (int, bool) {
+	n.mu.Lock()
+	var s *subscriber
+	for i, o := range n.subs {
+		if o.c == c {
+			s = o
+			n.subs = append(n.subs[:i], n.subs[i+1:]...)
+			break
+		}
+	}
+	rem := len(n.subs)
+	n.mu.Unlock()
+	if s == nil {
+		return rem, false
+	}
+	close(s.quit)
+	<-s.done
+	return rem, true
+}
+
+// Len returns the number of subscribers.
+func (n *Notifier) Len() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.subs)
+}
+
+// Notify queues chg for all subscribers.
+func (n *Notifier) Notify(chg *DevChange) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, s := range n.subs {
+		s.push(chg)
+	}
+}
+
+func (s *subscriber) push(chg *DevChange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if chg.Sense == DeviceRouteChange {
+		for _, o := range s.queue {
+			if o.Sense == DeviceRouteChange && o.Dev == chg.Dev {
+				return
+			}
+		}
+	}
+	s.queue = append(s.queue, chg)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber) pop() *DevChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return nil
+	}
+	chg := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	return chg
+}
+
+func (s *subscriber) serve() {
+	defer close(s.done)
+	for {
+		chg := s.pop()
+		if chg == nil {
+			select {
+			case <-s.wake:
+				continue
+			case <-s.quit:
+				return
+			}
+		}
+		select {
+		case s.c <- chg:
+		case <-s.quit:
+			return
+		}
+	}
+}
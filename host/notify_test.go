@@ -0,0 +1,79 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package host
+
+import (
+	"testing"
+	"time"
+
+	"zikichombo.org/sio/libsio"
+)
+
+func TestNotifier(t *testing.T) {
+	var n Notifier
+	a := make(chan *DevChange)
+	b := make(chan *DevChange, 1)
+	if k := n.Add(a); k != 1 {
+		t.Errorf("Add returned %d", k)
+	}
+	if k := n.Add(b); k != 2 {
+		t.Errorf("Add returned %d", k)
+	}
+	devs := make([]*libsio.Dev, 100)
+	for i := range devs {
+		devs[i] = &libsio.Dev{Id: uint64(i)}
+	}
+	// nobody is receiving, Notify mustn't block nor drop connections.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, d := range devs {
+			n.Notify(&DevChange{Sense: DeviceConnect, Dev: d})
+			n.Notify(&DevChange{Sense: DeviceRouteChange, Dev: d})
+			n.Notify(&DevChange{Sense: DeviceRouteChange, Dev: d})
+			n.Notify(&DevChange{Sense: DeviceDisconnect, Dev: d})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notify blocked")
+	}
+	for _, c := range []chan *DevChange{a, b} {
+		routes := 0
+		for _, d := range devs {
+			chg := <-c
+			if chg.Sense != DeviceConnect || chg.Dev != d {
+				t.Fatalf("got %v %d, expected connection of %d", chg.Sense, chg.Dev.Id, d.Id)
+			}
+			for chg = <-c; chg.Sense == DeviceRouteChange; chg = <-c {
+				routes++
+			}
+			if chg.Sense != DeviceDisconnect || chg.Dev != d {
+				t.Fatalf("got %v %d, expected disconnection of %d", chg.Sense, chg.Dev.Id, d.Id)
+			}
+		}
+		if routes < len(devs) || routes > 2*len(devs) {
+			t.Errorf("got %d route changes", routes)
+		}
+	}
+	n.Notify(&DevChange{Sense: DeviceConnect, Dev: devs[0]})
+	if k, ok := n.Remove(a); k != 1 || !ok {
+		t.Errorf("Remove returned %d %t", k, ok)
+	}
+	if k, ok := n.Remove(a); k != 1 || ok {
+		t.Errorf("second Remove returned %d %t", k, ok)
+	}
+	if chg := <-b; chg.Dev != devs[0] {
+		t.Errorf("got %d", chg.Dev.Id)
+	}
+	if _, ok := n.Remove(b); !ok || n.Len() != 0 {
+		t.Errorf("subscribers remain")
+	}
+	select {
+	case <-a:
+		t.Errorf("sent after Remove")
+	default:
+	}
+}
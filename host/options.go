@@ -0,0 +1,75 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package host
+
+import (
+	"time"
+
+	"zikichombo.org/sio/dither"
+	"zikichombo.org/sio/guard"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// SinkOptions configure the output stage of a sink opened with
+// OpenSinkWith.  The output stage processes data sent to the sink just
+// before the entry encodes it, so it works with any Entry.
+//
+// A nil *SinkOptions, or the zero value, adds no output stage.
+type SinkOptions struct {
+	// Dither, if not nil, dithers and noise shapes data for integer sample
+	// codecs, see package dither.
+	Dither *dither.Config
+
+	// Guard, if not nil, guards the output against clipping, NaNs and DC,
+	// see package guard.  The guard comes before dithering, and the
+	// returned sink is then a *guard.Sink, giving access to its counters.
+	Guard *guard.Config
+}
+
+// OpenSinkWith is as e.OpenSink, applying the output stage options o to
+// the returned sink.  Sinks with an output stage don't implement
+// libsio.RawSink.  The returned start time accounts for the delay of the
+// output stage, see guard.Sink.Start.
+func OpenSinkWith(e Entry, dev *libsio.Dev, v sound.Form, co sample.Codec, b int, o *SinkOptions) (sound.Sink, *time.Time, error) {
+	snk, t, err := e.OpenSink(dev, v, co, b)
+	if err != nil {
+		return nil, t, err
+	}
+	snk = o.Apply(snk, co)
+	if g, ok := snk.(*guard.Sink); ok {
+		t = g.Start(t)
+	}
+	return snk, t, nil
+}
+
+// Apply returns snk with the output stage o, for a sink opened with
+// sample codec co.  If snk implements libsio.RawSink, its codec is used
+// instead of co.  Apply returns snk if o is nil.
+func (o *SinkOptions) Apply(snk sound.Sink, co sample.Codec) sound.Sink {
+	if o == nil {
+		return snk
+	}
+	if raw, ok := snk.(libsio.RawSink); ok {
+		// the codec actually negotiated.
+		co = raw.Codec()
+	}
+	if o.Dither != nil {
+		snk = dither.NewSink(snk, co, o.Dither)
+	}
+	if o.Guard != nil {
+		snk = guard.NewSink(snk, o.Guard)
+	}
+	return snk
+}
+
+// PlayerOpts is as PlayerWith, applying the output stage options o.
+func PlayerOpts(e Entry, v sound.Form, co sample.Codec, b int, o *SinkOptions) (sound.Sink, error) {
+	if !e.CanOpenSink() {
+		return nil, ErrUnsupported
+	}
+	snk, _, err := OpenSinkWith(e, e.DefaultOutputDev(), v, co, b, o)
+	return snk, err
+}
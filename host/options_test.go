@@ -0,0 +1,119 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package host
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"zikichombo.org/sio/dither"
+	"zikichombo.org/sio/guard"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// rawSink records data sent to it and claims to encode it with co.  Its
+// start time is set by the first Send.
+type rawSink struct {
+	sound.Form
+	co    sample.Codec
+	d     []float64
+	start time.Time
+}
+
+func (s *rawSink) Close() error                        { return nil }
+func (s *rawSink) Codec() sample.Codec                 { return s.co }
+func (s *rawSink) SendRaw(pkt *libsio.RawPacket) error { return nil }
+
+func (s *rawSink) Send(d []float64) error {
+	if s.start.IsZero() {
+		s.start = time.Now()
+	}
+	s.d = append(s.d, d...)
+	return nil
+}
+
+type snkEntry struct {
+	NullEntry
+	snk *rawSink
+}
+
+func (e *snkEntry) CanOpenSink() bool { return true }
+
+func (e *snkEntry) OpenSink(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Sink, *time.Time, error) {
+	return e.snk, &e.snk.start, nil
+}
+
+func TestOpenSinkWith(t *testing.T) {
+	d := []float64{0.1, 0.2, 0.3}
+	for _, co := range []sample.Codec{sample.SFloat32L, sample.SInt16L} {
+		e := &snkEntry{snk: &rawSink{Form: sound.MonoCd(), co: co}}
+		// the codec negotiated by the entry decides.
+		snk, _, err := OpenSinkWith(e, nil, sound.MonoCd(), sample.SInt8, 3, &SinkOptions{Dither: &dither.Config{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := snk.Send(d); err != nil {
+			t.Fatal(err)
+		}
+		for i, x := range e.snk.d {
+			q := x * 32767
+			dithered := math.Abs(q-math.Floor(q+0.5)) < 1e-6
+			if dithered != (co == sample.SInt16L) {
+				t.Errorf("%s sample %d: got %f", co, i, x)
+			}
+		}
+	}
+}
+
+func TestOpenSinkGuard(t *testing.T) {
+	e := &snkEntry{snk: &rawSink{Form: sound.MonoCd(), co: sample.SInt16L}}
+	o := &SinkOptions{Dither: &dither.Config{}, Guard: &guard.Config{}}
+	snk, _, err := OpenSinkWith(e, nil, sound.MonoCd(), sample.SInt16L, 3, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs, ok := snk.(*guard.Sink)
+	if !ok {
+		t.Fatalf("got %T", snk)
+	}
+	if err := snk.Send([]float64{2, math.Inf(-1), 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	for i, x := range e.snk.d {
+		if math.Abs(x) > 1.0/32767 {
+			t.Errorf("sample %d: got %f", i, x)
+		}
+	}
+	if st := gs.Stats(); !st.Muted || st.NonFinite != 1 {
+		t.Errorf("got %+v", st)
+	}
+}
+
+func TestOpenSinkGuardStart(t *testing.T) {
+	for _, mode := range []guard.Mode{guard.Clip, guard.Limit} {
+		e := &snkEntry{snk: &rawSink{Form: sound.MonoCd(), co: sample.SInt16L}}
+		o := &SinkOptions{Guard: &guard.Config{Mode: mode}}
+		snk, start, err := OpenSinkWith(e, nil, sound.MonoCd(), sample.SInt16L, 3, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !start.IsZero() {
+			t.Errorf("%s: start time %s before Send", mode, start)
+		}
+		if err := snk.Send(make([]float64, 3)); err != nil {
+			t.Fatal(err)
+		}
+		// the limiter delays the first frame sent.
+		delay := snk.(*guard.Sink).Delay()
+		if (delay != 0) != (mode == guard.Limit) {
+			t.Errorf("%s: delay %s", mode, delay)
+		}
+		if got := start.Sub(e.snk.start); got != delay {
+			t.Errorf("%s: start time %s after the entry's, want %s", mode, got, delay)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package host
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+)
+
+// ErrStop may be returned by a Callback to stop running without error.
+var ErrStop = errors.New("stop")
+
+// Period describes the period of frames passed to a Callback.
+type Period struct {
+	// Frame is the index of the first frame of the period since running
+	// started.
+	Frame int64
+	// Time is the time of the first frame of the period, the zero time if
+	// it is unknown.
+	Time time.Time
+	// Overruns is the number of calls to the Callback so far which
+	// overran their deadline.
+	Overruns int64
+}
+
+// Callback processes one period of channel deinterleaved input in and
+// places output in out: in[c] and out[c] hold the frames of channel c.
+// in is nil without input and out is nil without output.  out is zero
+// when Callback is called, and in holds fewer frames than out only at the
+// end of a source.
+//
+// A Callback is called from one goroutine at a time and shouldn't retain
+// in, out or p.  It has the duration of the period to return, see
+// Overrun.
+type Callback func(in, out [][]float64, p *Period) error
+
+// Overrun reports a call to a Callback which took longer than its
+// deadline, the duration of the period.
+type Overrun struct {
+	// Frame is the first frame of the period.
+	Frame int64
+	// Took is the time the Callback took.
+	Took time.Duration
+	// Deadline is the duration of the period.
+	Deadline time.Duration
+}
+
+// RunConfig configures Run.  Run uses the default sample codec of the
+// entry, other codecs can be run by opening streams and running them with
+// RunSource, RunSink or RunDuplex.
+type RunConfig struct {
+	// In and Out are the forms of the input and of the output.  With both,
+	// Run opens a duplex, with only In a source and with only Out a sink.
+	// If both are nil, Out is the default form of the entry.
+	In, Out sound.Form
+	// Dev is the device, nil for the default device.
+	Dev *libsio.Dev
+	// Frames is the number of frames in a period, 0 for the default
+	// buffer size of the entry.
+	Frames int
+	// Options are the output stage options of a sink.
+	Options *SinkOptions
+	// Overruns, if not nil, receives Overruns.  Overruns are dropped if
+	// Overruns isn't ready.
+	Overruns chan<- Overrun
+}
+
+// Run opens a stream of e as configured by cfg, which may be nil, and
+// calls cb once per period until ctx is done, cb returns an error or the
+// input ends.  The stream is closed when Run returns.
+//
+// Run returns ctx.Err() if ctx is done, nil if cb returned ErrStop or the
+// input ended, and otherwise the error of cb or of the stream.
+//
+// All buffers are allocated before the first period, so Run doesn't
+// allocate as it runs.
+func Run(ctx context.Context, e Entry, cfg *RunConfig, cb Callback) error {
+	var c RunConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.In == nil && c.Out == nil {
+		c.Out = e.DefaultForm()
+	}
+	co := e.DefaultSampleCodec()
+	if c.Frames <= 0 {
+		c.Frames = e.DefaultBufSize()
+	}
+	r := &runner{b: c.Frames, ovr: c.Overruns}
+	switch {
+	case c.In != nil && c.Out != nil:
+		if !e.CanOpenDuplex() {
+			return ErrUnsupported
+		}
+		dev := c.Dev
+		if dev == nil {
+			dev = e.DefaultDuplexDev()
+		}
+		dpx, ct, _, err := e.OpenDuplex(dev, c.In, c.Out, co, c.Frames)
+		if err != nil {
+			return err
+		}
+		defer dpx.Close()
+		r.start = &ct
+		return r.runDuplex(ctx, dpx, cb)
+	case c.In != nil:
+		if !e.CanOpenSource() {
+			return ErrUnsupported
+		}
+		dev := c.Dev
+		if dev == nil {
+			dev = e.DefaultInputDev()
+		}
+		src, st, err := e.OpenSource(dev, c.In, co, c.Frames)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		r.start = &st
+		return r.runSource(ctx, src, cb)
+	default:
+		if !e.CanOpenSink() {
+			return ErrUnsupported
+		}
+		dev := c.Dev
+		if dev == nil {
+			dev = e.DefaultOutputDev()
+		}
+		snk, st, err := OpenSinkWith(e, dev, c.Out, co, c.Frames, c.Options)
+		if err != nil {
+			return err
+		}
+		defer snk.Close()
+		r.start = st
+		return r.runSink(ctx, snk, cb)
+	}
+}
+
+// RunSource calls cb with periods of b frames received from src, as Run
+// does.  src isn't closed.
+func RunSource(ctx context.Context, src sound.Source, b int, cb Callback, o chan<- Overrun) error {
+	r := &runner{b: b, ovr: o}
+	return r.runSource(ctx, src, cb)
+}
+
+// RunSink calls cb for periods of b frames sent to snk, as Run does.  snk
+// isn't closed.
+func RunSink(ctx context.Context, snk sound.Sink, b int, cb Callback, o chan<- Overrun) error {
+	r := &runner{b: b, ovr: o}
+	return r.runSink(ctx, snk, cb)
+}
+
+// RunDuplex calls cb for periods of b frames sent to and received from
+// dpx, as Run does.  The output of a period is sent with the input of the
+// next period, the first period sending silence.  dpx isn't closed.
+func RunDuplex(ctx context.Context, dpx sound.Duplex, b int, cb Callback, o chan<- Overrun) error {
+	r := &runner{b: b, ovr: o}
+	return r.runDuplex(ctx, dpx, cb)
+}
+
+// runner holds the state of running a Callback.
+type runner struct {
+	b     int
+	ovr   chan<- Overrun
+	start *time.Time // time of the first frame, may be nil or zero.
+	sr    float64
+	per   time.Duration
+	p     Period
+}
+
+// init sets up r for a stream with sample rate sr and returns channel
+// deinterleaved buffers for nC channels with their per channel slices.
+func (r *runner) init(v sound.Form, nC int) ([]float64, [][]float64) {
+	r.sr = v.SampleRate().Float64()
+	r.per = r.dur(int64(r.b))
+	if nC == 0 {
+		return nil, nil
+	}
+	buf := make([]float64, nC*r.b)
+	chans := make([][]float64, nC)
+	for c := range chans {
+		chans[c] = buf[c*r.b : (c+1)*r.b]
+	}
+	return buf, chans
+}
+
+func (r *runner) dur(frames int64) time.Duration {
+	return time.Duration(float64(frames) / r.sr * float64(time.Second))
+}
+
+// call calls cb for a period of n frames.
+func (r *runner) call(cb Callback, in, out [][]float64, n int) error {
+	if r.start != nil && !r.start.IsZero() {
+		r.p.Time = r.start.Add(r.dur(r.p.Frame))
+	}
+	t := time.Now()
+	err := cb(in, out, &r.p)
+	if took := time.Since(t); took > r.per {
+		r.p.Overruns++
+		if r.ovr != nil {
+			select {
+			case r.ovr <- Overrun{Frame: r.p.Frame, Took: took, Deadline: r.per}:
+			default:
+			}
+		}
+	}
+	r.p.Frame += int64(n)
+	return err
+}
+
+// stopped returns the error of running for the error err of a Callback.
+func stopped(err error) error {
+	if err == ErrStop {
+		return nil
+	}
+	return err
+}
+
+func zero(d []float64) {
+	for i := range d {
+		d[i] = 0
+	}
+}
+
+func (r *runner) runSource(ctx context.Context, src sound.Source, cb Callback) error {
+	ib, in := r.init(src, src.Channels())
+	dc := ctx.Done()
+	for {
+		select {
+		case <-dc:
+			return ctx.Err()
+		default:
+		}
+		n, err := src.Receive(ib)
+		if n > 0 {
+			for c := range in {
+				in[c] = ib[c*r.b : c*r.b+n]
+			}
+			if cerr := r.call(cb, in, nil, n); cerr != nil {
+				return stopped(cerr)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *runner) runSink(ctx context.Context, snk sound.Sink, cb Callback) error {
+	ob, out := r.init(snk, snk.Channels())
+	dc := ctx.Done()
+	for {
+		select {
+		case <-dc:
+			return ctx.Err()
+		default:
+		}
+		zero(ob)
+		if err := r.call(cb, nil, out, r.b); err != nil {
+			return stopped(err)
+		}
+		if err := snk.Send(ob); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *runner) runDuplex(ctx context.Context, dpx sound.Duplex, cb Callback) error {
+	ib, in := r.init(dpx, dpx.InChannels())
+	ob, out := r.init(dpx, dpx.OutChannels())
+	dc := ctx.Done()
+	for {
+		select {
+		case <-dc:
+			return ctx.Err()
+		default:
+		}
+		n, err := dpx.SendReceive(ob, ib)
+		if err == io.EOF && n == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		for c := range in {
+			in[c] = ib[c*r.b : c*r.b+n]
+		}
+		zero(ob)
+		if cerr := r.call(cb, in, out, n); cerr != nil {
+			return stopped(cerr)
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
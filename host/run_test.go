@@ -0,0 +1,225 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package host
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"zikichombo.org/sound"
+)
+
+// rampSource sends n frames whose samples are c*1000 + frame.
+type rampSource struct {
+	sound.Form
+	f, n int
+}
+
+func (s *rampSource) Close() error { return nil }
+
+func (s *rampSource) Receive(d []float64) (int, error) {
+	nC := s.Channels()
+	nF := len(d) / nC
+	if s.f == s.n {
+		return 0, io.EOF
+	}
+	if s.f+nF > s.n {
+		nF = s.n - s.f
+	}
+	for c := 0; c < nC; c++ {
+		for i := 0; i < nF; i++ {
+			d[c*(len(d)/nC)+i] = float64(c*1000 + s.f + i)
+		}
+	}
+	s.f += nF
+	return nF, nil
+}
+
+// sumSink sums the samples sent to it.
+type sumSink struct {
+	sound.Form
+	frames int
+	sum    float64
+}
+
+func (s *sumSink) Close() error { return nil }
+
+func (s *sumSink) Send(d []float64) error {
+	s.frames += len(d) / s.Channels()
+	for _, x := range d {
+		s.sum += x
+	}
+	return nil
+}
+
+// loopDuplex receives what was sent.
+type loopDuplex struct {
+	sound.Form
+}
+
+func (d *loopDuplex) Close() error     { return nil }
+func (d *loopDuplex) InChannels() int  { return d.Channels() }
+func (d *loopDuplex) OutChannels() int { return d.Channels() }
+
+func (d *loopDuplex) SendReceive(out, in []float64) (int, error) {
+	copy(in, out)
+	return len(in) / d.Channels(), nil
+}
+
+func TestRunSource(t *testing.T) {
+	src := &rampSource{Form: sound.StereoCd(), n: 250}
+	frame := int64(0)
+	err := RunSource(context.Background(), src, 100, func(in, out [][]float64, p *Period) error {
+		if out != nil || len(in) != 2 {
+			t.Fatalf("got %d in %d out channels", len(in), len(out))
+		}
+		if p.Frame != frame {
+			t.Errorf("got frame %d, want %d", p.Frame, frame)
+		}
+		for c := range in {
+			for i, x := range in[c] {
+				if exp := float64(c*1000) + float64(frame) + float64(i); x != exp {
+					t.Fatalf("frame %d channel %d: got %f, want %f", i, c, x, exp)
+				}
+			}
+		}
+		frame += int64(len(in[0]))
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame != 250 {
+		t.Errorf("got %d frames", frame)
+	}
+}
+
+func TestRunSink(t *testing.T) {
+	snk := &sumSink{Form: sound.StereoCd()}
+	n := 0
+	err := RunSink(context.Background(), snk, 64, func(in, out [][]float64, p *Period) error {
+		if n == 3 {
+			return ErrStop
+		}
+		n++
+		for c := range out {
+			for i := range out[c] {
+				out[c][i] = float64(c + 1)
+			}
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snk.frames != 3*64 || snk.sum != 3*64*3 {
+		t.Errorf("got %d frames summing to %f", snk.frames, snk.sum)
+	}
+
+	errCb := errors.New("cb")
+	err = RunSink(context.Background(), snk, 64, func(in, out [][]float64, p *Period) error {
+		return errCb
+	}, nil)
+	if err != errCb {
+		t.Errorf("got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = RunSink(ctx, snk, 64, func(in, out [][]float64, p *Period) error {
+		cancel()
+		return nil
+	}, nil)
+	if err != context.Canceled {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRunDuplex(t *testing.T) {
+	dpx := &loopDuplex{Form: sound.MonoCd()}
+	n := 0
+	err := RunDuplex(context.Background(), dpx, 32, func(in, out [][]float64, p *Period) error {
+		// the output of a period comes back as the input of the next.
+		exp := float64(n)
+		for i, x := range in[0] {
+			if x != exp {
+				t.Fatalf("period %d frame %d: got %f, want %f", n, i, x, exp)
+			}
+		}
+		n++
+		for i := range out[0] {
+			out[0][i] = float64(n)
+		}
+		if n == 5 {
+			return ErrStop
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunOverrun(t *testing.T) {
+	// 32 frames at 44.1kHz is well under a millisecond.
+	snk := &sumSink{Form: sound.MonoCd()}
+	ovr := make(chan Overrun, 1)
+	var p *Period
+	RunSink(context.Background(), snk, 32, func(in, out [][]float64, pp *Period) error {
+		p = pp
+		if pp.Frame == 64 {
+			return ErrStop
+		}
+		if pp.Frame == 32 {
+			time.Sleep(5 * time.Millisecond)
+		}
+		return nil
+	}, ovr)
+	if p.Overruns != 1 {
+		t.Errorf("got %d overruns", p.Overruns)
+	}
+	o := <-ovr
+	if o.Frame != 32 || o.Took < 5*time.Millisecond || o.Deadline >= time.Millisecond {
+		t.Errorf("got %+v", o)
+	}
+}
+
+func TestRun(t *testing.T) {
+	e := &snkEntry{snk: &rawSink{Form: sound.MonoCd()}}
+	n := 0
+	err := Run(context.Background(), e, &RunConfig{Frames: 16}, func(in, out [][]float64, p *Period) error {
+		n++
+		if n == 4 {
+			return ErrStop
+		}
+		return nil
+	})
+	if err != nil || len(e.snk.d) != 3*16 {
+		t.Errorf("got %d samples, %v", len(e.snk.d), err)
+	}
+	if err := Run(context.Background(), e, &RunConfig{In: sound.MonoCd()}, nil); err != ErrUnsupported {
+		t.Errorf("got %v", err)
+	}
+}
+
+// TestRunAllocs checks running doesn't allocate once started.
+func TestRunAllocs(t *testing.T) {
+	snk := &sumSink{Form: sound.StereoCd()}
+	allocs := func(periods int64) float64 {
+		return testing.AllocsPerRun(10, func() {
+			RunSink(context.Background(), snk, 64, func(in, out [][]float64, p *Period) error {
+				if p.Frame == periods*64 {
+					return ErrStop
+				}
+				out[0][0] = 1
+				return nil
+			}, nil)
+		})
+	}
+	if a, b := allocs(1), allocs(1000); a != b {
+		t.Errorf("got %f allocations for 1 period and %f for 1000", a, b)
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package latency measures the round trip latency of sound streams.
+//
+// A test signal, a linear chirp or a maximum length sequence, is played
+// several times on a sink, separated by silence, while a source captures.
+// Each occurrence of the signal is located in the capture by cross
+// correlation, to a fraction of a frame.  The start times of the streams
+// relate capture frames to played frames, giving the latency of each run,
+// from which the mean and jitter are computed.
+//
+// Streams are either a duplex stream or a source and sink pair, see Run.
+// On hardware, the output is connected to the input with a loopback cable.
+// For tests and continuous integration, Loopback is a host.Entry whose
+// sources capture what its sinks play after a fixed delay.
+//
+// Package latency is part of http://zikichombo.org
+package latency /* import "zikichombo.org/sio/latency" */
@@ -0,0 +1,84 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package latency
+
+import (
+	"testing"
+	"time"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+func TestMLS(t *testing.T) {
+	for order := uint(6); order <= 18; order++ {
+		d := mls(1<<order-1, 1)
+		sum := 0.0
+		for _, v := range d {
+			sum += v
+		}
+		// a maximum length sequence has one more 1 than 0.
+		if sum != 1 {
+			t.Errorf("order %d: sum %g", order, sum)
+		}
+	}
+}
+
+func check(t *testing.T, res *Result, err error, want time.Duration) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Timed {
+		t.Errorf("untimed")
+	}
+	if res.Failed != 0 {
+		t.Errorf("%d failed runs", res.Failed)
+	}
+	if d := res.Mean - want; d < -20*time.Microsecond || d > 20*time.Microsecond {
+		t.Errorf("got %s, want %s", res, want)
+	}
+	if res.Jitter > 10*time.Microsecond {
+		t.Errorf("jitter %s", res.Jitter)
+	}
+}
+
+func TestDuplex(t *testing.T) {
+	l := NewLoopback(480)
+	v := sound.NewForm(48000*freq.Hertz, 2)
+	res, err := Run(l, nil, nil, v, sample.SFloat32L, 256, &Config{Runs: 5, MaxLatency: 50 * time.Millisecond})
+	check(t, res, err, 10*time.Millisecond)
+}
+
+func TestPair(t *testing.T) {
+	for _, s := range []Signal{Chirp, MLS} {
+		l := NewLoopback(333)
+		l.Noise = 0.05
+		v := sound.NewForm(8000*freq.Hertz, 1)
+		src, ti, err := l.OpenSource(nil, v, sample.SInt16L, 128)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snk, to, err := l.OpenSink(nil, v, sample.SInt16L, 128)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := &Config{Signal: s, Len: 1024, Runs: 4, MaxLatency: 100 * time.Millisecond}
+		res, err := Pair(src, ti, snk, to, 128, cfg)
+		check(t, res, err, 41625*time.Microsecond)
+		snk.Close()
+		src.Close()
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	l := NewLoopback(10)
+	l.Noise = 0.5
+	v := sound.NewForm(8000*freq.Hertz, 1)
+	_, err := Run(l, nil, nil, v, sample.SFloat32L, 64, &Config{Amp: 1e-6, Runs: 2, MaxLatency: 10 * time.Millisecond})
+	if err != ErrNotFound {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package latency
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// ErrBusy is returned when opening a source or sink on a Loopback which
+// already has one open.
+var ErrBusy = errors.New("latency: loopback stream already open")
+
+// Loopback is an in-process host.Entry without devices whose sources
+// capture what its sinks play, and whose duplex streams capture their own
+// output.  Captures are delayed by Delay frames.  Streams aren't paced:
+// while a sink is open the source waits for it, and otherwise it captures
+// silence as fast as it is read.
+//
+// A Loopback has at most one source and one sink open at once.  They
+// share a frame clock which starts when the first of them is opened and
+// is advanced by the source, or by the sink without a source.  Start
+// times are given by that clock, so the measured latency is exactly Delay
+// frames.  Sinks block while Delay plus 8192 frames are buffered for an
+// open source, and keep only the last Delay frames without one.
+type Loopback struct {
+	host.NullEntry
+
+	// Delay is the delay of captures in frames.
+	Delay int
+
+	// Noise is the amplitude of uniform noise added to captures.
+	Noise float64
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	t0   time.Time
+	clk  int64 // frames since t0
+	src  *lbSource
+	snk  *lbSink
+	air  []float64 // sink frames, channel-interleaved
+	nC   int       // channels of air
+	rnd  *rand.Rand
+}
+
+// NewLoopback creates a Loopback with a delay of delay frames.
+func NewLoopback(delay int) *Loopback {
+	l := &Loopback{Delay: delay, rnd: rand.New(rand.NewSource(1))}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// lbBuffer bounds the frames buffered beyond Delay.
+const lbBuffer = 8192
+
+func (l *Loopback) Name() string {
+	return "Loopback"
+}
+
+func (l *Loopback) CanOpenSource() bool {
+	return true
+}
+
+func (l *Loopback) CanOpenSink() bool {
+	return true
+}
+
+func (l *Loopback) CanOpenDuplex() bool {
+	return true
+}
+
+// session starts a session if no stream is open.  l.mu is held.
+func (l *Loopback) session() {
+	if l.src == nil && l.snk == nil {
+		l.t0 = time.Now()
+		l.clk = 0
+		l.air = l.air[:0]
+	}
+}
+
+// now returns the time of the frame clock for streams of form v.  l.mu is
+// held.
+func (l *Loopback) now(v sound.Form) time.Time {
+	return l.t0.Add(time.Duration(float64(l.clk) * float64(time.Second) / v.SampleRate().Float64()))
+}
+
+// checkForm checks v against the form of the open stream of the other
+// direction, o.  l.mu is held.
+func checkForm(v, o sound.Form) error {
+	if v.Channels() <= 0 {
+		return sound.ErrChannelAlignment
+	}
+	if o != nil && o.SampleRate() != v.SampleRate() {
+		return host.ErrUnsupported
+	}
+	return nil
+}
+
+func (l *Loopback) OpenSource(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Source, time.Time, error) {
+	var t time.Time
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.src != nil {
+		return nil, t, ErrBusy
+	}
+	var o sound.Form
+	if l.snk != nil {
+		o = l.snk
+	}
+	if err := checkForm(v, o); err != nil {
+		return nil, t, err
+	}
+	l.session()
+	l.src = &lbSource{Form: v, l: l}
+	if l.snk != nil {
+		// pad the last frames of the sink to a delay line.
+		if pad := l.Delay*l.nC - len(l.air); pad > 0 {
+			l.air = append(make([]float64, pad), l.air...)
+		}
+	}
+	return l.src, l.now(v), nil
+}
+
+func (l *Loopback) OpenSink(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Sink, *time.Time, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.snk != nil {
+		return nil, nil, ErrBusy
+	}
+	var o sound.Form
+	if l.src != nil {
+		o = l.src
+	}
+	if err := checkForm(v, o); err != nil {
+		return nil, nil, err
+	}
+	l.session()
+	l.snk = &lbSink{Form: v, l: l}
+	l.nC = v.Channels()
+	l.air = append(l.air[:0], make([]float64, l.Delay*l.nC)...)
+	t := l.now(v)
+	l.cond.Broadcast()
+	return l.snk, &t, nil
+}
+
+func (l *Loopback) OpenDuplex(d *libsio.Dev, iv, ov sound.Form, co sample.Codec, b int) (sound.Duplex, time.Time, *time.Time, error) {
+	var t time.Time
+	if iv.SampleRate() != ov.SampleRate() {
+		return nil, t, nil, host.ErrUnsupported
+	}
+	if iv.Channels() <= 0 || ov.Channels() <= 0 {
+		return nil, t, nil, sound.ErrChannelAlignment
+	}
+	x := &lbDuplex{
+		Form:  iv,
+		nO:    ov.Channels(),
+		noise: l.Noise,
+		rnd:   rand.New(rand.NewSource(1)),
+		line:  make([]float64, l.Delay*ov.Channels())}
+	t = time.Now()
+	tOut := t
+	return x, t, &tOut, nil
+}
+
+// noise returns a noise sample.  l.mu is held.
+func (l *Loopback) noise() float64 {
+	if l.Noise == 0 {
+		return 0
+	}
+	return l.Noise * (2*l.rnd.Float64() - 1)
+}
+
+type lbSource struct {
+	sound.Form
+	l      *Loopback
+	closed bool
+}
+
+func (s *lbSource) Receive(d []float64) (int, error) {
+	nC := s.Channels()
+	if len(d)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := len(d) / nC
+	l := s.l
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for !s.closed && l.snk != nil && len(l.air) < nF*l.nC {
+		l.cond.Wait()
+	}
+	if s.closed {
+		return 0, io.EOF
+	}
+	avail := 0
+	if l.nC > 0 {
+		avail = len(l.air) / l.nC
+	}
+	for f := 0; f < nF; f++ {
+		for c := 0; c < nC; c++ {
+			v := 0.0
+			if f < avail {
+				v = l.air[f*l.nC+c%l.nC]
+			}
+			d[c*nF+f] = v + l.noise()
+		}
+	}
+	if avail > nF {
+		avail = nF
+	}
+	l.air = append(l.air[:0], l.air[avail*l.nC:]...)
+	l.clk += int64(nF)
+	l.cond.Broadcast()
+	return nF, nil
+}
+
+func (s *lbSource) Close() error {
+	l := s.l
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		l.src = nil
+		l.cond.Broadcast()
+	}
+	return nil
+}
+
+type lbSink struct {
+	sound.Form
+	l      *Loopback
+	closed bool
+}
+
+func (s *lbSink) Send(d []float64) error {
+	nC := s.Channels()
+	if len(d)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	nF := len(d) / nC
+	l := s.l
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s.closed {
+		return io.EOF
+	}
+	max := (l.Delay + lbBuffer) * nC
+	for l.src != nil && !s.closed && len(l.air) >= max {
+		l.cond.Wait()
+	}
+	if s.closed {
+		return io.EOF
+	}
+	for f := 0; f < nF; f++ {
+		if l.src != nil && len(l.air) >= max {
+			break
+		}
+		for c := 0; c < nC; c++ {
+			l.air = append(l.air, d[c*nF+f])
+		}
+	}
+	if l.src == nil {
+		// the sink drives the clock, keeping a delay line for a source
+		// to come.
+		if n := len(l.air) - l.Delay*nC; n > 0 {
+			l.air = append(l.air[:0], l.air[n:]...)
+		}
+		l.clk += int64(nF)
+	}
+	l.cond.Broadcast()
+	return nil
+}
+
+func (s *lbSink) Close() error {
+	l := s.l
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		l.snk = nil
+		l.cond.Broadcast()
+	}
+	return nil
+}
+
+type lbDuplex struct {
+	sound.Form
+	nO     int
+	noise  float64
+	rnd    *rand.Rand
+	line   []float64 // delay line, channel-interleaved
+	pos    int
+	mu     sync.Mutex
+	closed bool
+}
+
+func (x *lbDuplex) InChannels() int {
+	return x.Channels()
+}
+
+func (x *lbDuplex) OutChannels() int {
+	return x.nO
+}
+
+func (x *lbDuplex) SendReceive(out, in []float64) (int, error) {
+	nI, nO := x.InChannels(), x.nO
+	if len(out)%nO != 0 || len(in)%nI != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := len(out) / nO
+	if len(in)/nI != nF {
+		return 0, sound.ErrFrameAlignment
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.closed {
+		return 0, io.EOF
+	}
+	nD := len(x.line) / nO
+	for f := 0; f < nF; f++ {
+		for c := 0; c < nI; c++ {
+			oc := c % nO
+			v := out[oc*nF+f]
+			if nD > 0 {
+				v = x.line[x.pos*nO+oc]
+			}
+			if x.noise != 0 {
+				v += x.noise * (2*x.rnd.Float64() - 1)
+			}
+			in[c*nF+f] = v
+		}
+		if nD > 0 {
+			for c := 0; c < nO; c++ {
+				x.line[x.pos*nO+c] = out[c*nF+f]
+			}
+			x.pos = (x.pos + 1) % nD
+		}
+	}
+	return nF, nil
+}
+
+func (x *lbDuplex) Close() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.closed = true
+	return nil
+}
@@ -0,0 +1,363 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package latency
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Config configures a measurement.  Zero fields take the defaults given
+// below.
+type Config struct {
+	// Signal is the test signal, Chirp by default.
+	Signal Signal
+
+	// Len is the length of the test signal in frames, 4096 by default.
+	Len int
+
+	// Amp is the amplitude of the test signal, 0.5 by default.
+	Amp float64
+
+	// Runs is the number of times the test signal is played, 10 by
+	// default.
+	Runs int
+
+	// MaxLatency bounds the latency searched for, 500ms by default.
+	MaxLatency time.Duration
+
+	// InChannel is the input channel captured.  The test signal is
+	// played on all output channels.
+	InChannel int
+
+	// Threshold is the minimum magnitude of the normalized correlation
+	// for the signal to be found, 0.5 by default.
+	Threshold float64
+}
+
+// ErrNotFound is returned when the test signal is not found in any run.
+var ErrNotFound = errors.New("latency: test signal not found")
+
+// Result is the result of a measurement.
+type Result struct {
+	// Rate is the sample rate.
+	Rate freq.T
+
+	// Latencies holds the latency of each run in which the test signal
+	// was found.
+	Latencies []time.Duration
+
+	// Failed is the number of runs in which the test signal wasn't
+	// found.
+	Failed int
+
+	// Timed is true if the start times of the streams were known.  If
+	// not, latencies are the frame offsets of capture from playback,
+	// which miss the latency not reflected in the frame counts.
+	Timed bool
+
+	// Mean, Min and Max summarize Latencies, and Jitter is their
+	// standard deviation.
+	Mean, Min, Max, Jitter time.Duration
+}
+
+func (r *Result) String() string {
+	if len(r.Latencies) == 0 {
+		return fmt.Sprintf("no latency found, %d runs failed", r.Failed)
+	}
+	s := fmt.Sprintf("latency %v (min %v, max %v), jitter %v over %d runs",
+		r.Mean, r.Min, r.Max, r.Jitter, len(r.Latencies))
+	if r.Failed > 0 {
+		s += fmt.Sprintf(", %d failed", r.Failed)
+	}
+	if !r.Timed {
+		s += ", untimed"
+	}
+	return s
+}
+
+// plan lays out the test signal in the output: after lead frames of
+// silence, it occurs every spacing frames.  Each occurrence is searched
+// for from margin frames before its expected position to maxLag frames
+// after.
+type plan struct {
+	cfg     Config
+	rate    freq.T
+	sig     []float64
+	lead    int
+	spacing int
+	margin  int
+	maxLag  int
+	total   int
+}
+
+func newPlan(cfg *Config, rate freq.T) *plan {
+	p := &plan{rate: rate}
+	if cfg != nil {
+		p.cfg = *cfg
+	}
+	c := &p.cfg
+	if c.Len <= 0 {
+		c.Len = 4096
+	}
+	if c.Amp == 0 {
+		c.Amp = 0.5
+	}
+	if c.Runs <= 0 {
+		c.Runs = 10
+	}
+	if c.MaxLatency <= 0 {
+		c.MaxLatency = 500 * time.Millisecond
+	}
+	if c.Threshold == 0 {
+		c.Threshold = 0.5
+	}
+	p.sig = c.Signal.Generate(c.Len, c.Amp)
+	L := len(p.sig)
+	p.margin = L / 2
+	p.maxLag = int(c.MaxLatency.Seconds()*rate.Float64() + 0.5)
+	p.lead = L
+	p.spacing = p.margin + p.maxLag + 2*L
+	p.total = p.lead + c.Runs*p.spacing
+	return p
+}
+
+// fill fills the channel deinterleaved d of nC channels with the output
+// from frame f.
+func (p *plan) fill(d []float64, nC, f int) {
+	nF := len(d) / nC
+	L := len(p.sig)
+	for i := 0; i < nF; i++ {
+		v := 0.0
+		if g := f + i - p.lead; g >= 0 {
+			r, k := g/p.spacing, g%p.spacing
+			if r < p.cfg.Runs && k < L {
+				v = p.sig[k]
+			}
+		}
+		for c := 0; c < nC; c++ {
+			d[c*nF+i] = v
+		}
+	}
+}
+
+// need returns the number of frames to capture, given the output frame
+// captured at frame 0 of the capture, off.
+func (p *plan) need(off float64) int {
+	n := p.total + int(math.Ceil(off))
+	if n < p.total {
+		n = p.total
+	}
+	return n
+}
+
+// offset returns the output frame played at the time of the first
+// captured frame, 0 if unknown.
+func (p *plan) offset(in time.Time, out *time.Time) (float64, bool) {
+	if in.IsZero() || out == nil || out.IsZero() {
+		return 0, false
+	}
+	return out.Sub(in).Seconds() * p.rate.Float64(), true
+}
+
+// analyze finds the test signal in capture.
+func (p *plan) analyze(capture []float64, in time.Time, out *time.Time) (*Result, error) {
+	off, timed := p.offset(in, out)
+	res := &Result{Rate: p.rate, Timed: timed}
+	period := float64(time.Second) / p.rate.Float64()
+	L := len(p.sig)
+	for r := 0; r < p.cfg.Runs; r++ {
+		j := p.lead + r*p.spacing
+		from := j + int(math.Floor(off)) - p.margin
+		to := from + p.margin + p.maxLag + L
+		if from < 0 {
+			from = 0
+		}
+		if to > len(capture) {
+			to = len(capture)
+		}
+		if to-from < L {
+			res.Failed++
+			continue
+		}
+		pos, score := find(capture[from:to], p.sig)
+		if math.Abs(score) < p.cfg.Threshold {
+			res.Failed++
+			continue
+		}
+		lag := float64(from) + pos - float64(j) - off
+		res.Latencies = append(res.Latencies, time.Duration(lag*period))
+	}
+	if len(res.Latencies) == 0 {
+		return res, ErrNotFound
+	}
+	res.Min, res.Max = res.Latencies[0], res.Latencies[0]
+	var sum float64
+	for _, l := range res.Latencies {
+		sum += float64(l)
+		if l < res.Min {
+			res.Min = l
+		}
+		if l > res.Max {
+			res.Max = l
+		}
+	}
+	mean := sum / float64(len(res.Latencies))
+	var ss float64
+	for _, l := range res.Latencies {
+		ss += (float64(l) - mean) * (float64(l) - mean)
+	}
+	res.Mean = time.Duration(mean)
+	res.Jitter = time.Duration(math.Sqrt(ss / float64(len(res.Latencies))))
+	return res, nil
+}
+
+// Duplex measures the latency of dpx, whose input and output start times
+// are in and out, as returned by host.Entry.OpenDuplex.  Buffers of b
+// frames are exchanged.
+func Duplex(dpx sound.Duplex, in time.Time, out *time.Time, b int, cfg *Config) (*Result, error) {
+	p := newPlan(cfg, dpx.SampleRate())
+	nI, nO := dpx.InChannels(), dpx.OutChannels()
+	if p.cfg.InChannel >= nI {
+		return nil, fmt.Errorf("latency: no input channel %d", p.cfg.InChannel)
+	}
+	ob := make([]float64, b*nO)
+	ib := make([]float64, b*nI)
+	capture := make([]float64, 0, p.total+b)
+	f := 0
+	for {
+		p.fill(ob, nO, f)
+		n, err := dpx.SendReceive(ob, ib)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		f += b
+		capture = append(capture, ib[p.cfg.InChannel*b:p.cfg.InChannel*b+n]...)
+		off, _ := p.offset(in, out)
+		if len(capture) >= p.need(off) {
+			break
+		}
+	}
+	return p.analyze(capture, in, out)
+}
+
+// Pair measures the latency from snk to src, whose start times are
+// snkStart and srcStart, as returned by host.Entry.OpenSink and
+// host.Entry.OpenSource.  The sink is fed by another goroutine in buffers
+// of b frames, and src is received from in buffers of b frames.
+func Pair(src sound.Source, srcStart time.Time, snk sound.Sink, snkStart *time.Time, b int, cfg *Config) (*Result, error) {
+	if src.SampleRate() != snk.SampleRate() {
+		return nil, fmt.Errorf("latency: source rate %s differs from sink rate %s", src.SampleRate(), snk.SampleRate())
+	}
+	p := newPlan(cfg, src.SampleRate())
+	nI, nO := src.Channels(), snk.Channels()
+	if p.cfg.InChannel >= nI {
+		return nil, fmt.Errorf("latency: no input channel %d", p.cfg.InChannel)
+	}
+	stop := make(chan struct{})
+	sent := make(chan struct{})
+	errC := make(chan error, 1)
+	go func() {
+		ob := make([]float64, b*nO)
+		f := 0
+		for {
+			select {
+			case <-stop:
+				errC <- nil
+				return
+			default:
+			}
+			p.fill(ob, nO, f)
+			if err := snk.Send(ob); err != nil {
+				errC <- err
+				return
+			}
+			f += b
+			if f >= p.total && f-b < p.total {
+				close(sent)
+			}
+		}
+	}()
+	ib := make([]float64, b*nI)
+	c := p.cfg.InChannel
+	capture := make([]float64, 0, p.total+b)
+	var err error
+	for done := false; err == nil && !done; {
+		var n int
+		n, err = src.Receive(ib)
+		capture = append(capture, ib[c*b:c*b+n]...)
+		select {
+		case serr := <-errC:
+			// the sink failed, it only stops otherwise when told.
+			return nil, serr
+		case <-sent:
+			// the sink has started, so snkStart is set.
+			off, _ := p.offset(srcStart, snkStart)
+			done = len(capture) >= p.need(off)
+		default:
+		}
+	}
+	close(stop)
+	// keep the source flowing until the sink stops.
+	var serr error
+	for stopped := false; !stopped; {
+		if err != nil {
+			serr = <-errC
+			break
+		}
+		select {
+		case serr = <-errC:
+			stopped = true
+		default:
+			_, err = src.Receive(ib)
+		}
+	}
+	if serr != nil {
+		return nil, serr
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return p.analyze(capture, srcStart, snkStart)
+}
+
+// Run measures the latency of e from the output device out to the input
+// device in, with streams of form v, sample codec co and buffer size b.  A
+// duplex stream is used if e supports them and in and out are the same,
+// and otherwise a source and sink pair.
+func Run(e host.Entry, in, out *libsio.Dev, v sound.Form, co sample.Codec, b int, cfg *Config) (*Result, error) {
+	if e.CanOpenDuplex() && in == out {
+		dpx, ti, to, err := e.OpenDuplex(in, v, v, co, b)
+		if err == nil {
+			defer dpx.Close()
+			return Duplex(dpx, ti, to, b, cfg)
+		}
+		if err != host.ErrUnsupported {
+			return nil, err
+		}
+	}
+	src, ti, err := e.OpenSource(in, v, co, b)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	snk, to, err := e.OpenSink(out, v, co, b)
+	if err != nil {
+		return nil, err
+	}
+	defer snk.Close()
+	return Pair(src, ti, snk, to, b, cfg)
+}
@@ -0,0 +1,199 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package latency
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// Signal is a kind of test signal.
+type Signal int
+
+const (
+	// Chirp is a linear frequency sweep from 1% to 40% of the sample rate
+	// with tapered ends.  It is robust to band limited paths.
+	Chirp Signal = iota
+
+	// MLS is a maximum length sequence of ±1, of length 2^n-1.  Its
+	// autocorrelation is the sharpest, but it needs a flat path.
+	MLS
+)
+
+func (s Signal) String() string {
+	switch s {
+	case Chirp:
+		return "chirp"
+	case MLS:
+		return "mls"
+	}
+	return fmt.Sprintf("Signal(%d)", int(s))
+}
+
+// ParseSignal returns the Signal whose String is name.
+func ParseSignal(name string) (Signal, error) {
+	for _, s := range []Signal{Chirp, MLS} {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	return Chirp, fmt.Errorf("latency: unknown signal %q", name)
+}
+
+// Generate returns the test signal s of about n frames with amplitude amp.
+func (s Signal) Generate(n int, amp float64) []float64 {
+	switch s {
+	case MLS:
+		return mls(n, amp)
+	}
+	return chirp(n, amp)
+}
+
+func chirp(n int, amp float64) []float64 {
+	d := make([]float64, n)
+	// frequencies in cycles per frame.
+	f0, f1 := 0.01, 0.4
+	taper := n / 20
+	for i := range d {
+		t := float64(i)
+		ph := 2 * math.Pi * (f0*t + (f1-f0)*t*t/(2*float64(n)))
+		g := amp
+		if i < taper {
+			g *= 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(taper))
+		} else if j := n - 1 - i; j < taper {
+			g *= 0.5 - 0.5*math.Cos(math.Pi*float64(j)/float64(taper))
+		}
+		d[i] = g * math.Sin(ph)
+	}
+	return d
+}
+
+// mlsTaps gives feedback taps of maximal length Fibonacci LFSRs by order.
+var mlsTaps = map[uint][]uint{
+	6:  {6, 5},
+	7:  {7, 6},
+	8:  {8, 6, 5, 4},
+	9:  {9, 5},
+	10: {10, 7},
+	11: {11, 9},
+	12: {12, 11, 10, 4},
+	13: {13, 12, 11, 8},
+	14: {14, 13, 12, 2},
+	15: {15, 14},
+	16: {16, 15, 13, 4},
+	17: {17, 14},
+	18: {18, 11},
+}
+
+// mls returns a maximum length sequence of the smallest order from 6 to
+// 18 whose length is at least n.
+func mls(n int, amp float64) []float64 {
+	order := uint(6)
+	for order < 18 && 1<<order-1 < n {
+		order++
+	}
+	taps := mlsTaps[order]
+	d := make([]float64, 1<<order-1)
+	state := uint32(1)
+	for i := range d {
+		if state&1 != 0 {
+			d[i] = amp
+		} else {
+			d[i] = -amp
+		}
+		var bit uint32
+		for _, t := range taps {
+			bit ^= state >> (order - t)
+		}
+		state = state>>1 | (bit&1)<<(order-1)
+	}
+	return d
+}
+
+// fft computes the discrete Fourier transform of x in place, or its
+// inverse without scaling if inv is true.  len(x) is a power of 2.
+func fft(x []complex128, inv bool) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	sign := -1.0
+	if inv {
+		sign = 1
+	}
+	for m := 2; m <= n; m <<= 1 {
+		w := cmplx.Rect(1, sign*2*math.Pi/float64(m))
+		for k := 0; k < n; k += m {
+			wk := complex(1, 0)
+			for j := 0; j < m/2; j++ {
+				a, b := x[k+j], wk*x[k+j+m/2]
+				x[k+j], x[k+j+m/2] = a+b, a-b
+				wk *= w
+			}
+		}
+	}
+}
+
+// find locates sig in w by cross correlation.  It returns the position of
+// sig in w to a fraction of a frame and the normalized correlation there,
+// whose magnitude is 1 for an exact, possibly scaled, copy.
+func find(w, sig []float64) (float64, float64) {
+	L := len(sig)
+	if len(w) < L {
+		return 0, 0
+	}
+	n := 1
+	for n < len(w)+L {
+		n <<= 1
+	}
+	a := make([]complex128, n)
+	s := make([]complex128, n)
+	for i, v := range w {
+		a[i] = complex(v, 0)
+	}
+	for i, v := range sig {
+		s[i] = complex(v, 0)
+	}
+	fft(a, false)
+	fft(s, false)
+	for i := range a {
+		a[i] *= cmplx.Conj(s[i])
+	}
+	fft(a, true)
+	nLag := len(w) - L + 1
+	corr := make([]float64, nLag)
+	p := 0
+	for i := range corr {
+		corr[i] = real(a[i]) / float64(n)
+		if math.Abs(corr[i]) > math.Abs(corr[p]) {
+			p = i
+		}
+	}
+	var es, ew float64
+	for i, v := range sig {
+		es += v * v
+		ew += w[p+i] * w[p+i]
+	}
+	if es == 0 || ew == 0 {
+		return float64(p), 0
+	}
+	score := corr[p] / math.Sqrt(es*ew)
+	// parabolic interpolation of the peak.
+	pos := float64(p)
+	if p > 0 && p < nLag-1 {
+		y0, y1, y2 := math.Abs(corr[p-1]), math.Abs(corr[p]), math.Abs(corr[p+1])
+		if den := y0 - 2*y1 + y2; den != 0 {
+			pos += 0.5 * (y0 - y2) / den
+		}
+	}
+	return pos, score
+}
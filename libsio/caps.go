@@ -0,0 +1,238 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package libsio
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// ErrNoCaps is returned by Caps.Closest when the direction is
+// unsupported.
+var ErrNoCaps = errors.New("direction unsupported by device")
+
+// RateRange is a continuous range of sample rates.
+type RateRange struct {
+	Min, Max freq.T
+}
+
+// Layout names the speaker position of each channel of a channel layout,
+// as in Stereo.
+type Layout []string
+
+var (
+	Mono       = Layout{"FC"}
+	Stereo     = Layout{"FL", "FR"}
+	Surround51 = Layout{"FL", "FR", "FC", "LFE", "RL", "RR"}
+	Surround71 = Layout{"FL", "FR", "FC", "LFE", "RL", "RR", "SL", "SR"}
+)
+
+// MaxChannels is the channel bound of devices whose data format doesn't
+// bound the number of channels, such as raw PCM.  It is arbitrary, but
+// larger than the number of channels of any hardware.
+const MaxChannels = 64
+
+// Caps describes the capabilities of a device in one direction.
+//
+// Empty lists and zero bounds mean the entry doesn't know, rather than
+// that nothing is supported, except for MaxChannels.
+type Caps struct {
+	// MinChannels and MaxChannels bound the number of channels.  The
+	// direction is unsupported if MaxChannels is 0.
+	MinChannels, MaxChannels int
+
+	// Layouts lists the channel layouts of the device, if known.
+	Layouts []Layout
+
+	// Rates lists discrete sample rates, and RateRanges continuous ranges
+	// of sample rates.  A rate is supported if it is in either.
+	Rates      []freq.T
+	RateRanges []RateRange
+
+	// Codecs lists the sample codecs.
+	Codecs []sample.Codec
+
+	// MinPeriod and MaxPeriod bound the period, or buffer size, in
+	// frames.
+	MinPeriod, MaxPeriod int
+
+	// MinLatency and MaxLatency bound the latency of the device.
+	MinLatency, MaxLatency time.Duration
+}
+
+func (c *Caps) String() string {
+	if !c.Supported() {
+		return "none"
+	}
+	min, max := c.RateBounds()
+	return fmt.Sprintf("(%d..%d)@[%s..%s]%v", c.MinChannels, c.MaxChannels, min, max, c.Codecs)
+}
+
+// Supported returns whether the direction is supported.
+func (c *Caps) Supported() bool {
+	return c.MaxChannels > 0
+}
+
+func (c *Caps) supportsChannels(n int) bool {
+	return n > 0 && n >= c.MinChannels && n <= c.MaxChannels
+}
+
+// SupportsRate returns whether c supports the sample rate r.
+func (c *Caps) SupportsRate(r freq.T) bool {
+	if len(c.Rates) == 0 && len(c.RateRanges) == 0 {
+		return r > 0
+	}
+	for _, o := range c.Rates {
+		if o == r {
+			return true
+		}
+	}
+	for _, rr := range c.RateRanges {
+		if r >= rr.Min && r <= rr.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsForm returns whether c supports the channels and sample rate of
+// v.
+func (c *Caps) SupportsForm(v sound.Form) bool {
+	return c.supportsChannels(v.Channels()) && c.SupportsRate(v.SampleRate())
+}
+
+// SupportsCodec returns whether c supports the sample codec co.
+func (c *Caps) SupportsCodec(co sample.Codec) bool {
+	if len(c.Codecs) == 0 {
+		return true
+	}
+	for _, o := range c.Codecs {
+		if o == co {
+			return true
+		}
+	}
+	return false
+}
+
+// RateBounds returns the lowest and highest supported sample rates, 0 if
+// unknown.
+func (c *Caps) RateBounds() (min, max freq.T) {
+	for _, r := range c.Rates {
+		if min == 0 || r < min {
+			min = r
+		}
+		if r > max {
+			max = r
+		}
+	}
+	for _, rr := range c.RateRanges {
+		if min == 0 || rr.Min < min {
+			min = rr.Min
+		}
+		if rr.Max > max {
+			max = rr.Max
+		}
+	}
+	return min, max
+}
+
+// Closest returns the supported form and sample codec closest to v and
+// co.  The number of channels is clamped to the supported range.  The
+// sample rate is the nearest supported one, the higher of two equally
+// near.  The codec is co if supported, and otherwise one which doesn't
+// lose precision if possible, preferring codecs of the same kind,
+// integer or float, and of the nearest size.
+func (c *Caps) Closest(v sound.Form, co sample.Codec) (sound.Form, sample.Codec, error) {
+	if !c.Supported() {
+		return nil, co, ErrNoCaps
+	}
+	nC := v.Channels()
+	if nC < c.MinChannels {
+		nC = c.MinChannels
+	}
+	if nC < 1 {
+		nC = 1
+	}
+	if nC > c.MaxChannels {
+		nC = c.MaxChannels
+	}
+	return sound.NewForm(c.closestRate(v.SampleRate()), nC), c.closestCodec(co), nil
+}
+
+func (c *Caps) closestRate(r freq.T) freq.T {
+	if c.SupportsRate(r) {
+		return r
+	}
+	best := freq.T(0)
+	try := func(o freq.T) {
+		if best == 0 || dist(o, r) < dist(best, r) || (dist(o, r) == dist(best, r) && o > best) {
+			best = o
+		}
+	}
+	for _, o := range c.Rates {
+		try(o)
+	}
+	for _, rr := range c.RateRanges {
+		o := r
+		if o < rr.Min {
+			o = rr.Min
+		}
+		if o > rr.Max {
+			o = rr.Max
+		}
+		try(o)
+	}
+	return best
+}
+
+func dist(a, b freq.T) freq.T {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func (c *Caps) closestCodec(co sample.Codec) sample.Codec {
+	if c.SupportsCodec(co) {
+		return co
+	}
+	// rank by loss of precision, then change of kind, then size.
+	rank := func(o sample.Codec) [3]int {
+		var r [3]int
+		d := o.Bytes() - co.Bytes()
+		if d < 0 {
+			r[0] = 1
+			d = -d
+		}
+		if o.IsFloat() != co.IsFloat() {
+			r[1] = 1
+		}
+		r[2] = d
+		return r
+	}
+	best := c.Codecs[0]
+	for _, o := range c.Codecs[1:] {
+		ro, rb := rank(o), rank(best)
+		if ro[0] < rb[0] || ro[0] == rb[0] && (ro[1] < rb[1] || ro[1] == rb[1] && ro[2] < rb[2]) {
+			best = o
+		}
+	}
+	return best
+}
+
+// ClosestPeriod returns the supported period closest to b frames.
+func (c *Caps) ClosestPeriod(b int) int {
+	if c.MinPeriod > 0 && b < c.MinPeriod {
+		b = c.MinPeriod
+	}
+	if c.MaxPeriod > 0 && b > c.MaxPeriod {
+		b = c.MaxPeriod
+	}
+	return b
+}
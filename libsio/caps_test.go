@@ -0,0 +1,50 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package libsio
+
+import (
+	"testing"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+func TestCapsClosest(t *testing.T) {
+	c := &Caps{
+		MinChannels: 2,
+		MaxChannels: 8,
+		Rates:       []freq.T{44100 * freq.Hertz, 48000 * freq.Hertz},
+		RateRanges:  []RateRange{{Min: 88200 * freq.Hertz, Max: 96000 * freq.Hertz}},
+		Codecs:      []sample.Codec{sample.SInt16L, sample.SInt32L, sample.SFloat32L}}
+	for i, x := range []struct {
+		c   int
+		r   freq.T
+		co  sample.Codec
+		wc  int
+		wr  freq.T
+		wco sample.Codec
+	}{
+		{2, 48000, sample.SInt16L, 2, 48000, sample.SInt16L},
+		{1, 44100, sample.SInt32L, 2, 44100, sample.SInt32L},
+		{16, 8000, sample.SFloat32L, 8, 44100, sample.SFloat32L},
+		{2, 46050, sample.SInt24L, 2, 48000, sample.SInt32L},
+		{2, 92000, sample.SFloat64L, 2, 92000, sample.SFloat32L},
+		{2, 192000, sample.SInt8, 2, 96000, sample.SInt16L},
+	} {
+		v, co, err := c.Closest(sound.NewForm(x.r*freq.Hertz, x.c), x.co)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Channels() != x.wc || v.SampleRate() != x.wr*freq.Hertz || co != x.wco {
+			t.Errorf("%d: got %d %s %s", i, v.Channels(), v.SampleRate(), co)
+		}
+		if !c.SupportsForm(v) || !c.SupportsCodec(co) {
+			t.Errorf("%d: unsupported result", i)
+		}
+	}
+	if _, _, err := (&Caps{}).Closest(sound.MonoCd(), sample.SInt16L); err != ErrNoCaps {
+		t.Errorf("got %v, want ErrNoCaps", err)
+	}
+}
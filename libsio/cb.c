@@ -32,6 +32,7 @@ Cb * newCb(int bufSz) {
 	cb->time.tv_sec = 0;
 	cb->time.tv_nsec = 1000;
 	cb->inGo = 0;
+	cb->closed = 0;
 	cb->inCb = inCb;
 	cb->outCb = outCb;
 	cb->duplexCb = duplexCb;
@@ -129,8 +130,9 @@ static void toGoAndBack(Cb *cb) {
 	}
 }
 
-// TBD
+// closeCb makes Go i/o waiting for a callback return, see Cb.Close.
 void closeCb(Cb *cb) {
+	atomic_store(&cb->closed, 1);
 }
 
 
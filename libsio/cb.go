@@ -10,9 +10,9 @@ package libsio
 import "C"
 import (
 	"errors"
-	"fmt"
 	"io"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -63,7 +63,7 @@ const (
 //    - the caller should call SetMinCbFrames before use.
 //
 //  4. For best reliability, the Go code should be run on a thread with the same
-//     priority as the C API.
+//     priority as the C API, see Cb.ServeRT.
 //
 //  5. A Multicore system will be more reliable and give better performance than
 //     a single core system because the Go code will be on a different thread
@@ -95,6 +95,8 @@ type Cb struct {
 	// latency and cpu overhead, there is nothing that can be done as any regular alignment
 	// of bursts of irregular length data will have this effect.
 	over []float64
+	// likewise for ReceiveRaw
+	overRaw []byte
 
 	// time tracking
 	frames   int64
@@ -102,31 +104,17 @@ type Cb struct {
 	orgTime  time.Time // time of first sample w.r.t. underlying API
 	frameDur time.Duration
 
+	// whether minCbf frames last long enough to sleep, see maybeSleep.
+	sleeps bool
+
 	// keep track of missed deadlines.
 	misses []MissedDeadline
-}
-
-// MissedDeadline holds information for when a deadline
-// for communication with the C API was missed.
-//
-// If the underlying C API uses buffering, then some deadlines
-// may be missed and not cause glitching.  However, if no deadlines
-// are missed, then we know we are keeping up sufficiently
-// to not cause glitching.
-//
-// We cannot be more precise than this without imposing
-// assumptions on the underlying API that may or may not hold.
-type MissedDeadline struct {
-	// frame is the number of frames exchanged with the underlying API.
-	Frame int64
-	// OffBy is how much earlier the communication would have needed
-	// to happen in order to not miss the deadline.
-	OffBy time.Duration
-}
+	// misses not kept as misses was full, accessed atomically.
+	dropped int64
 
-// String for convenience.
-func (m *MissedDeadline) String() string {
-	return fmt.Sprintf("missed frame %d by %s\n", m.Frame, m.OffBy)
+	// mu is held by i/o calls, so that Close frees c once they return.
+	mu      sync.Mutex
+	closing int32
 }
 
 // NewCb creates a new Cb for the specified form (channels + sample rate)
@@ -139,27 +127,56 @@ func NewCb(v sound.Form, sco sample.Codec, b int) *Cb {
 		bsz:      b,
 		il:       cil.New(v.Channels(), b),
 		over:     make([]float64, 0, b),
+		overRaw:  make([]byte, 0, b*v.Channels()*sco.Bytes()),
 		c:        C.newCb(C.int(b)),
 		minCbf:   b,
 		frameDur: fd,
+		sleeps:   time.Duration(b)*fd > sleepSlack,
 		misses:   make([]MissedDeadline, 0, 128)}
 }
 
-// Close must be called to avoid resource leakage.
+// Close must be called to avoid resource leakage.  It may be called
+// concurrently with i/o, which then returns io.EOF, and more than once.
+//
+// The C API must no longer call back when Close is called.
 func (r *Cb) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.closing, 0, 1) {
+		return nil
+	}
 	C.closeCb(r.c)
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	C.freeCb(r.c)
+	r.c = nil
+	return nil
+}
+
+// lock locks r for i/o, returning io.EOF if r is closed.
+func (r *Cb) lock() error {
+	r.mu.Lock()
+	if r.c == nil {
+		r.mu.Unlock()
+		return io.EOF
+	}
 	return nil
 }
 
 // LastMisses returns the slice of MissedDeadline's
 // associated with the last i/o call (amongst Send,Receive,Duplex).
 //
-// the slice is cleared on entry to to an i/o call.
+// the slice is cleared on entry to to an i/o call, and holds at most
+// 128 misses so that recording them doesn't allocate.  Further misses are
+// counted by DroppedMisses.
 func (r *Cb) LastMisses() []MissedDeadline {
 	return r.misses
 }
 
+// DroppedMisses returns the number of missed deadlines which were not
+// recorded in LastMisses, as it was full, since r was created.
+func (r *Cb) DroppedMisses() int64 {
+	return atomic.LoadInt64(&r.dropped)
+}
+
 // LastMissed returns true if the last i/o call involved some
 // missed deadlines communicating with the underlying API.
 func (r *Cb) LastMissed() bool {
@@ -182,6 +199,7 @@ func (r *Cb) LastMissed() bool {
 // pseudo-spin more.
 func (r *Cb) SetMinCbFrames(cbf int) {
 	r.minCbf = cbf
+	r.sleeps = time.Duration(cbf)*r.frameDur > sleepSlack
 }
 
 // Receive is as in sound.Source.Receive
@@ -196,6 +214,10 @@ func (r *Cb) Receive(d []float64) (int, error) {
 	if nF%b != 0 {
 		return 0, sound.ErrFrameAlignment
 	}
+	if err := r.lock(); err != nil {
+		return 0, err
+	}
+	defer r.mu.Unlock()
 	r.misses = r.misses[:0]
 	start := 0
 	if len(r.over) != 0 {
@@ -211,7 +233,7 @@ func (r *Cb) Receive(d []float64) (int, error) {
 
 	for start < nF {
 		if err := r.fromC(addr); err != nil {
-			return 0, ErrCApiLost
+			return 0, err
 		}
 
 		nf = int(r.c.inF)
@@ -269,6 +291,10 @@ func (r *Cb) Send(d []float64) error {
 	if nF%b != 0 {
 		return sound.ErrFrameAlignment
 	}
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
 	r.misses = r.misses[:0]
 	r.il.Inter(d)
 	start := 0
@@ -280,7 +306,7 @@ func (r *Cb) Send(d []float64) error {
 	for start < nF {
 		r.checkDeadline(r.frames)
 		if err := r.fromC(addr); err != nil {
-			return ErrCApiLost
+			return err
 		}
 		// get the slice at buffer size
 		nf = int(r.c.outF)
@@ -311,6 +337,108 @@ func (r *Cb) Send(d []float64) error {
 	return nil
 }
 
+// Codec returns the sample codec of the data exchanged with the C API.
+func (r *Cb) Codec() sample.Codec {
+	return r.sco
+}
+
+// ReceiveRaw implements RawSource.  ReceiveRaw returns the data of at most
+// one callback of the C API.  If the callback data does not fit in pkt.D, the
+// remainder is returned by the next call.
+//
+// r should be used either via Receive or via ReceiveRaw, not both.
+func (r *Cb) ReceiveRaw(pkt *RawPacket) error {
+	bpf := r.sco.Bytes() * r.Channels()
+	nB := (cap(pkt.D) / bpf) * bpf
+	if nB == 0 {
+		return io.ErrShortBuffer
+	}
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+	r.misses = r.misses[:0]
+	if len(r.overRaw) != 0 {
+		pkt.D = pkt.D[:copy(pkt.D[:nB], r.overRaw)]
+		r.overRaw = r.overRaw[:copy(r.overRaw, r.overRaw[len(pkt.D):])]
+		pkt.N = int(r.frames) - (len(pkt.D)+len(r.overRaw))/bpf
+		pkt.Start = r.orgTime
+		return nil
+	}
+	addr := (*uint32)(unsafe.Pointer(&r.c.inGo))
+	if err := r.fromC(addr); err != nil {
+		return err
+	}
+	nf := int(r.c.inF)
+	if nf == 0 {
+		if err := r.toC(addr); err != nil {
+			return ErrCApiLost
+		}
+		return io.EOF
+	}
+	if r.frames == 0 {
+		r.setOrgTime(0)
+	}
+	cbBuf := (*[1 << 30]byte)(unsafe.Pointer(r.c.in))[:nf*bpf]
+	pkt.D = pkt.D[:copy(pkt.D[:nB], cbBuf)]
+	r.overRaw = append(r.overRaw[:0], cbBuf[len(pkt.D):]...)
+	if err := r.toC(addr); err != nil {
+		return ErrCApiLost
+	}
+	pkt.N = int(r.frames)
+	pkt.Start = r.orgTime
+	r.frames += int64(nf)
+	r.checkDeadline(r.frames)
+	return nil
+}
+
+// SendRaw implements RawSink.  Cb does not support scheduling in the
+// future, so pkt.N is ignored.
+//
+// r should be used either via Send or via SendRaw, not both.
+func (r *Cb) SendRaw(pkt *RawPacket) error {
+	bpf := r.sco.Bytes() * r.Channels()
+	if len(pkt.D)%bpf != 0 {
+		return sound.ErrChannelAlignment
+	}
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+	r.misses = r.misses[:0]
+	addr := (*uint32)(unsafe.Pointer(&r.c.inGo))
+	d := pkt.D
+	var nf int
+	for len(d) > 0 {
+		r.checkDeadline(r.frames)
+		if err := r.fromC(addr); err != nil {
+			return err
+		}
+		nf = int(r.c.outF)
+		if nf == 0 {
+			if err := r.toC(addr); err != nil {
+				return ErrCApiLost
+			}
+			return io.EOF
+		}
+		if r.frames == 0 {
+			r.setOrgTime(0)
+		}
+		if nf*bpf > len(d) {
+			nf = len(d) / bpf
+		}
+		cbBuf := (*[1 << 30]byte)(unsafe.Pointer(r.c.out))[:nf*bpf]
+		copy(cbBuf, d)
+		r.c.outF = C.int(nf)
+		if err := r.toC(addr); err != nil {
+			return ErrCApiLost
+		}
+		r.frames += int64(nf)
+		d = d[nf*bpf:]
+	}
+	return nil
+}
+
 // C returns a pointer to the C.Cb which does the C callbacks for
 // r.
 func (r *Cb) C() unsafe.Pointer {
@@ -322,6 +450,18 @@ func (r *Cb) SendReceive(out, in []float64) (int, error) {
 	return 0, nil
 }
 
+// ServeRT applies the configuration of SetRealtime to the calling
+// goroutine, as the package level ServeRT, so that it consumes r with
+// realtime scheduling.
+//
+// The goroutine is then locked to its OS thread for good, so ServeRT
+// should only be called from a goroutine dedicated to the i/o of r, before
+// its first i/o call.  Cb doesn't call it by itself, as the goroutines
+// calling its i/o methods belong to the application.
+func (r *Cb) ServeRT() *RTStatus {
+	return ServeRT("cb")
+}
+
 // set the time for the first sample.  the time is
 // the time that we know the underlying API will have
 // access to the first sample (for playback) or
@@ -333,9 +473,10 @@ func (r *Cb) setOrgTime(nf int) {
 }
 
 // maybeSleep sleeps only if the minimum buffer size is bigger than estimated
-// OS latency jitter.  see sleepSlack above.
+// OS latency jitter.  see sleepSlack above.  Otherwise, it doesn't even
+// read the clock.
 func (r *Cb) maybeSleep() {
-	if r.frames == 0 {
+	if !r.sleeps || r.frames == 0 {
 		return
 	}
 	trg := r.orgTime.Add(time.Duration(int64(r.bsz)+r.frames) * r.frameDur)
@@ -363,8 +504,13 @@ func (r *Cb) checkDeadline(nf int64) {
 	}
 	trg := r.orgTime.Add(time.Duration(nf+1) * r.frameDur)
 	deadline := time.Until(trg)
-	if deadline < 0 {
+	if deadline >= 0 {
+		return
+	}
+	if len(r.misses) < cap(r.misses) {
 		r.misses = append(r.misses, MissedDeadline{nf, -deadline})
+	} else {
+		atomic.AddInt64(&r.dropped, 1)
 	}
 }
 
@@ -373,9 +519,12 @@ func (r *Cb) checkDeadline(nf int64) {
 // block.
 var ErrCApiLost = errors.New("too many atomic tries, C callbacks aren't happening.")
 
+// fromC waits for the C API to call back, returning io.EOF if r is closed
+// meanwhile.
 func (r *Cb) fromC(addr *uint32) error {
 	r.maybeSleep()
 
+	closed := (*int32)(unsafe.Pointer(&r.c.closed))
 	var sz uint32
 	i := 0
 	for {
@@ -385,6 +534,9 @@ func (r *Cb) fromC(addr *uint32) error {
 		}
 		i++
 		if i%atomicTryLen == 0 {
+			if atomic.LoadInt32(closed) != 0 {
+				return io.EOF
+			}
 			if i >= atomicTryLim {
 				return ErrCApiLost
 			}
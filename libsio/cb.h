@@ -19,6 +19,7 @@ typedef struct Cb {
 	void * out; // output buffer
 	int outF; // output number of sample frames
 	struct timespec time;  // for throttling a bit when there's a long wait.
+	_Atomic int closed; // set by closeCb, Go stops waiting on C.
 
 
 	// function pointers below are used to give access to callbacks to 
@@ -5,9 +5,12 @@ package libsio
 
 import (
 	"fmt"
+	"io"
 	"testing"
+	"time"
 
 	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
 	"zikichombo.org/sound/sample"
 )
 
@@ -60,3 +63,183 @@ func TestCbPlay(t *testing.T) {
 		}
 	}
 }
+
+func TestEmulateCb(t *testing.T) {
+	N := 200
+	v := sound.NewForm(48000*freq.Hertz, 2)
+	c := sample.SInt16L
+	b := 48
+	cb := NewCb(v, c, b)
+	defer cb.Close()
+	go EmulateCb(cb, true, N, time.Millisecond, 200*time.Microsecond)
+	d := make([]float64, b*v.Channels())
+	n := 0
+	for {
+		_, err := cb.Receive(d)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != N {
+		t.Errorf("got %d callbacks, want %d", n, N)
+	}
+}
+
+func TestCbCloseDuringIO(t *testing.T) {
+	v := sound.MonoCd()
+	b := 64
+	for _, input := range []bool{true, false} {
+		cb := NewCb(v, sample.SInt16L, b)
+		d := make([]float64, b)
+		errC := make(chan error, 1)
+		// the C side never calls back, i/o waits until Close.
+		go func() {
+			if input {
+				_, err := cb.Receive(d)
+				errC <- err
+				return
+			}
+			errC <- cb.Send(d)
+		}()
+		time.Sleep(10 * time.Millisecond)
+		cb.Close()
+		select {
+		case err := <-errC:
+			if err != io.EOF {
+				t.Errorf("input %t: got %v not io.EOF", input, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("input %t: i/o didn't return after Close", input)
+		}
+		if _, err := cb.Receive(d); err != io.EOF {
+			t.Errorf("input %t: Receive after Close got %v", input, err)
+		}
+		cb.Close()
+	}
+}
+
+func TestCbServeRT(t *testing.T) {
+	sc := make(chan *RTStatus, 1)
+	SetRealtime(&RTConfig{NoRTKit: true, Status: sc})
+	defer SetRealtime(nil)
+	b := 48
+	cb := NewCb(sound.MonoCd(), sample.SInt16L, b)
+	defer cb.Close()
+	go EmulateCb(cb, true, 4, 0, 0)
+	// i/o doesn't elevate the calling goroutine.
+	d := make([]float64, b)
+	if _, err := cb.Receive(d); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-sc:
+		t.Fatalf("Receive served %s", s)
+	default:
+	}
+	done := make(chan *RTStatus)
+	go func() {
+		done <- cb.ServeRT()
+	}()
+	if s := <-done; s == nil || s.Name != "cb" || <-sc != s {
+		t.Errorf("got %v", s)
+	}
+	for {
+		if _, err := cb.Receive(d); err != nil {
+			break
+		}
+	}
+}
+
+func TestCbDroppedMisses(t *testing.T) {
+	cb := NewCb(sound.MonoCd(), sample.SInt16L, 64)
+	defer cb.Close()
+	// an hour late, every check misses its deadline.
+	cb.frames = 1
+	cb.orgTime = time.Now().Add(-time.Hour)
+	n := cap(cb.misses) + 10
+	for i := 0; i < n; i++ {
+		cb.checkDeadline(int64(i))
+	}
+	if len(cb.LastMisses()) != cap(cb.misses) || cb.DroppedMisses() != 10 {
+		t.Errorf("got %d misses, %d dropped", len(cb.LastMisses()), cb.DroppedMisses())
+	}
+}
+
+// emulateCbs returns a Cb whose C side calls back n times with benchPeriod
+// frames as fast as possible, in the background.  Benchmarks using it
+// include the handoff to the C side, which may sleep.
+func emulateCbs(input bool, n int) *Cb {
+	v := sound.StereoCd()
+	cb := NewCb(v, sample.SInt16L, benchPeriod)
+	go EmulateCb(cb, input, n, 0, 0)
+	return cb
+}
+
+// drainCb consumes the callbacks of cb until the end of its emulation.
+func drainCb(cb *Cb, input bool, d []float64) {
+	for {
+		var err error
+		if input {
+			_, err = cb.Receive(d)
+		} else {
+			err = cb.Send(d)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestCbAllocs(t *testing.T) {
+	for _, input := range []bool{true, false} {
+		cb := emulateCbs(input, 200)
+		d := make([]float64, 2*benchPeriod)
+		n := testing.AllocsPerRun(100, func() {
+			var err error
+			if input {
+				_, err = cb.Receive(d)
+			} else {
+				err = cb.Send(d)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+		if n != 0 {
+			t.Errorf("input %t: %f allocations per period", input, n)
+		}
+		drainCb(cb, input, d)
+		cb.Close()
+	}
+}
+
+func benchCb(b *testing.B, input bool) {
+	cb := emulateCbs(input, b.N+1)
+	defer cb.Close()
+	d := make([]float64, 2*benchPeriod)
+	b.SetBytes(2 * benchPeriod * 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if input {
+			_, err = cb.Receive(d)
+		} else {
+			err = cb.Send(d)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportFrames(b, start, b.N*benchPeriod)
+	b.StopTimer()
+	drainCb(cb, input, d)
+}
+
+func BenchmarkCbReceive(b *testing.B) { benchCb(b, true) }
+func BenchmarkCbSend(b *testing.B)    { benchCb(b, false) }
@@ -0,0 +1,111 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package libsio
+
+import "zikichombo.org/sound"
+
+// Conv converts channel deinterleaved data in one form to channel
+// interleaved data in another form.
+//
+// Channels are mapped as follows.  If the number of channels is the same,
+// each channel maps to itself.  A mono input is copied to all output
+// channels and a mono output receives the average of all input channels.
+// Otherwise, output channel c receives input channel c modulo the number of
+// input channels.
+//
+// The sample rate is converted by linear interpolation, which is cheap and
+// adequate for monitoring and system sounds.  Callers needing better
+// quality should convert before sending.
+type Conv struct {
+	inC, outC int
+	step      float64   // input frames per output frame, 0 if no resampling.
+	pos       float64   // position of next output frame relative to the next input.
+	prev      []float64 // last mapped input frame.
+	mapped    []float64 // interleaved, output channels, input rate.
+	out       []float64 // interleaved, output channels, output rate.
+}
+
+// NewConv creates a Conv from form src to form dst.
+func NewConv(src, dst sound.Form) *Conv {
+	cv := &Conv{
+		inC:  src.Channels(),
+		outC: dst.Channels(),
+		prev: make([]float64, dst.Channels())}
+	if src.SampleRate() != dst.SampleRate() {
+		cv.step = src.SampleRate().Float64() / dst.SampleRate().Float64()
+	}
+	return cv
+}
+
+// Convert converts d and returns a slice which is valid until the next call
+// to Convert.
+func (cv *Conv) Convert(d []float64) []float64 {
+	nF := len(d) / cv.inC
+	cv.mapped = cv.chanMap(cv.mapped[:0], d, nF)
+	if cv.step == 0 {
+		return cv.mapped
+	}
+	cv.out = cv.resample(cv.out[:0], cv.mapped, nF)
+	return cv.out
+}
+
+func (cv *Conv) chanMap(dst, d []float64, nF int) []float64 {
+	inC, outC := cv.inC, cv.outC
+	for f := 0; f < nF; f++ {
+		switch {
+		case inC == outC:
+			for c := 0; c < outC; c++ {
+				dst = append(dst, d[c*nF+f])
+			}
+		case inC == 1:
+			v := d[f]
+			for c := 0; c < outC; c++ {
+				dst = append(dst, v)
+			}
+		case outC == 1:
+			s := 0.0
+			for c := 0; c < inC; c++ {
+				s += d[c*nF+f]
+			}
+			dst = append(dst, s/float64(inC))
+		default:
+			for c := 0; c < outC; c++ {
+				dst = append(dst, d[(c%inC)*nF+f])
+			}
+		}
+	}
+	return dst
+}
+
+// resample resamples the nF interleaved frames in src, appending the result
+// to dst.
+//
+// The input is treated as continuing the previous input, whose last frame
+// is kept in cv.prev at position -1.
+func (cv *Conv) resample(dst, src []float64, nF int) []float64 {
+	nC := cv.outC
+	pos := cv.pos
+	last := float64(nF - 1)
+	for pos < last {
+		i := int(pos + 1) // pos >= -1
+		i--
+		t := pos - float64(i)
+		var a []float64
+		if i < 0 {
+			a = cv.prev
+		} else {
+			a = src[i*nC : (i+1)*nC]
+		}
+		b := src[(i+1)*nC : (i+2)*nC]
+		for c := 0; c < nC; c++ {
+			dst = append(dst, a[c]+t*(b[c]-a[c]))
+		}
+		pos += cv.step
+	}
+	if nF > 0 {
+		copy(cv.prev, src[(nF-1)*nC:nF*nC])
+		cv.pos = pos - float64(nF)
+	}
+	return dst
+}
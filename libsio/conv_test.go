@@ -0,0 +1,36 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package libsio
+
+import (
+	"math"
+	"testing"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+)
+
+func TestConvResample(t *testing.T) {
+	src := sound.NewForm(22050*freq.Hertz, 1)
+	dst := sound.NewForm(44100*freq.Hertz, 1)
+	cv := NewConv(src, dst)
+	n := 0
+	for i := 0; i < 10; i++ {
+		d := make([]float64, 100)
+		for j := range d {
+			d[j] = float64(i*100 + j)
+		}
+		out := cv.Convert(d)
+		for j, v := range out {
+			exp := float64(n+j) / 2
+			if math.Abs(v-exp) > 1e-9 {
+				t.Fatalf("chunk %d frame %d: got %f expected %f", i, j, v, exp)
+			}
+		}
+		n += len(out)
+	}
+	if n < 1998 || n > 2000 {
+		t.Errorf("got %d frames expected about 2000", n)
+	}
+}
@@ -0,0 +1,398 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build linux
+
+package libsio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file is a minimal D-Bus client, just enough to call methods with
+// basic arguments, as needed for rtkit.
+
+const (
+	dbusSystemBus = "/var/run/dbus/system_bus_socket"
+	dbusTimeout   = time.Second
+
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusError        = 3
+
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSignature   = 8
+)
+
+var errDbusMsg = errors.New("dbus: malformed message")
+
+// dbusMsg is a D-Bus message.  body is encoded according to sig, little
+// endian when sent and in order when received.
+type dbusMsg struct {
+	typ         byte
+	serial      uint32
+	replySerial uint32
+	path        string
+	iface       string
+	member      string
+	dest        string
+	errName     string
+	sig         string
+	body        []byte
+	order       binary.ByteOrder
+}
+
+// dbusEnc encodes little endian D-Bus data, aligned relative to the start
+// of b.
+type dbusEnc struct {
+	b []byte
+}
+
+func (e *dbusEnc) align(n int) {
+	for len(e.b)%n != 0 {
+		e.b = append(e.b, 0)
+	}
+}
+
+func (e *dbusEnc) byte(v byte) {
+	e.b = append(e.b, v)
+}
+
+func (e *dbusEnc) uint32(v uint32) {
+	e.align(4)
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	e.b = append(e.b, buf[:]...)
+}
+
+func (e *dbusEnc) uint64(v uint64) {
+	e.align(8)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	e.b = append(e.b, buf[:]...)
+}
+
+func (e *dbusEnc) str(s string) {
+	e.uint32(uint32(len(s)))
+	e.b = append(e.b, s...)
+	e.b = append(e.b, 0)
+}
+
+func (e *dbusEnc) sig(s string) {
+	e.byte(byte(len(s)))
+	e.b = append(e.b, s...)
+	e.b = append(e.b, 0)
+}
+
+func (e *dbusEnc) field(code byte, v string) {
+	if v == "" {
+		return
+	}
+	e.align(8)
+	e.byte(code)
+	switch code {
+	case dbusFieldPath:
+		e.sig("o")
+		e.str(v)
+	case dbusFieldSignature:
+		e.sig("g")
+		e.sig(v)
+	default:
+		e.sig("s")
+		e.str(v)
+	}
+}
+
+func (m *dbusMsg) encode() []byte {
+	// header fields start at offset 16, which is aligned like 0.
+	var f dbusEnc
+	f.field(dbusFieldPath, m.path)
+	f.field(dbusFieldInterface, m.iface)
+	f.field(dbusFieldMember, m.member)
+	f.field(dbusFieldErrorName, m.errName)
+	if m.replySerial != 0 {
+		f.align(8)
+		f.byte(dbusFieldReplySerial)
+		f.sig("u")
+		f.uint32(m.replySerial)
+	}
+	f.field(dbusFieldDestination, m.dest)
+	f.field(dbusFieldSignature, m.sig)
+	var e dbusEnc
+	e.b = append(e.b, 'l', m.typ, 0, 1)
+	e.uint32(uint32(len(m.body)))
+	e.uint32(m.serial)
+	e.uint32(uint32(len(f.b)))
+	e.b = append(e.b, f.b...)
+	e.align(8)
+	return append(e.b, m.body...)
+}
+
+// dbusDec decodes D-Bus data, aligned relative to the start of b.
+type dbusDec struct {
+	o   binary.ByteOrder
+	b   []byte
+	off int
+	err error
+}
+
+func (d *dbusDec) take(n, align int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	for d.off%align != 0 {
+		d.off++
+	}
+	if d.off+n > len(d.b) {
+		d.err = errDbusMsg
+		return nil
+	}
+	res := d.b[d.off : d.off+n]
+	d.off += n
+	return res
+}
+
+func (d *dbusDec) byte() byte {
+	if b := d.take(1, 1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *dbusDec) uint32() uint32 {
+	if b := d.take(4, 4); b != nil {
+		return d.o.Uint32(b)
+	}
+	return 0
+}
+
+func (d *dbusDec) uint64() uint64 {
+	if b := d.take(8, 8); b != nil {
+		return d.o.Uint64(b)
+	}
+	return 0
+}
+
+func (d *dbusDec) str() string {
+	n := int(d.uint32())
+	b := d.take(n+1, 1)
+	if b == nil {
+		return ""
+	}
+	return string(b[:n])
+}
+
+func (d *dbusDec) sig() string {
+	n := int(d.byte())
+	b := d.take(n+1, 1)
+	if b == nil {
+		return ""
+	}
+	return string(b[:n])
+}
+
+// basic decodes a value of the basic type t as a string or an int64.
+func (d *dbusDec) basic(t string) (string, int64) {
+	switch t {
+	case "s", "o":
+		return d.str(), 0
+	case "g":
+		return d.sig(), 0
+	case "y":
+		return "", int64(d.byte())
+	case "u":
+		return "", int64(d.uint32())
+	case "i":
+		return "", int64(int32(d.uint32()))
+	case "t":
+		return "", int64(d.uint64())
+	case "x":
+		return "", int64(d.uint64())
+	}
+	if d.err == nil {
+		d.err = fmt.Errorf("dbus: unsupported type %q", t)
+	}
+	return "", 0
+}
+
+func readDbusMsg(r io.Reader) (*dbusMsg, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	m := &dbusMsg{typ: hdr[1]}
+	switch hdr[0] {
+	case 'l':
+		m.order = binary.LittleEndian
+	case 'B':
+		m.order = binary.BigEndian
+	default:
+		return nil, errDbusMsg
+	}
+	bodyLen := int(m.order.Uint32(hdr[4:]))
+	m.serial = m.order.Uint32(hdr[8:])
+	fieldsLen := int(m.order.Uint32(hdr[12:]))
+	pad := (8 - fieldsLen%8) % 8
+	buf := make([]byte, fieldsLen+pad+bodyLen)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	d := &dbusDec{o: m.order, b: buf[:fieldsLen]}
+	for d.err == nil && d.off < fieldsLen {
+		d.take(0, 8)
+		code := d.byte()
+		s, n := d.basic(d.sig())
+		switch code {
+		case dbusFieldPath:
+			m.path = s
+		case dbusFieldInterface:
+			m.iface = s
+		case dbusFieldMember:
+			m.member = s
+		case dbusFieldErrorName:
+			m.errName = s
+		case dbusFieldReplySerial:
+			m.replySerial = uint32(n)
+		case dbusFieldDestination:
+			m.dest = s
+		case dbusFieldSignature:
+			m.sig = s
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	m.body = buf[fieldsLen+pad:]
+	return m, nil
+}
+
+// dbusConn is a connection to a message bus.
+type dbusConn struct {
+	c      net.Conn
+	r      *bufio.Reader
+	serial uint32
+}
+
+// dialSystemBus connects to the system bus, with a deadline for all
+// communication.
+func dialSystemBus() (*dbusConn, error) {
+	addr := dbusSystemBus
+	if a := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS"); strings.HasPrefix(a, "unix:path=") {
+		addr = strings.SplitN(strings.TrimPrefix(a, "unix:path="), ",", 2)[0]
+	}
+	c, err := net.DialTimeout("unix", addr, dbusTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Now().Add(dbusTimeout))
+	d, err := newDbusConn(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// newDbusConn authenticates on c and says hello to the bus.
+func newDbusConn(c net.Conn) (*dbusConn, error) {
+	d := &dbusConn{c: c, r: bufio.NewReader(c)}
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := io.WriteString(c, "\x00AUTH EXTERNAL "+uid+"\r\n"); err != nil {
+		return nil, err
+	}
+	line, err := d.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return nil, fmt.Errorf("dbus: authentication refused: %s", strings.TrimSpace(line))
+	}
+	if _, err := io.WriteString(c, "BEGIN\r\n"); err != nil {
+		return nil, err
+	}
+	_, err = d.call(&dbusMsg{
+		dest:   "org.freedesktop.DBus",
+		path:   "/org/freedesktop/DBus",
+		iface:  "org.freedesktop.DBus",
+		member: "Hello"})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *dbusConn) close() error {
+	return d.c.Close()
+}
+
+// call calls the method m and returns its reply, skipping other messages.
+func (d *dbusConn) call(m *dbusMsg) (*dbusMsg, error) {
+	d.serial++
+	m.typ = dbusMethodCall
+	m.serial = d.serial
+	if _, err := d.c.Write(m.encode()); err != nil {
+		return nil, err
+	}
+	for {
+		r, err := readDbusMsg(d.r)
+		if err != nil {
+			return nil, err
+		}
+		if r.replySerial != m.serial {
+			continue
+		}
+		switch r.typ {
+		case dbusMethodReturn:
+			return r, nil
+		case dbusError:
+			msg := ""
+			if r.sig == "s" {
+				msg, _ = (&dbusDec{o: r.order, b: r.body}).basic("s")
+			}
+			return nil, fmt.Errorf("%s: %s", r.errName, msg)
+		}
+	}
+}
+
+// getInt returns the integer property prop of iface of the object path of
+// dest.
+func (d *dbusConn) getInt(dest, path, iface, prop string) (int64, error) {
+	var e dbusEnc
+	e.str(iface)
+	e.str(prop)
+	r, err := d.call(&dbusMsg{
+		dest:   dest,
+		path:   path,
+		iface:  "org.freedesktop.DBus.Properties",
+		member: "Get",
+		sig:    "ss",
+		body:   e.b})
+	if err != nil {
+		return 0, err
+	}
+	if r.sig != "v" {
+		return 0, errDbusMsg
+	}
+	dec := &dbusDec{o: r.order, b: r.body}
+	t := dec.sig()
+	_, v := dec.basic(t)
+	if dec.err == nil && (t == "s" || t == "o" || t == "g") {
+		dec.err = fmt.Errorf("dbus: %s is not an integer", prop)
+	}
+	return v, dec.err
+}
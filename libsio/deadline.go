@@ -0,0 +1,32 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package libsio
+
+import (
+	"fmt"
+	"time"
+)
+
+// MissedDeadline holds information for when a deadline
+// for communication with the C API was missed.
+//
+// If the underlying C API uses buffering, then some deadlines
+// may be missed and not cause glitching.  However, if no deadlines
+// are missed, then we know we are keeping up sufficiently
+// to not cause glitching.
+//
+// We cannot be more precise than this without imposing
+// assumptions on the underlying API that may or may not hold.
+type MissedDeadline struct {
+	// frame is the number of frames exchanged with the underlying API.
+	Frame int64
+	// OffBy is how much earlier the communication would have needed
+	// to happen in order to not miss the deadline.
+	OffBy time.Duration
+}
+
+// String for convenience.
+func (m *MissedDeadline) String() string {
+	return fmt.Sprintf("missed frame %d by %s\n", m.Frame, m.OffBy)
+}
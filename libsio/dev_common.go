@@ -8,7 +8,6 @@ import (
 
 	"zikichombo.org/sound"
 	"zikichombo.org/sound/freq"
-	"zikichombo.org/sound/sample"
 )
 
 // Dev provides data about an sound device to connect to
@@ -26,78 +25,54 @@ import (
 // it may return an error even if the bounds and supplied support
 // in the Dev fields are respected.
 type Dev struct {
-	Id             uint64
-	Name           string
-	SampleCodecs   []sample.Codec
-	MaxInChannels  int
-	MaxOutChannels int
-	MinSampleRate  freq.T
-	MaxSampleRate  freq.T
-	IsDefaultIn    bool
-	IsDefaultOut   bool
-	IsDefaultSys   bool
+	// Id is a numeric identifier assigned by the entry, which need not
+	// survive a rescan, a replug or a reboot.
+	Id uint64
+
+	// UID identifies the device among those of its entry across
+	// rescans, replugs and reboots where the entry can tell, for
+	// example from the bus path of the hardware.  It is empty if the
+	// entry has no stable identity for the device.
+	UID string
+
+	Name string
+
+	// In and Out are the capabilities of the device for capture and
+	// playback.  A direction which the device doesn't support has zero
+	// MaxChannels.
+	In, Out Caps
+
+	IsDefaultIn  bool
+	IsDefaultOut bool
+	IsDefaultSys bool
 }
 
 func (d *Dev) String() string {
-	return fmt.Sprintf("sio.Dev[%d: %s (%d,%d)@[%s..%s] i=%t o=%t s=%t]", d.Id, d.Name,
-		d.MaxInChannels, d.MaxOutChannels, d.MinSampleRate, d.MaxSampleRate, d.IsDefaultIn,
-		d.IsDefaultOut, d.IsDefaultSys)
+	return fmt.Sprintf("sio.Dev[%d %q: %s in=%s out=%s i=%t o=%t s=%t]", d.Id, d.UID, d.Name,
+		&d.In, &d.Out, d.IsDefaultIn, d.IsDefaultOut, d.IsDefaultSys)
 }
 
 func (d *Dev) CanInput() bool {
-	return d.MaxInChannels > 0
+	return d.In.Supported()
 }
 
 func (d *Dev) CanOutput() bool {
-	return d.MaxInChannels > 0
-}
-
-func (d *Dev) SupportsCodec(c sample.Codec) bool {
-	for _, d := range d.SampleCodecs {
-		if d == c {
-			return true
-		}
-	}
-	return false
+	return d.Out.Supported()
 }
 
 func (d *Dev) CanOutputForm(v sound.Form) bool {
-	nC := v.Channels()
-	if nC > d.MaxOutChannels {
-		return false
-	}
-	sr := v.SampleRate()
-	if sr < d.MinSampleRate || sr > d.MaxSampleRate {
-		return false
-	}
-	return true
+	return d.Out.SupportsForm(v)
 }
 
 func (d *Dev) CanInputForm(v sound.Form) bool {
-	nC := v.Channels()
-	if nC > d.MaxOutChannels {
-		return false
-	}
-	sr := v.SampleRate()
-	if sr < d.MinSampleRate || sr > d.MaxSampleRate {
-		return false
-	}
-	return true
+	return d.In.SupportsForm(v)
 }
 
 func (d *Dev) CanDuplex() bool {
-	return d.MaxOutChannels > 0 && d.MaxInChannels > 0
+	return d.In.Supported() && d.Out.Supported()
 }
 
 func (d *Dev) CanDuplexForm(sr freq.T, inC, outC int) bool {
-	if sr < d.MinSampleRate || sr > d.MaxSampleRate {
-		return false
-	}
-	if inC > d.MaxInChannels {
-		return false
-	}
-	if outC > d.MaxOutChannels {
-		return false
-	}
-	return true
+	return d.In.SupportsRate(sr) && d.Out.SupportsRate(sr) &&
+		d.In.supportsChannels(inC) && d.Out.supportsChannels(outC)
 }
@@ -44,11 +44,10 @@ func (ch *chn) Close() error {
 func (ch *chn) Receive(dst []float64) (int, error) {
 	nC := ch.Channels()
 	if len(dst)%nC != 0 {
-		panic("wilma")
 		return 0, sound.ErrChannelAlignment
 	}
 	nF := len(dst) / nC
-	var f, c int
+	f := 0
 	for f < nF {
 		if ch.p == len(ch.buf) {
 			pkt, open := <-ch.ch
@@ -61,17 +60,38 @@ func (ch *chn) Receive(dst []float64) (int, error) {
 			ch.buf = pkt.D
 			ch.p = 0
 		}
-		dst[c*nF+f] = ch.buf[ch.p]
-		ch.p++
-		c++
-		if c == nC {
-			c = 0
-			f++
+		n := (len(ch.buf) - ch.p) / nC
+		if n == 0 {
+			// packets hold whole frames, drop a partial one.
+			ch.p = len(ch.buf)
+			continue
 		}
+		if n > nF-f {
+			n = nF - f
+		}
+		deinter(dst, f, nF, ch.buf[ch.p:ch.p+n*nC], nC)
+		ch.p += n * nC
+		f += n
 	}
 	return f, nil
 }
 
+// deinter places the interleaved frames src of nC channels at frame f of
+// the channel deinterleaved dst of nF frames.
+func deinter(dst []float64, f, nF int, src []float64, nC int) {
+	if nC == 1 {
+		copy(dst[f:], src)
+		return
+	}
+	n := len(src) / nC
+	for c := 0; c < nC; c++ {
+		d := dst[c*nF+f : c*nF+f+n]
+		for i := range d {
+			d[i] = src[i*nC+c]
+		}
+	}
+}
+
 // InputSource returns a source from an input.
 func InputSource(in Input) sound.Source {
 	return &chn{
@@ -3,11 +3,7 @@
 
 package libsio
 
-import (
-	"errors"
-
-	"zikichombo.org/sound"
-)
+import "zikichombo.org/sound"
 
 // Output encapsulates an output device such as to a speaker.
 type Output interface {
@@ -60,31 +56,47 @@ func (o *osnk) Send(d []float64) error {
 		return sound.ErrChannelAlignment
 	}
 	nF := len(d) / nC
-	var c, f int
+	f := 0
 	for f < nF {
 		if o.pkt == nil {
 			pkt, ok := <-o.fillC
 			if !ok {
-				return errors.New("output closed")
+				return ErrOutputClosed
 			}
 			o.pkt = pkt
 		}
-		o.pkt.D[o.p] = d[c*nF+f]
-		o.p++
-		if o.p == len(o.pkt.D) {
+		n := (len(o.pkt.D) - o.p) / nC
+		if n > nF-f {
+			n = nF - f
+		}
+		inter(o.pkt.D[o.p:o.p+n*nC], d, f, nF, nC)
+		o.p += n * nC
+		f += n
+		if len(o.pkt.D)-o.p < nC {
 			o.playC <- o.pkt
 			o.p = 0
 			o.pkt = nil
 		}
-		c++
-		if c == nC {
-			c = 0
-			f++
-		}
 	}
 	return nil
 }
 
+// inter places the frames of the channel deinterleaved src of nF frames
+// starting at frame f in the interleaved dst of nC channels.
+func inter(dst, src []float64, f, nF, nC int) {
+	if nC == 1 {
+		copy(dst, src[f:])
+		return
+	}
+	n := len(dst) / nC
+	for c := 0; c < nC; c++ {
+		s := src[c*nF+f : c*nF+f+n]
+		for i, x := range s {
+			dst[i*nC+c] = x
+		}
+	}
+}
+
 // OutputSink converts an output to a Sink.
 func OutputSink(o Output) sound.Sink {
 	return &osnk{
@@ -0,0 +1,210 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package libsio
+
+import (
+	"testing"
+	"time"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// reportFrames reports the time per frame of a benchmark which started at
+// start and processed frames frames.
+func reportFrames(b *testing.B, start time.Time, frames int) {
+	b.ReportMetric(float64(time.Since(start).Nanoseconds())/float64(frames), "ns/frame")
+}
+
+// feedInput rotates 3 packets of benchPeriod frames through in until done
+// is closed.
+func feedInput(in *benchInput, done chan struct{}) {
+	var pkts [3]Packet
+	for i := range pkts {
+		pkts[i].D = make([]float64, in.Channels()*benchPeriod)
+	}
+	for pi := 0; ; pi = (pi + 1) % len(pkts) {
+		select {
+		case in.c <- &pkts[pi]:
+		case <-done:
+			return
+		}
+	}
+}
+
+// drainOutput rotates 3 packets of benchPeriod frames through out until
+// done is closed.
+func drainOutput(out *benchOutput, done chan struct{}) {
+	var pkts [3]Packet
+	for i := range pkts {
+		pkts[i].D = make([]float64, out.Channels()*benchPeriod)
+	}
+	for pi := 0; ; pi = (pi + 1) % len(pkts) {
+		select {
+		case out.fillC <- &pkts[pi]:
+		case <-done:
+			return
+		}
+		<-out.playC
+	}
+}
+
+type rawBenchInput struct {
+	sound.Form
+	co sample.Codec
+	c  chan *RawPacket
+}
+
+func (in *rawBenchInput) Codec() sample.Codec     { return in.co }
+func (in *rawBenchInput) RawC() <-chan *RawPacket { return in.c }
+func (in *rawBenchInput) Close() error            { return nil }
+func newRawBenchInput(co sample.Codec) *rawBenchInput {
+	return &rawBenchInput{Form: sound.StereoCd(), co: co, c: make(chan *RawPacket, 1)}
+}
+
+func (in *rawBenchInput) feed(done chan struct{}) {
+	var pkts [3]RawPacket
+	for i := range pkts {
+		pkts[i].D = make([]byte, in.Channels()*in.co.Bytes()*benchPeriod)
+	}
+	for pi := 0; ; pi = (pi + 1) % len(pkts) {
+		select {
+		case in.c <- &pkts[pi]:
+		case <-done:
+			return
+		}
+	}
+}
+
+type rawBenchOutput struct {
+	sound.Form
+	co    sample.Codec
+	fillC chan *RawPacket
+	playC chan *RawPacket
+}
+
+func (o *rawBenchOutput) Codec() sample.Codec         { return o.co }
+func (o *rawBenchOutput) RawFillC() <-chan *RawPacket { return o.fillC }
+func (o *rawBenchOutput) RawPlayC() chan<- *RawPacket { return o.playC }
+func (o *rawBenchOutput) Close() error                { return nil }
+func newRawBenchOutput(co sample.Codec) *rawBenchOutput {
+	return &rawBenchOutput{
+		Form:  sound.StereoCd(),
+		co:    co,
+		fillC: make(chan *RawPacket, 1),
+		playC: make(chan *RawPacket)}
+}
+
+func (o *rawBenchOutput) drain(done chan struct{}) {
+	var pkts [3]RawPacket
+	for i := range pkts {
+		pkts[i].D = make([]byte, o.Channels()*o.co.Bytes()*benchPeriod)
+	}
+	for pi := 0; ; pi = (pi + 1) % len(pkts) {
+		select {
+		case o.fillC <- &pkts[pi]:
+		case <-done:
+			return
+		}
+		<-o.playC
+	}
+}
+
+// steadyStates returns functions doing one period of steady state i/o on
+// each of the adapters of this package, and a function stopping them.
+func steadyStates() (map[string]func() error, func()) {
+	done := make(chan struct{})
+	v := sound.StereoCd()
+	d := make([]float64, 2*benchPeriod)
+
+	in := &benchInput{Form: v, c: make(chan *Packet, 1)}
+	go feedInput(in, done)
+	src := InputSource(in)
+
+	out := &benchOutput{Form: v, fillC: make(chan *Packet, 1), playC: make(chan *Packet)}
+	go drainOutput(out, done)
+	snk := OutputSink(out)
+
+	rin := newRawBenchInput(sample.SInt16L)
+	go rin.feed(done)
+	rsrc := RawInputSource(rin)
+
+	rout := newRawBenchOutput(sample.SInt16L)
+	go rout.drain(done)
+	rsnk := RawOutputSink(rout)
+
+	return map[string]func() error{
+		"InputSource": func() error {
+			_, err := src.Receive(d)
+			return err
+		},
+		"OutputSink": func() error {
+			return snk.Send(d)
+		},
+		"RawInputSource": func() error {
+			_, err := rsrc.Receive(d)
+			return err
+		},
+		"RawOutputSink": func() error {
+			return rsnk.Send(d)
+		},
+	}, func() { close(done) }
+}
+
+// TestSteadyStateAllocs checks steady state i/o doesn't allocate.
+func TestSteadyStateAllocs(t *testing.T) {
+	fns, stop := steadyStates()
+	defer stop()
+	for name, fn := range fns {
+		// the first periods may size buffers.
+		for i := 0; i < 3; i++ {
+			fn()
+		}
+		if n := testing.AllocsPerRun(100, func() {
+			if err := fn(); err != nil {
+				t.Fatal(err)
+			}
+		}); n != 0 {
+			t.Errorf("%s: %f allocations per period", name, n)
+		}
+	}
+}
+
+func benchSteadyState(b *testing.B, name string) {
+	fns, stop := steadyStates()
+	defer stop()
+	fn := fns[name]
+	fn()
+	b.SetBytes(2 * benchPeriod * 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if err := fn(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportFrames(b, start, b.N*benchPeriod)
+}
+
+func BenchmarkRawInputSource(b *testing.B) { benchSteadyState(b, "RawInputSource") }
+func BenchmarkRawOutputSink(b *testing.B)  { benchSteadyState(b, "RawOutputSink") }
+
+// BenchmarkCodec measures encoding and decoding periods of the codecs of
+// the raw paths.
+func BenchmarkCodec(b *testing.B) {
+	for _, co := range []sample.Codec{sample.SInt16L, sample.SInt24L, sample.SFloat32L} {
+		d := make([]float64, 2*benchPeriod)
+		buf := make([]byte, len(d)*co.Bytes())
+		b.Run(co.String(), func(b *testing.B) {
+			b.ReportAllocs()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				co.Encode(buf, d)
+				co.Decode(d, buf)
+			}
+			reportFrames(b, start, b.N*benchPeriod)
+		})
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package libsio
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// RawPacket is as Packet, but holds channel-interleaved data encoded in a
+// sample.Codec rather than float64 samples.
+type RawPacket struct {
+	D     []byte    // encoded data, channel-interleaved.
+	N     int       // frame number of first frame.  For playback, this can be used to schedule in the future.
+	Start time.Time // time of first sample in stream.  This is approximate but normally very close.
+}
+
+// RawSource is an optional interface implemented by sound.Sources returned
+// from host.Entry.OpenSource which give access to captured data in the
+// device's negotiated sample.Codec, without conversion to float64.
+//
+// A RawSource should be used either via Receive or via ReceiveRaw, not both.
+type RawSource interface {
+	sound.Form
+	sound.Closer
+
+	// Codec returns the sample codec of the data.
+	Codec() sample.Codec
+
+	// ReceiveRaw fills pkt.D with channel-interleaved data and sets pkt.N
+	// and pkt.Start.  pkt.D is resliced to the whole number of frames
+	// received, which is at least 1 unless an error is returned.  The
+	// capacity of pkt.D must be at least one frame.
+	//
+	// ReceiveRaw returns io.EOF when no more data is available.
+	ReceiveRaw(pkt *RawPacket) error
+}
+
+// RawSink is an optional interface implemented by sound.Sinks returned
+// from host.Entry.OpenSink which accept data in the device's negotiated
+// sample.Codec, without conversion from float64.
+//
+// A RawSink should be used either via Send or via SendRaw, not both.
+type RawSink interface {
+	sound.Form
+	sound.Closer
+
+	// Codec returns the sample codec of the data.
+	Codec() sample.Codec
+
+	// SendRaw sends pkt.D, which must contain a whole number of
+	// channel-interleaved frames, for playback.
+	//
+	// pkt.N may be used to schedule data in the future as with Output
+	// packets, if the implementation supports it.  Otherwise, pkt.N
+	// should be 0 or the frame number following the previous packet.
+	SendRaw(pkt *RawPacket) error
+}
+
+// RawInput is as Input, but exchanges RawPackets.
+//
+// Ports implementing RawInput may use RawInputSource to provide a
+// sound.Source which also implements RawSource.  Decoding to float64 then
+// happens in the goroutine of the caller of Receive, and only if Receive is
+// used.
+type RawInput interface {
+	sound.Form
+	sound.Closer
+
+	// Codec returns the sample codec of the data.
+	Codec() sample.Codec
+
+	// RawC is as Input.C.
+	RawC() <-chan *RawPacket
+}
+
+// RawOutput is as Output, but exchanges RawPackets.
+//
+// Ports implementing RawOutput may use RawOutputSink to provide a
+// sound.Sink which also implements RawSink.
+type RawOutput interface {
+	sound.Form
+	sound.Closer
+
+	// Codec returns the sample codec of the data.
+	Codec() sample.Codec
+
+	// RawFillC is as Output.FillC.  The packets received must have
+	// len(D) set to the capacity of the packet.
+	RawFillC() <-chan *RawPacket
+
+	// RawPlayC is as Output.PlayC.  The packets sent may have D
+	// resliced to a shorter, whole number of frames.
+	RawPlayC() chan<- *RawPacket
+}
+
+// ErrOutputClosed is returned when sending to an Output or RawOutput
+// which is closed.
+var ErrOutputClosed = errors.New("output closed")
+
+type rawChn struct {
+	sound.Form
+	in    RawInput
+	ch    <-chan *RawPacket
+	co    sample.Codec
+	bpf   int // bytes per frame
+	pkt   *RawPacket
+	p     int       // byte index in pkt.D
+	dec   []float64 // decoded interleaved samples of pkt.D[p:]
+	dp    int       // index in dec
+	valid bool      // whether dec holds pkt.D
+
+	closed int32 // set atomically by Close
+}
+
+// RawInputSource returns a sound.Source from a RawInput.  The returned
+// value also implements RawSource.
+func RawInputSource(in RawInput) sound.Source {
+	co := in.Codec()
+	return &rawChn{
+		Form: in,
+		in:   in,
+		ch:   in.RawC(),
+		co:   co,
+		bpf:  co.Bytes() * in.Channels()}
+}
+
+func (ch *rawChn) Codec() sample.Codec {
+	return ch.co
+}
+
+// Close closes the RawInput.  Packets it delivered but which weren't
+// received are discarded.
+func (ch *rawChn) Close() error {
+	atomic.StoreInt32(&ch.closed, 1)
+	return ch.in.Close()
+}
+
+func (ch *rawChn) next() bool {
+	pkt, open := <-ch.ch
+	if !open {
+		return false
+	}
+	ch.pkt = pkt
+	ch.p = 0
+	ch.dp = 0
+	ch.valid = false
+	return true
+}
+
+func (ch *rawChn) ReceiveRaw(pkt *RawPacket) error {
+	nF := cap(pkt.D) / ch.bpf
+	if nF == 0 {
+		return io.ErrShortBuffer
+	}
+	if atomic.LoadInt32(&ch.closed) != 0 {
+		return io.EOF
+	}
+	if ch.pkt == nil || ch.p == len(ch.pkt.D) {
+		if !ch.next() {
+			return io.EOF
+		}
+	}
+	src := ch.pkt.D[ch.p:]
+	if len(src) > nF*ch.bpf {
+		src = src[:nF*ch.bpf]
+	}
+	pkt.D = pkt.D[:len(src)]
+	copy(pkt.D, src)
+	pkt.N = ch.pkt.N + ch.p/ch.bpf
+	pkt.Start = ch.pkt.Start
+	ch.p += len(src)
+	return nil
+}
+
+func (ch *rawChn) Receive(dst []float64) (int, error) {
+	nC := ch.Channels()
+	if len(dst)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	if atomic.LoadInt32(&ch.closed) != 0 {
+		return 0, io.EOF
+	}
+	nF := len(dst) / nC
+	f := 0
+	for f < nF {
+		if ch.pkt == nil || ch.p == len(ch.pkt.D) {
+			if !ch.next() {
+				if f == 0 {
+					return 0, io.EOF
+				}
+				return f, nil
+			}
+		}
+		if !ch.valid {
+			n := len(ch.pkt.D) / ch.co.Bytes()
+			if cap(ch.dec) < n {
+				ch.dec = make([]float64, n)
+			}
+			ch.dec = ch.dec[:n]
+			ch.co.Decode(ch.dec, ch.pkt.D)
+			ch.valid = true
+		}
+		n := (len(ch.dec) - ch.dp) / nC
+		if n == 0 {
+			// packets hold whole frames, drop a partial one.
+			ch.p = len(ch.pkt.D)
+			continue
+		}
+		if n > nF-f {
+			n = nF - f
+		}
+		deinter(dst, f, nF, ch.dec[ch.dp:ch.dp+n*nC], nC)
+		ch.dp += n * nC
+		ch.p += n * ch.bpf
+		f += n
+	}
+	return f, nil
+}
+
+type rawSnk struct {
+	sound.Form
+	out   RawOutput
+	fillC <-chan *RawPacket
+	playC chan<- *RawPacket
+	co    sample.Codec
+	bpf   int
+	pkt   *RawPacket
+	enc   []float64 // interleaved samples to be encoded to pkt.D
+	p     int       // index in enc
+}
+
+// RawOutputSink returns a sound.Sink from a RawOutput.  The returned value
+// also implements RawSink.
+func RawOutputSink(o RawOutput) sound.Sink {
+	co := o.Codec()
+	return &rawSnk{
+		Form:  o,
+		out:   o,
+		fillC: o.RawFillC(),
+		playC: o.RawPlayC(),
+		co:    co,
+		bpf:   co.Bytes() * o.Channels()}
+}
+
+func (o *rawSnk) Codec() sample.Codec {
+	return o.co
+}
+
+func (o *rawSnk) Close() error {
+	return o.out.Close()
+}
+
+func (o *rawSnk) SendRaw(pkt *RawPacket) error {
+	if len(pkt.D)%o.bpf != 0 {
+		return sound.ErrChannelAlignment
+	}
+	d := pkt.D
+	sched := pkt.N
+	for len(d) > 0 {
+		if o.pkt == nil {
+			p, ok := <-o.fillC
+			if !ok {
+				return ErrOutputClosed
+			}
+			o.pkt = p
+			if sched > o.pkt.N {
+				o.pkt.N = sched
+			}
+		}
+		// scheduling only applies to the start of pkt.
+		sched = 0
+		m := copy(o.pkt.D[o.p:], d)
+		o.p += m
+		d = d[m:]
+		if o.p == len(o.pkt.D) {
+			o.playC <- o.pkt
+			o.pkt = nil
+			o.p = 0
+		}
+	}
+	return nil
+}
+
+func (o *rawSnk) Send(d []float64) error {
+	nC := o.Channels()
+	if len(d)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	nF := len(d) / nC
+	f := 0
+	for f < nF {
+		if o.pkt == nil {
+			pkt, ok := <-o.fillC
+			if !ok {
+				return ErrOutputClosed
+			}
+			o.pkt = pkt
+			n := len(pkt.D) / o.co.Bytes()
+			if cap(o.enc) < n {
+				o.enc = make([]float64, n)
+			}
+			o.enc = o.enc[:n]
+		}
+		n := (len(o.enc) - o.p) / nC
+		if n > nF-f {
+			n = nF - f
+		}
+		inter(o.enc[o.p:o.p+n*nC], d, f, nF, nC)
+		o.p += n * nC
+		f += n
+		if len(o.enc)-o.p < nC {
+			o.co.Encode(o.pkt.D, o.enc)
+			o.playC <- o.pkt
+			o.p = 0
+			o.pkt = nil
+		}
+	}
+	return nil
+}
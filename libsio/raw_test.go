@@ -0,0 +1,103 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package libsio
+
+import (
+	"io"
+	"testing"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+type rawIn struct {
+	sound.Form
+	c chan *RawPacket
+}
+
+func (r *rawIn) Codec() sample.Codec {
+	return sample.SInt16L
+}
+
+func (r *rawIn) RawC() <-chan *RawPacket {
+	return r.c
+}
+
+func (r *rawIn) Close() error {
+	return nil
+}
+
+// feed sends n packets of nF stereo frames in which frame i is encoded
+// from sample values i/1000 and -i/1000.
+func (r *rawIn) feed(n, nF int) {
+	co := r.Codec()
+	for i := 0; i < n; i++ {
+		d := make([]float64, 2*nF)
+		for f := 0; f < nF; f++ {
+			d[2*f] = float64(i*nF+f) / 1000
+			d[2*f+1] = -float64(i*nF+f) / 1000
+		}
+		pkt := &RawPacket{D: make([]byte, len(d)*co.Bytes()), N: i * nF}
+		co.Encode(pkt.D, d)
+		r.c <- pkt
+	}
+	close(r.c)
+}
+
+func TestRawInputSource(t *testing.T) {
+	in := &rawIn{Form: sound.StereoCd(), c: make(chan *RawPacket)}
+	go in.feed(4, 100)
+	src := RawInputSource(in)
+	d := make([]float64, 2*30)
+	f := 0
+	for {
+		n, err := src.Receive(d)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			exp := float64(f) / 1000
+			if d[i]-exp > 1e-4 || exp-d[i] > 1e-4 || d[30+i]+d[i] > 1e-4 {
+				t.Fatalf("frame %d: got %f,%f", f, d[i], d[30+i])
+			}
+			f++
+		}
+	}
+	if f != 400 {
+		t.Errorf("got %d frames expected 400", f)
+	}
+}
+
+func TestRawInputSourceRaw(t *testing.T) {
+	in := &rawIn{Form: sound.StereoCd(), c: make(chan *RawPacket)}
+	go in.feed(4, 100)
+	src := RawInputSource(in).(RawSource)
+	if src.Codec() != sample.SInt16L {
+		t.Errorf("wrong codec %s", src.Codec())
+	}
+	pkt := &RawPacket{D: make([]byte, 0, 64*4+3)}
+	N := 0
+	for {
+		err := src.ReceiveRaw(pkt)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pkt.D)%4 != 0 || len(pkt.D) > 64*4 {
+			t.Fatalf("bad packet length %d", len(pkt.D))
+		}
+		if pkt.N != N {
+			t.Fatalf("packet N %d expected %d", pkt.N, N)
+		}
+		N += len(pkt.D) / 4
+	}
+	if N != 400 {
+		t.Errorf("got %d frames expected 400", N)
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package libsio
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zikichombo.org/sound"
+)
+
+// Ring is a lock free single-producer single-consumer ring buffer of
+// channel-interleaved frames.
+//
+// Ring is an alternative to the Packet channel protocol of Input and Output
+// for transport between a device thread or goroutine and Go clients.  Its
+// total latency is determined by its capacity rather than by a fixed number
+// of packets, and data is exchanged without channel handoffs.  Notifications
+// are sent on channels only when a watermark is crossed.
+//
+// Exactly one goroutine may write to a Ring and exactly one goroutine may read
+// from a Ring.  Once the Ring is created, no operation on it allocates.
+//
+// The producer writes either with Write or by filling the regions returned
+// by WriteRegions followed by WriteCommit.  Likewise, the consumer reads either
+// with Read or with ReadRegions followed by ReadCommit.  The latter allow ports
+// to decode and encode directly to and from the ring.
+type Ring struct {
+	sound.Form
+	buf  []float64
+	nC   int
+	capF uint64 // capacity in frames, a power of 2.
+	mask uint64
+
+	// frame counters, only ever increase.
+	w uint64 // written by producer
+	r uint64 // written by consumer
+
+	lo, hi     uint64 // watermarks in frames.
+	loC, hiC   chan struct{}
+	closed     uint32
+	closeC     chan struct{}
+	closeOnce  sync.Once
+	start      time.Time
+	startValid uint32
+}
+
+// ErrRingClosed is returned by RingSink Send when the Ring is closed.
+var ErrRingClosed = errors.New("ring closed")
+
+// NewRing creates a new Ring for data of form v with capacity of at least
+// capF frames.  The capacity is rounded up to a power of 2.
+//
+// The low watermark defaults to half the capacity and the high watermark to
+// 1 frame.  See SetWatermarks.
+func NewRing(v sound.Form, capF int) *Ring {
+	c := uint64(1)
+	for c < uint64(capF) {
+		c <<= 1
+	}
+	nC := v.Channels()
+	return &Ring{
+		Form:   v,
+		buf:    make([]float64, int(c)*nC),
+		nC:     nC,
+		capF:   c,
+		mask:   c - 1,
+		lo:     c / 2,
+		hi:     1,
+		loC:    make(chan struct{}, 1),
+		hiC:    make(chan struct{}, 1),
+		closeC: make(chan struct{})}
+}
+
+// Cap returns the capacity of r in frames.
+func (r *Ring) Cap() int {
+	return int(r.capF)
+}
+
+// SetWatermarks sets the low and high watermarks of r, in frames.
+//
+// After a read leaves at most lo frames in r, a notification is sent on
+// LowC().  After a write leaves at least hi frames in r, a notification is
+// sent on HighC().  Notifications are not queued: at most one is pending on
+// each channel.
+//
+// SetWatermarks should be called before r is in use.
+func (r *Ring) SetWatermarks(lo, hi int) {
+	r.lo = uint64(lo)
+	r.hi = uint64(hi)
+	if r.hi < 1 {
+		r.hi = 1
+	}
+	if r.hi > r.capF {
+		r.hi = r.capF
+	}
+}
+
+// LowC returns a channel on which the producer may wait for the fill
+// level of r to fall to the low watermark.
+func (r *Ring) LowC() <-chan struct{} {
+	return r.loC
+}
+
+// HighC returns a channel on which the consumer may wait for the fill
+// level of r to rise to the high watermark.
+func (r *Ring) HighC() <-chan struct{} {
+	return r.hiC
+}
+
+// CloseC returns a channel which is closed when r is closed.
+func (r *Ring) CloseC() <-chan struct{} {
+	return r.closeC
+}
+
+// Len returns the number of frames available for reading.
+func (r *Ring) Len() int {
+	return int(atomic.LoadUint64(&r.w) - atomic.LoadUint64(&r.r))
+}
+
+// Free returns the number of frames available for writing.
+func (r *Ring) Free() int {
+	return int(r.capF - (atomic.LoadUint64(&r.w) - atomic.LoadUint64(&r.r)))
+}
+
+// WriteN returns the frame number of the next frame to be written, which is
+// the number of frames written to r so far.
+func (r *Ring) WriteN() int {
+	return int(atomic.LoadUint64(&r.w))
+}
+
+// ReadN returns the frame number of the next frame to be read, which is the
+// number of frames read from r so far.
+func (r *Ring) ReadN() int {
+	return int(atomic.LoadUint64(&r.r))
+}
+
+// SetStart sets the time of the first frame of r, as in Packet.Start.
+// SetStart should be called once, by the producer, before the first write.
+func (r *Ring) SetStart(t time.Time) {
+	r.start = t
+	atomic.StoreUint32(&r.startValid, 1)
+}
+
+// Start returns the time of the first frame of r and whether it has been
+// set.
+func (r *Ring) Start() (time.Time, bool) {
+	if atomic.LoadUint32(&r.startValid) == 0 {
+		return time.Time{}, false
+	}
+	return r.start, true
+}
+
+// WriteRegions returns up to 2 slices of interleaved frames of free space in
+// r, in order.  The producer may fill them and then call WriteCommit.
+func (r *Ring) WriteRegions() (a, b []float64) {
+	w := atomic.LoadUint64(&r.w)
+	rd := atomic.LoadUint64(&r.r)
+	return r.regions(w, r.capF-(w-rd))
+}
+
+// WriteCommit makes nF frames written to the regions returned by
+// WriteRegions available to the consumer.
+func (r *Ring) WriteCommit(nF int) {
+	w := atomic.LoadUint64(&r.w) + uint64(nF)
+	atomic.StoreUint64(&r.w, w)
+	if w-atomic.LoadUint64(&r.r) >= r.hi {
+		notify(r.hiC)
+	}
+}
+
+// ReadRegions returns up to 2 slices of interleaved frames available for
+// reading in r, in order.  The consumer may read them and then call
+// ReadCommit.
+func (r *Ring) ReadRegions() (a, b []float64) {
+	rd := atomic.LoadUint64(&r.r)
+	w := atomic.LoadUint64(&r.w)
+	return r.regions(rd, w-rd)
+}
+
+// ReadCommit releases nF frames read from the regions returned by
+// ReadRegions to the producer.
+func (r *Ring) ReadCommit(nF int) {
+	rd := atomic.LoadUint64(&r.r) + uint64(nF)
+	atomic.StoreUint64(&r.r, rd)
+	if atomic.LoadUint64(&r.w)-rd <= r.lo {
+		notify(r.loC)
+	}
+}
+
+// Write writes as many interleaved frames from d as fit in r and returns the
+// number of frames written.
+func (r *Ring) Write(d []float64) int {
+	a, b := r.WriteRegions()
+	n := copy(a, d)
+	n += copy(b, d[n:])
+	nF := n / r.nC
+	if nF > 0 {
+		r.WriteCommit(nF)
+	}
+	return nF
+}
+
+// Read reads as many interleaved frames into d as are available and fit
+// and returns the number of frames read.
+func (r *Ring) Read(d []float64) int {
+	a, b := r.ReadRegions()
+	n := copy(d, a)
+	n += copy(d[n:], b)
+	nF := n / r.nC
+	if nF > 0 {
+		r.ReadCommit(nF)
+	}
+	return nF
+}
+
+// Close closes r.  Either side may close r.  Once closed, the consumer may
+// still read the remaining frames and the producer may no longer write.
+func (r *Ring) Close() error {
+	r.closeOnce.Do(func() {
+		atomic.StoreUint32(&r.closed, 1)
+		close(r.closeC)
+	})
+	return nil
+}
+
+// Closed returns whether r has been closed.
+func (r *Ring) Closed() bool {
+	return atomic.LoadUint32(&r.closed) != 0
+}
+
+// regions returns the regions of nF frames starting at frame counter p.
+func (r *Ring) regions(p, nF uint64) (a, b []float64) {
+	i := p & r.mask
+	nC := uint64(r.nC)
+	if i+nF <= r.capF {
+		return r.buf[i*nC : (i+nF)*nC], nil
+	}
+	return r.buf[i*nC:], r.buf[:(i+nF-r.capF)*nC]
+}
+
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+type ringSrc struct {
+	*Ring
+}
+
+// RingSource returns a sound.Source whose data is read from r.
+//
+// Receive blocks on r.HighC() when r is empty and returns io.EOF once r is
+// closed and empty.  Closing the returned Source closes r.
+func RingSource(r *Ring) sound.Source {
+	return &ringSrc{Ring: r}
+}
+
+func (s *ringSrc) Receive(dst []float64) (int, error) {
+	nC := s.nC
+	if len(dst)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := len(dst) / nC
+	f := 0
+	for f < nF {
+		a, b := s.ReadRegions()
+		if len(a) == 0 {
+			if s.Closed() && s.Len() == 0 {
+				break
+			}
+			select {
+			case <-s.hiC:
+			case <-s.closeC:
+			}
+			continue
+		}
+		k := s.deinter(dst, nF, f, a)
+		if f+k < nF {
+			k += s.deinter(dst, nF, f+k, b)
+		}
+		s.ReadCommit(k)
+		f += k
+	}
+	if f == 0 && nF != 0 {
+		return 0, io.EOF
+	}
+	return f, nil
+}
+
+// deinter copies interleaved frames from src into dst, which has nF frames
+// per channel, starting at frame f.  It returns the number of frames copied.
+func (s *ringSrc) deinter(dst []float64, nF, f int, src []float64) int {
+	nC := s.nC
+	k := len(src) / nC
+	if k > nF-f {
+		k = nF - f
+	}
+	for i := 0; i < k; i++ {
+		fr := src[i*nC : (i+1)*nC]
+		for c, v := range fr {
+			dst[c*nF+f+i] = v
+		}
+	}
+	return k
+}
+
+type ringSnk struct {
+	*Ring
+}
+
+// RingSink returns a sound.Sink whose data is written to r.
+//
+// Send blocks on r.LowC() while r is full and returns an error if r is
+// closed.  Closing the returned Sink closes r.
+func RingSink(r *Ring) sound.Sink {
+	return &ringSnk{Ring: r}
+}
+
+func (s *ringSnk) Send(d []float64) error {
+	nC := s.nC
+	if len(d)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	nF := len(d) / nC
+	f := 0
+	for f < nF {
+		if s.Closed() {
+			return ErrRingClosed
+		}
+		a, b := s.WriteRegions()
+		if len(a) == 0 {
+			select {
+			case <-s.loC:
+			case <-s.closeC:
+			}
+			continue
+		}
+		k := s.inter(a, d, nF, f)
+		if f+k < nF {
+			k += s.inter(b, d, nF, f+k)
+		}
+		s.WriteCommit(k)
+		f += k
+	}
+	return nil
+}
+
+// inter copies frames of the channel deinterleaved src, which has nF frames
+// per channel, starting at frame f, to the interleaved dst.  It returns the
+// number of frames copied.
+func (s *ringSnk) inter(dst, src []float64, nF, f int) int {
+	nC := s.nC
+	k := len(dst) / nC
+	if k > nF-f {
+		k = nF - f
+	}
+	for i := 0; i < k; i++ {
+		fr := dst[i*nC : (i+1)*nC]
+		for c := range fr {
+			fr[c] = src[c*nF+f+i]
+		}
+	}
+	return k
+}
@@ -0,0 +1,290 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package libsio
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"zikichombo.org/sound"
+)
+
+func TestRingSource(t *testing.T) {
+	v := sound.StereoCd()
+	r := NewRing(v, 100)
+	if r.Cap() != 128 {
+		t.Fatalf("expected cap 128 got %d", r.Cap())
+	}
+	N := 10000
+	go func() {
+		f := 0
+		buf := make([]float64, 2*37)
+		for f < N {
+			n := len(buf) / 2
+			if n > N-f {
+				n = N - f
+			}
+			for i := 0; i < n; i++ {
+				buf[2*i] = float64(f + i)
+				buf[2*i+1] = -float64(f + i)
+			}
+			d := buf[:2*n]
+			for len(d) > 0 {
+				w := r.Write(d)
+				d = d[2*w:]
+				if len(d) > 0 {
+					<-r.LowC()
+				}
+			}
+			f += n
+		}
+		r.Close()
+	}()
+	src := RingSource(r)
+	d := make([]float64, 2*50)
+	f := 0
+	for {
+		n, err := src.Receive(d)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			if d[i] != float64(f) || d[50+i] != -float64(f) {
+				t.Fatalf("frame %d: got %f,%f", f, d[i], d[50+i])
+			}
+			f++
+		}
+	}
+	if f != N {
+		t.Errorf("got %d frames expected %d", f, N)
+	}
+	if r.ReadN() != N {
+		t.Errorf("ReadN %d expected %d", r.ReadN(), N)
+	}
+}
+
+func TestRingSink(t *testing.T) {
+	v := sound.MonoCd()
+	r := NewRing(v, 64)
+	r.SetWatermarks(16, 1)
+	N := 1000
+	done := make(chan int)
+	go func() {
+		f := 0
+		d := make([]float64, 10)
+		for f < N {
+			n := r.Read(d)
+			for i := 0; i < n; i++ {
+				if d[i] != float64(f) {
+					t.Errorf("frame %d: got %f", f, d[i])
+				}
+				f++
+			}
+			if n == 0 {
+				<-r.HighC()
+			}
+		}
+		done <- f
+	}()
+	snk := RingSink(r)
+	d := make([]float64, 100)
+	for f := 0; f < N; f += len(d) {
+		for i := range d {
+			d[i] = float64(f + i)
+		}
+		if err := snk.Send(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f := <-done; f != N {
+		t.Errorf("got %d expected %d", f, N)
+	}
+	snk.Close()
+	if err := snk.Send(d); err != ErrRingClosed {
+		t.Errorf("expected ErrRingClosed got %v", err)
+	}
+}
+
+const benchPeriod = 256
+
+func BenchmarkRingCapture(b *testing.B) {
+	v := sound.StereoCd()
+	r := NewRing(v, 3*benchPeriod)
+	r.SetWatermarks(0, benchPeriod)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			for r.Free() < benchPeriod {
+				<-r.LowC()
+			}
+			a, c := r.WriteRegions()
+			for j := range a {
+				a[j] = 0.5
+			}
+			for j := range c {
+				c[j] = 0.5
+			}
+			r.WriteCommit(benchPeriod)
+		}
+		r.Close()
+	}()
+	src := RingSource(r)
+	d := make([]float64, 2*benchPeriod)
+	b.SetBytes(2 * benchPeriod * 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := src.Receive(d); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type benchInput struct {
+	sound.Form
+	c chan *Packet
+}
+
+func (in *benchInput) C() <-chan *Packet {
+	return in.c
+}
+
+func (in *benchInput) Close() error {
+	return nil
+}
+
+// BenchmarkPacketCapture measures InputSource and the Packet channel
+// protocol as used by the ALSA port, rotating 3 packets through a channel
+// of capacity 1.
+func BenchmarkPacketCapture(b *testing.B) {
+	v := sound.StereoCd()
+	in := &benchInput{Form: v, c: make(chan *Packet, 1)}
+	var pkts [3]Packet
+	for i := range pkts {
+		pkts[i].D = make([]float64, 2*benchPeriod)
+	}
+	go func() {
+		pi := 0
+		for i := 0; i < b.N; i++ {
+			pkt := &pkts[pi]
+			for j := range pkt.D {
+				pkt.D[j] = 0.5
+			}
+			pkt.N = i * benchPeriod
+			in.c <- pkt
+			pi++
+			if pi == len(pkts) {
+				pi = 0
+			}
+		}
+		close(in.c)
+	}()
+	src := InputSource(in)
+	d := make([]float64, 2*benchPeriod)
+	b.SetBytes(2 * benchPeriod * 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if _, err := src.Receive(d); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportFrames(b, start, b.N*benchPeriod)
+}
+
+func BenchmarkRingPlay(b *testing.B) {
+	v := sound.StereoCd()
+	r := NewRing(v, 3*benchPeriod)
+	r.SetWatermarks(2*benchPeriod, benchPeriod)
+	go func() {
+		for {
+			for r.Len() < benchPeriod {
+				select {
+				case <-r.HighC():
+				case <-r.CloseC():
+					return
+				}
+			}
+			r.ReadCommit(benchPeriod)
+		}
+	}()
+	snk := RingSink(r)
+	d := make([]float64, 2*benchPeriod)
+	b.SetBytes(2 * benchPeriod * 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := snk.Send(d); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	r.Close()
+}
+
+type benchOutput struct {
+	sound.Form
+	fillC chan *Packet
+	playC chan *Packet
+}
+
+func (o *benchOutput) FillC() <-chan *Packet {
+	return o.fillC
+}
+
+func (o *benchOutput) PlayC() chan<- *Packet {
+	return o.playC
+}
+
+func (o *benchOutput) Close() error {
+	return nil
+}
+
+// BenchmarkPacketPlay measures OutputSink and the Packet channel protocol
+// as used by the ALSA port for playback.
+func BenchmarkPacketPlay(b *testing.B) {
+	v := sound.StereoCd()
+	out := &benchOutput{
+		Form:  v,
+		fillC: make(chan *Packet, 1),
+		playC: make(chan *Packet)}
+	var pkts [3]Packet
+	for i := range pkts {
+		pkts[i].D = make([]float64, 2*benchPeriod)
+	}
+	done := make(chan struct{})
+	go func() {
+		pi := 0
+		for {
+			select {
+			case out.fillC <- &pkts[pi]:
+			case <-done:
+				return
+			}
+			<-out.playC
+			pi++
+			if pi == len(pkts) {
+				pi = 0
+			}
+		}
+	}()
+	snk := OutputSink(out)
+	d := make([]float64, 2*benchPeriod)
+	b.SetBytes(2 * benchPeriod * 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if err := snk.Send(d); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportFrames(b, start, b.N*benchPeriod)
+	b.StopTimer()
+	close(done)
+}
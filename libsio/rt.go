@@ -0,0 +1,167 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package libsio
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// ErrRTUnsupported is the error of an RTStatus on systems where libsio
+// can't ask for realtime scheduling.
+var ErrRTUnsupported = errors.New("realtime scheduling unsupported")
+
+// DefaultRTPriority is the default of RTConfig.Priority.
+const DefaultRTPriority = 10
+
+// Policy is a thread scheduling policy.
+type Policy int
+
+const (
+	// SchedOther is the normal, time shared, policy.
+	SchedOther Policy = iota
+	// SchedFIFO is the first in first out realtime policy.
+	SchedFIFO
+	// SchedRR is the round robin realtime policy.
+	SchedRR
+)
+
+var policyNames = [...]string{"SCHED_OTHER", "SCHED_FIFO", "SCHED_RR"}
+
+func (p Policy) String() string {
+	if p < 0 || int(p) >= len(policyNames) {
+		return fmt.Sprintf("Policy(%d)", int(p))
+	}
+	return policyNames[p]
+}
+
+// RTConfig configures realtime scheduling of goroutines serving i/o, see
+// SetRealtime.
+type RTConfig struct {
+	// Policy is the policy to ask for, SchedFIFO or SchedRR.  SchedOther
+	// means SchedFIFO.
+	Policy Policy
+
+	// Priority is the priority to ask for, from 1 to 99.  0 means
+	// DefaultRTPriority.  If the system allows less, the highest priority
+	// allowed is asked for instead.
+	Priority int
+
+	// NoRTKit disables asking the rtkit D-Bus service for realtime
+	// scheduling when the process isn't allowed to set it itself.
+	NoRTKit bool
+
+	// Status, if not nil, receives the RTStatus of each goroutine serving
+	// i/o.  RTStatuses are dropped if Status isn't ready.
+	Status chan<- *RTStatus
+}
+
+// RTStatus reports the scheduling a goroutine serving i/o obtained.
+type RTStatus struct {
+	// Name describes what the goroutine serves.
+	Name string
+	// TID is the id of the OS thread of the goroutine.
+	TID int
+	// Policy and Priority are the scheduling of the thread, as reported by
+	// the system after asking for realtime scheduling.
+	Policy   Policy
+	Priority int
+	// Via is how realtime scheduling was obtained, "sched" or "rtkit", and
+	// empty if it wasn't.
+	Via string
+	// Err is why realtime scheduling wasn't obtained, if it wasn't.
+	Err error
+}
+
+// Realtime reports whether s has a realtime policy.
+func (s *RTStatus) Realtime() bool {
+	return s.Policy == SchedFIFO || s.Policy == SchedRR
+}
+
+func (s *RTStatus) String() string {
+	if s.Err != nil {
+		return fmt.Sprintf("%s: thread %d %s %d: %s", s.Name, s.TID, s.Policy, s.Priority, s.Err)
+	}
+	return fmt.Sprintf("%s: thread %d %s %d via %s", s.Name, s.TID, s.Policy, s.Priority, s.Via)
+}
+
+var rt struct {
+	sync.Mutex
+	cfg *RTConfig
+}
+
+// SetRealtime enables realtime scheduling of goroutines serving i/o which
+// start afterwards, as configured by cfg.  A nil cfg, the default,
+// disables it.
+//
+// The goroutines serving ALSA devices in ports/linux are then locked to
+// their OS thread with realtime scheduling, see ServeRT.  Goroutines
+// consuming a Cb belong to the application, which may dedicate one to
+// the i/o of the Cb and have it call Cb.ServeRT.
+func SetRealtime(cfg *RTConfig) {
+	rt.Lock()
+	defer rt.Unlock()
+	rt.cfg = cfg
+}
+
+// Realtime returns the configuration set by SetRealtime.
+func Realtime() *RTConfig {
+	rt.Lock()
+	defer rt.Unlock()
+	return rt.cfg
+}
+
+// ServeRT is called by ports at the start of goroutines serving i/o, name
+// describing what they serve.  If SetRealtime enabled realtime scheduling,
+// ServeRT is as LockRT and sends the resulting RTStatus to the Status
+// channel of the configuration.  Otherwise ServeRT does nothing and
+// returns nil.
+func ServeRT(name string) *RTStatus {
+	cfg := Realtime()
+	if cfg == nil {
+		return nil
+	}
+	s := LockRT(name, cfg)
+	if cfg.Status != nil {
+		select {
+		case cfg.Status <- s:
+		default:
+		}
+	}
+	return s
+}
+
+// LockRT locks the calling goroutine to its OS thread and asks for
+// realtime scheduling of the thread as configured by cfg, which may be
+// nil for the defaults.  It first tries to set it directly, lowering the
+// priority to the RLIMIT_RTPRIO resource limit if need be, and then, unless
+// cfg.NoRTKit, asks rtkit.
+//
+// If realtime scheduling is refused, the thread keeps its scheduling.
+// Either way, LockRT returns the scheduling the thread obtained.
+//
+// The goroutine should exit without unlocking its thread, so that the
+// thread isn't reused by other goroutines; the Go runtime then terminates
+// the thread.
+func LockRT(name string, cfg *RTConfig) *RTStatus {
+	var c RTConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Policy == SchedOther {
+		c.Policy = SchedFIFO
+	}
+	if c.Priority <= 0 {
+		c.Priority = DefaultRTPriority
+	}
+	if c.Priority > 99 {
+		c.Priority = 99
+	}
+	runtime.LockOSThread()
+	s := &RTStatus{Name: name}
+	elevate(s, &c)
+	return s
+}
@@ -0,0 +1,145 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build linux
+
+package libsio
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	schedFIFO        = 1
+	schedRR          = 2
+	schedResetOnFork = 0x40000000
+
+	rlimitRTPrio = 14
+	rlimitRTTime = 15
+
+	rtkitName = "org.freedesktop.RealtimeKit1"
+	rtkitPath = "/org/freedesktop/RealtimeKit1"
+)
+
+type schedParam struct {
+	prio int32
+}
+
+func setScheduler(tid int, pol Policy, prio int) error {
+	lp := schedFIFO
+	if pol == SchedRR {
+		lp = schedRR
+	}
+	p := schedParam{prio: int32(prio)}
+	_, _, e := syscall.RawSyscall(syscall.SYS_SCHED_SETSCHEDULER, uintptr(tid), uintptr(lp), uintptr(unsafe.Pointer(&p)))
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+func getScheduler(tid int) (Policy, int, error) {
+	lp, _, e := syscall.RawSyscall(syscall.SYS_SCHED_GETSCHEDULER, uintptr(tid), 0, 0)
+	if e != 0 {
+		return SchedOther, 0, e
+	}
+	var p schedParam
+	_, _, e = syscall.RawSyscall(syscall.SYS_SCHED_GETPARAM, uintptr(tid), uintptr(unsafe.Pointer(&p)), 0)
+	if e != 0 {
+		return SchedOther, 0, e
+	}
+	switch lp &^ schedResetOnFork {
+	case schedFIFO:
+		return SchedFIFO, int(p.prio), nil
+	case schedRR:
+		return SchedRR, int(p.prio), nil
+	}
+	return SchedOther, int(p.prio), nil
+}
+
+func elevate(s *RTStatus, c *RTConfig) {
+	tid := syscall.Gettid()
+	s.TID = tid
+	err := setScheduler(tid, c.Policy, c.Priority)
+	if err == syscall.EPERM {
+		// unprivileged processes may use priorities up to RLIMIT_RTPRIO.
+		var lim syscall.Rlimit
+		if syscall.Getrlimit(rlimitRTPrio, &lim) == nil && lim.Cur > 0 && lim.Cur < uint64(c.Priority) {
+			err = setScheduler(tid, c.Policy, int(lim.Cur))
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("sched_setscheduler: %s", err)
+	}
+	if err == nil {
+		s.Via = "sched"
+	} else if !c.NoRTKit {
+		if rerr := rtkitElevate(tid, c.Priority); rerr == nil {
+			s.Via, err = "rtkit", nil
+		} else {
+			err = fmt.Errorf("%s, rtkit: %s", err, rerr)
+		}
+	}
+	s.Err = err
+	s.Policy, s.Priority, _ = getScheduler(tid)
+}
+
+// rtkitElevate asks rtkit on the system bus for realtime scheduling of the
+// thread tid.
+func rtkitElevate(tid, prio int) error {
+	d, err := dialSystemBus()
+	if err != nil {
+		return err
+	}
+	defer d.close()
+	return rtkit(d, tid, prio)
+}
+
+// rtkit asks rtkit via d for realtime scheduling of the thread tid, at
+// most at the priority rtkit allows.  rtkit gives SCHED_RR to processes
+// whose RLIMIT_RTTIME is limited, so rtkit lowers the limit if need be.
+func rtkit(d *dbusConn, tid, prio int) error {
+	max, err := d.getInt(rtkitName, rtkitPath, rtkitName, "MaxRealtimePriority")
+	if err != nil {
+		return err
+	}
+	if int64(prio) > max {
+		prio = int(max)
+	}
+	usec, err := d.getInt(rtkitName, rtkitPath, rtkitName, "RTTimeUSecMax")
+	if err != nil {
+		return err
+	}
+	if err := limitRTTime(uint64(usec)); err != nil {
+		return err
+	}
+	var e dbusEnc
+	e.uint64(uint64(tid))
+	e.uint32(uint32(prio))
+	_, err = d.call(&dbusMsg{
+		dest:   rtkitName,
+		path:   rtkitPath,
+		iface:  rtkitName,
+		member: "MakeThreadRealtime",
+		sig:    "tu",
+		body:   e.b})
+	return err
+}
+
+// limitRTTime limits RLIMIT_RTTIME to at most usec microseconds.
+func limitRTTime(usec uint64) error {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(rlimitRTTime, &lim); err != nil {
+		return err
+	}
+	if lim.Max <= usec {
+		return nil
+	}
+	lim.Max = usec
+	if lim.Cur > usec {
+		lim.Cur = usec
+	}
+	return syscall.Setrlimit(rlimitRTTime, &lim)
+}
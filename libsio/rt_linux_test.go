@@ -0,0 +1,113 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build linux
+
+package libsio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeRtkit serves c as a bus with rtkit, refusing threads other than tid.
+func fakeRtkit(t *testing.T, c net.Conn, tid uint64) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "\x00AUTH EXTERNAL ") {
+		t.Errorf("auth: %q %v", line, err)
+		return
+	}
+	io.WriteString(c, "OK 0123\r\n")
+	if line, _ = r.ReadString('\n'); line != "BEGIN\r\n" {
+		t.Errorf("begin: %q", line)
+		return
+	}
+	serial := uint32(0)
+	for {
+		m, err := readDbusMsg(r)
+		if err != nil {
+			return
+		}
+		serial++
+		res := &dbusMsg{typ: dbusMethodReturn, serial: serial, replySerial: m.serial}
+		var e dbusEnc
+		d := &dbusDec{o: m.order, b: m.body}
+		switch m.member {
+		case "Hello":
+			// a signal before the reply.
+			c.Write((&dbusMsg{typ: 4, serial: serial, member: "NameAcquired"}).encode())
+			res.sig = "s"
+			e.str(":1.1")
+		case "Get":
+			d.str()
+			res.sig = "v"
+			switch d.str() {
+			case "MaxRealtimePriority":
+				e.sig("i")
+				e.uint32(20)
+			case "RTTimeUSecMax":
+				e.sig("x")
+				e.uint64(1 << 62)
+			}
+		case "MakeThreadRealtime":
+			th, prio := d.uint64(), d.uint32()
+			if m.sig != "tu" || prio != 20 {
+				t.Errorf("got %s %d %d", m.sig, th, prio)
+			}
+			if th != tid {
+				res.typ = dbusError
+				res.errName = "org.freedesktop.DBus.Error.AccessDenied"
+				res.sig = "s"
+				e.str("nope")
+			}
+		}
+		res.body = e.b
+		c.Write(res.encode())
+	}
+}
+
+func TestRtkit(t *testing.T) {
+	for _, tid := range []uint64{7, 8} {
+		c, s := net.Pipe()
+		go fakeRtkit(t, s, 7)
+		d, err := newDbusConn(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = rtkit(d, int(tid), 50)
+		if tid == 7 && err != nil {
+			t.Error(err)
+		}
+		if tid == 8 && (err == nil || !strings.Contains(err.Error(), "AccessDenied: nope")) {
+			t.Errorf("got %v", err)
+		}
+		d.close()
+	}
+}
+
+func TestDbusMsg(t *testing.T) {
+	var e dbusEnc
+	e.str("x")
+	e.uint64(3)
+	m := &dbusMsg{typ: dbusMethodCall, serial: 9, path: "/a", iface: "b.c", member: "D", dest: "e.f", sig: "st", body: e.b}
+	r, err := readDbusMsg(strings.NewReader(string(m.encode())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.order != binary.LittleEndian || r.serial != 9 || r.path != "/a" || r.iface != "b.c" || r.member != "D" || r.dest != "e.f" || r.sig != "st" {
+		t.Errorf("got %+v", r)
+	}
+	d := &dbusDec{o: r.order, b: r.body}
+	if s, n := d.str(), d.uint64(); s != "x" || n != 3 || d.err != nil {
+		t.Errorf("got %q %d %v", s, n, d.err)
+	}
+	if _, err := readDbusMsg(strings.NewReader("B\x01\x00\x01")); err == nil {
+		t.Error("read truncated message")
+	}
+}
@@ -0,0 +1,10 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build !linux
+
+package libsio
+
+func elevate(s *RTStatus, c *RTConfig) {
+	s.Err = ErrRTUnsupported
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package libsio
+
+import "testing"
+
+func TestLockRT(t *testing.T) {
+	sc := make(chan *RTStatus)
+	go func() {
+		// exiting locked terminates the thread, whatever its scheduling.
+		sc <- LockRT("test", &RTConfig{Policy: SchedRR, NoRTKit: true})
+	}()
+	s := <-sc
+	if s.Name != "test" {
+		t.Errorf("got name %q", s.Name)
+	}
+	// elevation may or may not be allowed here, but the status must be
+	// consistent.
+	if s.Err == nil {
+		if s.Policy != SchedRR || s.Priority <= 0 || s.Priority > DefaultRTPriority || s.Via != "sched" {
+			t.Errorf("got %s", s)
+		}
+	} else if s.Realtime() || s.Via != "" {
+		t.Errorf("got %s", s)
+	}
+}
+
+func TestServeRT(t *testing.T) {
+	if s := ServeRT("off"); s != nil {
+		t.Errorf("got %s while disabled", s)
+	}
+	sc := make(chan *RTStatus, 1)
+	SetRealtime(&RTConfig{NoRTKit: true, Status: sc})
+	defer SetRealtime(nil)
+	done := make(chan *RTStatus)
+	go func() {
+		done <- ServeRT("on")
+	}()
+	s := <-done
+	if got := <-sc; got != s || s.Name != "on" {
+		t.Errorf("got %s, want %s", got, s)
+	}
+}
@@ -8,9 +8,11 @@ package libsio
 // this file is for testing only, but _test.go files don't support cgo,
 // so you have to compile this every time :(
 
+// #define _POSIX_C_SOURCE 200809L
 // #include "cb.h"
 // #include <stdlib.h>
 // #include <pthread.h>
+// #include <time.h>
 //
 // typedef struct runcb {
 //     Cb * cb;
@@ -90,11 +92,121 @@ package libsio
 //    freercb(rcb);
 //    return ret;
 // }
+//
+// // emucb emulates an audio API calling back every period ns, each
+// // callback delayed by up to jitter ns, and then signalling the end of the
+// // stream.
+// typedef struct emucb {
+//     runcb rcb;
+//     long period;
+//     long jitter;
+//     unsigned seed;
+// } emucb;
+//
+// static void addns(struct timespec *t, long ns) {
+//     t->tv_nsec += ns;
+//     while (t->tv_nsec >= 1000000000L) {
+//         t->tv_nsec -= 1000000000L;
+//         t->tv_sec++;
+//     }
+// }
+//
+// // sleepuntil sleeps until the monotonic clock reaches at.  It uses
+// // nanosleep with a relative timeout rather than clock_nanosleep, which
+// // macOS doesn't provide.
+// static void sleepuntil(const struct timespec *at) {
+//     struct timespec now, d;
+//     for (;;) {
+//         clock_gettime(CLOCK_MONOTONIC, &now);
+//         d.tv_sec = at->tv_sec - now.tv_sec;
+//         d.tv_nsec = at->tv_nsec - now.tv_nsec;
+//         if (d.tv_nsec < 0) {
+//             d.tv_nsec += 1000000000L;
+//             d.tv_sec--;
+//         }
+//         if (d.tv_sec < 0 || (d.tv_sec == 0 && d.tv_nsec == 0)) {
+//             return;
+//         }
+//         nanosleep(&d, NULL);
+//     }
+// }
+//
+// void * emulate(void *p) {
+//     emucb *e = (emucb *) p;
+//     runcb *rcb = &e->rcb;
+//     Cb *cb = rcb->cb;
+//     struct timespec next, at;
+//     int of;
+//     clock_gettime(CLOCK_MONOTONIC, &next);
+//     for (int i = 0; i <= rcb->n; i++) {
+//         addns(&next, e->period);
+//         at = next;
+//         if (e->jitter > 0) {
+//             addns(&at, (long)(rand_r(&e->seed) % (e->jitter + 1)));
+//         }
+//         sleepuntil(&at);
+//         // the last callback has no frames, ending the stream.
+//         of = i < rcb->n ? rcb->bf : 0;
+//         if (rcb->input) {
+//             cb->inCb(cb, rcb->buf, of);
+//         } else {
+//             cb->outCb(cb, rcb->buf, &of);
+//         }
+//     }
+//     return NULL;
+// }
+//
+// int emulatecbs(Cb *cb, int n, int b, int bpf, int input, long period, long jitter, unsigned seed) {
+//    emucb e;
+//    runcb * rcb = newruncb(cb, n, b, bpf, input);
+//    if (rcb == 0) {
+//        return -1;
+//    }
+//    e.rcb = *rcb;
+//    e.period = period;
+//    e.jitter = jitter;
+//    e.seed = seed;
+//    pthread_t hwa_emu;
+//    int ret = 0;
+//    if (pthread_create(&hwa_emu, NULL, emulate, &e)) {
+//        ret = 1;
+//    } else if (pthread_join(hwa_emu, NULL)) {
+//        ret = 2;
+//    }
+//    freercb(rcb);
+//    return ret;
+// }
 import "C"
 
+import (
+	"fmt"
+	"time"
+)
+
 func runcbsCapture(cb *Cb, n, bf, spf int) {
 	C.runcbs(cb.c, C.int(n), C.int(bf), C.int(spf), 1)
 }
 func runcbsPlay(cb *Cb, n, bf, spf int) {
 	C.runcbs(cb.c, C.int(n), C.int(bf), C.int(spf), 0)
 }
+
+// EmulateCb emulates a callback based audio API calling back on cb from a C
+// thread, for testing.  It makes n callbacks of b frames, the buffer size
+// of cb, every period, each delayed by a random duration up to jitter, and
+// then a callback without frames, which ends the stream.  If input is true,
+// capture callbacks are made, otherwise playback callbacks.
+//
+// EmulateCb returns when the emulation ends.  cb must be used, by Receive
+// or Send according to input, until it returns io.EOF.
+func EmulateCb(cb *Cb, input bool, n int, period, jitter time.Duration) error {
+	in := 0
+	if input {
+		in = 1
+	}
+	bpf := cb.sco.Bytes() * cb.Channels()
+	seed := C.uint(time.Now().UnixNano())
+	if ret := C.emulatecbs(cb.c, C.int(n), C.int(cb.bsz), C.int(bpf), C.int(in), C.long(period), C.long(jitter), seed); ret != 0 {
+		return fmt.Errorf("libsio: callback emulation failed (%d)", int(ret))
+	}
+	return nil
+}
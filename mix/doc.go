@@ -0,0 +1,13 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package mix provides an in-process software mixer so that several
+// sound.Sinks can share one output.
+//
+// A Mixer owns one output sound.Sink, usually opened on a device via a
+// host.Entry, and hands out any number of Inputs.  Each Input is a
+// sound.Sink with its own sound.Form and gain.  Inputs may be added and
+// closed while the Mixer is playing.
+//
+// Package mix is part of http://zikichombo.org
+package mix /* import "zikichombo.org/sio/mix" */
@@ -0,0 +1,379 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package mix
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// ErrClosed is returned by Input.Send and Mixer.Input once the Mixer
+// or Input is closed.
+var ErrClosed = errors.New("mixer closed")
+
+// Mixer mixes any number of Inputs onto one output sound.Sink.
+//
+// The Mixer runs a goroutine which sends one buffer of the configured size
+// to the output per cycle.  Inputs which cannot supply a full buffer in a
+// cycle contribute silence for the missing frames, so that a slow Input
+// never causes a glitch in the other Inputs nor in the output.
+type Mixer struct {
+	sound.Form
+	dst sound.Sink
+	bsz int       // frames per cycle
+	buf []float64 // channel deinterleaved mix buffer
+
+	mu     sync.Mutex
+	ins    []*Input
+	cyc    []*Input // snapshot of ins used by the mixing goroutine
+	closed bool
+	err    error
+
+	once     sync.Once
+	doneC    chan struct{}
+	loopDone chan struct{}
+}
+
+// New creates a new Mixer which sends mixed audio to dst in buffers of b
+// frames.  The Mixer starts sending to dst immediately, silence if there
+// are no Inputs.
+//
+// The Mixer owns dst and closes it when the Mixer is closed.
+func New(dst sound.Sink, b int) *Mixer {
+	m := &Mixer{
+		Form:     dst,
+		dst:      dst,
+		bsz:      b,
+		buf:      make([]float64, b*dst.Channels()),
+		doneC:    make(chan struct{}),
+		loopDone: make(chan struct{})}
+	go m.serve()
+	return m
+}
+
+// Open opens a sink via e with the specified device, form, sample codec
+// and buffer size and returns a Mixer whose output is that sink.
+func Open(e host.Entry, dev *libsio.Dev, v sound.Form, co sample.Codec, b int) (*Mixer, error) {
+	if !e.CanOpenSink() {
+		return nil, host.ErrUnsupported
+	}
+	snk, _, err := e.OpenSink(dev, v, co, b)
+	if err != nil {
+		return nil, err
+	}
+	return New(snk, b), nil
+}
+
+// BufSize returns the number of frames the Mixer sends to its output
+// each cycle.
+func (m *Mixer) BufSize() int {
+	return m.bsz
+}
+
+// Input adds a new Input to m, to which data in form v may be sent.
+//
+// The Input buffers up to 2 cycles of data of m.  See InputWith.
+func (m *Mixer) Input(v sound.Form) (*Input, error) {
+	return m.InputWith(v, 2*m.bsz)
+}
+
+// InputWith adds a new Input to m, to which data in form v may be sent.
+//
+// nF indicates the capacity, in frames of the form of m, of the Input's
+// buffer.  Larger buffers tolerate more irregular calls to Send at the cost
+// of latency.  nF is at least one cycle of m, smaller values are raised to
+// one cycle.
+//
+// Data sent to the Input is converted to the form of m: channels are mapped
+// and the sample rate is converted by linear interpolation.
+func (m *Mixer) InputWith(v sound.Form, nF int) (*Input, error) {
+	if nF < m.bsz {
+		nF = m.bsz
+	}
+	nC := m.Channels()
+	in := &Input{
+		Form:  v,
+		m:     m,
+		fifo:  make([]float64, nF*nC),
+		cap:   nF,
+		gain:  1,
+		cur:   1,
+		cv:    libsio.NewConv(v, m),
+		doneC: make(chan struct{})}
+	in.cond = sync.NewCond(&in.mu)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, m.closedErr()
+	}
+	m.ins = append(m.ins, in)
+	return in, nil
+}
+
+// Inputs returns the number of Inputs currently being mixed.
+func (m *Mixer) Inputs() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.ins)
+}
+
+// Close stops mixing, closes all Inputs and closes the output.  The output
+// is closed before waiting for the mixing goroutine, so that Close doesn't
+// hang on an output whose Send blocks.
+func (m *Mixer) Close() error {
+	var err error
+	m.once.Do(func() {
+		close(m.doneC)
+		m.shutdown(nil)
+		err = m.dst.Close()
+		<-m.loopDone
+	})
+	return err
+}
+
+// shutdown marks m closed with error e and releases all Inputs.
+func (m *Mixer) shutdown(e error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	m.closed = true
+	m.err = e
+	for _, in := range m.ins {
+		in.mu.Lock()
+		in.remove(e)
+		in.mu.Unlock()
+	}
+	m.ins = nil
+}
+
+func (m *Mixer) closedErr() error {
+	if m.err != nil {
+		return m.err
+	}
+	return ErrClosed
+}
+
+func (m *Mixer) serve() {
+	defer close(m.loopDone)
+	for {
+		select {
+		case <-m.doneC:
+			return
+		default:
+		}
+		m.mix()
+		if err := m.dst.Send(m.buf); err != nil {
+			m.shutdown(err)
+			return
+		}
+	}
+}
+
+// mix fills m.buf with one cycle of mixed data from all Inputs, removing
+// those which have been closed and drained.
+func (m *Mixer) mix() {
+	for i := range m.buf {
+		m.buf[i] = 0
+	}
+	m.mu.Lock()
+	m.cyc = append(m.cyc[:0], m.ins...)
+	m.mu.Unlock()
+
+	removed := false
+	for _, in := range m.cyc {
+		if in.mixInto(m.buf, m.bsz) {
+			removed = true
+		}
+	}
+	if !removed {
+		return
+	}
+	m.mu.Lock()
+	j := 0
+	for _, in := range m.ins {
+		if in.isRemoved() {
+			continue
+		}
+		m.ins[j] = in
+		j++
+	}
+	for i := j; i < len(m.ins); i++ {
+		m.ins[i] = nil
+	}
+	m.ins = m.ins[:j]
+	m.mu.Unlock()
+}
+
+// Input is a virtual sound.Sink whose data is mixed by a Mixer.
+type Input struct {
+	sound.Form
+	m  *Mixer
+	cv *libsio.Conv
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	fifo    []float64 // ring buffer of interleaved frames in the form of m.
+	cap     int       // capacity of fifo in frames
+	r, n    int       // read frame index and number of frames in fifo.
+	primed  bool      // true once a cycle of data is available after (re)starting.
+	closing bool
+	removed bool
+	err     error // error of the Mixer output, if any, once removed.
+	gain    float64
+	cur     float64 // gain applied at the end of the last cycle.
+	doneC   chan struct{}
+}
+
+// Send is as in sound.Sink.Send.  Send blocks while the Input's buffer is
+// full.
+func (in *Input) Send(d []float64) error {
+	nC := in.Channels()
+	if len(d)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	src := in.cv.Convert(d)
+	mC := in.m.Channels()
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for len(src) > 0 {
+		for in.n == in.cap && !in.removed {
+			in.cond.Wait()
+		}
+		if in.removed {
+			if in.err != nil {
+				return in.err
+			}
+			return ErrClosed
+		}
+		if in.closing {
+			return ErrClosed
+		}
+		w := (in.r + in.n) % in.cap
+		nF := len(src) / mC
+		if nF > in.cap-in.n {
+			nF = in.cap - in.n
+		}
+		if nF > in.cap-w {
+			nF = in.cap - w
+		}
+		copy(in.fifo[w*mC:(w+nF)*mC], src[:nF*mC])
+		in.n += nF
+		src = src[nF*mC:]
+	}
+	return nil
+}
+
+// Close stops accepting data from Send and blocks until the buffered data
+// has been mixed and the Input is removed from the Mixer.
+func (in *Input) Close() error {
+	in.mu.Lock()
+	in.closing = true
+	in.cond.Broadcast()
+	in.mu.Unlock()
+	select {
+	case <-in.doneC:
+	case <-in.m.loopDone:
+		in.m.shutdown(nil)
+	}
+	return nil
+}
+
+// SetGain sets the linear gain applied to in.  Changes in gain are applied
+// gradually over one cycle of the Mixer to avoid clicks.
+func (in *Input) SetGain(g float64) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.gain = g
+}
+
+// Gain returns the linear gain applied to in.
+func (in *Input) Gain() float64 {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.gain
+}
+
+// Latency returns the latency which in contributes to data sent to it
+// in addition to that of the output of the Mixer.  This is the duration of
+// the data currently buffered in in plus one cycle of the Mixer.
+func (in *Input) Latency() time.Duration {
+	in.mu.Lock()
+	n := in.n
+	in.mu.Unlock()
+	return time.Duration(n+in.m.bsz) * in.m.SampleRate().Period()
+}
+
+// mixInto adds up to nF frames of in into the channel deinterleaved
+// buffer dst.  mixInto returns true if in was removed.
+func (in *Input) mixInto(dst []float64, nF int) bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.removed {
+		return true
+	}
+	if !in.primed {
+		if in.n < nF && !in.closing {
+			return false
+		}
+		in.primed = true
+	}
+	k := in.n
+	if k > nF {
+		k = nF
+	}
+	nC := in.m.Channels()
+	g0, g1 := in.cur, in.gain
+	dg := (g1 - g0) / float64(nF)
+	p := in.r
+	for f := 0; f < k; f++ {
+		g := g0 + dg*float64(f+1)
+		src := in.fifo[p*nC : (p+1)*nC]
+		for c, v := range src {
+			dst[c*nF+f] += g * v
+		}
+		p++
+		if p == in.cap {
+			p = 0
+		}
+	}
+	in.cur = g1
+	in.r = p
+	in.n -= k
+	in.cond.Broadcast()
+	if in.n == 0 && in.closing {
+		in.remove(nil)
+		return true
+	}
+	if k < nF {
+		// underrun: wait for a full cycle before contributing again
+		// rather than sputtering.
+		in.primed = false
+	}
+	return false
+}
+
+// remove must be called with in.mu held.
+func (in *Input) remove(e error) {
+	if in.removed {
+		return
+	}
+	in.removed = true
+	in.err = e
+	in.cond.Broadcast()
+	close(in.doneC)
+}
+
+func (in *Input) isRemoved() bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.removed
+}
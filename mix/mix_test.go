@@ -0,0 +1,142 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package mix
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"zikichombo.org/sound"
+)
+
+type chSink struct {
+	sound.Form
+	c    chan []float64
+	stop chan struct{}
+}
+
+func newChSink(v sound.Form) *chSink {
+	return &chSink{Form: v, c: make(chan []float64), stop: make(chan struct{})}
+}
+
+func (s *chSink) Send(d []float64) error {
+	cp := make([]float64, len(d))
+	copy(cp, d)
+	select {
+	case s.c <- cp:
+	case <-s.stop:
+	}
+	return nil
+}
+
+func (s *chSink) Close() error {
+	return nil
+}
+
+func constant(n int, v float64) []float64 {
+	d := make([]float64, n)
+	for i := range d {
+		d[i] = v
+	}
+	return d
+}
+
+func TestMixerSum(t *testing.T) {
+	b := 64
+	snk := newChSink(sound.MonoCd())
+	m := New(snk, b)
+	a, err := m.Input(sound.MonoCd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := m.Input(sound.StereoCd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Send(constant(2*b, 0.25)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(constant(2*2*b, 0.5)); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for i := 0; i < 8 && !found; i++ {
+		d := <-snk.c
+		if math.Abs(d[0]-0.75) < 1e-9 {
+			found = true
+			for j := range d {
+				if math.Abs(d[j]-0.75) > 1e-9 {
+					t.Errorf("frame %d: got %f expected 0.75", j, d[j])
+				}
+			}
+		}
+	}
+	if !found {
+		t.Errorf("never got mixed output")
+	}
+	close(snk.stop)
+	if err := m.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := a.Send(constant(b, 0)); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestMixerRemove(t *testing.T) {
+	b := 32
+	snk := newChSink(sound.MonoCd())
+	m := New(snk, b)
+	a, _ := m.Input(sound.MonoCd())
+	if err := a.Send(constant(b, 1)); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		a.Close()
+		close(done)
+	}()
+	for m.Inputs() != 0 {
+		<-snk.c
+	}
+	<-done
+	close(snk.stop)
+	m.Close()
+}
+
+// blockSink blocks in Send until it is closed.
+type blockSink struct {
+	sound.Form
+	closed chan struct{}
+}
+
+func (s *blockSink) Send(d []float64) error {
+	<-s.closed
+	return ErrClosed
+}
+
+func (s *blockSink) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestMixerCloseBlocked(t *testing.T) {
+	m := New(&blockSink{Form: sound.MonoCd(), closed: make(chan struct{})}, 32)
+	done := make(chan error)
+	go func() {
+		done <- m.Close()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on output")
+	}
+	if _, err := m.Input(sound.MonoCd()); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package pipe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"zikichombo.org/sio/host/hosttest"
+)
+
+func TestConformance(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in")
+	if err := ioutil.WriteFile(in, make([]byte, 1<<16), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := NewEntry()
+	e.AddPath(&Path{Name: "file", In: in, Out: filepath.Join(dir, "out")})
+	hosttest.Test(t, e, &hosttest.Config{BufSize: 64})
+}
@@ -0,0 +1,20 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package pipe provides a host.Entry which exchanges raw PCM with other
+// programs through standard input and output, file descriptors and named
+// pipes (FIFOs), for use in shell pipelines with tools such as sox or
+// ffmpeg.
+//
+// Devices of the entry are configured paths, see Path and AddPath.  Sources
+// read channel-interleaved frames encoded in the requested sample.Codec and
+// sinks write them.  Optionally, I/O is paced in real time and data is
+// preceded by a WAV header.  Sources implement libsio.RawSource and sinks
+// libsio.RawSink.
+//
+// Package pipe registers the entry Default under the name "Pipe" on
+// initialisation.  Default has one device, named "-", which reads standard
+// input and writes standard output.
+//
+// Package pipe is part of http://zikichombo.org
+package pipe /* import "zikichombo.org/sio/pipe" */
@@ -0,0 +1,255 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package pipe
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Path configures a device of an Entry.
+//
+// In and Out name what sources read and sinks write: "-" for standard input
+// or output, "fd:N" for the file descriptor N, or the name of a file or
+// FIFO.  Opening a FIFO blocks until the other end is opened.  Streams
+// close the files they open, including file descriptors, but not standard
+// input and output.
+type Path struct {
+	// Name is the name of the device.
+	Name string
+
+	// In is read by sources.  The device can't be used for sources if
+	// In is empty.
+	In string
+
+	// Out is written by sinks, and created if it doesn't exist.  The
+	// device can't be used for sinks if Out is empty.
+	Out string
+
+	// Pace paces I/O in real time according to the sample rate.  Without
+	// pacing, sources and sinks run as fast as the other end of the pipe.
+	Pace bool
+
+	// WAV indicates that data is preceded by a WAV header.  Sinks write
+	// the header, and sources read it and check that it matches the form
+	// and sample codec with which they are opened.  WAV data is little
+	// endian 16, 24 or 32 bit integer or 32 or 64 bit float.
+	WAV bool
+}
+
+// ErrUnknownPath is returned when opening a stream on a device not added
+// with AddPath.
+var ErrUnknownPath = errors.New("pipe: unknown path")
+
+// ErrDirection is returned when opening a source on a Path without In or
+// a sink on a Path without Out.
+var ErrDirection = errors.New("pipe: path not configured in this direction")
+
+// Entry is a host.Entry whose devices are configured Paths.
+type Entry struct {
+	host.NullEntry
+	mu    sync.Mutex
+	paths map[*libsio.Dev]*Path
+	devs  []*libsio.Dev
+	subs  host.Notifier
+	id    uint64
+}
+
+// Default is the Entry registered by package pipe.
+var Default = NewEntry()
+
+// NewEntry creates a new Entry with no paths.
+func NewEntry() *Entry {
+	return &Entry{paths: make(map[*libsio.Dev]*Path)}
+}
+
+func init() {
+	Default.AddPath(&Path{Name: "-", In: "-", Out: "-"})
+	if err := host.RegisterEntry(Default); err != nil {
+		log.Printf("zc failed load %s: %s\n", Default.Name(), err.Error())
+	}
+}
+
+var codecs = []sample.Codec{
+	sample.SInt8,
+	sample.SInt16L, sample.SInt16B,
+	sample.SInt24L, sample.SInt24B,
+	sample.SInt32L, sample.SInt32B,
+	sample.SFloat32L, sample.SFloat32B,
+	sample.SFloat64L, sample.SFloat64B}
+
+// AddPath adds p to e and returns the corresponding device.  Subscribers
+// to device notifications are notified.
+func (e *Entry) AddPath(p *Path) *libsio.Dev {
+	d := &libsio.Dev{
+		UID:  p.Name,
+		Name: p.Name}
+	caps := libsio.Caps{
+		MinChannels: 1,
+		MaxChannels: libsio.MaxChannels,
+		RateRanges:  []libsio.RateRange{{Min: 1 * freq.Hertz, Max: 768000 * freq.Hertz}},
+		Codecs:      codecs}
+	if p.In != "" {
+		d.In = caps
+	}
+	if p.Out != "" {
+		d.Out = caps
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	d.Id = e.id
+	e.id++
+	e.paths[d] = p
+	e.devs = append(e.devs, d)
+	e.subs.Notify(&host.DevChange{Sense: host.DeviceConnect, Dev: d})
+	return d
+}
+
+// RemovePath removes the device d from e.  Streams already open on d are
+// unaffected.  Subscribers to device notifications are notified.
+func (e *Entry) RemovePath(d *libsio.Dev) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.paths[d]; !ok {
+		return
+	}
+	delete(e.paths, d)
+	for i, o := range e.devs {
+		if o == d {
+			e.devs = append(e.devs[:i], e.devs[i+1:]...)
+			break
+		}
+	}
+	e.subs.Notify(&host.DevChange{Sense: host.DeviceDisconnect, Dev: d})
+}
+
+// Path returns the Path of d, or nil if d is not a device of e.
+func (e *Entry) Path(d *libsio.Dev) *Path {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.paths[d]
+}
+
+func (e *Entry) Name() string {
+	return "Pipe"
+}
+
+func (e *Entry) DefaultSampleCodec() sample.Codec {
+	return sample.SInt16L
+}
+
+func (e *Entry) DefaultForm() sound.Form {
+	return sound.NewForm(44100*freq.Hertz, 2)
+}
+
+func (e *Entry) DefaultBufSize() int {
+	return 1024
+}
+
+func (e *Entry) CanOpenSource() bool {
+	return true
+}
+
+// OpenSource opens a source reading the In of the path of d.  The returned
+// sound.Source implements libsio.RawSource.  If the path has a WAV header,
+// it is read before OpenSource returns.
+func (e *Entry) OpenSource(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Source, time.Time, error) {
+	var t time.Time
+	p := e.Path(d)
+	if p == nil {
+		return nil, t, ErrUnknownPath
+	}
+	if p.In == "" {
+		return nil, t, ErrDirection
+	}
+	s, err := newSrc(p, v, co)
+	if err != nil {
+		return nil, t, err
+	}
+	return s, s.start, nil
+}
+
+func (e *Entry) CanOpenSink() bool {
+	return true
+}
+
+// OpenSink opens a sink writing the Out of the path of d.  The returned
+// sound.Sink implements libsio.RawSink.
+func (e *Entry) OpenSink(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Sink, *time.Time, error) {
+	p := e.Path(d)
+	if p == nil {
+		return nil, nil, ErrUnknownPath
+	}
+	if p.Out == "" {
+		return nil, nil, ErrDirection
+	}
+	s, err := newSnk(p, v, co)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, &s.start, nil
+}
+
+func (e *Entry) HasDevices() bool {
+	return true
+}
+
+func (e *Entry) ScanDevices() ([]*host.DevScanResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	res := make([]*host.DevScanResult, len(e.devs))
+	for i, d := range e.devs {
+		res[i] = &host.DevScanResult{Dev: d}
+	}
+	return res, nil
+}
+
+func (e *Entry) Devices() []*libsio.Dev {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	res := make([]*libsio.Dev, len(e.devs))
+	copy(res, e.devs)
+	return res
+}
+
+func (e *Entry) DevicesNotify(c chan<- *host.DevChange) error {
+	e.subs.Add(c)
+	return nil
+}
+
+func (e *Entry) DevicesNotifyClose(c chan<- *host.DevChange) {
+	e.subs.Remove(c)
+}
+
+// DefaultInputDev returns the first device which can be used for sources.
+func (e *Entry) DefaultInputDev() *libsio.Dev {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, d := range e.devs {
+		if d.CanInput() {
+			return d
+		}
+	}
+	return nil
+}
+
+// DefaultOutputDev returns the first device which can be used for sinks.
+func (e *Entry) DefaultOutputDev() *libsio.Dev {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, d := range e.devs {
+		if d.CanOutput() {
+			return d
+		}
+	}
+	return nil
+}
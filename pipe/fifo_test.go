@@ -0,0 +1,80 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package pipe
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"zikichombo.org/sound/sample"
+)
+
+func TestFIFO(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "fifo")
+	if err := syscall.Mkfifo(name, 0600); err != nil {
+		t.Fatal(err)
+	}
+	e := NewEntry()
+	dev := e.AddPath(&Path{Name: "fifo", In: name, Out: name, WAV: true})
+	nF := 5000
+	d := ramp(nF)
+	errC := make(chan error, 1)
+	go func() {
+		snk, _, err := e.OpenSink(dev, testForm, sample.SInt16L, 256)
+		if err != nil {
+			errC <- err
+			return
+		}
+		for i := 0; i < nF; i += 1000 {
+			if err := snk.Send(sub(d, nF, i, 1000)); err != nil {
+				errC <- err
+				return
+			}
+		}
+		errC <- snk.Close()
+	}()
+	src, _, err := e.OpenSource(dev, testForm, sample.SInt16L, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	got := make([]float64, 2*nF)
+	n, err := src.Receive(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != nF {
+		t.Fatalf("got %d frames", n)
+	}
+	if _, err := src.Receive(got); err != io.EOF {
+		t.Errorf("got %v not EOF", err)
+	}
+	if err := <-errC; err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 2)
+	exp := make([]float64, 1)
+	for i, v := range d {
+		sample.SInt16L.Encode(b, d[i:i+1])
+		sample.SInt16L.Decode(exp, b)
+		if got[i] != exp[0] {
+			t.Fatalf("sample %d: got %f not %f (%f)", i, got[i], exp[0], v)
+		}
+	}
+}
+
+// sub returns the n frames of the 2 channel d from frame f.
+func sub(d []float64, nF, f, n int) []float64 {
+	res := make([]float64, 2*n)
+	copy(res, d[f:f+n])
+	copy(res[n:], d[nF+f:nF+f+n])
+	return res
+}
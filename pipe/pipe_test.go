@@ -0,0 +1,192 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package pipe
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+var testForm = sound.NewForm(8000*freq.Hertz, 2)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "pipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// ramp returns nF frames of 2 channels, with values exactly representable
+// as float32.
+func ramp(nF int) []float64 {
+	d := make([]float64, 2*nF)
+	for f := 0; f < nF; f++ {
+		d[f] = float64(f%64) / 64
+		d[nF+f] = -d[f]
+	}
+	return d
+}
+
+func TestWAVRoundTrip(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	e := NewEntry()
+	dev := e.AddPath(&Path{Name: "wav", In: filepath.Join(dir, "a.wav"), Out: filepath.Join(dir, "a.wav"), WAV: true})
+	snk, start, err := e.OpenSink(dev, testForm, sample.SFloat32L, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nF := 1000
+	d := ramp(nF)
+	if err := snk.Send(d); err != nil {
+		t.Fatal(err)
+	}
+	if start.IsZero() {
+		t.Errorf("start time not set")
+	}
+	if err := snk.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "a.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != wavHeaderSize+nF*8 {
+		t.Fatalf("got %d bytes", len(b))
+	}
+	if n := binary.LittleEndian.Uint32(b[40:]); n != uint32(nF*8) {
+		t.Errorf("data size %d", n)
+	}
+	src, _, err := e.OpenSource(dev, testForm, sample.SFloat32L, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	got := make([]float64, 2*nF)
+	n, err := src.Receive(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != nF {
+		t.Fatalf("got %d frames", n)
+	}
+	for i := range d {
+		if got[i] != d[i] {
+			t.Fatalf("sample %d: got %f not %f", i, got[i], d[i])
+		}
+	}
+	if _, err := src.Receive(got); err != io.EOF {
+		t.Errorf("got %v not EOF", err)
+	}
+	if _, _, err := e.OpenSource(dev, sound.NewForm(8000*freq.Hertz, 1), sample.SFloat32L, 256); err == nil {
+		t.Errorf("opened WAV source with the wrong form")
+	}
+	if _, _, err := e.OpenSink(dev, testForm, sample.SInt8, 256); err == nil {
+		t.Errorf("opened WAV sink with 8 bit samples")
+	}
+}
+
+func TestRaw(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "a.raw")
+	// a partial frame at the end is dropped.
+	if err := ioutil.WriteFile(name, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9}, 0666); err != nil {
+		t.Fatal(err)
+	}
+	e := NewEntry()
+	dev := e.AddPath(&Path{Name: "raw", In: name})
+	if _, _, err := e.OpenSink(dev, testForm, sample.SInt16L, 256); err != ErrDirection {
+		t.Errorf("got %v not ErrDirection", err)
+	}
+	src, _, err := e.OpenSource(dev, testForm, sample.SInt16L, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	pkt := &libsio.RawPacket{D: make([]byte, 64)}
+	if err := src.(libsio.RawSource).ReceiveRaw(pkt); err != nil {
+		t.Fatal(err)
+	}
+	if len(pkt.D) != 8 || pkt.D[7] != 8 || pkt.N != 0 {
+		t.Errorf("got packet %v at %d", pkt.D, pkt.N)
+	}
+	if err := src.(libsio.RawSource).ReceiveRaw(pkt); err != io.EOF {
+		t.Errorf("got %v not EOF", err)
+	}
+}
+
+func TestPace(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "a.raw")
+	if err := ioutil.WriteFile(name, make([]byte, 800*4), 0666); err != nil {
+		t.Fatal(err)
+	}
+	e := NewEntry()
+	dev := e.AddPath(&Path{Name: "paced", In: name, Out: filepath.Join(dir, "b.raw"), Pace: true})
+	src, _, err := e.OpenSource(dev, testForm, sample.SInt16L, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	begin := time.Now()
+	d := make([]float64, 160)
+	for {
+		if _, err := src.Receive(d); err != nil {
+			break
+		}
+	}
+	if el := time.Since(begin); el < 90*time.Millisecond {
+		t.Errorf("800 frames at 8kHz read in %s", el)
+	}
+	snk, _, err := e.OpenSink(dev, testForm, sample.SInt16L, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snk.Close()
+	begin = time.Now()
+	for i := 0; i < 11; i++ {
+		if err := snk.Send(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if el := time.Since(begin); el < 90*time.Millisecond {
+		t.Errorf("880 frames at 8kHz written in %s", el)
+	}
+}
+
+func TestDevices(t *testing.T) {
+	e := NewEntry()
+	c := make(chan *host.DevChange, 2)
+	e.DevicesNotify(c)
+	out := e.AddPath(&Path{Name: "out", Out: "-"})
+	in := e.AddPath(&Path{Name: "in", In: "-"})
+	if e.DefaultInputDev() != in || e.DefaultOutputDev() != out {
+		t.Errorf("wrong default devices")
+	}
+	if chg := <-c; chg.Dev != out || chg.Sense != host.DeviceConnect {
+		t.Errorf("got change %v", chg)
+	}
+	e.DevicesNotifyClose(c)
+	e.RemovePath(out)
+	if devs := e.Devices(); len(devs) != 1 || devs[0] != in {
+		t.Errorf("got devices %v", devs)
+	}
+	if _, _, err := e.OpenSink(out, testForm, sample.SInt16L, 256); err != ErrUnknownPath {
+		t.Errorf("got %v not ErrUnknownPath", err)
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package pipe
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// pacer paces a stream in real time.
+type pacer struct {
+	period time.Duration
+	start  time.Time
+}
+
+// wait sleeps until the time of frame n.
+func (p *pacer) wait(n int) {
+	if d := time.Until(p.start.Add(time.Duration(n) * p.period)); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// openFd opens names of the form "fd:N".
+func openFd(name string) (*os.File, error) {
+	n, err := strconv.Atoi(name[3:])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("pipe: bad file descriptor %q", name)
+	}
+	return os.NewFile(uintptr(n), name), nil
+}
+
+// openIn opens name for reading and returns whether the caller owns the
+// returned file.
+func openIn(name string) (*os.File, bool, error) {
+	switch {
+	case name == "-":
+		return os.Stdin, false, nil
+	case strings.HasPrefix(name, "fd:"):
+		f, err := openFd(name)
+		return f, true, err
+	}
+	f, err := os.Open(name)
+	return f, true, err
+}
+
+// openOut is as openIn, for writing.  Files which don't exist are created.
+func openOut(name string) (*os.File, bool, error) {
+	switch {
+	case name == "-":
+		return os.Stdout, false, nil
+	case strings.HasPrefix(name, "fd:"):
+		f, err := openFd(name)
+		return f, true, err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	return f, true, err
+}
+
+// src implements sound.Source and libsio.RawSource.
+type src struct {
+	sound.Form
+	co    sample.Codec
+	bpf   int
+	f     *os.File
+	own   bool
+	r     io.Reader
+	pace  *pacer
+	start time.Time
+	n     int // frames received.
+	buf   []byte
+	dec   []float64
+}
+
+func newSrc(p *Path, v sound.Form, co sample.Codec) (*src, error) {
+	f, own, err := openIn(p.In)
+	if err != nil {
+		return nil, err
+	}
+	s := &src{
+		Form: v,
+		co:   co,
+		bpf:  co.Bytes() * v.Channels(),
+		f:    f,
+		own:  own,
+		r:    f}
+	if p.WAV {
+		info, err := readWavHeader(f)
+		if err == nil && (info.channels != v.Channels() ||
+			info.rate != int(v.SampleRate().Float64()) || info.co != co) {
+			err = fmt.Errorf("pipe: WAV stream has %d channels at %d Hz in %s",
+				info.channels, info.rate, info.co)
+		}
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		if info.size >= 0 {
+			s.r = io.LimitReader(f, info.size)
+		}
+	}
+	s.start = time.Now()
+	if p.Pace {
+		s.pace = &pacer{period: v.SampleRate().Period(), start: s.start}
+	}
+	return s, nil
+}
+
+func (s *src) Codec() sample.Codec {
+	return s.co
+}
+
+// read reads whole frames into b and returns the number of frames read.
+// A partial frame at the end of the stream is dropped.
+func (s *src) read(b []byte) (int, error) {
+	n, err := io.ReadFull(s.r, b)
+	nF := n / s.bpf
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+		if nF == 0 {
+			err = io.EOF
+		}
+	}
+	if s.pace != nil && nF > 0 {
+		s.pace.wait(s.n + nF)
+	}
+	s.n += nF
+	return nF, err
+}
+
+func (s *src) Receive(d []float64) (int, error) {
+	nC := s.Channels()
+	if len(d)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := len(d) / nC
+	if cap(s.buf) < nF*s.bpf {
+		s.buf = make([]byte, nF*s.bpf)
+		s.dec = make([]float64, len(d))
+	}
+	n, err := s.read(s.buf[:nF*s.bpf])
+	if n == 0 {
+		return 0, err
+	}
+	dec := s.dec[:n*nC]
+	s.co.Decode(dec, s.buf[:n*s.bpf])
+	for i := 0; i < n; i++ {
+		for c := 0; c < nC; c++ {
+			d[c*nF+i] = dec[i*nC+c]
+		}
+	}
+	return n, nil
+}
+
+func (s *src) ReceiveRaw(pkt *libsio.RawPacket) error {
+	nF := cap(pkt.D) / s.bpf
+	if nF == 0 {
+		return io.ErrShortBuffer
+	}
+	n, err := s.read(pkt.D[:nF*s.bpf])
+	pkt.D = pkt.D[:n*s.bpf]
+	pkt.N = s.n - n
+	pkt.Start = s.start
+	if n == 0 {
+		return err
+	}
+	return nil
+}
+
+// Close closes the file read by s, unless it is standard input.
+func (s *src) Close() error {
+	if s.own {
+		return s.f.Close()
+	}
+	return nil
+}
+
+// snk implements sound.Sink and libsio.RawSink.
+type snk struct {
+	sound.Form
+	co    sample.Codec
+	bpf   int
+	f     *os.File
+	own   bool
+	wav   bool
+	pace  *pacer
+	start time.Time
+	n     int // frames sent.
+	buf   []byte
+	enc   []float64
+}
+
+func newSnk(p *Path, v sound.Form, co sample.Codec) (*snk, error) {
+	var hdr [wavHeaderSize]byte
+	if p.WAV {
+		if err := putWavHeader(hdr[:], v, co, wavUnknownSize); err != nil {
+			return nil, err
+		}
+	}
+	f, own, err := openOut(p.Out)
+	if err != nil {
+		return nil, err
+	}
+	s := &snk{
+		Form: v,
+		co:   co,
+		bpf:  co.Bytes() * v.Channels(),
+		f:    f,
+		own:  own,
+		wav:  p.WAV}
+	if p.WAV {
+		if _, err := f.Write(hdr[:]); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	if p.Pace {
+		s.pace = &pacer{period: v.SampleRate().Period()}
+	}
+	return s, nil
+}
+
+func (s *snk) Codec() sample.Codec {
+	return s.co
+}
+
+// write writes the nF frames in b.  The first write sets the start time.
+func (s *snk) write(b []byte, nF int) error {
+	if s.n == 0 {
+		s.start = time.Now()
+		if s.pace != nil {
+			s.pace.start = s.start
+		}
+	}
+	if s.pace != nil {
+		s.pace.wait(s.n)
+	}
+	if _, err := s.f.Write(b); err != nil {
+		return err
+	}
+	s.n += nF
+	return nil
+}
+
+func (s *snk) Send(d []float64) error {
+	nC := s.Channels()
+	if len(d)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	nF := len(d) / nC
+	if nF == 0 {
+		return nil
+	}
+	if cap(s.buf) < nF*s.bpf {
+		s.buf = make([]byte, nF*s.bpf)
+		s.enc = make([]float64, len(d))
+	}
+	enc := s.enc[:len(d)]
+	for i := 0; i < nF; i++ {
+		for c := 0; c < nC; c++ {
+			enc[i*nC+c] = d[c*nF+i]
+		}
+	}
+	b := s.buf[:nF*s.bpf]
+	s.co.Encode(b, enc)
+	return s.write(b, nF)
+}
+
+// SendRaw implements libsio.RawSink.  pkt.N is ignored.
+func (s *snk) SendRaw(pkt *libsio.RawPacket) error {
+	if len(pkt.D)%s.bpf != 0 {
+		return sound.ErrChannelAlignment
+	}
+	if len(pkt.D) == 0 {
+		return nil
+	}
+	return s.write(pkt.D, len(pkt.D)/s.bpf)
+}
+
+// Close closes the file written by s, unless it is standard output.  If s
+// writes a WAV header to a regular file, the sizes in the header are
+// updated.
+func (s *snk) Close() error {
+	if s.wav {
+		if fi, err := s.f.Stat(); err == nil && fi.Mode().IsRegular() {
+			var hdr [wavHeaderSize]byte
+			putWavHeader(hdr[:], s, s.co, uint32(s.n*s.bpf))
+			s.f.WriteAt(hdr[:], 0)
+		}
+	}
+	if s.own {
+		return s.f.Close()
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package pipe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+const (
+	wavPCM        = 1
+	wavFloat      = 3
+	wavExtensible = 0xfffe
+
+	wavHeaderSize = 44
+
+	// wavUnknownSize is used for the sizes of headers written to
+	// streams, as sox and ffmpeg do.
+	wavUnknownSize = 0xffffffff
+)
+
+// ErrNotWAV is returned when opening a source with a WAV header on data
+// which doesn't start with one.
+var ErrNotWAV = errors.New("pipe: not a WAV stream")
+
+// wavTag returns the WAV format tag of co.  WAV data is little endian and
+// 8 bit WAV is unsigned, so only some codecs may be used.
+func wavTag(co sample.Codec) (uint16, error) {
+	switch co {
+	case sample.SInt16L, sample.SInt24L, sample.SInt32L:
+		return wavPCM, nil
+	case sample.SFloat32L, sample.SFloat64L:
+		return wavFloat, nil
+	}
+	return 0, fmt.Errorf("pipe: sample codec %s can't be used with WAV", co)
+}
+
+// wavCodec returns the sample codec of WAV data.
+func wavCodec(tag uint16, bits int) (sample.Codec, error) {
+	switch {
+	case tag == wavPCM && bits == 16:
+		return sample.SInt16L, nil
+	case tag == wavPCM && bits == 24:
+		return sample.SInt24L, nil
+	case tag == wavPCM && bits == 32:
+		return sample.SInt32L, nil
+	case tag == wavFloat && bits == 32:
+		return sample.SFloat32L, nil
+	case tag == wavFloat && bits == 64:
+		return sample.SFloat64L, nil
+	}
+	return 0, fmt.Errorf("pipe: unsupported WAV format %d with %d bits", tag, bits)
+}
+
+// putWavHeader puts a canonical WAV header in b for data of size bytes,
+// or of unknown size if size is wavUnknownSize.
+func putWavHeader(b []byte, v sound.Form, co sample.Codec, size uint32) error {
+	tag, err := wavTag(co)
+	if err != nil {
+		return err
+	}
+	bps := co.Bytes()
+	nC := v.Channels()
+	rate := uint32(v.SampleRate().Float64())
+	riff := uint32(wavUnknownSize)
+	if size != wavUnknownSize {
+		riff = size + wavHeaderSize - 8
+	}
+	le := binary.LittleEndian
+	copy(b[0:], "RIFF")
+	le.PutUint32(b[4:], riff)
+	copy(b[8:], "WAVEfmt ")
+	le.PutUint32(b[16:], 16)
+	le.PutUint16(b[20:], tag)
+	le.PutUint16(b[22:], uint16(nC))
+	le.PutUint32(b[24:], rate)
+	le.PutUint32(b[28:], rate*uint32(nC*bps))
+	le.PutUint16(b[32:], uint16(nC*bps))
+	le.PutUint16(b[34:], uint16(8*bps))
+	copy(b[36:], "data")
+	le.PutUint32(b[40:], size)
+	return nil
+}
+
+// wavInfo is the format of a WAV stream.
+type wavInfo struct {
+	channels int
+	rate     int
+	co       sample.Codec
+	size     int64 // size of the data, -1 if unknown.
+}
+
+// readWavHeader reads the chunks of a WAV stream up to the start of its
+// data.
+func readWavHeader(r io.Reader) (*wavInfo, error) {
+	var b [12]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, ErrNotWAV
+	}
+	if string(b[0:4]) != "RIFF" || string(b[8:12]) != "WAVE" {
+		return nil, ErrNotWAV
+	}
+	le := binary.LittleEndian
+	var info *wavInfo
+	for {
+		if _, err := io.ReadFull(r, b[:8]); err != nil {
+			return nil, ErrNotWAV
+		}
+		id, n := string(b[0:4]), int64(le.Uint32(b[4:8]))
+		switch id {
+		case "fmt ":
+			if n < 16 || n > 64 {
+				return nil, ErrNotWAV
+			}
+			f := make([]byte, n+n&1)
+			if _, err := io.ReadFull(r, f); err != nil {
+				return nil, ErrNotWAV
+			}
+			tag := le.Uint16(f[0:])
+			if tag == wavExtensible && n >= 40 {
+				tag = le.Uint16(f[24:])
+			}
+			co, err := wavCodec(tag, int(le.Uint16(f[14:])))
+			if err != nil {
+				return nil, err
+			}
+			info = &wavInfo{
+				channels: int(le.Uint16(f[2:])),
+				rate:     int(le.Uint32(f[4:])),
+				co:       co}
+		case "data":
+			if info == nil {
+				return nil, ErrNotWAV
+			}
+			info.size = n
+			if n == 0 || n == wavUnknownSize {
+				info.size = -1
+			}
+			return info, nil
+		default:
+			if _, err := io.CopyN(ioutil.Discard, r, n+n&1); err != nil {
+				return nil, ErrNotWAV
+			}
+		}
+	}
+}
+
+// ReadWAVHeader reads the header of the WAV stream r up to the start of its
+// data, and returns the form and sample codec of the data.  It may be used
+// to open a source on a Path with a WAV header of unknown format.
+func ReadWAVHeader(r io.Reader) (sound.Form, sample.Codec, error) {
+	info, err := readWavHeader(r)
+	if err != nil {
+		var co sample.Codec
+		return nil, co, err
+	}
+	return sound.NewForm(freq.T(info.rate)*freq.Hertz, info.channels), info.co, nil
+}
@@ -122,10 +122,10 @@ func (u *auhal) enableIO() error {
 
 func (u *auhal) setDev(dev *libsio.Dev) error {
 	iom := u.iom
-	if dev.MaxOutChannels == 0 && iom.Outputs() {
+	if !dev.Out.Supported() && iom.Outputs() {
 		return fmt.Errorf("no output channels on device %s\n", dev.Name)
 	}
-	if dev.MaxInChannels == 0 && iom.Inputs() {
+	if !dev.In.Supported() && iom.Inputs() {
 		return fmt.Errorf("no output channels on device %s\n", dev.Name)
 	}
 	if err := setBufferSize(dev, u.bufSz); err != nil {
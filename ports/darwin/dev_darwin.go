@@ -15,6 +15,7 @@ import (
 
 	"zikichombo.org/sio/libsio"
 	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
 )
 
 // #cgo LDFLAGS: -framework CoreServices -framework CoreAudio -framework AudioToolbox
@@ -40,7 +41,7 @@ func setSampleRate(d *libsio.Dev, sr freq.T) error {
 	pAddr.mScope = C.kAudioDevicePropertyScopeOutput
 	pAddr.mElement = 0
 	pAddr.mSelector = C.kAudioDevicePropertyNominalSampleRate
-	if d.MaxOutChannels == 0 {
+	if !d.Out.Supported() {
 		pAddr.mScope = C.kAudioDevicePropertyScopeInput
 		pAddr.mElement = 1
 	}
@@ -53,7 +54,7 @@ func setBufferSize(d *libsio.Dev, sz int) error {
 	csz := C.UInt32(sz)
 	var pAddr C.AudioObjectPropertyAddress
 	pAddr.mSelector = C.kAudioDevicePropertyBufferFrameSize
-	if d.MaxOutChannels > 0 {
+	if d.Out.Supported() {
 		pAddr.mScope = C.kAudioDevicePropertyScopeInput
 		pAddr.mElement = 0
 		st := C.AudioObjectSetPropertyData(C.AudioObjectID(d.Id), &pAddr, 0, C.NULL,
@@ -62,7 +63,7 @@ func setBufferSize(d *libsio.Dev, sz int) error {
 			return err
 		}
 	}
-	if d.MaxInChannels > 0 {
+	if d.In.Supported() {
 		pAddr.mScope = C.kAudioDevicePropertyScopeOutput
 		pAddr.mElement = 1
 		st := C.AudioObjectSetPropertyData(C.AudioObjectID(d.Id), &pAddr, 0, C.NULL,
@@ -113,19 +114,22 @@ func list() error {
 			continue
 		}
 		D.Name = nm
+		uid, err := uid(dev)
+		if err != nil {
+			log.Printf("error getting uid of device %d: %s\n", dev, err)
+		}
+		D.UID = uid
 
 		ic, err := channels(dev, C.kAudioDevicePropertyScopeInput)
 		if err != nil {
 			log.Printf("error getting input channels of device %d: %s\n", dev, err)
 			continue
 		}
-		D.MaxInChannels = ic
 		oc, err := channels(dev, C.kAudioDevicePropertyScopeOutput)
 		if err != nil {
 			log.Printf("error getting output channels of device %d: %s\n", dev, err)
 			continue
 		}
-		D.MaxOutChannels = oc
 		if dev == dIn {
 			D.IsDefaultIn = true
 		}
@@ -136,29 +140,40 @@ func list() error {
 			D.IsDefaultSys = true
 			// TBD: DefaultSysDev
 		}
-		if D.MaxOutChannels+D.MaxInChannels == 0 {
+		if oc+ic == 0 {
 			continue
 		}
-		var minSr, maxSr freq.T
-		if D.MaxOutChannels > 0 {
-			minSr, maxSr, err = devSrs(dev, C.kAudioDevicePropertyScopeOutput)
+		if ic > 0 {
+			D.In, err = devCaps(dev, C.kAudioDevicePropertyScopeInput, ic)
 			if err != nil {
 				return err
 			}
-		} else if D.MaxInChannels > 0 {
-			minSr, maxSr, err = devSrs(dev, C.kAudioDevicePropertyScopeInput)
+		}
+		if oc > 0 {
+			D.Out, err = devCaps(dev, C.kAudioDevicePropertyScopeOutput, oc)
 			if err != nil {
 				return err
 			}
 		}
-		D.MinSampleRate = minSr
-		D.MaxSampleRate = maxSr
 		fmt.Printf("%s\n", D)
 	}
 	return nil
 }
 
-func devSrs(dev C.AudioObjectID, scope C.AudioObjectPropertyScope) (freq.T, freq.T, error) {
+// devCaps returns the capabilities of dev in scope, which has nc channels.
+func devCaps(dev C.AudioObjectID, scope C.AudioObjectPropertyScope, nc int) (libsio.Caps, error) {
+	c := libsio.Caps{MinChannels: 1, MaxChannels: nc, Codecs: caCodecs}
+	var err error
+	c.Rates, c.RateRanges, err = devSrs(dev, scope)
+	return c, err
+}
+
+// caCodecs are the sample codecs CoreAudio converts to and from.
+var caCodecs = []sample.Codec{sample.SInt16L, sample.SInt24L, sample.SInt32L, sample.SFloat32L}
+
+// devSrs returns the nominal sample rates of dev in scope.  Ranges
+// reported with equal bounds are discrete rates.
+func devSrs(dev C.AudioObjectID, scope C.AudioObjectPropertyScope) ([]freq.T, []libsio.RateRange, error) {
 	var pAddr C.AudioObjectPropertyAddress
 	pAddr.mScope = scope
 	if scope == C.kAudioDevicePropertyScopeOutput {
@@ -170,33 +185,32 @@ func devSrs(dev C.AudioObjectID, scope C.AudioObjectPropertyScope) (freq.T, freq
 	var sz C.UInt32
 	st := C.AudioObjectGetPropertyDataSize(dev, &pAddr, 0, C.NULL, &sz)
 	if err := caStatus(st); err != nil {
-		return 0, 0, err
+		return nil, nil, err
 	}
 	rangesPtr := (*C.AudioValueRange)(C.malloc(C.ulong(sz)))
 	defer C.free(unsafe.Pointer(rangesPtr))
 	st = C.AudioObjectGetPropertyData(dev, &pAddr, 0, C.NULL, &sz, unsafe.Pointer(rangesPtr))
 	if err := caStatus(st); err != nil {
-		return 0, 0, err
+		return nil, nil, err
 	}
 	nRanges := sz / C.sizeof_AudioValueRange
 	ranges := (*[1 << 30]C.AudioValueRange)(unsafe.Pointer(rangesPtr))[:nRanges]
-	min := 1e10
-	max := 1.0
+	var rates []freq.T
+	var rrs []libsio.RateRange
 	for i := range ranges {
 		r := &ranges[i]
-		if float64(r.mMinimum) < min {
-			min = float64(r.mMinimum)
+		minF := freq.T(uint64(math.Floor(float64(r.mMinimum)+0.5))) * freq.Hertz
+		maxF := freq.T(uint64(math.Floor(float64(r.mMaximum)+0.5))) * freq.Hertz
+		if minF > maxF {
+			return nil, nil, errors.New("invalid sample rate range")
 		}
-		if float64(r.mMaximum) > max {
-			max = float64(r.mMaximum)
+		if minF == maxF {
+			rates = append(rates, minF)
+			continue
 		}
+		rrs = append(rrs, libsio.RateRange{Min: minF, Max: maxF})
 	}
-	minF := freq.T(uint64(math.Floor(min+0.5))) * freq.Hertz
-	maxF := freq.T(uint64(math.Floor(max+0.5))) * freq.Hertz
-	if minF > maxF {
-		return 0, 0, errors.New("disjoint ranges not supported")
-	}
-	return minF, maxF, nil
+	return rates, rrs, nil
 }
 
 func hwDefaults() (C.AudioObjectID, C.AudioObjectID, C.AudioObjectID, error) {
@@ -256,6 +270,12 @@ func name(dev C.AudioObjectID) (string, error) {
 	return strProperty(dev, C.kAudioObjectPropertyName)
 }
 
+// uid returns the persistent identifier CoreAudio gives dev, unlike its
+// AudioObjectID.
+func uid(dev C.AudioObjectID) (string, error) {
+	return strProperty(dev, C.kAudioDevicePropertyDeviceUID)
+}
+
 func strProperty(dev C.AudioObjectID, sel C.AudioObjectPropertySelector) (string, error) {
 	var devAddr C.AudioObjectPropertyAddress
 	devAddr.mSelector = sel
@@ -0,0 +1,101 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build linux
+// +build cgo
+
+package linux
+
+import (
+	"time"
+	"unsafe"
+
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// #cgo pkg-config: alsa
+// #include "alsa/asoundlib.h"
+//
+import "C"
+
+// alsaRates are the rates tested on devices with discrete rates.
+var alsaRates = []uint{8000, 11025, 16000, 22050, 32000, 44100, 48000, 64000,
+	88200, 96000, 176400, 192000, 352800, 384000}
+
+// alsaMaxChannels bounds the channels reported by plugins, which may
+// accept any number.
+const alsaMaxChannels = 64
+
+// alsaCaps probes the capabilities of the pcm named name for capture or
+// playback.  It returns zero Caps if the pcm can't be opened in that
+// direction.
+func alsaCaps(name string, capture bool) libsio.Caps {
+	var caps libsio.Caps
+	dir := C.snd_pcm_stream_t(C.SND_PCM_STREAM_PLAYBACK)
+	if capture {
+		dir = C.SND_PCM_STREAM_CAPTURE
+	}
+	var pcm *C.snd_pcm_t
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	// don't wait for a device in use.
+	if C.snd_pcm_open(&pcm, cName, dir, C.SND_PCM_NONBLOCK) < 0 {
+		return caps
+	}
+	defer C.snd_pcm_close(pcm)
+	var hw *C.snd_pcm_hw_params_t
+	if C.snd_pcm_hw_params_malloc(&hw) < 0 {
+		return caps
+	}
+	defer C.snd_pcm_hw_params_free(hw)
+	if C.snd_pcm_hw_params_any(pcm, hw) < 0 {
+		return caps
+	}
+	var cmin, cmax C.uint
+	C.snd_pcm_hw_params_get_channels_min(hw, &cmin)
+	C.snd_pcm_hw_params_get_channels_max(hw, &cmax)
+	if cmax > alsaMaxChannels {
+		cmax = alsaMaxChannels
+	}
+	caps.MinChannels, caps.MaxChannels = int(cmin), int(cmax)
+
+	var rmin, rmax C.uint
+	var sub C.int
+	C.snd_pcm_hw_params_get_rate_min(hw, &rmin, &sub)
+	C.snd_pcm_hw_params_get_rate_max(hw, &rmax, &sub)
+	// resampling plugins accept an odd rate, unlike the usual ones,
+	// hardware usually doesn't.
+	if odd := (rmin + (rmax-rmin)/2) | 1; rmin < rmax && odd <= rmax &&
+		C.snd_pcm_hw_params_test_rate(pcm, hw, odd, 0) == 0 {
+		caps.RateRanges = []libsio.RateRange{{
+			Min: freq.T(rmin) * freq.Hertz,
+			Max: freq.T(rmax) * freq.Hertz}}
+	} else {
+		for _, r := range alsaRates {
+			if C.uint(r) >= rmin && C.uint(r) <= rmax && C.snd_pcm_hw_params_test_rate(pcm, hw, C.uint(r), 0) == 0 {
+				caps.Rates = append(caps.Rates, freq.T(r)*freq.Hertz)
+			}
+		}
+	}
+
+	for _, co := range sample.Codecs {
+		f, ok := scodec2Alsa[co]
+		if ok && C.snd_pcm_hw_params_test_format(pcm, hw, f) == 0 {
+			caps.Codecs = append(caps.Codecs, co)
+		}
+	}
+
+	var pmin, pmax, bmin, bmax C.snd_pcm_uframes_t
+	C.snd_pcm_hw_params_get_period_size_min(hw, &pmin, &sub)
+	C.snd_pcm_hw_params_get_period_size_max(hw, &pmax, &sub)
+	caps.MinPeriod, caps.MaxPeriod = int(pmin), int(pmax)
+	C.snd_pcm_hw_params_get_buffer_size_min(hw, &bmin)
+	C.snd_pcm_hw_params_get_buffer_size_max(hw, &bmax)
+	if rmin > 0 && rmax > 0 {
+		caps.MinLatency = time.Duration(bmin) * time.Second / time.Duration(rmax)
+		caps.MaxLatency = time.Duration(bmax) * time.Second / time.Duration(rmin)
+	}
+	return caps
+}
@@ -0,0 +1,301 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build linux
+// +build cgo
+// +build jack
+
+package linux
+
+// #cgo pkg-config: jack
+// #include <errno.h>
+// #include <stdlib.h>
+// #include "jack_linux.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unsafe"
+
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// ErrJackServer is returned when no JACK server is running.  We never
+// start one.
+var ErrJackServer = errors.New("unable to connect to JACK server")
+
+// jackClientName is the name under which streams register with JACK.  The
+// server makes it unique.
+const jackClientName = "sio"
+
+func jackOpen(name string) (*C.jack_client_t, error) {
+	cn := C.CString(name)
+	defer C.free(unsafe.Pointer(cn))
+	var st C.jack_status_t
+	c := C.jackOpenClient(cn, &st)
+	if c == nil {
+		return nil, fmt.Errorf("%s: status 0x%x", ErrJackServer, int(st))
+	}
+	return c, nil
+}
+
+func jackRate(c *C.jack_client_t) freq.T {
+	return freq.T(C.jack_get_sample_rate(c)) * freq.Hertz
+}
+
+// jackStartTimeout bounds the wait for the first process cycle of a
+// capture stream.
+const jackStartTimeout = 2 * time.Second
+
+// jackStream is a JACK client with one port per channel whose process
+// callback is bridged to Go by a libsio.Cb.
+//
+// Playback streams are activated and their ports connected on the first
+// call to Send, so that the process callback doesn't wait on Go before the
+// caller is ready.  Capture streams are activated when they are opened, to
+// know their start time, and discard captured cycles until the first call
+// to Receive, which receives silence in their place so that frames keep
+// their times.
+type jackStream struct {
+	*libsio.Cb
+	client  *C.jack_client_t
+	thunk   *C.JackThunk
+	mode    libsio.IoMode
+	targets []string
+
+	// mu guards the client and thunk, which Close frees, against
+	// concurrent I/O.
+	mu      sync.Mutex
+	closed  bool
+	started bool
+
+	startT  time.Time // time of the first frame
+	sealed  bool      // whether captured cycles are no longer discarded
+	skipped int       // frames discarded
+	skip    int       // frames of silence still to be received
+}
+
+func newJackStream(mode libsio.IoMode, targets []string, v sound.Form, co sample.Codec, b int) (*jackStream, error) {
+	if co != sample.SFloat32L {
+		return nil, fmt.Errorf("jack: unsupported sample codec %s, JACK uses %s", co, sample.SFloat32L)
+	}
+	client, err := jackOpen(jackClientName)
+	if err != nil {
+		return nil, err
+	}
+	if sr := jackRate(client); sr != v.SampleRate() {
+		C.jack_client_close(client)
+		return nil, fmt.Errorf("jack: server sample rate is %s, not %s", sr, v.SampleRate())
+	}
+	cm := C.JACK_THUNK_IN
+	if mode == libsio.OutputMode {
+		cm = C.JACK_THUNK_OUT
+	}
+	cb := libsio.NewCb(v, co, b)
+	thunk := C.newJackThunk(client, (*C.Cb)(cb.C()), C.int(cm), C.int(v.Channels()), C.int(b))
+	if thunk == nil {
+		cb.Close()
+		C.jack_client_close(client)
+		return nil, fmt.Errorf("oom")
+	}
+	s := &jackStream{Cb: cb, client: client, thunk: thunk, mode: mode, targets: targets}
+	if C.jackThunkRegister(thunk) != 0 {
+		s.Close()
+		return nil, fmt.Errorf("jack: unable to register %d ports", v.Channels())
+	}
+	// the server buffer size may be smaller than b.
+	if n := int(C.jack_get_buffer_size(client)); n < b {
+		cb.SetMinCbFrames(n)
+	}
+	return s, nil
+}
+
+// start activates s, returning io.EOF if s is closed.
+func (s *jackStream) start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return io.EOF
+	}
+	if s.started {
+		return nil
+	}
+	if C.jack_activate(s.client) != 0 {
+		return fmt.Errorf("jack: unable to activate client")
+	}
+	s.started = true
+	for c, t := range s.targets {
+		if c == s.Channels() {
+			break
+		}
+		ours := C.jackThunkPortName(s.thunk, C.int(c))
+		ct := C.CString(t)
+		var ret C.int
+		if s.mode == libsio.InputMode {
+			ret = C.jack_connect(s.client, ct, ours)
+		} else {
+			ret = C.jack_connect(s.client, ours, ct)
+		}
+		C.free(unsafe.Pointer(ct))
+		if ret != 0 && ret != C.EEXIST {
+			return fmt.Errorf("jack: unable to connect to %s", t)
+		}
+	}
+	return nil
+}
+
+// waitStart waits for the first process cycle of s and returns the time
+// of its first frame.
+func (s *jackStream) waitStart() (time.Time, error) {
+	deadline := time.Now().Add(jackStartTimeout)
+	for {
+		if t := C.jackThunkStart(s.thunk); t != 0 {
+			return jackTime(t), nil
+		}
+		if time.Now().After(deadline) {
+			return time.Time{}, fmt.Errorf("jack: no process cycle within %s", jackStartTimeout)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// setStart sets the start time of a playback stream after its first
+// process cycle.
+func (s *jackStream) setStart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || !s.startT.IsZero() {
+		return
+	}
+	if t := C.jackThunkStart(s.thunk); t != 0 {
+		s.startT = jackTime(t)
+	}
+}
+
+// jackTime converts a time of the clock of the JACK server, in
+// microseconds, to a time.Time.
+func jackTime(t C.jack_time_t) time.Time {
+	now := time.Now()
+	return now.Add(time.Duration(int64(t)-int64(C.jack_get_time())) * time.Microsecond)
+}
+
+// seal stops the discarding of captured cycles on the first call to
+// Receive, returning io.EOF if s is closed.
+func (s *jackStream) seal() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return io.EOF
+	}
+	if s.sealed {
+		return nil
+	}
+	s.sealed = true
+	s.skipped = int(C.jackThunkSeal(s.thunk))
+	s.skip = s.skipped
+	return nil
+}
+
+func (s *jackStream) Receive(d []float64) (int, error) {
+	if err := s.start(); err != nil {
+		return 0, err
+	}
+	if err := s.seal(); err != nil {
+		return 0, err
+	}
+	if s.skip > 0 {
+		nC := s.Channels()
+		if len(d)%nC != 0 {
+			return 0, sound.ErrChannelAlignment
+		}
+		n := len(d) / nC
+		if n > s.skip {
+			n = s.skip
+		}
+		for i := range d[:n*nC] {
+			d[i] = 0
+		}
+		s.skip -= n
+		return n, nil
+	}
+	return s.Cb.Receive(d)
+}
+
+func (s *jackStream) ReceiveRaw(pkt *libsio.RawPacket) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if err := s.seal(); err != nil {
+		return err
+	}
+	if s.skip > 0 {
+		bpf := s.Codec().Bytes() * s.Channels()
+		n := cap(pkt.D) / bpf
+		if n == 0 {
+			return io.ErrShortBuffer
+		}
+		if n > s.skip {
+			n = s.skip
+		}
+		pkt.D = pkt.D[:n*bpf]
+		for i := range pkt.D {
+			pkt.D[i] = 0
+		}
+		pkt.N = s.skipped - s.skip
+		pkt.Start = s.startT
+		s.skip -= n
+		return nil
+	}
+	if err := s.Cb.ReceiveRaw(pkt); err != nil {
+		return err
+	}
+	pkt.N += s.skipped
+	pkt.Start = s.startT
+	return nil
+}
+
+func (s *jackStream) Send(d []float64) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if err := s.Cb.Send(d); err != nil {
+		return err
+	}
+	s.setStart()
+	return nil
+}
+
+func (s *jackStream) SendRaw(pkt *libsio.RawPacket) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if err := s.Cb.SendRaw(pkt); err != nil {
+		return err
+	}
+	s.setStart()
+	return nil
+}
+
+func (s *jackStream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	C.jackThunkClose(s.thunk)
+	if s.started {
+		C.jack_deactivate(s.client)
+	}
+	C.jack_client_close(s.client)
+	C.freeJackThunk(s.thunk)
+	s.mu.Unlock()
+	return s.Cb.Close()
+}
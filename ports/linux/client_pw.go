@@ -0,0 +1,193 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build linux
+// +build cgo
+// +build pipewire
+
+package linux
+
+// #cgo pkg-config: libpipewire-0.3
+// #include <stdlib.h>
+// #include "pw_linux.h"
+import "C"
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// pwStream is a PipeWire stream whose realtime process callback is bridged
+// to Go by a libsio.Cb.
+//
+// The stream is negotiated when opened, so that errors such as an
+// unavailable node are reported then.  Playback streams are only activated
+// on the first call to Send, so that the process callback doesn't wait on Go
+// before the caller is ready, capture streams are activated when opened to
+// find their start time.
+type pwStream struct {
+	*libsio.Cb
+	s       *C.PwStream
+	mu      sync.Mutex
+	closed  bool
+	started bool
+	startT  time.Time
+}
+
+// pwStartTimeout bounds the time to wait for the first cycle of a stream.
+const pwStartTimeout = 2 * time.Second
+
+// newPwStream opens a stream on the node with id target, or on the default
+// node if target is C.PW_SIO_ID_ANY.  The requested quantum of the graph is b
+// frames.
+func newPwStream(capture bool, target uint32, v sound.Form, co sample.Codec, b int) (*pwStream, error) {
+	bytes, isFloat, bigEndian, ok := pwFormat(co)
+	if !ok {
+		return nil, fmt.Errorf("pipewire: unsupported sample codec %s", co)
+	}
+	cb := libsio.NewCb(v, co, b)
+	var errBuf [128]C.char
+	s := C.pwStreamNew((*C.Cb)(cb.C()), cbool(capture), C.uint32_t(target),
+		C.int(bytes), cbool(isFloat), cbool(bigEndian),
+		C.int(v.Channels()), C.int(v.SampleRate().Float64()), C.int(b),
+		&errBuf[0], C.int(len(errBuf)))
+	if s == nil {
+		cb.Close()
+		return nil, fmt.Errorf("pipewire: %s", C.GoString(&errBuf[0]))
+	}
+	return &pwStream{Cb: cb, s: s}, nil
+}
+
+func cbool(b bool) C.int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// pwFormat gives the parameters of the PipeWire sample format
+// corresponding to co.
+func pwFormat(co sample.Codec) (bytes int, isFloat, bigEndian, ok bool) {
+	switch co {
+	case sample.SInt8:
+		return 1, false, false, true
+	case sample.SInt16L, sample.SInt24L, sample.SInt32L:
+		return co.Bytes(), false, false, true
+	case sample.SInt16B, sample.SInt24B, sample.SInt32B:
+		return co.Bytes(), false, true, true
+	case sample.SFloat32L, sample.SFloat64L:
+		return co.Bytes(), true, false, true
+	case sample.SFloat32B, sample.SFloat64B:
+		return co.Bytes(), true, true, true
+	}
+	return 0, false, false, false
+}
+
+// start activates s, returning io.EOF if s is closed.
+func (s *pwStream) start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return io.EOF
+	}
+	if s.started {
+		return nil
+	}
+	if ret := C.pwStreamStart(s.s); ret < 0 {
+		return fmt.Errorf("pipewire: unable to activate stream (%d)", int(ret))
+	}
+	s.started = true
+	return nil
+}
+
+// waitStart waits for the first process cycle of s and returns the time
+// of its first frame.
+func (s *pwStream) waitStart() (time.Time, error) {
+	deadline := time.Now().Add(pwStartTimeout)
+	for {
+		if t := C.pwStreamStartTime(s.s); t != 0 {
+			return pwTime(t), nil
+		}
+		if time.Now().After(deadline) {
+			return time.Time{}, fmt.Errorf("pipewire: no process cycle within %s", pwStartTimeout)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// setStart sets the start time of a playback stream after its first
+// process cycle.
+func (s *pwStream) setStart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || !s.startT.IsZero() {
+		return
+	}
+	if t := C.pwStreamStartTime(s.s); t != 0 {
+		s.startT = pwTime(t)
+	}
+}
+
+// pwTime converts a time given by pwNow to a time.Time.
+func pwTime(t C.int64_t) time.Time {
+	now := time.Now()
+	return now.Add(time.Duration(int64(t) - int64(C.pwNow())))
+}
+
+func (s *pwStream) Receive(d []float64) (int, error) {
+	if err := s.start(); err != nil {
+		return 0, err
+	}
+	return s.Cb.Receive(d)
+}
+
+func (s *pwStream) ReceiveRaw(pkt *libsio.RawPacket) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if err := s.Cb.ReceiveRaw(pkt); err != nil {
+		return err
+	}
+	pkt.Start = s.startT
+	return nil
+}
+
+func (s *pwStream) Send(d []float64) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if err := s.Cb.Send(d); err != nil {
+		return err
+	}
+	s.setStart()
+	return nil
+}
+
+func (s *pwStream) SendRaw(pkt *libsio.RawPacket) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if err := s.Cb.SendRaw(pkt); err != nil {
+		return err
+	}
+	s.setStart()
+	return nil
+}
+
+func (s *pwStream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	C.pwStreamFree(s.s)
+	s.mu.Unlock()
+	return s.Cb.Close()
+}
@@ -0,0 +1,18 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build linux
+// +build cgo
+// +build jack
+
+package linux
+
+import (
+	"testing"
+
+	"zikichombo.org/sio/host/hosttest"
+)
+
+func TestJackConformance(t *testing.T) {
+	hosttest.Test(t, jackTestEntry(t), nil)
+}
@@ -0,0 +1,18 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build linux
+// +build cgo
+// +build pipewire
+
+package linux
+
+import (
+	"testing"
+
+	"zikichombo.org/sio/host/hosttest"
+)
+
+func TestPwConformance(t *testing.T) {
+	hosttest.Test(t, pwTestEntry(t), nil)
+}
@@ -7,7 +7,12 @@
 package linux
 
 import (
+	"io/ioutil"
 	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"zikichombo.org/sio/host"
@@ -46,7 +51,7 @@ func (e *alsaEntry) OpenSource(d *libsio.Dev, v sound.Form, co sample.Codec, b i
 	if err := pcm.open(); err != nil {
 		return nil, t, err
 	}
-	return libsio.InputSource(pcm), pcm.pkts[0].Start, nil
+	return libsio.RawInputSource(pcm), pcm.pkts[0].Start, nil
 }
 
 func (e *alsaEntry) CanOpenSink() bool {
@@ -58,7 +63,7 @@ func (e *alsaEntry) OpenSink(d *libsio.Dev, v sound.Form, co sample.Codec, b int
 	if err := pcm.open(); err != nil {
 		return nil, nil, err
 	}
-	return libsio.OutputSink(pcm), &pcm.pkts[0].Start, nil
+	return libsio.RawOutputSink(pcm), &pcm.pkts[0].Start, nil
 }
 
 func (e *alsaEntry) HasDevices() bool {
@@ -66,19 +71,19 @@ func (e *alsaEntry) HasDevices() bool {
 }
 
 func (e *alsaEntry) Devices() []*libsio.Dev {
-	return devices
+	return probedDevices()
 }
 
 func (e *alsaEntry) DefaultInputDev() *libsio.Dev {
-	return devices[0]
+	return probedDevices()[0]
 }
 
 func (e *alsaEntry) DefaultOutputDev() *libsio.Dev {
-	return devices[0]
+	return probedDevices()[0]
 }
 
 func (e *alsaEntry) DefaultDuplexDev() *libsio.Dev {
-	return devices[0]
+	return probedDevices()[0]
 }
 
 // set up process of ids and free list.
@@ -98,6 +103,58 @@ var devices = []*libsio.Dev{
 	&libsio.Dev{Name: "plughw:0,0"},
 	&libsio.Dev{Name: "hw:0,0"}}
 
+var probeOnce sync.Once
+
+// probedDevices returns devices with their identities and capabilities,
+// probed on first use.
+func probedDevices() []*libsio.Dev {
+	probeOnce.Do(func() {
+		for _, d := range devices {
+			d.UID = alsaUID(d.Name)
+			d.In = alsaCaps(d.Name, true)
+			d.Out = alsaCaps(d.Name, false)
+		}
+	})
+	return devices
+}
+
+// alsaUID returns a stable identity for the pcm named name.  The card
+// index of hw and plughw names depends on the order in which cards are
+// probed, so it is replaced by the bus path of the card, such as
+// 0000:00:1f.3 for PCI or 1-1.2:1.0 for a USB port, or else by its ALSA id.
+func alsaUID(name string) string {
+	i := strings.IndexByte(name, ':')
+	if i < 0 {
+		return name
+	}
+	kind, args := name[:i], name[i+1:]
+	if kind != "hw" && kind != "plughw" {
+		return name
+	}
+	card, dev := args, "0"
+	if j := strings.IndexByte(args, ','); j >= 0 {
+		card, dev = args[:j], args[j+1:]
+	}
+	if _, err := strconv.Atoi(card); err != nil {
+		// already an id, as in hw:PCH,0
+		return name
+	}
+	return kind + ":" + alsaCardPath(card) + "," + dev
+}
+
+// alsaCardPath returns the bus path of card number card, or its id.
+func alsaCardPath(card string) string {
+	p, err := filepath.EvalSymlinks(filepath.Join("/sys/class/sound", "card"+card, "device"))
+	if err == nil {
+		return filepath.Base(p)
+	}
+	id, err := ioutil.ReadFile(filepath.Join("/proc/asound", "card"+card, "id"))
+	if err == nil {
+		return strings.TrimSpace(string(id))
+	}
+	return card
+}
+
 // name says it all.
 /*
 func oldBrokenInit() {
@@ -125,9 +182,9 @@ func oldBrokenInit() {
 			if ret < 0 {
 				continue
 			}
-			d.SampleCodecs = append(d.SampleCodecs, codec)
+			d.In.Codecs = append(d.In.Codecs, codec)
 		}
-		fmt.Printf("%s: %v\n", d, d.SampleCodecs)
+		fmt.Printf("%s: %v\n", d, d.In.Codecs)
 		devs[j] = d
 		j++
 		C.snd_pcm_drain(pcm)
@@ -0,0 +1,424 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build linux
+// +build cgo
+// +build jack
+
+package linux
+
+// #cgo pkg-config: jack
+// #include <stdlib.h>
+// #include "jack_linux.h"
+import "C"
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// jackEntry is a host.Entry joining a JACK graph.
+//
+// Its devices are the audio ports of the graph.  A port to which other
+// clients write (a JACK output port such as "system:capture_1") can be
+// captured from and a port which other clients read (a JACK input port such
+// as "system:playback_1") can be played to.  The channel counts of a device
+// are the number of consecutive ports of the same client and direction
+// starting with its port, and opening a stream with c channels on a device
+// connects the stream's ports to the first c of those.
+type jackEntry struct {
+	host.NullEntry
+	mu    sync.Mutex
+	devs  []*libsio.Dev
+	subs  host.Notifier
+	watch *jackWatch
+}
+
+func (e *jackEntry) Name() string {
+	return "Linux -- JACK"
+}
+
+func (e *jackEntry) DefaultBufSize() int {
+	return 256
+}
+
+func (e *jackEntry) DefaultSampleCodec() sample.Codec {
+	return sample.SFloat32L
+}
+
+// DefaultForm returns stereo at the sample rate of the server, if it is
+// running.
+func (e *jackEntry) DefaultForm() sound.Form {
+	c, err := jackOpen(jackClientName)
+	if err != nil {
+		return sound.StereoCd()
+	}
+	defer C.jack_client_close(c)
+	return sound.NewForm(jackRate(c), 2)
+}
+
+func (e *jackEntry) CanOpenSource() bool {
+	return true
+}
+
+// OpenSource opens a capture stream, which is activated and has run its
+// first process cycle when OpenSource returns.
+func (e *jackEntry) OpenSource(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Source, time.Time, error) {
+	var t time.Time
+	s, err := newJackStream(libsio.InputMode, e.targets(d, libsio.InputMode), v, co, b)
+	if err != nil {
+		return nil, t, err
+	}
+	if err = s.start(); err == nil {
+		t, err = s.waitStart()
+	}
+	if err != nil {
+		s.Close()
+		return nil, t, err
+	}
+	s.startT = t
+	return s, t, nil
+}
+
+func (e *jackEntry) CanOpenSink() bool {
+	return true
+}
+
+func (e *jackEntry) OpenSink(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Sink, *time.Time, error) {
+	s, err := newJackStream(libsio.OutputMode, e.targets(d, libsio.OutputMode), v, co, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, &s.startT, nil
+}
+
+func (e *jackEntry) HasDevices() bool {
+	return true
+}
+
+func (e *jackEntry) ScanDevices() ([]*host.DevScanResult, error) {
+	devs, err := jackScan(nil)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*host.DevScanResult, len(devs))
+	for i, d := range devs {
+		res[i] = &host.DevScanResult{Dev: d}
+	}
+	return res, nil
+}
+
+func (e *jackEntry) Devices() []*libsio.Dev {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.devs == nil {
+		devs, err := jackScan(nil)
+		if err != nil {
+			return nil
+		}
+		e.devs = devs
+	}
+	return e.devs
+}
+
+func (e *jackEntry) DefaultInputDev() *libsio.Dev {
+	for _, d := range e.Devices() {
+		if d.IsDefaultIn {
+			return d
+		}
+	}
+	return nil
+}
+
+func (e *jackEntry) DefaultOutputDev() *libsio.Dev {
+	for _, d := range e.Devices() {
+		if d.IsDefaultOut {
+			return d
+		}
+	}
+	return nil
+}
+
+// targets returns the names of the ports to which a stream on d with mode
+// m is connected, starting from the default device if d is nil.
+func (e *jackEntry) targets(d *libsio.Dev, m libsio.IoMode) []string {
+	if d == nil {
+		if m == libsio.InputMode {
+			d = e.DefaultInputDev()
+		} else {
+			d = e.DefaultOutputDev()
+		}
+		if d == nil {
+			return nil
+		}
+	}
+	devs := e.Devices()
+	for i, o := range devs {
+		if o.Name != d.Name {
+			continue
+		}
+		// d may be stale, the channels are those of the current scan.
+		n := o.In.MaxChannels
+		if m == libsio.OutputMode {
+			n = o.Out.MaxChannels
+		}
+		if n > len(devs)-i {
+			n = len(devs) - i
+		}
+		cl := jackClientOf(o.Name)
+		res := make([]string, 0, n)
+		for _, p := range devs[i : i+n] {
+			if jackClientOf(p.Name) != cl {
+				break
+			}
+			res = append(res, p.Name)
+		}
+		return res
+	}
+	return nil
+}
+
+// DevicesNotify sends a DeviceConnect or DeviceDisconnect change when a
+// port is registered or unregistered, and a DeviceRouteChange for both
+// ports when ports are connected or disconnected.
+func (e *jackEntry) DevicesNotify(c chan<- *host.DevChange) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.watch == nil {
+		w, err := newJackWatch(e)
+		if err != nil {
+			return err
+		}
+		e.watch = w
+	}
+	e.subs.Add(c)
+	return nil
+}
+
+func (e *jackEntry) DevicesNotifyClose(c chan<- *host.DevChange) {
+	e.mu.Lock()
+	var w *jackWatch
+	if n, ok := e.subs.Remove(c); ok && n == 0 {
+		w = e.watch
+		e.watch = nil
+	}
+	e.mu.Unlock()
+	if w != nil {
+		w.close()
+	}
+}
+
+// rescan updates e's devices and notifies subscribers of the differences,
+// keeping existing *libsio.Devs for ports which remain.
+func (e *jackEntry) rescan(c *C.jack_client_t) {
+	devs, err := jackScan(c)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	old := make(map[string]*libsio.Dev, len(e.devs))
+	for _, d := range e.devs {
+		old[d.Name] = d
+	}
+	var added []*libsio.Dev
+	for i, d := range devs {
+		if o, ok := old[d.Name]; ok {
+			*o = *d
+			devs[i] = o
+			delete(old, d.Name)
+			continue
+		}
+		added = append(added, d)
+	}
+	e.devs = devs
+	e.mu.Unlock()
+	for _, d := range old {
+		e.subs.Notify(&host.DevChange{Sense: host.DeviceDisconnect, Dev: d})
+	}
+	for _, d := range added {
+		e.subs.Notify(&host.DevChange{Sense: host.DeviceConnect, Dev: d})
+	}
+}
+
+func (e *jackEntry) devByName(name string) *libsio.Dev {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, d := range e.devs {
+		if d.Name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// jackScan lists the audio ports of the graph with client c, or a new
+// client if c is nil.
+func jackScan(c *C.jack_client_t) ([]*libsio.Dev, error) {
+	if c == nil {
+		var err error
+		c, err = jackOpen(jackClientName)
+		if err != nil {
+			return nil, err
+		}
+		defer C.jack_client_close(c)
+	}
+	sr := jackRate(c)
+	ports := C.jack_get_ports(c, nil, C.jackAudioType(), 0)
+	if ports == nil {
+		return []*libsio.Dev{}, nil
+	}
+	defer C.jack_free(unsafe.Pointer(ports))
+	var names []string
+	for _, p := range (*[1 << 20]*C.char)(unsafe.Pointer(ports)) {
+		if p == nil {
+			break
+		}
+		names = append(names, C.GoString(p))
+	}
+	devs := make([]*libsio.Dev, len(names))
+	flags := make([]C.int, len(names))
+	var defIn, defOut bool
+	for i, name := range names {
+		cn := C.CString(name)
+		port := C.jack_port_by_name(c, cn)
+		C.free(unsafe.Pointer(cn))
+		if port != nil {
+			flags[i] = C.jack_port_flags(port)
+		}
+		d := &libsio.Dev{
+			Id:   uint64(i),
+			UID:  name,
+			Name: name}
+		phys := flags[i]&C.JackPortIsPhysical != 0
+		if flags[i]&C.JackPortIsOutput != 0 && phys && !defIn {
+			d.IsDefaultIn = true
+			defIn = true
+		}
+		if flags[i]&C.JackPortIsInput != 0 && phys && !defOut {
+			d.IsDefaultOut = true
+			defOut = true
+		}
+		devs[i] = d
+	}
+	// channel counts from runs of ports with the same client and direction,
+	// counted backwards.
+	dirs := C.int(C.JackPortIsInput | C.JackPortIsOutput)
+	for i := len(devs) - 1; i >= 0; i-- {
+		n := 1
+		if i+1 < len(devs) && jackClientOf(names[i]) == jackClientOf(names[i+1]) && flags[i]&dirs == flags[i+1]&dirs {
+			n += devs[i+1].In.MaxChannels + devs[i+1].Out.MaxChannels
+		}
+		// JACK runs at one rate, with float samples only.
+		caps := libsio.Caps{
+			MinChannels: 1,
+			MaxChannels: n,
+			Rates:       []freq.T{sr},
+			Codecs:      []sample.Codec{sample.SFloat32L}}
+		if flags[i]&C.JackPortIsOutput != 0 {
+			devs[i].In = caps
+		} else if flags[i]&C.JackPortIsInput != 0 {
+			devs[i].Out = caps
+		}
+	}
+	return devs, nil
+}
+
+func jackClientOf(port string) string {
+	if i := strings.IndexByte(port, ':'); i >= 0 {
+		return port[:i]
+	}
+	return port
+}
+
+// jackWatch is a JACK client with no ports which receives graph events
+// on behalf of a jackEntry.
+type jackWatch struct {
+	e      *jackEntry
+	client *C.jack_client_t
+	r, w   *os.File
+	done   chan struct{}
+}
+
+func newJackWatch(e *jackEntry) (*jackWatch, error) {
+	c, err := jackOpen(jackClientName + "-watch")
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		C.jack_client_close(c)
+		return nil, err
+	}
+	jw := &jackWatch{e: e, client: c, r: r, w: w, done: make(chan struct{})}
+	if C.jackWatch(c, C.int(w.Fd())) != 0 || C.jack_activate(c) != 0 {
+		C.jack_client_close(c)
+		r.Close()
+		w.Close()
+		return nil, ErrJackServer
+	}
+	if e.devs == nil {
+		e.devs, _ = jackScan(c)
+	}
+	go jw.serve()
+	return jw, nil
+}
+
+func (w *jackWatch) close() {
+	C.jack_deactivate(w.client)
+	w.w.Close()
+	<-w.done
+	w.r.Close()
+	C.jack_client_close(w.client)
+}
+
+func (w *jackWatch) serve() {
+	defer close(w.done)
+	var ev C.JackEvent
+	buf := (*[C.sizeof_JackEvent]byte)(unsafe.Pointer(&ev))[:]
+	for {
+		if _, err := io.ReadFull(w.r, buf); err != nil {
+			return
+		}
+		switch ev.kind {
+		case C.JACK_EV_REGISTER:
+			w.e.rescan(w.client)
+		case C.JACK_EV_CONNECT:
+			for _, id := range [2]C.uint32_t{ev.a, ev.b} {
+				p := C.jack_port_by_id(w.client, C.jack_port_id_t(id))
+				if p == nil {
+					continue
+				}
+				if d := w.e.devByName(C.GoString(C.jack_port_name(p))); d != nil {
+					w.e.subs.Notify(&host.DevChange{Sense: host.DeviceRouteChange, Dev: d})
+				}
+			}
+		case C.JACK_EV_SHUTDOWN:
+			log.Printf("jack: server shut down")
+			w.e.mu.Lock()
+			devs := w.e.devs
+			w.e.devs = nil
+			w.e.mu.Unlock()
+			for _, d := range devs {
+				w.e.subs.Notify(&host.DevChange{Sense: host.DeviceDisconnect, Dev: d})
+			}
+		}
+	}
+}
+
+func init() {
+	e := &jackEntry{NullEntry: host.NullEntry{}}
+	if err := host.RegisterEntry(e); err != nil {
+		log.Printf("zc failed load %s: %s\n", e.Name(), err.Error())
+	}
+}
@@ -0,0 +1,388 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build linux
+// +build cgo
+// +build pipewire
+
+package linux
+
+// #cgo pkg-config: libpipewire-0.3
+// #include "pw_linux.h"
+import "C"
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// ErrPwServer is returned when no PipeWire server can be reached.
+var ErrPwServer = errors.New("unable to connect to PipeWire server")
+
+// pwScanTimeout bounds the time to wait for the initial list of nodes.
+const pwScanTimeout = 5 * time.Second
+
+// pwEntry is a host.Entry for PipeWire.
+//
+// Its devices are the audio nodes of the graph (media class Audio/Sink,
+// Audio/Source and Audio/Duplex), preceded by a device named "default"
+// which lets PipeWire choose the node.  The Id of a device is the id of its
+// node.  The buffer size requested when opening a stream is used as the
+// quantum requested from the graph, and the sample rate as the requested
+// graph rate.
+type pwEntry struct {
+	host.NullEntry
+	mu    sync.Mutex
+	devs  []*libsio.Dev
+	subs  host.Notifier
+	watch *pwWatch
+}
+
+// pwDefaultDev selects the node chosen by PipeWire.
+var pwDefaultDev = &libsio.Dev{
+	Id:           C.PW_SIO_ID_ANY,
+	UID:          "default",
+	Name:         "default",
+	In:           pwCaps(64),
+	Out:          pwCaps(64),
+	IsDefaultIn:  true,
+	IsDefaultOut: true,
+	IsDefaultSys: true}
+
+// pwCaps returns the capabilities of a node with nc channels.  PipeWire
+// converts rates and formats, so any are accepted.
+func pwCaps(nc int) libsio.Caps {
+	return libsio.Caps{
+		MinChannels: 1,
+		MaxChannels: nc,
+		RateRanges:  []libsio.RateRange{{Min: 8000 * freq.Hertz, Max: 384000 * freq.Hertz}},
+		Codecs:      pwCodecs}
+}
+
+var pwCodecs = []sample.Codec{
+	sample.SInt8,
+	sample.SInt16L, sample.SInt16B,
+	sample.SInt24L, sample.SInt24B,
+	sample.SInt32L, sample.SInt32B,
+	sample.SFloat32L, sample.SFloat32B,
+	sample.SFloat64L, sample.SFloat64B}
+
+func (e *pwEntry) Name() string {
+	return "Linux -- PipeWire"
+}
+
+func (e *pwEntry) DefaultBufSize() int {
+	return 256
+}
+
+func (e *pwEntry) DefaultSampleCodec() sample.Codec {
+	return sample.SFloat32L
+}
+
+func (e *pwEntry) DefaultForm() sound.Form {
+	return sound.NewForm(48000*freq.Hertz, 2)
+}
+
+func (e *pwEntry) CanOpenSource() bool {
+	return true
+}
+
+// OpenSource opens a capture stream, which is activated and has run its
+// first process cycle when OpenSource returns.
+func (e *pwEntry) OpenSource(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Source, time.Time, error) {
+	var t time.Time
+	s, err := newPwStream(true, pwTarget(d), v, co, b)
+	if err != nil {
+		return nil, t, err
+	}
+	if err = s.start(); err == nil {
+		t, err = s.waitStart()
+	}
+	if err != nil {
+		s.Close()
+		return nil, t, err
+	}
+	s.startT = t
+	return s, t, nil
+}
+
+func (e *pwEntry) CanOpenSink() bool {
+	return true
+}
+
+func (e *pwEntry) OpenSink(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Sink, *time.Time, error) {
+	s, err := newPwStream(false, pwTarget(d), v, co, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, &s.startT, nil
+}
+
+func pwTarget(d *libsio.Dev) uint32 {
+	if d == nil {
+		return C.PW_SIO_ID_ANY
+	}
+	return uint32(d.Id)
+}
+
+func (e *pwEntry) HasDevices() bool {
+	return true
+}
+
+func (e *pwEntry) ScanDevices() ([]*host.DevScanResult, error) {
+	devs, err := pwScan()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*host.DevScanResult, len(devs))
+	for i, d := range devs {
+		res[i] = &host.DevScanResult{Dev: d}
+	}
+	return res, nil
+}
+
+func (e *pwEntry) Devices() []*libsio.Dev {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.devs == nil {
+		devs, err := pwScan()
+		if err != nil {
+			return nil
+		}
+		e.devs = devs
+	}
+	return e.devs
+}
+
+func (e *pwEntry) DefaultInputDev() *libsio.Dev {
+	return pwDefaultDev
+}
+
+func (e *pwEntry) DefaultOutputDev() *libsio.Dev {
+	return pwDefaultDev
+}
+
+// DevicesNotify sends a DeviceConnect or DeviceDisconnect change when an
+// audio node is added to or removed from the graph.
+func (e *pwEntry) DevicesNotify(c chan<- *host.DevChange) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.watch == nil {
+		w, err := newPwWatch(e)
+		if err != nil {
+			return err
+		}
+		e.watch = w
+	}
+	e.subs.Add(c)
+	return nil
+}
+
+func (e *pwEntry) DevicesNotifyClose(c chan<- *host.DevChange) {
+	e.mu.Lock()
+	var w *pwWatch
+	if n, ok := e.subs.Remove(c); ok && n == 0 {
+		w = e.watch
+		e.watch = nil
+	}
+	e.mu.Unlock()
+	if w != nil {
+		w.close()
+	}
+}
+
+func (e *pwEntry) add(d *libsio.Dev) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.devs = append(e.devs, d)
+	e.subs.Notify(&host.DevChange{Sense: host.DeviceConnect, Dev: d})
+}
+
+func (e *pwEntry) remove(id uint32) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, d := range e.devs {
+		if d != pwDefaultDev && d.Id == uint64(id) {
+			e.devs = append(e.devs[:i], e.devs[i+1:]...)
+			e.subs.Notify(&host.DevChange{Sense: host.DeviceDisconnect, Dev: d})
+			return
+		}
+	}
+}
+
+// pwGraph reads the events of a C.PwGraph from a pipe.
+type pwGraph struct {
+	g    *C.PwGraph
+	r, w *os.File
+	ev   C.PwEvent
+}
+
+func openPwGraph() (*pwGraph, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	g := C.pwGraphNew(C.int(w.Fd()))
+	if g == nil {
+		r.Close()
+		w.Close()
+		return nil, ErrPwServer
+	}
+	return &pwGraph{g: g, r: r, w: w}, nil
+}
+
+// next reads the next event into g.ev.
+func (g *pwGraph) next() error {
+	buf := (*[C.sizeof_PwEvent]byte)(unsafe.Pointer(&g.ev))[:]
+	_, err := io.ReadFull(g.r, buf)
+	return err
+}
+
+// dev returns the device described by an add event.
+func (g *pwGraph) dev() *libsio.Dev {
+	cls := C.GoString(&g.ev.cls[0])
+	d := &libsio.Dev{
+		Id:   uint64(g.ev.id),
+		Name: C.GoString(&g.ev.name[0])}
+	// node names, such as alsa_output.pci-0000_00_1f.3.analog-stereo,
+	// are derived from the hardware by the session manager.
+	d.UID = d.Name
+	if d.Name == "" {
+		d.Name = C.GoString(&g.ev.desc[0])
+	}
+	nc := int(g.ev.channels)
+	if nc == 0 {
+		nc = 2
+	}
+	if strings.Contains(cls, "Source") || strings.Contains(cls, "Duplex") {
+		d.In = pwCaps(nc)
+	}
+	if strings.Contains(cls, "Sink") || strings.Contains(cls, "Duplex") {
+		d.Out = pwCaps(nc)
+	}
+	return d
+}
+
+// initial reads the devices present when g was opened.
+func (g *pwGraph) initial() ([]*libsio.Dev, error) {
+	g.r.SetReadDeadline(time.Now().Add(pwScanTimeout))
+	defer g.r.SetReadDeadline(time.Time{})
+	devs := []*libsio.Dev{pwDefaultDev}
+	for {
+		if err := g.next(); err != nil {
+			return nil, err
+		}
+		switch g.ev.kind {
+		case C.PW_EV_ADD:
+			devs = append(devs, g.dev())
+		case C.PW_EV_DONE:
+			return devs, nil
+		case C.PW_EV_SHUTDOWN:
+			return nil, ErrPwServer
+		}
+	}
+}
+
+// close stops the C side from writing before closing the pipe.
+func (g *pwGraph) close() {
+	C.pwGraphFree(g.g)
+	g.w.Close()
+	g.r.Close()
+}
+
+func pwScan() ([]*libsio.Dev, error) {
+	g, err := openPwGraph()
+	if err != nil {
+		return nil, err
+	}
+	defer g.close()
+	return g.initial()
+}
+
+// pwWatch follows a graph on behalf of a pwEntry.
+type pwWatch struct {
+	e    *pwEntry
+	g    *pwGraph
+	done chan struct{}
+}
+
+// newPwWatch must be called with e.mu held.
+func newPwWatch(e *pwEntry) (*pwWatch, error) {
+	g, err := openPwGraph()
+	if err != nil {
+		return nil, err
+	}
+	devs, err := g.initial()
+	if err != nil {
+		g.close()
+		return nil, err
+	}
+	// keep the devices the caller may already have.
+	if e.devs != nil {
+		old := make(map[uint64]*libsio.Dev, len(e.devs))
+		for _, d := range e.devs {
+			old[d.Id] = d
+		}
+		for i, d := range devs {
+			if o := old[d.Id]; o != nil && o.Name == d.Name {
+				devs[i] = o
+			}
+		}
+	}
+	e.devs = devs
+	w := &pwWatch{e: e, g: g, done: make(chan struct{})}
+	go w.serve()
+	return w, nil
+}
+
+func (w *pwWatch) close() {
+	C.pwGraphFree(w.g.g)
+	w.g.w.Close()
+	<-w.done
+	w.g.r.Close()
+}
+
+func (w *pwWatch) serve() {
+	defer close(w.done)
+	for {
+		if err := w.g.next(); err != nil {
+			return
+		}
+		switch w.g.ev.kind {
+		case C.PW_EV_ADD:
+			w.e.add(w.g.dev())
+		case C.PW_EV_REMOVE:
+			w.e.remove(uint32(w.g.ev.id))
+		case C.PW_EV_SHUTDOWN:
+			log.Printf("pipewire: server shut down")
+			w.e.mu.Lock()
+			devs := w.e.devs
+			w.e.devs = nil
+			for _, d := range devs {
+				if d != pwDefaultDev {
+					w.e.subs.Notify(&host.DevChange{Sense: host.DeviceDisconnect, Dev: d})
+				}
+			}
+			w.e.mu.Unlock()
+		}
+	}
+}
+
+func init() {
+	e := &pwEntry{NullEntry: host.NullEntry{}}
+	if err := host.RegisterEntry(e); err != nil {
+		log.Printf("zc failed load %s: %s\n", e.Name(), err.Error())
+	}
+}
@@ -3,5 +3,10 @@
 
 // Package linux zc sound/io entry points.
 //
+// The shared memory entry "Linux -- SHM" is provided by package
+// zikichombo.org/sio/shm, which package linux imports.
+//
 // Package linux is part of http://zikichombo.org
 package linux /* import "zikichombo.org/sio/ports/linux" */
+
+import _ "zikichombo.org/sio/shm"
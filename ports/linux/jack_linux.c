@@ -0,0 +1,210 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build cgo
+// +build jack
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <unistd.h>
+
+#include "jack_linux.h"
+
+static int process(jack_nframes_t nFrames, void *arg);
+
+jack_client_t * jackOpenClient(const char *name, jack_status_t *st) {
+	return jack_client_open(name, JackNoStartServer, st);
+}
+
+JackThunk * newJackThunk(jack_client_t *client, Cb *cb, int mode, int nc, int bufSz) {
+	JackThunk *t = (JackThunk *) calloc(1, sizeof(JackThunk));
+	if (t == 0) {
+		return t;
+	}
+	t->client = client;
+	t->cb = cb;
+	t->mode = mode;
+	t->nc = nc;
+	t->bufSz = bufSz;
+	t->ports = (jack_port_t **) calloc(nc, sizeof(jack_port_t *));
+	t->buf = (float *) calloc(nc * bufSz, sizeof(float));
+	if (t->ports == 0 || t->buf == 0) {
+		freeJackThunk(t);
+		return 0;
+	}
+	atomic_store(&t->closing, 0);
+	atomic_store(&t->start, 0);
+	atomic_store(&t->skipped, 0);
+	return t;
+}
+
+void freeJackThunk(JackThunk *t) {
+	free(t->ports);
+	free(t->buf);
+	free(t);
+}
+
+// jackThunkRegister registers the ports of t and its process callback.
+int jackThunkRegister(JackThunk *t) {
+	char name[32];
+	unsigned long flags = JackPortIsInput;
+	const char *pfx = "in";
+	if (t->mode == JACK_THUNK_OUT) {
+		flags = JackPortIsOutput;
+		pfx = "out";
+	}
+	int c;
+	for (c = 0; c < t->nc; c++) {
+		snprintf(name, sizeof(name), "%s_%d", pfx, c+1);
+		t->ports[c] = jack_port_register(t->client, name, JACK_DEFAULT_AUDIO_TYPE, flags, 0);
+		if (t->ports[c] == 0) {
+			return -1;
+		}
+	}
+	return jack_set_process_callback(t->client, process, t);
+}
+
+// jackThunkPortName returns the full name of the port for channel c.
+const char * jackThunkPortName(JackThunk *t, int c) {
+	return jack_port_name(t->ports[c]);
+}
+
+const char * jackAudioType(void) {
+	return JACK_DEFAULT_AUDIO_TYPE;
+}
+
+// jackThunkClose stops t from exchanging data with Go, so that
+// deactivating the client doesn't wait on Go.
+void jackThunkClose(JackThunk *t) {
+	atomic_store(&t->closing, 1);
+}
+
+// jackThunkStart returns the time of the first frame of t, or 0 before the
+// first cycle.
+jack_time_t jackThunkStart(JackThunk *t) {
+	return atomic_load(&t->start);
+}
+
+// jackThunkSeal stops t from discarding captured cycles, which it does
+// until the first receive, and returns the number of frames discarded.
+int64_t jackThunkSeal(JackThunk *t) {
+	int64_t n = atomic_load(&t->skipped);
+	while (!atomic_compare_exchange_weak(&t->skipped, &n, n | JACK_THUNK_SEALED)) {
+	}
+	return n;
+}
+
+static void zero(JackThunk *t, jack_nframes_t nFrames) {
+	int c;
+	for (c = 0; c < t->nc; c++) {
+		float *p = (float *) jack_port_get_buffer(t->ports[c], nFrames);
+		memset(p, 0, nFrames * sizeof(float));
+	}
+}
+
+static int process(jack_nframes_t nFrames, void *arg) {
+	JackThunk *t = (JackThunk *) arg;
+	if (atomic_load(&t->closing)) {
+		if (t->mode == JACK_THUNK_OUT) {
+			zero(t, nFrames);
+		}
+		return 0;
+	}
+	if (atomic_load(&t->start) == 0) {
+		// captured data is that of the last cycle, played data is
+		// that of the next one.
+		jack_nframes_t f = jack_last_frame_time(t->client);
+		if (t->mode == JACK_THUNK_IN) {
+			f -= nFrames;
+		} else {
+			f += nFrames;
+		}
+		atomic_store(&t->start, jack_frames_to_time(t->client, f));
+	}
+	if (t->mode == JACK_THUNK_IN) {
+		int64_t n = atomic_load(&t->skipped);
+		while ((n & JACK_THUNK_SEALED) == 0) {
+			if (atomic_compare_exchange_weak(&t->skipped, &n, n + nFrames)) {
+				return 0;
+			}
+		}
+	}
+	int nc = t->nc;
+	float *bufs[nc];
+	int c, i;
+	for (c = 0; c < nc; c++) {
+		bufs[c] = (float *) jack_port_get_buffer(t->ports[c], nFrames);
+	}
+	Cb *cb = t->cb;
+	int off = 0;
+	while (off < (int)nFrames) {
+		int n = (int)nFrames - off;
+		if (n > t->bufSz) {
+			n = t->bufSz;
+		}
+		if (t->mode == JACK_THUNK_IN) {
+			for (i = 0; i < n; i++) {
+				for (c = 0; c < nc; c++) {
+					t->buf[i*nc+c] = bufs[c][off+i];
+				}
+			}
+			cb->inCb(cb, t->buf, n);
+		} else {
+			int m = n;
+			cb->outCb(cb, t->buf, &m);
+			for (i = 0; i < m; i++) {
+				for (c = 0; c < nc; c++) {
+					bufs[c][off+i] = t->buf[i*nc+c];
+				}
+			}
+			// short output is end of stream.
+			for (i = m; i < n; i++) {
+				for (c = 0; c < nc; c++) {
+					bufs[c][off+i] = 0.0f;
+				}
+			}
+		}
+		off += n;
+	}
+	return 0;
+}
+
+// watch callbacks run on a JACK notification thread, they only write to
+// the pipe read by Go.
+static void writeEv(int fd, int32_t kind, int32_t yes, uint32_t a, uint32_t b) {
+	JackEvent ev;
+	ev.kind = kind;
+	ev.yes = yes;
+	ev.a = a;
+	ev.b = b;
+	if (write(fd, &ev, sizeof(ev)) != sizeof(ev)) {
+		fprintf(stderr, "jack: lost event %d\n", kind);
+	}
+}
+
+static void onRegister(jack_port_id_t port, int yes, void *arg) {
+	writeEv((int)(intptr_t)arg, JACK_EV_REGISTER, yes, port, 0);
+}
+
+static void onConnect(jack_port_id_t a, jack_port_id_t b, int yes, void *arg) {
+	writeEv((int)(intptr_t)arg, JACK_EV_CONNECT, yes, a, b);
+}
+
+static void onShutdown(void *arg) {
+	writeEv((int)(intptr_t)arg, JACK_EV_SHUTDOWN, 0, 0, 0);
+}
+
+int jackWatch(jack_client_t *client, int fd) {
+	void *arg = (void *)(intptr_t)fd;
+	int ret = jack_set_port_registration_callback(client, onRegister, arg);
+	if (ret != 0) {
+		return ret;
+	}
+	ret = jack_set_port_connect_callback(client, onConnect, arg);
+	if (ret != 0) {
+		return ret;
+	}
+	jack_on_shutdown(client, onShutdown, arg);
+	return 0;
+}
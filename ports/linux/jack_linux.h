@@ -0,0 +1,70 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+#ifndef ZC_SIO_JACK_LINUX_H
+#define ZC_SIO_JACK_LINUX_H
+
+#include <stdatomic.h>
+#include <stdint.h>
+#include <jack/jack.h>
+
+#include "../../libsio/cb.h"
+
+#define JACK_THUNK_IN 1
+#define JACK_THUNK_OUT 2
+
+// JackThunk bridges a JACK process callback to a libsio Cb.  JACK gives one
+// non-interleaved float buffer per port and a server determined number of
+// frames per cycle, whereas Cb expects interleaved data in chunks of at most
+// bufSz frames.
+typedef struct JackThunk {
+	jack_client_t *client;
+	Cb *cb;
+	int mode;
+	int nc;
+	int bufSz;
+	jack_port_t **ports;
+	float *buf;  // nc * bufSz interleaved samples.
+	_Atomic int closing;
+	// time of the first frame, 0 until the first cycle.
+	_Atomic jack_time_t start;
+	// frames captured before the first receive, or'ed with
+	// JACK_THUNK_SEALED once they are no longer discarded.
+	_Atomic int64_t skipped;
+} JackThunk;
+
+#define JACK_THUNK_SEALED ((int64_t)1 << 62)
+
+// jackOpenClient opens a client without starting a server, as cgo can't
+// call the variadic jack_client_open.
+jack_client_t * jackOpenClient(const char *name, jack_status_t *st);
+
+JackThunk * newJackThunk(jack_client_t *client, Cb *cb, int mode, int nc, int bufSz);
+int jackThunkRegister(JackThunk *t);
+const char * jackThunkPortName(JackThunk *t, int c);
+void jackThunkClose(JackThunk *t);
+jack_time_t jackThunkStart(JackThunk *t);
+int64_t jackThunkSeal(JackThunk *t);
+void freeJackThunk(JackThunk *t);
+
+// jackAudioType returns JACK_DEFAULT_AUDIO_TYPE.
+const char * jackAudioType(void);
+
+// events written by jackWatch.
+#define JACK_EV_REGISTER 1
+#define JACK_EV_CONNECT 2
+#define JACK_EV_SHUTDOWN 3
+
+typedef struct JackEvent {
+	int32_t kind;
+	int32_t yes; // registered or connected
+	uint32_t a;
+	uint32_t b;
+} JackEvent;
+
+// jackWatch arranges for port registration, connection and shutdown events
+// of client to be written as JackEvents to fd.  It must be called before
+// the client is activated.
+int jackWatch(jack_client_t *client, int fd);
+
+#endif
@@ -0,0 +1,96 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build linux
+// +build cgo
+// +build jack
+
+package linux
+
+import (
+	"testing"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// These tests need a running JACK server, such as
+//
+//	jackd -d dummy -r 48000 -p 256
+func jackTestEntry(t *testing.T) *jackEntry {
+	e := &jackEntry{}
+	if _, err := e.ScanDevices(); err != nil {
+		t.Skip(err)
+	}
+	return e
+}
+
+func TestJackDevices(t *testing.T) {
+	e := jackTestEntry(t)
+	in, out := e.DefaultInputDev(), e.DefaultOutputDev()
+	if in == nil || out == nil {
+		t.Fatalf("no physical ports, in %v out %v", in, out)
+	}
+	if !in.CanInput() || !out.CanOutput() {
+		t.Errorf("wrong direction, in %v out %v", in, out)
+	}
+}
+
+func TestJackPlayCapture(t *testing.T) {
+	e := jackTestEntry(t)
+	v := e.DefaultForm()
+	nc := make(chan *host.DevChange, 64)
+	if err := e.DevicesNotify(nc); err != nil {
+		t.Fatal(err)
+	}
+	defer e.DevicesNotifyClose(nc)
+
+	before := time.Now()
+	src, start, err := e.OpenSource(e.DefaultInputDev(), v, sample.SFloat32L, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := start.Sub(before); d < -time.Second || d > time.Second {
+		t.Errorf("capture started %s after opening", d)
+	}
+	d := make([]float64, 256*v.Channels())
+	for i := 0; i < 100; i++ {
+		if _, err := src.Receive(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src.Close()
+
+	snk, pstart, err := e.OpenSink(e.DefaultOutputDev(), sound.NewForm(v.SampleRate(), 1), sample.SFloat32L, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = d[:256]
+	for i := 0; i < 100; i++ {
+		if err := snk.Send(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pstart.IsZero() {
+		t.Errorf("zero playback start time")
+	}
+	snk.Close()
+
+	var reg, route int
+	tmr := time.After(time.Second)
+	for reg == 0 || route == 0 {
+		select {
+		case c := <-nc:
+			switch c.Sense {
+			case host.DeviceConnect:
+				reg++
+			case host.DeviceRouteChange:
+				route++
+			}
+		case <-tmr:
+			t.Fatalf("got %d registrations, %d connections", reg, route)
+		}
+	}
+}
@@ -36,10 +36,11 @@ type alsaPcm struct {
 	swParams   *C.snd_pcm_sw_params_t
 	perBuf     *C.char
 	start      time.Time
-	pkts       [3]libsio.Packet
+	pkts       [3]libsio.RawPacket
+	pktMem     [3]*byte // memory of pkts, which played packets must use
 	pi         int
 	doneC      chan struct{}
-	pktC       [2]chan *libsio.Packet
+	pktC       [2]chan *libsio.RawPacket
 	once       sync.Once
 	periodSize C.ulong
 	periods    int
@@ -48,15 +49,15 @@ type alsaPcm struct {
 func newAlsaPcmIn(name string, v sound.Form, sc sample.Codec, nf int) *alsaPcm {
 	res := newAlsaPcm(name, v, sc, nf)
 	res.dir = C.SND_PCM_STREAM_CAPTURE
-	res.pktC[0] = make(chan *libsio.Packet, 1)
+	res.pktC[0] = make(chan *libsio.RawPacket, 1)
 	return res
 }
 
 func newAlsaPcmOut(name string, v sound.Form, sc sample.Codec, nf int) *alsaPcm {
 	res := newAlsaPcm(name, v, sc, nf)
 	res.dir = C.SND_PCM_STREAM_PLAYBACK
-	res.pktC[0] = make(chan *libsio.Packet, 1)
-	res.pktC[1] = make(chan *libsio.Packet)
+	res.pktC[0] = make(chan *libsio.RawPacket, 1)
+	res.pktC[1] = make(chan *libsio.RawPacket)
 	return res
 }
 
@@ -120,7 +121,11 @@ func (dev *alsaPcm) open() error {
 }
 
 func (dev *alsaPcm) bufFrames() int {
-	return len(dev.pkts[0].D) / dev.Channels()
+	return len(dev.pkts[0].D) / dev.bytesPerFrame()
+}
+
+func (dev *alsaPcm) bytesPerFrame() int {
+	return dev.Channels() * dev.codec.Bytes()
 }
 
 func (dev *alsaPcm) chooseBufPeriods(nF int) error {
@@ -153,9 +158,10 @@ func (dev *alsaPcm) chooseBufPeriods(nF int) error {
 			per, sndStrerror(ret))
 	}
 	dev.periodSize = per
-	ns := int(per) * dev.Channels()
+	ns := int(per) * dev.bytesPerFrame()
 	for i := 0; i < 3; i++ {
-		dev.pkts[i].D = make([]float64, ns)
+		dev.pkts[i].D = make([]byte, ns)
+		dev.pktMem[i] = &dev.pkts[i].D[0]
 	}
 
 	bufMin := C.snd_pcm_uframes_t(0)
@@ -184,19 +190,22 @@ func (dev *alsaPcm) chooseBufPeriods(nF int) error {
 }
 
 func (dev *alsaPcm) serveCapture() {
+	libsio.ServeRT("alsa capture " + dev.name)
 	N := 0
-	codec := dev.codec
-	bytesPerFrame := C.long(dev.Channels() * codec.Bytes())
+	bytesPerFrame := dev.bytesPerFrame()
 	pi := 0
 	start := time.Now()
 	for i := range dev.pkts {
 		dev.pkts[i].Start = start
 	}
 	defer dev.pcmClose()
-	buf := unsafe.Pointer(dev.perBuf)
 
 	for {
-		nf := C.snd_pcm_readi(dev.pcm, buf, dev.periodSize)
+		// read directly into the packet, decoding if any happens in
+		// the client goroutine.  see libsio.RawInputSource.
+		pkt := &dev.pkts[pi]
+		pkt.D = pkt.D[:cap(pkt.D)]
+		nf := C.snd_pcm_readi(dev.pcm, unsafe.Pointer(&pkt.D[0]), dev.periodSize)
 		switch nf {
 		case -C.EPIPE:
 			log.Printf("alsa: overrun")
@@ -212,10 +221,7 @@ func (dev *alsaPcm) serveCapture() {
 		case 0:
 			return
 		}
-		pkt := &dev.pkts[pi]
-		slice := (*[1 << 30]byte)(buf)[:nf*bytesPerFrame]
-		pkt.D = pkt.D[:int(nf)*dev.Channels()]
-		codec.Decode(pkt.D, slice)
+		pkt.D = pkt.D[:int(nf)*bytesPerFrame]
 		pkt.N = N
 		N += int(nf)
 		select {
@@ -231,11 +237,9 @@ func (dev *alsaPcm) serveCapture() {
 }
 
 func (dev *alsaPcm) servePlay() {
+	libsio.ServeRT("alsa playback " + dev.name)
 	defer dev.pcmClose()
-	buf := unsafe.Pointer(dev.perBuf)
-	nC := dev.Channels()
-	bytesPerFrame := dev.codec.Bytes() * dev.Channels()
-	codec := dev.codec
+	bytesPerFrame := dev.bytesPerFrame()
 	start := time.Now()
 	for i := range dev.pkts {
 		dev.pkts[i].Start = start
@@ -243,17 +247,18 @@ func (dev *alsaPcm) servePlay() {
 	dev.initPerBuf()
 	// prime the device loop
 	for i := 0; i < dev.periods; i++ {
-		if err := dev.writei(dev.periodSize); err != nil {
+		if err := dev.writei(unsafe.Pointer(dev.perBuf), dev.periodSize); err != nil {
 			log.Printf("error: %s\n", err)
 			return
 		}
 	}
-	var pkt *libsio.Packet
+	var pkt *libsio.RawPacket
 	var ok bool
 	pi := 0
 	N := 0
 	for {
 		pkt = &dev.pkts[pi]
+		pkt.D = pkt.D[:cap(pkt.D)]
 		pkt.N = N
 		select {
 		case <-dev.doneC:
@@ -270,7 +275,7 @@ func (dev *alsaPcm) servePlay() {
 			}
 		}
 		// check memory reqs respected
-		if &pkt.D[0] != &dev.pkts[pi].D[0] {
+		if len(pkt.D) != 0 && &pkt.D[0] != dev.pktMem[pi] {
 			panic("must use packet memory")
 		}
 		pi++
@@ -290,14 +295,15 @@ func (dev *alsaPcm) servePlay() {
 			}
 			N = pkt.N
 		}
-		nF := len(pkt.D) / nC
-		slice := (*[1 << 30]byte)(buf)[:nF*bytesPerFrame]
-		codec.Encode(slice, pkt.D)
-		if err := dev.writei(dev.periodSize); err != nil {
+		nF := len(pkt.D) / bytesPerFrame
+		if nF == 0 {
+			continue
+		}
+		if err := dev.writei(unsafe.Pointer(&pkt.D[0]), C.ulong(nF)); err != nil {
 			log.Printf("error: %s\n", err)
 			return
 		}
-		N += int(dev.periodSize)
+		N += nF
 	}
 }
 
@@ -309,15 +315,16 @@ func (dev *alsaPcm) initPerBuf() {
 	}
 }
 
+// writeSilence writes n frames of silence from perBuf, which is zeroed by
+// servePlay and never written.
 func (dev *alsaPcm) writeSilence(n C.ulong) error {
-	dev.initPerBuf()
 	var i C.ulong
 	for i < n {
 		m := n - i
 		if m > dev.periodSize {
 			m = dev.periodSize
 		}
-		if err := dev.writei(m); err != nil {
+		if err := dev.writei(unsafe.Pointer(dev.perBuf), m); err != nil {
 			return err
 		}
 		i += m
@@ -325,9 +332,9 @@ func (dev *alsaPcm) writeSilence(n C.ulong) error {
 	return nil
 }
 
-func (dev *alsaPcm) writei(wf C.ulong) error {
+func (dev *alsaPcm) writei(buf unsafe.Pointer, wf C.ulong) error {
 wi:
-	nf := C.snd_pcm_writei(dev.pcm, unsafe.Pointer(dev.perBuf), wf)
+	nf := C.snd_pcm_writei(dev.pcm, buf, wf)
 	switch nf {
 	case -C.EPIPE:
 		log.Printf("alsa: underrun")
@@ -344,15 +351,19 @@ wi:
 	return nil
 }
 
-func (dev *alsaPcm) C() <-chan *libsio.Packet {
+func (dev *alsaPcm) Codec() sample.Codec {
+	return dev.codec
+}
+
+func (dev *alsaPcm) RawC() <-chan *libsio.RawPacket {
 	return dev.pktC[0]
 }
 
-func (dev *alsaPcm) FillC() <-chan *libsio.Packet {
+func (dev *alsaPcm) RawFillC() <-chan *libsio.RawPacket {
 	return dev.pktC[0]
 }
 
-func (dev *alsaPcm) PlayC() chan<- *libsio.Packet {
+func (dev *alsaPcm) RawPlayC() chan<- *libsio.RawPacket {
 	return dev.pktC[1]
 }
 
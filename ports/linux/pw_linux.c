@@ -0,0 +1,453 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build cgo
+// +build pipewire
+
+#include <errno.h>
+#include <pthread.h>
+#include <stdatomic.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <time.h>
+#include <unistd.h>
+
+#include <pipewire/pipewire.h>
+#include <spa/param/audio/format-utils.h>
+#include <spa/utils/result.h>
+
+#include "pw_linux.h"
+
+// seconds to wait for the server to set up a stream.
+#define PW_SIO_TIMEOUT 5
+
+static pthread_once_t initOnce = PTHREAD_ONCE_INIT;
+
+static void initPw(void) {
+	pw_init(NULL, NULL);
+}
+
+// conn holds a connection to the server with its own loop thread.
+typedef struct conn {
+	struct pw_thread_loop *loop;
+	struct pw_context *ctx;
+	struct pw_core *core;
+} conn;
+
+static int connOpen(conn *c, const char *name) {
+	pthread_once(&initOnce, initPw);
+	c->loop = pw_thread_loop_new(name, NULL);
+	if (c->loop == NULL) {
+		return -1;
+	}
+	c->ctx = pw_context_new(pw_thread_loop_get_loop(c->loop), NULL, 0);
+	if (c->ctx == NULL) {
+		return -1;
+	}
+	if (pw_thread_loop_start(c->loop) != 0) {
+		return -1;
+	}
+	pw_thread_loop_lock(c->loop);
+	c->core = pw_context_connect(c->ctx, NULL, 0);
+	pw_thread_loop_unlock(c->loop);
+	return c->core == NULL ? -1 : 0;
+}
+
+// connClose must be called after the objects using c->core are destroyed.
+static void connClose(conn *c) {
+	if (c->loop == NULL) {
+		return;
+	}
+	if (c->core != NULL) {
+		pw_thread_loop_lock(c->loop);
+		pw_core_disconnect(c->core);
+		pw_thread_loop_unlock(c->loop);
+	}
+	pw_thread_loop_stop(c->loop);
+	if (c->ctx != NULL) {
+		pw_context_destroy(c->ctx);
+	}
+	pw_thread_loop_destroy(c->loop);
+}
+
+struct PwStream {
+	conn c;
+	struct pw_stream *stream;
+	struct spa_hook listener;
+	Cb *cb;
+	int capture;
+	int bpf;   // bytes per frame
+	int bufSz; // frames
+	int rate;
+	_Atomic int closing;
+	_Atomic int64_t start; // see pwStreamStartTime
+	enum pw_stream_state state;
+	char err[128];
+};
+
+static void onStreamState(void *data, enum pw_stream_state old,
+		enum pw_stream_state state, const char *error) {
+	PwStream *s = (PwStream *) data;
+	s->state = state;
+	if (state == PW_STREAM_STATE_ERROR && error != NULL) {
+		snprintf(s->err, sizeof(s->err), "%s", error);
+	}
+	pw_thread_loop_signal(s->c.loop, false);
+}
+
+int64_t pwNow(void) {
+	struct timespec ts;
+	clock_gettime(CLOCK_MONOTONIC, &ts);
+	return (int64_t) ts.tv_sec * 1000000000 + ts.tv_nsec;
+}
+
+// setStart records the time of the first frame of s on its first cycle,
+// which exchanges nF frames.  Captured data is that of the last cycle,
+// played data is delayed by the graph.
+static void setStart(PwStream *s, int nF) {
+	if (atomic_load(&s->start) != 0) {
+		return;
+	}
+	struct pw_time t;
+	memset(&t, 0, sizeof(t));
+#if PW_CHECK_VERSION(0, 3, 50)
+	pw_stream_get_time_n(s->stream, &t, sizeof(t));
+#else
+	pw_stream_get_time(s->stream, &t);
+#endif
+	int64_t now = t.now;
+	if (now == 0) {
+		now = pwNow();
+	}
+	if (s->capture) {
+		now -= (int64_t) nF * 1000000000 / s->rate;
+	} else if (t.delay > 0 && t.rate.denom != 0) {
+		now += t.delay * 1000000000 * t.rate.num / t.rate.denom;
+	}
+	atomic_store(&s->start, now);
+}
+
+// onProcess runs on the realtime data thread.
+static void onProcess(void *data) {
+	PwStream *s = (PwStream *) data;
+	struct pw_buffer *pb = pw_stream_dequeue_buffer(s->stream);
+	if (pb == NULL) {
+		return;
+	}
+	struct spa_data *d = &pb->buffer->datas[0];
+	if (d->data == NULL) {
+		pw_stream_queue_buffer(s->stream, pb);
+		return;
+	}
+	int closing = atomic_load(&s->closing);
+	Cb *cb = s->cb;
+	int bpf = s->bpf;
+	int o = 0, n;
+	if (s->capture) {
+		uint32_t off = SPA_MIN(d->chunk->offset, d->maxsize);
+		uint32_t size = SPA_MIN(d->chunk->size, d->maxsize - off);
+		uint8_t *p = SPA_PTROFF(d->data, off, uint8_t);
+		int nF = (int)(size / bpf);
+		if (!closing) {
+			setStart(s, nF);
+		}
+		while (!closing && o < nF) {
+			n = SPA_MIN(nF - o, s->bufSz);
+			cb->inCb(cb, p + o*bpf, n);
+			o += n;
+		}
+	} else {
+		uint8_t *p = (uint8_t *) d->data;
+		int nF = (int)(d->maxsize / bpf);
+#if PW_CHECK_VERSION(0, 3, 49)
+		if (pb->requested != 0 && (int)pb->requested < nF) {
+			nF = (int)pb->requested;
+		}
+#endif
+		if (!closing) {
+			setStart(s, nF);
+		}
+		while (!closing && o < nF) {
+			n = SPA_MIN(nF - o, s->bufSz);
+			int m = n;
+			cb->outCb(cb, p + o*bpf, &m);
+			o += m;
+			if (m < n) {
+				// end of stream.
+				break;
+			}
+		}
+		memset(p + o*bpf, 0, (nF - o)*bpf);
+		d->chunk->offset = 0;
+		d->chunk->stride = bpf;
+		d->chunk->size = nF * bpf;
+	}
+	pw_stream_queue_buffer(s->stream, pb);
+}
+
+static const struct pw_stream_events streamEvents = {
+	PW_VERSION_STREAM_EVENTS,
+	.state_changed = onStreamState,
+	.process = onProcess,
+};
+
+static int spaFormat(int bytes, int isFloat, int be) {
+	if (isFloat) {
+		switch (bytes) {
+		case 4: return be ? SPA_AUDIO_FORMAT_F32_BE : SPA_AUDIO_FORMAT_F32_LE;
+		case 8: return be ? SPA_AUDIO_FORMAT_F64_BE : SPA_AUDIO_FORMAT_F64_LE;
+		}
+		return SPA_AUDIO_FORMAT_UNKNOWN;
+	}
+	switch (bytes) {
+	case 1: return SPA_AUDIO_FORMAT_S8;
+	case 2: return be ? SPA_AUDIO_FORMAT_S16_BE : SPA_AUDIO_FORMAT_S16_LE;
+	case 3: return be ? SPA_AUDIO_FORMAT_S24_BE : SPA_AUDIO_FORMAT_S24_LE;
+	case 4: return be ? SPA_AUDIO_FORMAT_S32_BE : SPA_AUDIO_FORMAT_S32_LE;
+	}
+	return SPA_AUDIO_FORMAT_UNKNOWN;
+}
+
+PwStream * pwStreamNew(Cb *cb, int capture, uint32_t target,
+		int bytes, int isFloat, int bigEndian, int nc, int rate, int quantum,
+		char *err, int errLen) {
+	PwStream *s = (PwStream *) calloc(1, sizeof(PwStream));
+	if (s == NULL) {
+		snprintf(err, errLen, "out of memory");
+		return NULL;
+	}
+	s->cb = cb;
+	s->capture = capture;
+	s->bpf = bytes * nc;
+	s->bufSz = quantum;
+	s->rate = rate;
+	atomic_store(&s->closing, 0);
+	atomic_store(&s->start, 0);
+	struct spa_audio_info_raw info;
+	memset(&info, 0, sizeof(info));
+	info.format = spaFormat(bytes, isFloat, bigEndian);
+	info.channels = nc;
+	info.rate = rate;
+	if (info.format == SPA_AUDIO_FORMAT_UNKNOWN) {
+		snprintf(err, errLen, "unsupported sample format");
+		free(s);
+		return NULL;
+	}
+	switch (nc) {
+	case 1:
+		info.position[0] = SPA_AUDIO_CHANNEL_MONO;
+		break;
+	case 2:
+		info.position[0] = SPA_AUDIO_CHANNEL_FL;
+		info.position[1] = SPA_AUDIO_CHANNEL_FR;
+		break;
+	default:
+		info.flags = SPA_AUDIO_FLAG_UNPOSITIONED;
+	}
+	if (connOpen(&s->c, "sio") != 0) {
+		snprintf(err, errLen, "unable to connect to PipeWire");
+		pwStreamFree(s);
+		return NULL;
+	}
+	pw_thread_loop_lock(s->c.loop);
+	struct pw_properties *props = pw_properties_new(
+			PW_KEY_MEDIA_TYPE, "Audio",
+			PW_KEY_MEDIA_CATEGORY, capture ? "Capture" : "Playback",
+			NULL);
+	pw_properties_setf(props, PW_KEY_NODE_LATENCY, "%d/%d", quantum, rate);
+	pw_properties_setf(props, PW_KEY_NODE_RATE, "1/%d", rate);
+	uint32_t connTarget = target;
+#ifdef PW_KEY_TARGET_OBJECT
+	if (target != PW_ID_ANY) {
+		pw_properties_setf(props, PW_KEY_TARGET_OBJECT, "%u", target);
+	}
+	connTarget = PW_ID_ANY;
+#endif
+	s->stream = pw_stream_new(s->c.core, "sio", props);
+	if (s->stream == NULL) {
+		pw_thread_loop_unlock(s->c.loop);
+		snprintf(err, errLen, "unable to create stream");
+		pwStreamFree(s);
+		return NULL;
+	}
+	pw_stream_add_listener(s->stream, &s->listener, &streamEvents, s);
+
+	uint8_t buf[1024];
+	struct spa_pod_builder b = SPA_POD_BUILDER_INIT(buf, sizeof(buf));
+	const struct spa_pod *params[1];
+	params[0] = spa_format_audio_raw_build(&b, SPA_PARAM_EnumFormat, &info);
+	int ret = pw_stream_connect(s->stream,
+			capture ? PW_DIRECTION_INPUT : PW_DIRECTION_OUTPUT,
+			connTarget,
+			PW_STREAM_FLAG_AUTOCONNECT |
+			PW_STREAM_FLAG_MAP_BUFFERS |
+			PW_STREAM_FLAG_RT_PROCESS |
+			PW_STREAM_FLAG_INACTIVE,
+			params, 1);
+	// wait until the format is negotiated.
+	while (ret >= 0 && s->state != PW_STREAM_STATE_PAUSED &&
+			s->state != PW_STREAM_STATE_STREAMING &&
+			s->state != PW_STREAM_STATE_ERROR) {
+		if (pw_thread_loop_timed_wait(s->c.loop, PW_SIO_TIMEOUT) != 0) {
+			ret = -ETIMEDOUT;
+		}
+	}
+	pw_thread_loop_unlock(s->c.loop);
+	if (ret < 0 || s->state == PW_STREAM_STATE_ERROR) {
+		if (s->err[0] != 0) {
+			snprintf(err, errLen, "%s", s->err);
+		} else {
+			snprintf(err, errLen, "unable to connect stream: %s", spa_strerror(ret));
+		}
+		pwStreamFree(s);
+		return NULL;
+	}
+	return s;
+}
+
+int pwStreamStart(PwStream *s) {
+	pw_thread_loop_lock(s->c.loop);
+	int ret = pw_stream_set_active(s->stream, true);
+	pw_thread_loop_unlock(s->c.loop);
+	return ret;
+}
+
+int64_t pwStreamStartTime(PwStream *s) {
+	return atomic_load(&s->start);
+}
+
+void pwStreamFree(PwStream *s) {
+	atomic_store(&s->closing, 1);
+	if (s->stream != NULL) {
+		pw_thread_loop_lock(s->c.loop);
+		pw_stream_destroy(s->stream);
+		pw_thread_loop_unlock(s->c.loop);
+	}
+	connClose(&s->c);
+	free(s);
+}
+
+struct PwGraph {
+	conn c;
+	struct pw_registry *reg;
+	struct spa_hook coreListener;
+	struct spa_hook regListener;
+	int fd;
+	int seq;
+};
+
+static void writeEv(PwGraph *g, PwEvent *ev) {
+	if (write(g->fd, ev, sizeof(PwEvent)) != sizeof(PwEvent)) {
+		fprintf(stderr, "pipewire: lost event %d\n", ev->kind);
+	}
+}
+
+static void onGlobal(void *data, uint32_t id, uint32_t perms,
+		const char *type, uint32_t version, const struct spa_dict *props) {
+	PwGraph *g = (PwGraph *) data;
+	if (props == NULL || strcmp(type, PW_TYPE_INTERFACE_Node) != 0) {
+		return;
+	}
+	const char *cls = spa_dict_lookup(props, PW_KEY_MEDIA_CLASS);
+	if (cls == NULL || strncmp(cls, "Audio/", 6) != 0) {
+		return;
+	}
+	PwEvent ev;
+	memset(&ev, 0, sizeof(ev));
+	ev.kind = PW_EV_ADD;
+	ev.id = id;
+	snprintf(ev.cls, sizeof(ev.cls), "%s", cls);
+	const char *s = spa_dict_lookup(props, PW_KEY_NODE_NAME);
+	if (s != NULL) {
+		snprintf(ev.name, sizeof(ev.name), "%s", s);
+	}
+	s = spa_dict_lookup(props, PW_KEY_NODE_DESCRIPTION);
+	if (s != NULL) {
+		snprintf(ev.desc, sizeof(ev.desc), "%s", s);
+	}
+	s = spa_dict_lookup(props, PW_KEY_AUDIO_CHANNELS);
+	if (s != NULL) {
+		ev.channels = atoi(s);
+	}
+	writeEv(g, &ev);
+}
+
+static void onGlobalRemove(void *data, uint32_t id) {
+	PwEvent ev;
+	memset(&ev, 0, sizeof(ev));
+	ev.kind = PW_EV_REMOVE;
+	ev.id = id;
+	writeEv((PwGraph *) data, &ev);
+}
+
+static const struct pw_registry_events regEvents = {
+	PW_VERSION_REGISTRY_EVENTS,
+	.global = onGlobal,
+	.global_remove = onGlobalRemove,
+};
+
+static void onCoreDone(void *data, uint32_t id, int seq) {
+	PwGraph *g = (PwGraph *) data;
+	if (id != PW_ID_CORE || seq != g->seq) {
+		return;
+	}
+	PwEvent ev;
+	memset(&ev, 0, sizeof(ev));
+	ev.kind = PW_EV_DONE;
+	writeEv(g, &ev);
+}
+
+static void onCoreError(void *data, uint32_t id, int seq, int res, const char *msg) {
+	if (id != PW_ID_CORE || res != -EPIPE) {
+		return;
+	}
+	PwEvent ev;
+	memset(&ev, 0, sizeof(ev));
+	ev.kind = PW_EV_SHUTDOWN;
+	writeEv((PwGraph *) data, &ev);
+}
+
+static const struct pw_core_events coreEvents = {
+	PW_VERSION_CORE_EVENTS,
+	.done = onCoreDone,
+	.error = onCoreError,
+};
+
+PwGraph * pwGraphNew(int fd) {
+	PwGraph *g = (PwGraph *) calloc(1, sizeof(PwGraph));
+	if (g == NULL) {
+		return NULL;
+	}
+	g->fd = fd;
+	if (connOpen(&g->c, "sio-watch") != 0) {
+		pwGraphFree(g);
+		return NULL;
+	}
+	pw_thread_loop_lock(g->c.loop);
+	pw_core_add_listener(g->c.core, &g->coreListener, &coreEvents, g);
+	g->reg = pw_core_get_registry(g->c.core, PW_VERSION_REGISTRY, 0);
+	if (g->reg == NULL) {
+		pw_thread_loop_unlock(g->c.loop);
+		pwGraphFree(g);
+		return NULL;
+	}
+	pw_registry_add_listener(g->reg, &g->regListener, &regEvents, g);
+	g->seq = pw_core_sync(g->c.core, PW_ID_CORE, 0);
+	pw_thread_loop_unlock(g->c.loop);
+	return g;
+}
+
+void pwGraphFree(PwGraph *g) {
+	if (g->c.core != NULL) {
+		pw_thread_loop_lock(g->c.loop);
+		spa_hook_remove(&g->coreListener);
+		if (g->reg != NULL) {
+			spa_hook_remove(&g->regListener);
+			pw_proxy_destroy((struct pw_proxy *) g->reg);
+		}
+		pw_thread_loop_unlock(g->c.loop);
+	}
+	connClose(&g->c);
+	free(g);
+}
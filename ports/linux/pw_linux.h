@@ -0,0 +1,64 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+#ifndef ZC_SIO_PW_LINUX_H
+#define ZC_SIO_PW_LINUX_H
+
+#include <stdint.h>
+
+#include "../../libsio/cb.h"
+
+// the PipeWire headers are only included in pw_linux.c, the Go code only
+// uses the declarations below.
+
+#define PW_SIO_ID_ANY 0xffffffff
+
+// PwStream is a PipeWire stream whose process callback is bridged to a
+// libsio Cb.
+typedef struct PwStream PwStream;
+
+// pwStreamNew connects a stream for capture or playback to the node with id
+// target, or to the default node if target is PW_SIO_ID_ANY.  The stream is
+// inactive until pwStreamStart is called.  The sample format is given by the
+// number of bytes per sample, whether it is floating point, and whether it
+// is big endian.  quantum requests the number of frames per cycle.
+//
+// On failure, pwStreamNew returns 0 and places a message in err.
+PwStream * pwStreamNew(Cb *cb, int capture, uint32_t target,
+		int bytes, int isFloat, int bigEndian, int nc, int rate, int quantum,
+		char *err, int errLen);
+int pwStreamStart(PwStream *s);
+void pwStreamFree(PwStream *s);
+
+// pwStreamStartTime returns the time of the first frame of s, as given by
+// pwNow, or 0 before its first cycle.
+int64_t pwStreamStartTime(PwStream *s);
+
+// pwNow returns the time of CLOCK_MONOTONIC in nanoseconds.
+int64_t pwNow(void);
+
+// events written by PwGraph.
+#define PW_EV_ADD 1
+#define PW_EV_REMOVE 2
+#define PW_EV_DONE 3
+#define PW_EV_SHUTDOWN 4
+
+typedef struct PwEvent {
+	int32_t kind;
+	uint32_t id;
+	int32_t channels;  // 0 if unknown
+	char cls[32];      // media.class, such as Audio/Sink
+	char name[224];    // node.name
+	char desc[224];    // node.description
+} PwEvent;
+
+// PwGraph follows the nodes of a PipeWire graph.  It writes a PW_EV_ADD
+// event to fd for each audio node present, then PW_EV_DONE, then PW_EV_ADD
+// or PW_EV_REMOVE events as the graph changes.  Remove events are written
+// for all objects, not only nodes.
+typedef struct PwGraph PwGraph;
+
+PwGraph * pwGraphNew(int fd);
+void pwGraphFree(PwGraph *g);
+
+#endif
@@ -0,0 +1,74 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build linux
+// +build cgo
+// +build pipewire
+
+package linux
+
+import (
+	"testing"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sound/sample"
+)
+
+// These tests need a running PipeWire server.  One with only the dummy
+// driver is enough, such as started with a configuration whose
+// context.objects create a support.null-audio-sink node.
+func pwTestEntry(t *testing.T) *pwEntry {
+	e := &pwEntry{}
+	if _, err := e.ScanDevices(); err != nil {
+		t.Skip(err)
+	}
+	return e
+}
+
+func TestPwDevices(t *testing.T) {
+	e := pwTestEntry(t)
+	devs := e.Devices()
+	if len(devs) == 0 || devs[0] != pwDefaultDev {
+		t.Fatalf("expected default device first, got %v", devs)
+	}
+	for _, d := range devs[1:] {
+		if !d.CanInput() && !d.CanOutput() {
+			t.Errorf("device %v has no channels", d)
+		}
+	}
+	nc := make(chan *host.DevChange, 16)
+	if err := e.DevicesNotify(nc); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Devices()) != len(devs) {
+		t.Errorf("devices changed from %d to %d", len(devs), len(e.Devices()))
+	}
+	e.DevicesNotifyClose(nc)
+}
+
+func TestPwPlayCapture(t *testing.T) {
+	e := pwTestEntry(t)
+	v := e.DefaultForm()
+	snk, _, err := e.OpenSink(e.DefaultOutputDev(), v, sample.SFloat32L, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := make([]float64, 256*v.Channels())
+	for i := 0; i < 100; i++ {
+		if err := snk.Send(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snk.Close()
+
+	src, _, err := e.OpenSource(e.DefaultInputDev(), v, sample.SInt16L, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	for i := 0; i < 100; i++ {
+		if _, err := src.Receive(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
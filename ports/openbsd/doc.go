@@ -2,4 +2,10 @@
 // code is governed by a license that can be found in the License file.
 
 // Package openbsd zc sound/io entry points.
+//
+// The sound system of OpenBSD is sndio, whose entry is provided by the
+// portable package zikichombo.org/sio/sndio and is the default entry on
+// OpenBSD.
 package openbsd
+
+import _ "zikichombo.org/sio/sndio"
@@ -0,0 +1,21 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package remote
+
+import (
+	"testing"
+
+	"zikichombo.org/sio/host/hosttest"
+)
+
+func TestConformance(t *testing.T) {
+	t.Run("Local", func(t *testing.T) {
+		hosttest.Test(t, newTestEntry(), nil)
+	})
+	t.Run("Remote", func(t *testing.T) {
+		e, srv := serve(t, newTestEntry(), false, 0)
+		defer srv.Close()
+		hosttest.Test(t, e, nil)
+	})
+}
@@ -0,0 +1,29 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package remote provides access to the host.Entry of another host over
+// TCP.
+//
+// A Server exports a host.Entry, for example that of a workstation with
+// sound hardware.  An Entry connects to a Server and implements
+// host.Entry by proxying OpenSource, OpenSink, OpenDuplex, ScanDevices and
+// DevicesNotify to it, so that programs on hosts without sound hardware
+// can use the devices of the Server.
+//
+// Each stream uses its own TCP connection, and device scanning and
+// notifications use another, shared by the streams of an Entry.  The clock
+// offset between the hosts is estimated when an Entry connects, and start
+// times returned by the Entry are in the local clock.  Start times of sinks
+// account for the network delay until the Server starts playback.
+//
+// Network induced xruns are reported by the Source and Sink returned by
+// the Entry, see Stats.  A Server which can't send captured data as fast as
+// it is captured drops it, and the Source fills the gap with silence.  A
+// Server whose playback queue runs dry waits for data, and the Sink is told
+// of the xrun.
+//
+// Package remote registers the entry Default under the name "Remote" on
+// initialisation.  Its Addr must be set before use.
+//
+// Package remote is part of http://zikichombo.org
+package remote /* import "zikichombo.org/sio/remote" */
@@ -0,0 +1,595 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Entry is a host.Entry proxying the entry exported by a Server.
+//
+// An Entry connects to its Server when first used, and reconnects when
+// used after losing the connection.  Devices are then disconnected, and
+// those of the Server must be scanned again.  The defaults of an Entry
+// which can't connect are those of host.NullEntry.
+type Entry struct {
+	host.NullEntry
+
+	// Addr is the TCP address of the Server.
+	Addr string
+
+	// Timeout bounds connecting to the Server and waiting for its
+	// replies, 5s if 0.
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	ctl     *ctl
+	devs    []*libsio.Dev
+	byH     map[uint64]*libsio.Dev
+	hs      map[*libsio.Dev]uint64
+	defs    [3]uint64 // handles of the default input, output and duplex devices.
+	scanned bool
+	subs    host.Notifier
+}
+
+// Default is the Entry registered by package remote.  Its Addr must be
+// set before use.
+var Default = &Entry{}
+
+func init() {
+	if err := host.RegisterEntry(Default); err != nil {
+		log.Printf("zc failed load %s: %s\n", Default.Name(), err.Error())
+	}
+}
+
+var (
+	// ErrNoAddr is returned when using an Entry without Addr.
+	ErrNoAddr = errors.New("remote: no server address")
+
+	// ErrVersion is returned when connecting to a Server of another
+	// protocol version.
+	ErrVersion = errors.New("remote: server protocol version mismatch")
+
+	// ErrTimeout is returned when the Server doesn't reply in time.
+	ErrTimeout = errors.New("remote: server timeout")
+
+	// ErrLost is returned when the connection to the Server is lost.
+	ErrLost = errors.New("remote: connection lost")
+)
+
+func (e *Entry) timeout() time.Duration {
+	if e.Timeout == 0 {
+		return 5 * time.Second
+	}
+	return e.Timeout
+}
+
+// ctl is the control connection of an Entry.
+type ctl struct {
+	c       *conn
+	info    info
+	offset  time.Duration // server clock minus local clock.
+	delay   time.Duration // one way network delay.
+	timeout time.Duration
+	reqMu   sync.Mutex
+	replyC  chan reply
+	done    chan struct{}
+}
+
+type reply struct {
+	t byte
+	p []byte
+}
+
+// local converts a time of the server in ns since the epoch to local
+// time.
+func (k *ctl) local(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns).Add(-k.offset)
+}
+
+// sync estimates the clock offset and network delay from the ping with
+// the smallest round trip time of n pings.
+func (k *ctl) sync(n int) error {
+	var b [8]byte
+	best := time.Duration(-1)
+	for i := 0; i < n; i++ {
+		t0 := time.Now()
+		putInt64(b[:], t0.UnixNano())
+		if err := k.c.write(msgPing, b[:]); err != nil {
+			return err
+		}
+		t, p, err := k.c.read(nil)
+		if err != nil {
+			return err
+		}
+		rtt := time.Since(t0)
+		if t != msgPing || len(p) != 16 || getInt64(p) != t0.UnixNano() {
+			return ErrProtocol
+		}
+		if best < 0 || rtt < best {
+			best = rtt
+			srv := time.Unix(0, getInt64(p[8:]))
+			k.offset = srv.Sub(t0.Round(0).Add(rtt / 2))
+			k.delay = rtt / 2
+		}
+	}
+	return nil
+}
+
+// request sends a request and waits for the reply, which is of the same
+// type.  Requests are serialised.
+func (k *ctl) request(t byte, v, rv interface{}) error {
+	k.reqMu.Lock()
+	defer k.reqMu.Unlock()
+	if err := k.c.writeJSON(t, v); err != nil {
+		return err
+	}
+	tmr := time.NewTimer(k.timeout)
+	defer tmr.Stop()
+	select {
+	case r := <-k.replyC:
+		if r.t != t {
+			return ErrProtocol
+		}
+		return json.Unmarshal(r.p, rv)
+	case <-k.done:
+		return ErrLost
+	case <-tmr.C:
+		// a late reply would be taken for that of the next request.
+		k.c.Close()
+		return ErrTimeout
+	}
+}
+
+// conn returns the control connection, connecting if need be.
+func (e *Entry) conn() (*ctl, error) {
+	e.mu.Lock()
+	if e.ctl != nil {
+		defer e.mu.Unlock()
+		return e.ctl, nil
+	}
+	k, err := e.connect()
+	if err != nil {
+		e.mu.Unlock()
+		return nil, err
+	}
+	e.ctl = k
+	e.byH = make(map[uint64]*libsio.Dev)
+	e.hs = make(map[*libsio.Dev]uint64)
+	e.devs = nil
+	e.scanned = false
+	resub := e.subs.Len() > 0
+	e.mu.Unlock()
+	go e.serve(k)
+	if resub {
+		var a ack
+		k.request(msgNotify, &notifyReq{On: true}, &a)
+	}
+	return k, nil
+}
+
+func (e *Entry) connect() (*ctl, error) {
+	if e.Addr == "" {
+		return nil, ErrNoAddr
+	}
+	nc, err := net.DialTimeout("tcp", e.Addr, e.timeout())
+	if err != nil {
+		return nil, err
+	}
+	nc.SetDeadline(time.Now().Add(e.timeout()))
+	k := &ctl{
+		c:       newConn(nc),
+		timeout: e.timeout(),
+		replyC:  make(chan reply, 1),
+		done:    make(chan struct{})}
+	err = k.c.writeJSON(msgHello, &hello{Version: protoVersion})
+	if err == nil {
+		err = k.c.readJSON(msgHello, &k.info)
+	}
+	if err == nil && k.info.Version != protoVersion {
+		err = ErrVersion
+	}
+	if err == nil {
+		err = k.sync(5)
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc.SetDeadline(time.Time{})
+	return k, nil
+}
+
+// serve reads messages of the control connection k until it fails.
+func (e *Entry) serve(k *ctl) {
+	defer e.lost(k)
+	defer close(k.done)
+	for {
+		t, p, err := k.c.read(nil)
+		if err != nil {
+			return
+		}
+		if t == msgDevChange {
+			var chg devChange
+			if json.Unmarshal(p, &chg) == nil {
+				e.devChange(k, &chg)
+			}
+			continue
+		}
+		select {
+		case k.replyC <- reply{t: t, p: p}:
+		default:
+			// no request is waiting.
+			k.c.Close()
+			return
+		}
+	}
+}
+
+// lost disconnects the devices of the control connection k.
+func (e *Entry) lost(k *ctl) {
+	k.c.Close()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.ctl != k {
+		return
+	}
+	e.ctl = nil
+	for _, d := range e.devs {
+		e.subs.Notify(&host.DevChange{Sense: host.DeviceDisconnect, Dev: d})
+	}
+	e.devs = nil
+	e.scanned = false
+}
+
+func (e *Entry) devChange(k *ctl, chg *devChange) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.ctl != k {
+		return
+	}
+	d := e.known(&chg.Dev)
+	sense := host.DevChangeSense(chg.Sense)
+	for i, o := range e.devs {
+		if o == d {
+			if sense == host.DeviceDisconnect {
+				e.devs = append(e.devs[:i], e.devs[i+1:]...)
+			}
+			d = nil
+			break
+		}
+	}
+	if d != nil && sense == host.DeviceConnect {
+		e.devs = append(e.devs, d)
+	}
+	// defaults may have changed.
+	e.scanned = false
+	e.subs.Notify(&host.DevChange{Sense: sense, Dev: e.byH[chg.Dev.H]})
+}
+
+// known must be called with e.mu held.  It returns the device of w,
+// creating it if w is new.
+func (e *Entry) known(w *wireDev) *libsio.Dev {
+	if d, ok := e.byH[w.H]; ok {
+		return d
+	}
+	d := w.dev()
+	e.byH[w.H] = d
+	e.hs[d] = w.H
+	return d
+}
+
+// request sends a request on the control connection.
+func (e *Entry) request(t byte, v, rv interface{}) (*ctl, error) {
+	k, err := e.conn()
+	if err != nil {
+		return nil, err
+	}
+	return k, k.request(t, v, rv)
+}
+
+func (e *Entry) info() *info {
+	k, err := e.conn()
+	if err != nil {
+		return nil
+	}
+	return &k.info
+}
+
+// RemoteName returns the name of the entry exported by the Server.
+func (e *Entry) RemoteName() (string, error) {
+	k, err := e.conn()
+	if err != nil {
+		return "", err
+	}
+	return k.info.Name, nil
+}
+
+// Delay returns the estimated one way network delay to the Server.
+func (e *Entry) Delay() (time.Duration, error) {
+	k, err := e.conn()
+	if err != nil {
+		return 0, err
+	}
+	return k.delay, nil
+}
+
+// Offset returns the estimated offset of the clock of the Server relative
+// to the local clock.
+func (e *Entry) Offset() (time.Duration, error) {
+	k, err := e.conn()
+	if err != nil {
+		return 0, err
+	}
+	return k.offset, nil
+}
+
+func (e *Entry) Name() string {
+	return "Remote"
+}
+
+func (e *Entry) DefaultForm() sound.Form {
+	if i := e.info(); i != nil {
+		return sound.NewForm(freq.T(i.Rate), i.Channels)
+	}
+	return e.NullEntry.DefaultForm()
+}
+
+func (e *Entry) DefaultSampleCodec() sample.Codec {
+	if i := e.info(); i != nil {
+		if co, err := codecByName(i.Codec); err == nil {
+			return co
+		}
+	}
+	return e.NullEntry.DefaultSampleCodec()
+}
+
+func (e *Entry) DefaultBufSize() int {
+	if i := e.info(); i != nil {
+		return i.BufSize
+	}
+	return e.NullEntry.DefaultBufSize()
+}
+
+func (e *Entry) CanOpenSource() bool {
+	i := e.info()
+	return i != nil && i.Source
+}
+
+// OpenSource opens a source of the entry of the Server.  The returned
+// sound.Source is a *Source and the start time is in the local clock.
+func (e *Entry) OpenSource(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Source, time.Time, error) {
+	var t time.Time
+	c, _, start, err := e.open(kindSource, d, v.Channels(), 0, v, co, b)
+	if err != nil {
+		return nil, t, err
+	}
+	return newSource(c, v, co, b, start), start, nil
+}
+
+func (e *Entry) CanOpenSink() bool {
+	i := e.info()
+	return i != nil && i.Sink
+}
+
+// OpenSink opens a sink of the entry of the Server.  The returned
+// sound.Sink is a *Sink.  The start time is estimated on the first Send
+// from the network delay, and set to the start time reported by the Server
+// on a later Send.
+func (e *Entry) OpenSink(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Sink, *time.Time, error) {
+	c, k, _, err := e.open(kindSink, d, 0, v.Channels(), v, co, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := newSink(c, k, v, co, b)
+	return s, &s.start, nil
+}
+
+func (e *Entry) CanOpenDuplex() bool {
+	i := e.info()
+	return i != nil && i.Duplex
+}
+
+// OpenDuplex opens a duplex stream of the entry of the Server.  Each call
+// to SendReceive of the returned sound.Duplex is a round trip to the
+// Server, so the buffer size should cover the network delay.
+func (e *Entry) OpenDuplex(d *libsio.Dev, iv, ov sound.Form, co sample.Codec, b int) (sound.Duplex, time.Time, *time.Time, error) {
+	var t time.Time
+	if iv.SampleRate() != ov.SampleRate() {
+		return nil, t, nil, errors.New("remote: duplex forms must have the same sample rate")
+	}
+	c, k, start, err := e.open(kindDuplex, d, iv.Channels(), ov.Channels(), ov, co, b)
+	if err != nil {
+		return nil, t, nil, err
+	}
+	x := newDuplex(c, k, iv, ov, co, b)
+	return x, start, &x.pstart, nil
+}
+
+func (e *Entry) ctlOf() *ctl {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.ctl
+}
+
+// open opens a stream connection and returns it with the control
+// connection and the start time of the stream in the local clock.
+func (e *Entry) open(kind int, d *libsio.Dev, nI, nO int, v sound.Form, co sample.Codec, b int) (*conn, *ctl, time.Time, error) {
+	var t time.Time
+	if b <= 0 {
+		return nil, nil, t, errors.New("remote: bad buffer size")
+	}
+	k, err := e.conn()
+	if err != nil {
+		return nil, nil, t, err
+	}
+	h, err := e.handle(d)
+	if err != nil {
+		return nil, nil, t, err
+	}
+	nc, err := net.DialTimeout("tcp", e.Addr, e.timeout())
+	if err != nil {
+		return nil, nil, t, err
+	}
+	c := newConn(nc)
+	nc.SetDeadline(time.Now().Add(e.timeout()))
+	req := &openReq{
+		Kind:    kind,
+		Dev:     h,
+		In:      nI,
+		Out:     nO,
+		Rate:    int64(v.SampleRate()),
+		Codec:   co.String(),
+		BufSize: b}
+	var r openReply
+	err = c.writeJSON(msgOpen, req)
+	if err == nil {
+		err = c.readJSON(msgOpen, &r)
+	}
+	if err == nil {
+		err = peerErr(r.Err)
+	}
+	if err != nil {
+		nc.Close()
+		return nil, nil, t, err
+	}
+	nc.SetDeadline(time.Time{})
+	return c, k, k.local(r.Start), nil
+}
+
+// handle returns the handle of d, 0 for nil.
+func (e *Entry) handle(d *libsio.Dev) (uint64, error) {
+	if d == nil {
+		return 0, nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	h, ok := e.hs[d]
+	if !ok {
+		return 0, ErrUnknownDev
+	}
+	return h, nil
+}
+
+func (e *Entry) HasDevices() bool {
+	i := e.info()
+	return i != nil && i.Devices
+}
+
+// ScanDevices scans the devices of the entry of the Server.  Devices keep
+// their identity across scans.
+func (e *Entry) ScanDevices() ([]*host.DevScanResult, error) {
+	var r scanReply
+	k, err := e.request(msgScan, struct{}{}, &r)
+	if err != nil {
+		return nil, err
+	}
+	if err := peerErr(r.Err); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.ctl != k {
+		return nil, ErrLost
+	}
+	devs := make([]*libsio.Dev, len(r.Devs))
+	res := make([]*host.DevScanResult, 0, len(r.Devs)+len(r.Errs))
+	for i := range r.Devs {
+		devs[i] = e.known(&r.Devs[i])
+		res = append(res, &host.DevScanResult{Dev: devs[i]})
+	}
+	for _, s := range r.Errs {
+		res = append(res, &host.DevScanResult{E: peerErr(s)})
+	}
+	e.devs = devs
+	e.defs = [3]uint64{r.In, r.Out, r.Duplex}
+	e.scanned = true
+	return res, nil
+}
+
+func (e *Entry) Devices() []*libsio.Dev {
+	e.mu.Lock()
+	scanned := e.scanned
+	e.mu.Unlock()
+	if !scanned {
+		if _, err := e.ScanDevices(); err != nil {
+			return nil
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	res := make([]*libsio.Dev, len(e.devs))
+	copy(res, e.devs)
+	return res
+}
+
+func (e *Entry) defaultDev(i int) *libsio.Dev {
+	if e.Devices() == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.byH[e.defs[i]]
+}
+
+func (e *Entry) DefaultInputDev() *libsio.Dev {
+	return e.defaultDev(0)
+}
+
+func (e *Entry) DefaultOutputDev() *libsio.Dev {
+	return e.defaultDev(1)
+}
+
+func (e *Entry) DefaultDuplexDev() *libsio.Dev {
+	return e.defaultDev(2)
+}
+
+// DevicesNotify forwards the device notifications of the entry of the
+// Server.  When the connection to the Server is lost, all devices are
+// reported disconnected.
+func (e *Entry) DevicesNotify(c chan<- *host.DevChange) error {
+	if e.subs.Add(c) != 1 {
+		return nil
+	}
+	var a ack
+	_, err := e.request(msgNotify, &notifyReq{On: true}, &a)
+	if err == nil {
+		err = peerErr(a.Err)
+	}
+	if err != nil {
+		e.unsubscribe(c)
+		return err
+	}
+	return nil
+}
+
+func (e *Entry) DevicesNotifyClose(c chan<- *host.DevChange) {
+	if !e.unsubscribe(c) {
+		return
+	}
+	if k := e.ctlOf(); k != nil {
+		var a ack
+		k.request(msgNotify, &notifyReq{}, &a)
+	}
+}
+
+// unsubscribe removes c and returns whether no subscribers remain.
+func (e *Entry) unsubscribe(c chan<- *host.DevChange) bool {
+	n, ok := e.subs.Remove(c)
+	return ok && n == 0
+}
@@ -0,0 +1,363 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package remote
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Messages are a type byte and a big endian uint32 payload length followed
+// by the payload.  Control payloads are JSON, data payloads are binary.
+const (
+	// control connection.
+	msgHello     = iota + 1 // client: hello; server: info
+	msgPing                 // client: 8 byte time; server: client time, server time
+	msgScan                 // client: empty; server: scanReply
+	msgNotify               // client: notifyReq; server: ack
+	msgDevChange            // server: devChange
+
+	// stream connections.
+	msgOpen  // client: openReq; server: openReply
+	msgData  // 8 byte frame number, channel-interleaved encoded samples
+	msgXrun  // server: 8 byte frame number, 8 byte frames lost
+	msgStart // server: 8 byte time of the first sample played
+	msgEnd   // client: drain and close a sink; server: ack
+)
+
+const (
+	protoVersion = 2
+	hdrSize      = 5
+	maxPayload   = 1 << 24
+)
+
+// stream kinds.
+const (
+	kindSource = iota + 1
+	kindSink
+	kindDuplex
+)
+
+// ErrProtocol is returned when a peer sends an unexpected message.
+var ErrProtocol = errors.New("remote: protocol error")
+
+type hello struct {
+	Version int
+}
+
+type info struct {
+	Version  int
+	Name     string
+	Channels int
+	Rate     int64
+	Codec    string
+	BufSize  int
+	Source   bool
+	Sink     bool
+	Duplex   bool
+	Devices  bool
+}
+
+// wireDev is a libsio.Dev identified by a handle of the server.
+type wireDev struct {
+	H       uint64
+	Id      uint64
+	UID     string
+	Name    string
+	In, Out wireCaps
+	DefIn   bool
+	DefOut  bool
+	DefSys  bool
+}
+
+// wireCaps is a libsio.Caps with rates in nanohertz and durations in ns.
+type wireCaps struct {
+	MinChannels, MaxChannels int
+	Layouts                  []libsio.Layout
+	Rates                    []int64
+	RateRanges               [][2]int64
+	Codecs                   []string
+	MinPeriod, MaxPeriod     int
+	MinLatency, MaxLatency   int64
+}
+
+// scanReply holds the devices of the server with the handles of the
+// default devices, 0 if none.
+type scanReply struct {
+	Devs   []wireDev
+	Errs   []string
+	In     uint64
+	Out    uint64
+	Duplex uint64
+	Err    string
+}
+
+type notifyReq struct {
+	On bool
+}
+
+type ack struct {
+	Err string
+}
+
+// end ends a source with the number of frames it produced, so that the
+// client sees data dropped at the end.
+type end struct {
+	Err    string
+	Frames int64
+}
+
+type devChange struct {
+	Sense int
+	Dev   wireDev
+}
+
+type openReq struct {
+	Kind    int
+	Dev     uint64
+	In      int
+	Out     int
+	Rate    int64
+	Codec   string
+	BufSize int
+}
+
+type openReply struct {
+	Err   string
+	Start int64
+}
+
+// conn frames messages on a net.Conn.  Writes may be concurrent, reads may
+// not.
+type conn struct {
+	net.Conn
+	r   *bufio.Reader
+	wmu sync.Mutex
+	w   *bufio.Writer
+	hdr [hdrSize]byte
+	rh  [hdrSize]byte
+}
+
+func newConn(c net.Conn) *conn {
+	if tc, ok := c.(*net.TCPConn); ok {
+		tc.SetNoDelay(true)
+	}
+	return &conn{Conn: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}
+}
+
+// write writes a message whose payload is the concatenation of ps.
+func (c *conn) write(t byte, ps ...[]byte) error {
+	n := 0
+	for _, p := range ps {
+		n += len(p)
+	}
+	if n > maxPayload {
+		return fmt.Errorf("remote: message of %d bytes too large", n)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.hdr[0] = t
+	binary.BigEndian.PutUint32(c.hdr[1:], uint32(n))
+	c.w.Write(c.hdr[:])
+	for _, p := range ps {
+		c.w.Write(p)
+	}
+	return c.w.Flush()
+}
+
+func (c *conn) writeJSON(t byte, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.write(t, b)
+}
+
+// read reads a message.  The payload is read into buf if it fits.
+func (c *conn) read(buf []byte) (byte, []byte, error) {
+	if _, err := io.ReadFull(c.r, c.rh[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(c.rh[1:])
+	if n > maxPayload {
+		return 0, nil, ErrProtocol
+	}
+	if int(n) > cap(buf) {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := io.ReadFull(c.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return c.rh[0], buf, nil
+}
+
+// readJSON reads a message of type t into v.
+func (c *conn) readJSON(t byte, v interface{}) error {
+	mt, p, err := c.read(nil)
+	if err != nil {
+		return err
+	}
+	if mt != t {
+		return ErrProtocol
+	}
+	return json.Unmarshal(p, v)
+}
+
+func putInt64(b []byte, v int64) {
+	binary.BigEndian.PutUint64(b, uint64(v))
+}
+
+func getInt64(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+// unixNano returns t in ns since the epoch, or 0 if t is zero.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// errString returns the text of err for the peer.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// peerErr returns the error whose text the peer sent.  Errors of package
+// host are returned as such.
+func peerErr(s string) error {
+	switch s {
+	case "":
+		return nil
+	case host.ErrUnsupported.Error():
+		return host.ErrUnsupported
+	}
+	return errors.New("remote: " + s)
+}
+
+func codecByName(name string) (sample.Codec, error) {
+	for _, co := range sample.Codecs {
+		if co.String() == name {
+			return co, nil
+		}
+	}
+	return sample.SInt16L, fmt.Errorf("remote: unknown sample codec %s", name)
+}
+
+func toWire(h uint64, d *libsio.Dev) wireDev {
+	return wireDev{
+		H:      h,
+		Id:     d.Id,
+		UID:    d.UID,
+		Name:   d.Name,
+		In:     toWireCaps(&d.In),
+		Out:    toWireCaps(&d.Out),
+		DefIn:  d.IsDefaultIn,
+		DefOut: d.IsDefaultOut,
+		DefSys: d.IsDefaultSys}
+}
+
+func toWireCaps(c *libsio.Caps) wireCaps {
+	w := wireCaps{
+		MinChannels: c.MinChannels,
+		MaxChannels: c.MaxChannels,
+		Layouts:     c.Layouts,
+		MinPeriod:   c.MinPeriod,
+		MaxPeriod:   c.MaxPeriod,
+		MinLatency:  int64(c.MinLatency),
+		MaxLatency:  int64(c.MaxLatency)}
+	for _, r := range c.Rates {
+		w.Rates = append(w.Rates, int64(r))
+	}
+	for _, rr := range c.RateRanges {
+		w.RateRanges = append(w.RateRanges, [2]int64{int64(rr.Min), int64(rr.Max)})
+	}
+	for _, co := range c.Codecs {
+		w.Codecs = append(w.Codecs, co.String())
+	}
+	return w
+}
+
+func (w *wireDev) dev() *libsio.Dev {
+	return &libsio.Dev{
+		Id:           w.Id,
+		UID:          w.UID,
+		Name:         w.Name,
+		In:           w.In.caps(),
+		Out:          w.Out.caps(),
+		IsDefaultIn:  w.DefIn,
+		IsDefaultOut: w.DefOut,
+		IsDefaultSys: w.DefSys}
+}
+
+func (w *wireCaps) caps() libsio.Caps {
+	c := libsio.Caps{
+		MinChannels: w.MinChannels,
+		MaxChannels: w.MaxChannels,
+		Layouts:     w.Layouts,
+		MinPeriod:   w.MinPeriod,
+		MaxPeriod:   w.MaxPeriod,
+		MinLatency:  time.Duration(w.MinLatency),
+		MaxLatency:  time.Duration(w.MaxLatency)}
+	for _, r := range w.Rates {
+		c.Rates = append(c.Rates, freq.T(r))
+	}
+	for _, rr := range w.RateRanges {
+		c.RateRanges = append(c.RateRanges, libsio.RateRange{Min: freq.T(rr[0]), Max: freq.T(rr[1])})
+	}
+	for _, name := range w.Codecs {
+		// codecs unknown locally can't be used anyway.
+		if co, err := codecByName(name); err == nil {
+			c.Codecs = append(c.Codecs, co)
+		}
+	}
+	return c
+}
+
+// encode encodes nF frames of the channel deinterleaved d, which has dF
+// frames per channel, from frame f, to dst as channel-interleaved samples.
+// tmp must hold nF frames.
+func encode(co sample.Codec, dst []byte, d []float64, nC, dF, f, nF int, tmp []float64) {
+	tmp = tmp[:nF*nC]
+	for c := 0; c < nC; c++ {
+		for i := 0; i < nF; i++ {
+			tmp[i*nC+c] = d[c*dF+f+i]
+		}
+	}
+	co.Encode(dst, tmp)
+}
+
+// decode is the inverse of encode, the number of frames being given by
+// src.  It returns the number of frames decoded.
+func decode(co sample.Codec, d []float64, nC, dF, f int, src []byte, tmp []float64) int {
+	nF := len(src) / (co.Bytes() * nC)
+	tmp = tmp[:nF*nC]
+	co.Decode(tmp, src)
+	for c := 0; c < nC; c++ {
+		for i := 0; i < nF; i++ {
+			d[c*dF+f+i] = tmp[i*nC+c]
+		}
+	}
+	return nF
+}
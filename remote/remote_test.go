@@ -0,0 +1,506 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package remote
+
+import (
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// testEntry is a host.Entry whose sources produce val, whose sinks record
+// what they play and whose duplex streams echo.
+type testEntry struct {
+	host.NullEntry
+	mu     sync.Mutex
+	devs   []*libsio.Dev
+	subs   host.Notifier
+	frames int           // produced by sources.
+	played [][]float64   // by channel.
+	delay  time.Duration // of each Receive.
+}
+
+func newTestEntry() *testEntry {
+	e := &testEntry{frames: 10000}
+	e.add("a")
+	e.add("b")
+	return e
+}
+
+func (e *testEntry) add(name string) *libsio.Dev {
+	d := &libsio.Dev{
+		Name: name,
+		In: libsio.Caps{
+			MinChannels: 1,
+			MaxChannels: 2,
+			Rates:       []freq.T{8000 * freq.Hertz, 48000 * freq.Hertz},
+			Codecs:      []sample.Codec{sample.SInt16L, sample.SFloat32L}},
+		Out: libsio.Caps{
+			MinChannels: 1,
+			MaxChannels: 2,
+			Layouts:     []libsio.Layout{libsio.Mono, libsio.Stereo},
+			RateRanges:  []libsio.RateRange{{Min: 8000 * freq.Hertz, Max: 48000 * freq.Hertz}},
+			Codecs:      []sample.Codec{sample.SInt16L, sample.SFloat32L},
+			MinPeriod:   64,
+			MaxLatency:  time.Second}}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.devs = append(e.devs, d)
+	e.subs.Notify(&host.DevChange{Sense: host.DeviceConnect, Dev: d})
+	return d
+}
+
+func (e *testEntry) remove(d *libsio.Dev) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, o := range e.devs {
+		if o == d {
+			e.devs = append(e.devs[:i], e.devs[i+1:]...)
+		}
+	}
+	e.subs.Notify(&host.DevChange{Sense: host.DeviceDisconnect, Dev: d})
+}
+
+func (e *testEntry) Name() string                     { return "test" }
+func (e *testEntry) DefaultSampleCodec() sample.Codec { return sample.SInt16L }
+func (e *testEntry) CanOpenSource() bool              { return true }
+func (e *testEntry) CanOpenSink() bool                { return true }
+func (e *testEntry) CanOpenDuplex() bool              { return true }
+func (e *testEntry) HasDevices() bool                 { return true }
+func (e *testEntry) DefaultInputDev() *libsio.Dev     { return e.Devices()[0] }
+func (e *testEntry) DefaultOutputDev() *libsio.Dev    { return e.Devices()[0] }
+func (e *testEntry) DefaultDuplexDev() *libsio.Dev    { return e.Devices()[0] }
+
+func (e *testEntry) Devices() []*libsio.Dev {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*libsio.Dev(nil), e.devs...)
+}
+
+func (e *testEntry) ScanDevices() ([]*host.DevScanResult, error) {
+	var res []*host.DevScanResult
+	for _, d := range e.Devices() {
+		res = append(res, &host.DevScanResult{Dev: d})
+	}
+	return res, nil
+}
+
+func (e *testEntry) DevicesNotify(c chan<- *host.DevChange) error {
+	e.subs.Add(c)
+	return nil
+}
+
+func (e *testEntry) DevicesNotifyClose(c chan<- *host.DevChange) {
+	e.subs.Remove(c)
+}
+
+func (e *testEntry) OpenSource(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Source, time.Time, error) {
+	if d.Name == "b" {
+		return nil, time.Time{}, host.ErrUnsupported
+	}
+	return &testSrc{Form: v, total: e.frames, delay: e.delay}, time.Now(), nil
+}
+
+func (e *testEntry) OpenSink(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Sink, *time.Time, error) {
+	e.mu.Lock()
+	e.played = make([][]float64, v.Channels())
+	e.mu.Unlock()
+	s := &testSnk{Form: v, e: e}
+	return s, &s.start, nil
+}
+
+func (e *testEntry) OpenDuplex(d *libsio.Dev, iv, ov sound.Form, co sample.Codec, b int) (sound.Duplex, time.Time, *time.Time, error) {
+	x := &testDpx{Form: ov, nI: iv.Channels()}
+	return x, time.Now(), &x.start, nil
+}
+
+// val is the sample of frame f of channel c of test sources.
+func val(f, c int) float64 {
+	return float64((f*7+c)%1000)/1000 - 0.5
+}
+
+// near reports whether the float64 values a and b are equal within the
+// precision of 16 bit samples.
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-4
+}
+
+// closer makes streams fail after Close.
+type closer int32
+
+func (c *closer) Close() error {
+	atomic.StoreInt32((*int32)(c), 1)
+	return nil
+}
+
+func (c *closer) closed() bool {
+	return atomic.LoadInt32((*int32)(c)) != 0
+}
+
+type testSrc struct {
+	sound.Form
+	closer
+	n, total int
+	delay    time.Duration
+}
+
+func (s *testSrc) Receive(d []float64) (int, error) {
+	if s.closed() {
+		return 0, io.EOF
+	}
+	nC := s.Channels()
+	if len(d)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := len(d) / nC
+	k := s.total - s.n
+	if k == 0 {
+		return 0, io.EOF
+	}
+	if k > nF {
+		k = nF
+	}
+	for c := 0; c < nC; c++ {
+		for f := 0; f < k; f++ {
+			d[c*nF+f] = val(s.n+f, c)
+		}
+	}
+	s.n += k
+	time.Sleep(s.delay)
+	return k, nil
+}
+
+type testSnk struct {
+	sound.Form
+	closer
+	e     *testEntry
+	start time.Time
+}
+
+func (s *testSnk) Send(d []float64) error {
+	if s.closed() {
+		return io.EOF
+	}
+	nC := s.Channels()
+	if len(d)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	nF := len(d) / nC
+	s.e.mu.Lock()
+	defer s.e.mu.Unlock()
+	for c := 0; c < nC; c++ {
+		s.e.played[c] = append(s.e.played[c], d[c*nF:(c+1)*nF]...)
+	}
+	if s.start.IsZero() {
+		s.start = time.Now()
+	}
+	return nil
+}
+
+type testDpx struct {
+	sound.Form
+	closer
+	nI    int
+	start time.Time
+}
+
+func (x *testDpx) InChannels() int  { return x.nI }
+func (x *testDpx) OutChannels() int { return x.Channels() }
+
+func (x *testDpx) SendReceive(out, in []float64) (int, error) {
+	if x.closed() {
+		return 0, io.EOF
+	}
+	if len(out)%x.Channels() != 0 || len(in)%x.nI != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := len(out) / x.Channels()
+	if len(in)/x.nI != nF {
+		return 0, sound.ErrFrameAlignment
+	}
+	for c := 0; c < x.nI; c++ {
+		copy(in[c*nF:(c+1)*nF], out[(c%x.Channels())*nF:])
+	}
+	if x.start.IsZero() {
+		x.start = time.Now()
+	}
+	return nF, nil
+}
+
+// slowListener delays writes of the connections it accepts.
+type slowListener struct {
+	net.Listener
+}
+
+func (l slowListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	return slowConn{c}, err
+}
+
+type slowConn struct {
+	net.Conn
+}
+
+func (c slowConn) Write(d []byte) (int, error) {
+	time.Sleep(2 * time.Millisecond)
+	return c.Conn.Write(d)
+}
+
+// serve serves te over loopback and returns an Entry connected to it.
+func serve(t *testing.T, te *testEntry, slow bool, q int) (*Entry, *Server) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(te)
+	srv.Queue = q
+	var sl net.Listener = l
+	if slow {
+		sl = slowListener{l}
+	}
+	go srv.Serve(sl)
+	return &Entry{Addr: l.Addr().String()}, srv
+}
+
+var stereo = sound.NewForm(8000*freq.Hertz, 2)
+
+func TestDevices(t *testing.T) {
+	te := newTestEntry()
+	e, srv := serve(t, te, false, 0)
+	defer srv.Close()
+	if name, err := e.RemoteName(); err != nil || name != "test" {
+		t.Fatalf("got %q, %v", name, err)
+	}
+	if off, _ := e.Offset(); off > 50*time.Millisecond || off < -50*time.Millisecond {
+		t.Errorf("clock offset %s over loopback", off)
+	}
+	if !e.HasDevices() || e.DefaultSampleCodec() != sample.SInt16L {
+		t.Errorf("got wrong info")
+	}
+	devs := e.Devices()
+	if len(devs) != 2 || devs[0].Name != "a" || devs[1].Name != "b" {
+		t.Fatalf("got %v", devs)
+	}
+	if !reflect.DeepEqual(devs[0].In, te.devs[0].In) || !reflect.DeepEqual(devs[0].Out, te.devs[0].Out) {
+		t.Errorf("got %v, want %v", devs[0], te.devs[0])
+	}
+	if e.DefaultInputDev() != devs[0] {
+		t.Errorf("default input is not the first device")
+	}
+	c := make(chan *host.DevChange, 8)
+	if err := e.DevicesNotify(c); err != nil {
+		t.Fatal(err)
+	}
+	next := func() *host.DevChange {
+		select {
+		case chg := <-c:
+			return chg
+		case <-time.After(5 * time.Second):
+			t.Fatal("no notification")
+		}
+		return nil
+	}
+	te.add("c")
+	chg := next()
+	if chg.Sense != host.DeviceConnect || chg.Dev.Name != "c" {
+		t.Fatalf("got %v", chg)
+	}
+	devs = e.Devices()
+	if len(devs) != 3 || devs[2] != chg.Dev {
+		t.Errorf("got %v", devs)
+	}
+	te.remove(te.Devices()[1])
+	if chg := next(); chg.Sense != host.DeviceDisconnect || chg.Dev != devs[1] {
+		t.Errorf("got %v", chg)
+	}
+	srv.Close()
+	for _, d := range []*libsio.Dev{devs[0], devs[2]} {
+		if chg := next(); chg.Sense != host.DeviceDisconnect || chg.Dev != d {
+			t.Errorf("got %v", chg)
+		}
+	}
+	e.DevicesNotifyClose(c)
+}
+
+func TestSource(t *testing.T) {
+	te := newTestEntry()
+	te.delay = time.Millisecond
+	e, srv := serve(t, te, false, 64)
+	defer srv.Close()
+	if _, _, err := e.OpenSource(e.Devices()[1], stereo, sample.SInt16L, 256); err != host.ErrUnsupported {
+		t.Errorf("got %v not host.ErrUnsupported", err)
+	}
+	src, start, err := e.OpenSource(e.DefaultInputDev(), stereo, sample.SInt16L, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if d := time.Since(start); d < 0 || d > time.Second {
+		t.Errorf("start %s ago", d)
+	}
+	d := make([]float64, 2*300)
+	n := 0
+	for {
+		k, err := src.Receive(d)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for f := 0; f < k; f++ {
+			if !near(d[f], val(n+f, 0)) || !near(d[300+f], val(n+f, 1)) {
+				t.Fatalf("frame %d: got %f %f", n+f, d[f], d[300+f])
+			}
+		}
+		n += k
+	}
+	if n != te.frames {
+		t.Errorf("got %d frames not %d", n, te.frames)
+	}
+	s := src.(*Source)
+	if s.Stats().Xruns != 0 || s.Err() != nil {
+		t.Errorf("got %+v, %v", s.Stats(), s.Err())
+	}
+}
+
+func TestSourceDrop(t *testing.T) {
+	te := newTestEntry()
+	te.frames = 20000
+	e, srv := serve(t, te, true, 1)
+	defer srv.Close()
+	src, _, err := e.OpenSource(e.DefaultInputDev(), stereo, sample.SInt16L, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	d := make([]float64, 2*64)
+	n := 0
+	for {
+		k, err := src.Receive(d)
+		if err != nil {
+			break
+		}
+		for f := 0; f < k; f++ {
+			if d[f] != 0 && !near(d[f], val(n+f, 0)) {
+				t.Fatalf("frame %d: got %f", n+f, d[f])
+			}
+		}
+		n += k
+	}
+	st := src.(*Source).Stats()
+	if st.Xruns == 0 || st.Frames == 0 || n > te.frames {
+		t.Errorf("got %d frames, %+v", n, st)
+	}
+}
+
+func TestSink(t *testing.T) {
+	te := newTestEntry()
+	e, srv := serve(t, te, false, 0)
+	defer srv.Close()
+	snk, start, err := e.OpenSink(e.DefaultOutputDev(), stereo, sample.SInt16L, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := make([]float64, 2*1000)
+	for i := 0; i < 5; i++ {
+		for f := 0; f < 1000; f++ {
+			d[f] = val(i*1000+f, 0)
+			d[1000+f] = val(i*1000+f, 1)
+		}
+		if err := snk.Send(d); err != nil {
+			t.Fatal(err)
+		}
+		if start.IsZero() {
+			t.Fatal("start not set")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if d := time.Since(*start); d < 0 || d > time.Second {
+		t.Errorf("start %s ago", d)
+	}
+	if err := snk.Close(); err != nil {
+		t.Fatal(err)
+	}
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	if len(te.played[0]) != 5000 {
+		t.Fatalf("played %d frames", len(te.played[0]))
+	}
+	for f, v := range te.played[1] {
+		if !near(v, val(f, 1)) {
+			t.Fatalf("frame %d: got %f", f, v)
+		}
+	}
+}
+
+func TestSinkXrun(t *testing.T) {
+	te := newTestEntry()
+	e, srv := serve(t, te, false, 0)
+	defer srv.Close()
+	snk, _, err := e.OpenSink(e.DefaultOutputDev(), stereo, sample.SInt16L, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := make([]float64, 2*64)
+	for i := 0; i < 2; i++ {
+		if err := snk.Send(d); err != nil {
+			t.Fatal(err)
+		}
+		// 64 frames last 8ms.
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err := snk.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if st := snk.(*Sink).Stats(); st.Xruns != 1 || st.Frames < 400 {
+		t.Errorf("got %+v", st)
+	}
+}
+
+func TestDuplex(t *testing.T) {
+	te := newTestEntry()
+	e, srv := serve(t, te, false, 0)
+	defer srv.Close()
+	mono := sound.NewForm(8000*freq.Hertz, 1)
+	dpx, _, pstart, err := e.OpenDuplex(e.DefaultDuplexDev(), mono, stereo, sample.SInt16L, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dpx.InChannels() != 1 || dpx.OutChannels() != 2 {
+		t.Errorf("got %d in %d out channels", dpx.InChannels(), dpx.OutChannels())
+	}
+	out := make([]float64, 2*300)
+	in := make([]float64, 300)
+	for f := 0; f < 300; f++ {
+		out[f] = val(f, 0)
+		out[300+f] = val(f, 1)
+	}
+	n, err := dpx.SendReceive(out, in)
+	if err != nil || n != 300 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	for f := 0; f < 300; f++ {
+		if !near(in[f], val(f, 0)) {
+			t.Fatalf("frame %d: got %f", f, in[f])
+		}
+	}
+	if pstart.IsZero() {
+		t.Errorf("play start not set")
+	}
+	if err := dpx.Close(); err != nil {
+		t.Error(err)
+	}
+}
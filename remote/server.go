@@ -0,0 +1,635 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Server exports a host.Entry to Entries of other hosts.
+type Server struct {
+	// Entry is the exported entry.
+	Entry host.Entry
+
+	// Queue is the number of buffers each stream queues between the
+	// network and the entry, 4 if 0.  Sources drop captured data when
+	// their queue is full.
+	Queue int
+
+	mu      sync.Mutex
+	devs    map[uint64]*libsio.Dev
+	handles map[*libsio.Dev]uint64
+	ls      map[net.Listener]struct{}
+	conns   map[net.Conn]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// ErrServerClosed is returned by Serve once the Server is closed.
+var ErrServerClosed = errors.New("remote: server closed")
+
+// ErrUnknownDev is returned when opening a stream on a device which is not
+// known to the server.
+var ErrUnknownDev = errors.New("remote: unknown device")
+
+// NewServer creates a Server exporting e.
+func NewServer(e host.Entry) *Server {
+	return &Server{
+		Entry:   e,
+		devs:    make(map[uint64]*libsio.Dev),
+		handles: make(map[*libsio.Dev]uint64),
+		ls:      make(map[net.Listener]struct{}),
+		conns:   make(map[net.Conn]struct{})}
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves the connections accepted by l until l fails or s is closed.
+// l is closed when Serve returns.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.ls[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.ls, l)
+		s.mu.Unlock()
+	}()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		if !s.track(c) {
+			c.Close()
+			return ErrServerClosed
+		}
+		go s.serveConn(c)
+	}
+}
+
+// Close closes the listeners and connections of s, closing all streams,
+// and waits for them to be closed.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.ls {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) track(c net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) queue() int {
+	if s.Queue <= 0 {
+		return 4
+	}
+	return s.Queue
+}
+
+// handle returns the handle of d, 0 for nil.
+func (s *Server) handle(d *libsio.Dev) uint64 {
+	if d == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.handles[d]
+	if !ok {
+		h = uint64(len(s.handles) + 1)
+		s.handles[d] = h
+		s.devs[h] = d
+	}
+	return h
+}
+
+func (s *Server) dev(h uint64) (*libsio.Dev, error) {
+	if h == 0 {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devs[h]
+	if !ok {
+		return nil, ErrUnknownDev
+	}
+	return d, nil
+}
+
+func (s *Server) serveConn(nc net.Conn) {
+	defer func() {
+		nc.Close()
+		s.mu.Lock()
+		delete(s.conns, nc)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+	c := newConn(nc)
+	t, p, err := c.read(nil)
+	if err != nil {
+		return
+	}
+	switch t {
+	case msgHello:
+		s.control(c)
+	case msgOpen:
+		s.stream(c, p)
+	}
+}
+
+func (s *Server) info() *info {
+	e := s.Entry
+	v := e.DefaultForm()
+	return &info{
+		Version:  protoVersion,
+		Name:     e.Name(),
+		Channels: v.Channels(),
+		Rate:     int64(v.SampleRate()),
+		Codec:    e.DefaultSampleCodec().String(),
+		BufSize:  e.DefaultBufSize(),
+		Source:   e.CanOpenSource(),
+		Sink:     e.CanOpenSink(),
+		Duplex:   e.CanOpenDuplex(),
+		Devices:  e.HasDevices()}
+}
+
+// control serves the control connection of an Entry.  The version of the
+// client is checked by the client.
+func (s *Server) control(c *conn) {
+	if err := c.writeJSON(msgHello, s.info()); err != nil {
+		return
+	}
+	var nc chan *host.DevChange
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		if nc != nil {
+			s.Entry.DevicesNotifyClose(nc)
+		}
+		close(done)
+		wg.Wait()
+	}()
+	var pong [16]byte
+	for {
+		t, p, err := c.read(nil)
+		if err != nil {
+			return
+		}
+		switch t {
+		case msgPing:
+			if len(p) != 8 {
+				return
+			}
+			copy(pong[:8], p)
+			putInt64(pong[8:], time.Now().UnixNano())
+			err = c.write(msgPing, pong[:])
+		case msgScan:
+			err = c.writeJSON(msgScan, s.scan())
+		case msgNotify:
+			var req notifyReq
+			if json.Unmarshal(p, &req) != nil {
+				return
+			}
+			var nerr error
+			if req.On && nc == nil {
+				nc = make(chan *host.DevChange, 16)
+				if nerr = s.Entry.DevicesNotify(nc); nerr != nil {
+					nc = nil
+				} else {
+					wg.Add(1)
+					go s.forward(c, nc, done, &wg)
+				}
+			} else if !req.On && nc != nil {
+				s.Entry.DevicesNotifyClose(nc)
+				nc = nil
+			}
+			err = c.writeJSON(msgNotify, &ack{Err: errString(nerr)})
+		default:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// forward forwards device notifications of the entry until done is closed.
+func (s *Server) forward(c *conn, nc <-chan *host.DevChange, done <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-done:
+			return
+		case chg := <-nc:
+			if chg == nil || chg.Dev == nil {
+				continue
+			}
+			c.writeJSON(msgDevChange, &devChange{
+				Sense: int(chg.Sense),
+				Dev:   toWire(s.handle(chg.Dev), chg.Dev)})
+		}
+	}
+}
+
+func (s *Server) scan() *scanReply {
+	e := s.Entry
+	res, err := e.ScanDevices()
+	if err != nil {
+		return &scanReply{Err: errString(err)}
+	}
+	r := &scanReply{}
+	for _, dr := range res {
+		if dr.E != nil {
+			r.Errs = append(r.Errs, dr.E.Error())
+		}
+		if dr.Dev != nil {
+			r.Devs = append(r.Devs, toWire(s.handle(dr.Dev), dr.Dev))
+		}
+	}
+	r.In = s.handle(e.DefaultInputDev())
+	r.Out = s.handle(e.DefaultOutputDev())
+	r.Duplex = s.handle(e.DefaultDuplexDev())
+	return r
+}
+
+// stream serves a stream connection.
+func (s *Server) stream(c *conn, p []byte) {
+	var req openReq
+	fail := func(err error) {
+		c.writeJSON(msgOpen, &openReply{Err: errString(err)})
+	}
+	if err := json.Unmarshal(p, &req); err != nil {
+		fail(err)
+		return
+	}
+	d, err := s.dev(req.Dev)
+	if err != nil {
+		fail(err)
+		return
+	}
+	co, err := codecByName(req.Codec)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if req.BufSize <= 0 || req.In < 0 || req.Out < 0 || req.Rate <= 0 {
+		fail(ErrProtocol)
+		return
+	}
+	rate := freq.T(req.Rate)
+	switch req.Kind {
+	case kindSource:
+		s.serveSource(c, d, sound.NewForm(rate, req.In), co, req.BufSize)
+	case kindSink:
+		s.serveSink(c, d, sound.NewForm(rate, req.Out), co, req.BufSize)
+	case kindDuplex:
+		s.serveDuplex(c, d, sound.NewForm(rate, req.In), sound.NewForm(rate, req.Out), co, req.BufSize)
+	default:
+		fail(ErrProtocol)
+	}
+}
+
+// serveSource sends data captured by a source of the entry.  Data is
+// dropped when the queue is full, which the client sees as a gap in frame
+// numbers.
+func (s *Server) serveSource(c *conn, d *libsio.Dev, v sound.Form, co sample.Codec, b int) {
+	src, start, err := s.Entry.OpenSource(d, v, co, b)
+	if err != nil {
+		c.writeJSON(msgOpen, &openReply{Err: errString(err)})
+		return
+	}
+	var once sync.Once
+	closeSrc := func() {
+		once.Do(func() { src.Close() })
+	}
+	defer closeSrc()
+	if err := c.writeJSON(msgOpen, &openReply{Start: unixNano(start)}); err != nil {
+		return
+	}
+	// the client sends nothing, so a read returns when it goes away.
+	go func() {
+		c.read(nil)
+		closeSrc()
+	}()
+	nC := v.Channels()
+	bpf := co.Bytes() * nC
+	q := s.queue()
+	full := make(chan []byte, q)
+	free := make(chan []byte, q+1)
+	for i := 0; i < q+1; i++ {
+		free <- make([]byte, 8+b*bpf)
+	}
+	endC := make(chan *end, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var err error
+		for p := range full {
+			if err == nil {
+				if err = c.write(msgData, p); err != nil {
+					c.Close()
+				}
+			}
+			free <- p[:cap(p)]
+		}
+		if err == nil {
+			c.writeJSON(msgEnd, <-endC)
+		}
+	}()
+	raw, isRaw := src.(libsio.RawSource)
+	isRaw = isRaw && raw.Codec() == co
+	fd := make([]float64, b*nC)
+	tmp := make([]float64, b*nC)
+	scratch := make([]byte, 8+b*bpf)
+	var pkt libsio.RawPacket
+	var fn int64
+	for {
+		var p []byte
+		drop := false
+		select {
+		case p = <-free:
+		default:
+			// the network is behind, capture to scratch and drop.
+			p, drop = scratch, true
+		}
+		var n int
+		if isRaw {
+			pkt.D = p[8:]
+			err = raw.ReceiveRaw(&pkt)
+			n = len(pkt.D) / bpf
+			fn = int64(pkt.N)
+		} else {
+			n, err = src.Receive(fd)
+			encode(co, p[8:], fd, nC, b, 0, n, tmp)
+		}
+		if err != nil {
+			if !drop {
+				free <- p
+			}
+			break
+		}
+		if !drop {
+			putInt64(p, fn)
+			full <- p[:8+n*bpf]
+		}
+		fn += int64(n)
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	endC <- &end{Err: errString(err), Frames: fn}
+	close(full)
+	<-done
+}
+
+// serveSink plays data received from the client on a sink of the entry.
+// When the queue runs dry for longer than the data played so far lasts,
+// the client is told of an xrun.
+func (s *Server) serveSink(c *conn, d *libsio.Dev, v sound.Form, co sample.Codec, b int) {
+	snk, startp, err := s.Entry.OpenSink(d, v, co, b)
+	if err != nil {
+		c.writeJSON(msgOpen, &openReply{Err: errString(err)})
+		return
+	}
+	if err := c.writeJSON(msgOpen, &openReply{}); err != nil {
+		snk.Close()
+		return
+	}
+	nC := v.Channels()
+	bpf := co.Bytes() * nC
+	q := s.queue()
+	full := make(chan []byte, q)
+	free := make(chan []byte, q+1)
+	for i := 0; i < q+1; i++ {
+		free <- make([]byte, 8+b*bpf)
+	}
+	quit := make(chan struct{})
+	drain := make(chan bool, 1)
+	go func() {
+		defer close(full)
+		for {
+			var p []byte
+			select {
+			case p = <-free:
+			case <-quit:
+				drain <- false
+				return
+			}
+			t, m, err := c.read(p)
+			if err != nil {
+				drain <- false
+				return
+			}
+			switch {
+			case t == msgEnd:
+				drain <- true
+				return
+			case t != msgData || len(m) < 8 || len(m) > cap(p) || (len(m)-8)%bpf != 0:
+				c.Close()
+				drain <- false
+				return
+			}
+			select {
+			case full <- m:
+			case <-quit:
+				drain <- false
+				return
+			}
+		}
+	}()
+	raw, isRaw := snk.(libsio.RawSink)
+	isRaw = isRaw && raw.Codec() == co
+	fd := make([]float64, b*nC)
+	tmp := make([]float64, b*nC)
+	var pkt libsio.RawPacket
+	var xrun [16]byte
+	period := v.SampleRate().Period()
+	tmr := time.NewTimer(time.Hour)
+	tmr.Stop()
+	var t0 time.Time
+	var played int64
+	for err == nil {
+		due := t0.Add(time.Duration(played) * period)
+		p, ok, late := next(full, due, t0.IsZero(), tmr)
+		if !ok {
+			break
+		}
+		if late {
+			now := time.Now()
+			putInt64(xrun[:], played)
+			putInt64(xrun[8:], int64(now.Sub(due)/period))
+			c.write(msgXrun, xrun[:])
+			t0 = now.Add(-time.Duration(played) * period)
+		}
+		n := (len(p) - 8) / bpf
+		if isRaw {
+			pkt.D = p[8:]
+			pkt.N = 0
+			err = raw.SendRaw(&pkt)
+		} else {
+			decode(co, fd, nC, n, 0, p[8:], tmp)
+			err = snk.Send(fd[:n*nC])
+		}
+		free <- p[:cap(p)]
+		if err != nil || n == 0 {
+			continue
+		}
+		if t0.IsZero() {
+			t0 = time.Now()
+			start := t0
+			if startp != nil && !startp.IsZero() {
+				start = *startp
+			}
+			var st [8]byte
+			putInt64(st[:], start.UnixNano())
+			c.write(msgStart, st[:])
+		}
+		played += int64(n)
+	}
+	close(quit)
+	if err != nil {
+		c.writeJSON(msgEnd, &ack{Err: errString(err)})
+		c.Close()
+	}
+	for range full {
+	}
+	cerr := snk.Close()
+	if <-drain && err == nil {
+		c.writeJSON(msgEnd, &ack{Err: errString(cerr)})
+	}
+}
+
+// next returns the next buffer of c, and whether it came after due.
+func next(c <-chan []byte, due time.Time, idle bool, tmr *time.Timer) ([]byte, bool, bool) {
+	select {
+	case p, ok := <-c:
+		return p, ok, false
+	default:
+	}
+	if idle {
+		p, ok := <-c
+		return p, ok, false
+	}
+	if d := time.Until(due); d > 0 {
+		tmr.Reset(d)
+		select {
+		case p, ok := <-c:
+			if !tmr.Stop() {
+				<-tmr.C
+			}
+			return p, ok, false
+		case <-tmr.C:
+		}
+	}
+	p, ok := <-c
+	return p, ok, ok
+}
+
+// serveDuplex exchanges buffers with the client, one buffer of captured
+// data for each buffer of data to play.
+func (s *Server) serveDuplex(c *conn, d *libsio.Dev, iv, ov sound.Form, co sample.Codec, b int) {
+	dpx, start, pstartp, err := s.Entry.OpenDuplex(d, iv, ov, co, b)
+	if err != nil {
+		c.writeJSON(msgOpen, &openReply{Err: errString(err)})
+		return
+	}
+	if err := c.writeJSON(msgOpen, &openReply{Start: unixNano(start)}); err != nil {
+		dpx.Close()
+		return
+	}
+	nI, nO := iv.Channels(), ov.Channels()
+	ibpf, obpf := co.Bytes()*nI, co.Bytes()*nO
+	in := make([]float64, b*nI)
+	out := make([]float64, b*nO)
+	tmp := make([]float64, b*(nI+nO))
+	rbuf := make([]byte, 8+b*obpf)
+	wbuf := make([]byte, 8+b*ibpf)
+	started := false
+	for err == nil {
+		t, m, rerr := c.read(rbuf)
+		if rerr != nil {
+			break
+		}
+		if t == msgEnd {
+			err = io.EOF
+			break
+		}
+		if t != msgData || len(m) < 8 || len(m) > len(rbuf) || (len(m)-8)%obpf != 0 {
+			break
+		}
+		fn := getInt64(m)
+		n := decode(co, out, nO, b, 0, m[8:], tmp)
+		// the buffers are sized for n frames so that channels are
+		// contiguous.
+		var k int
+		k, err = dpx.SendReceive(out[:n*nO], in[:n*nI])
+		if err != nil {
+			break
+		}
+		if !started && pstartp != nil && !pstartp.IsZero() {
+			started = true
+			var st [8]byte
+			putInt64(st[:], pstartp.UnixNano())
+			c.write(msgStart, st[:])
+		}
+		putInt64(wbuf, fn)
+		encode(co, wbuf[8:], in, nI, n, 0, k, tmp)
+		if c.write(msgData, wbuf[:8+k*ibpf]) != nil {
+			break
+		}
+	}
+	cerr := dpx.Close()
+	if err == io.EOF {
+		err = cerr
+	}
+	c.writeJSON(msgEnd, &ack{Err: errString(err)})
+}
@@ -0,0 +1,525 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Stats counts the network induced xruns of a stream.
+type Stats struct {
+	// Xruns is the number of xruns.
+	Xruns int
+
+	// Frames is the number of frames lost by a Source, or the estimated
+	// number of frames a Sink was late by, because of xruns.
+	Frames int64
+}
+
+// maxGap bounds the silence a Source inserts for one gap, in seconds.
+const maxGap = 1
+
+// Source is the sound.Source returned by Entry.OpenSource.  It implements
+// libsio.RawSource in addition to sound.Source.
+type Source struct {
+	sound.Source
+	raw libsio.RawSource
+	s   *src
+}
+
+func newSource(c *conn, v sound.Form, co sample.Codec, b int, start time.Time) *Source {
+	s := &src{
+		Form:  v,
+		c:     c,
+		co:    co,
+		bpf:   co.Bytes() * v.Channels(),
+		b:     b,
+		start: start,
+		ch:    make(chan *libsio.RawPacket, 1),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{})}
+	for i := range s.pkts {
+		s.pkts[i].D = make([]byte, b*s.bpf)
+	}
+	go s.serve()
+	raw := libsio.RawInputSource(s)
+	return &Source{Source: raw, raw: raw.(libsio.RawSource), s: s}
+}
+
+// Codec returns the sample codec with which s was opened.
+func (s *Source) Codec() sample.Codec {
+	return s.raw.Codec()
+}
+
+// ReceiveRaw implements libsio.RawSource.
+func (s *Source) ReceiveRaw(pkt *libsio.RawPacket) error {
+	return s.raw.ReceiveRaw(pkt)
+}
+
+// Stats returns the xrun counters of s.
+func (s *Source) Stats() Stats {
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+	return s.s.stats
+}
+
+// Err returns the error which ended s, if any.  It is nil if the source of
+// the Server reached its end.
+func (s *Source) Err() error {
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+	return s.s.err
+}
+
+// src implements libsio.RawInput.
+type src struct {
+	sound.Form
+	c     *conn
+	co    sample.Codec
+	bpf   int
+	b     int
+	start time.Time
+
+	pkts [3]libsio.RawPacket
+	i    int
+	ch   chan *libsio.RawPacket
+
+	mu    sync.Mutex
+	stats Stats
+	err   error
+
+	quit chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func (s *src) Codec() sample.Codec {
+	return s.co
+}
+
+func (s *src) RawC() <-chan *libsio.RawPacket {
+	return s.ch
+}
+
+func (s *src) Close() error {
+	s.once.Do(func() {
+		close(s.quit)
+		s.c.Close()
+	})
+	<-s.done
+	return nil
+}
+
+func (s *src) setErr(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// serve delivers the data of the Server, filling gaps in frame numbers
+// with silence.
+func (s *src) serve() {
+	defer close(s.done)
+	defer close(s.ch)
+	buf := make([]byte, 8+s.b*s.bpf)
+	var next int64
+	first := true
+	for {
+		t, m, err := s.c.read(buf)
+		if err != nil {
+			select {
+			case <-s.quit:
+			default:
+				s.setErr(err)
+			}
+			return
+		}
+		switch t {
+		case msgData:
+			if len(m) < 8 || len(m) > len(buf) || (len(m)-8)%s.bpf != 0 {
+				s.setErr(ErrProtocol)
+				return
+			}
+			fn := getInt64(m)
+			if !first && !s.gap(next, fn) {
+				return
+			}
+			first = false
+			if !s.put(fn, m[8:]) {
+				return
+			}
+			next = fn + int64((len(m)-8)/s.bpf)
+		case msgEnd:
+			var a end
+			if err := json.Unmarshal(m, &a); err != nil {
+				s.setErr(err)
+				return
+			}
+			if !first {
+				s.gap(next, a.Frames)
+			}
+			s.setErr(peerErr(a.Err))
+			return
+		default:
+			s.setErr(ErrProtocol)
+			return
+		}
+	}
+}
+
+// put delivers d as the frames from fn.  It returns false if s is closed.
+func (s *src) put(fn int64, d []byte) bool {
+	pkt := &s.pkts[s.i%len(s.pkts)]
+	s.i++
+	pkt.D = pkt.D[:len(d)]
+	copy(pkt.D, d)
+	pkt.N = int(fn)
+	pkt.Start = s.start
+	select {
+	case s.ch <- pkt:
+		return true
+	case <-s.quit:
+		return false
+	}
+}
+
+// gap counts an xrun if the frames from next to fn were dropped, and fills
+// the gap with at most maxGap seconds of silence.  It returns false if s is
+// closed.
+func (s *src) gap(next, fn int64) bool {
+	n := fn - next
+	if n <= 0 {
+		return true
+	}
+	s.mu.Lock()
+	s.stats.Xruns++
+	s.stats.Frames += n
+	s.mu.Unlock()
+	if maxF := int64(s.SampleRate().Float64()) * maxGap; n > maxF {
+		n = maxF
+	}
+	return s.silence(fn-n, n)
+}
+
+func (s *src) silence(fn, n int64) bool {
+	for n > 0 {
+		k := int64(s.b)
+		if k > n {
+			k = n
+		}
+		pkt := &s.pkts[s.i%len(s.pkts)]
+		s.i++
+		pkt.D = pkt.D[:int(k)*s.bpf]
+		for i := range pkt.D {
+			pkt.D[i] = 0
+		}
+		pkt.N = int(fn)
+		pkt.Start = s.start
+		select {
+		case s.ch <- pkt:
+		case <-s.quit:
+			return false
+		}
+		fn += k
+		n -= k
+	}
+	return true
+}
+
+// Sink is the sound.Sink returned by Entry.OpenSink.
+type Sink struct {
+	sound.Form
+	c   *conn
+	k   *ctl
+	co  sample.Codec
+	bpf int
+	b   int
+	buf []byte
+	tmp []float64
+	fn  int64
+
+	start time.Time
+	exact bool // whether start was reported by the Server.
+
+	mu     sync.Mutex
+	pstart time.Time
+	stats  Stats
+	err    error
+
+	done chan struct{}
+	once sync.Once
+}
+
+func newSink(c *conn, k *ctl, v sound.Form, co sample.Codec, b int) *Sink {
+	s := &Sink{
+		Form: v,
+		c:    c,
+		k:    k,
+		co:   co,
+		bpf:  co.Bytes() * v.Channels(),
+		b:    b,
+		tmp:  make([]float64, b*v.Channels()),
+		done: make(chan struct{})}
+	s.buf = make([]byte, 8+b*s.bpf)
+	go s.serve()
+	return s
+}
+
+// Stats returns the xrun counters of s.
+func (s *Sink) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+func (s *Sink) state() (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pstart, s.err
+}
+
+// Send sends d to the Server, waiting while its queue is full.
+func (s *Sink) Send(d []float64) error {
+	nC := s.Channels()
+	if len(d)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	if _, err := s.state(); err != nil {
+		return err
+	}
+	nF := len(d) / nC
+	for f := 0; f < nF; {
+		k := nF - f
+		if k > s.b {
+			k = s.b
+		}
+		putInt64(s.buf, s.fn)
+		encode(s.co, s.buf[8:], d, nC, nF, f, k, s.tmp)
+		if err := s.c.write(msgData, s.buf[:8+k*s.bpf]); err != nil {
+			if _, serr := s.state(); serr != nil {
+				return serr
+			}
+			return err
+		}
+		s.fn += int64(k)
+		f += k
+	}
+	if !s.exact && nF > 0 {
+		pstart, _ := s.state()
+		switch {
+		case !pstart.IsZero():
+			s.start = pstart
+			s.exact = true
+		case s.start.IsZero():
+			s.start = time.Now().Add(s.k.delay)
+		}
+	}
+	return nil
+}
+
+// Close waits for the Server to play the data sent and close its sink.
+func (s *Sink) Close() error {
+	s.once.Do(func() {
+		if s.c.write(msgEnd) != nil {
+			s.c.Close()
+		}
+		s.c.SetReadDeadline(time.Now().Add(s.k.timeout))
+	})
+	<-s.done
+	s.c.Close()
+	_, err := s.state()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// serve reads messages of the Server until it closes the stream.
+func (s *Sink) serve() {
+	defer close(s.done)
+	var err error
+	for err == nil {
+		var t byte
+		var m []byte
+		t, m, err = s.c.read(nil)
+		if err != nil {
+			break
+		}
+		switch {
+		case t == msgStart && len(m) == 8:
+			s.mu.Lock()
+			s.pstart = s.k.local(getInt64(m))
+			s.mu.Unlock()
+		case t == msgXrun && len(m) == 16:
+			s.mu.Lock()
+			s.stats.Xruns++
+			s.stats.Frames += getInt64(m[8:])
+			s.mu.Unlock()
+		case t == msgEnd:
+			var a ack
+			if err = json.Unmarshal(m, &a); err == nil {
+				if err = peerErr(a.Err); err == nil {
+					err = io.EOF
+				}
+			}
+		default:
+			err = ErrProtocol
+		}
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// duplex is the sound.Duplex returned by Entry.OpenDuplex.
+type duplex struct {
+	sound.Form
+	c      *conn
+	k      *ctl
+	co     sample.Codec
+	nI, nO int
+	b      int
+	wbuf   []byte
+	rbuf   []byte
+	tmp    []float64
+	fn     int64
+	pstart time.Time
+
+	mu  sync.Mutex // serializes SendReceive and Close
+	err error
+}
+
+func newDuplex(c *conn, k *ctl, iv, ov sound.Form, co sample.Codec, b int) *duplex {
+	nI, nO := iv.Channels(), ov.Channels()
+	return &duplex{
+		Form: ov,
+		c:    c,
+		k:    k,
+		co:   co,
+		nI:   nI,
+		nO:   nO,
+		b:    b,
+		wbuf: make([]byte, 8+b*nO*co.Bytes()),
+		rbuf: make([]byte, 8+b*nI*co.Bytes()),
+		tmp:  make([]float64, b*(nI+nO))}
+}
+
+func (x *duplex) InChannels() int {
+	return x.nI
+}
+
+func (x *duplex) OutChannels() int {
+	return x.nO
+}
+
+// SendReceive sends out to the Server and receives the data captured
+// meanwhile in in, in round trips of at most the buffer size.
+func (x *duplex) SendReceive(out, in []float64) (int, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.err != nil {
+		return 0, x.err
+	}
+	if len(out)%x.nO != 0 || len(in)%x.nI != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := len(out) / x.nO
+	if len(in)/x.nI != nF {
+		return 0, sound.ErrFrameAlignment
+	}
+	f := 0
+	for f < nF {
+		k := nF - f
+		if k > x.b {
+			k = x.b
+		}
+		putInt64(x.wbuf, x.fn)
+		encode(x.co, x.wbuf[8:], out, x.nO, nF, f, k, x.tmp)
+		if x.err = x.c.write(msgData, x.wbuf[:8+k*x.nO*x.co.Bytes()]); x.err != nil {
+			break
+		}
+		x.fn += int64(k)
+		n, err := x.receive(in, nF, f)
+		if err != nil {
+			x.err = err
+			break
+		}
+		f += n
+		if n < k {
+			break
+		}
+	}
+	if f == 0 && x.err != nil {
+		return 0, x.err
+	}
+	return f, nil
+}
+
+// receive receives the reply to a buffer sent into in from frame f.
+func (x *duplex) receive(in []float64, nF, f int) (int, error) {
+	for {
+		t, m, err := x.c.read(x.rbuf)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case t == msgStart && len(m) == 8:
+			x.pstart = x.k.local(getInt64(m))
+		case t == msgData && len(m) >= 8 && len(m) <= len(x.rbuf):
+			if (len(m)-8)%(x.nI*x.co.Bytes()) != 0 || (len(m)-8)/(x.nI*x.co.Bytes()) > nF-f {
+				return 0, ErrProtocol
+			}
+			return decode(x.co, in, x.nI, nF, f, m[8:], x.tmp), nil
+		case t == msgEnd:
+			var a ack
+			json.Unmarshal(m, &a)
+			if err := peerErr(a.Err); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		default:
+			return 0, ErrProtocol
+		}
+	}
+}
+
+// Close closes the duplex stream of the Server.
+func (x *duplex) Close() error {
+	// bound a concurrent SendReceive waiting for the Server.
+	x.c.SetReadDeadline(time.Now().Add(x.k.timeout))
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	defer x.c.Close()
+	if x.err != nil {
+		if x.err == io.EOF {
+			return nil
+		}
+		return x.err
+	}
+	x.err = errors.New("remote: duplex closed")
+	if err := x.c.write(msgEnd); err != nil {
+		return err
+	}
+	for {
+		t, m, err := x.c.read(x.rbuf)
+		if err != nil {
+			return err
+		}
+		if t == msgEnd {
+			var a ack
+			if err := json.Unmarshal(m, &a); err != nil {
+				return err
+			}
+			return peerErr(a.Err)
+		}
+	}
+}
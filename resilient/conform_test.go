@@ -0,0 +1,16 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package resilient
+
+import (
+	"testing"
+
+	"zikichombo.org/sio/host/hosttest"
+)
+
+func TestConformance(t *testing.T) {
+	e, _, clean := pipeEntry(t)
+	defer clean()
+	hosttest.Test(t, New(e, nil), &hosttest.Config{BufSize: 64})
+}
@@ -0,0 +1,26 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package resilient provides a host.Entry whose streams survive the
+// temporary loss of their device.
+//
+// An Entry decorates a host.Entry.  When Send, Receive or SendReceive on
+// one of its streams fails because the device went away, for example a
+// USB interface which dropped out for a moment, the stream closes the
+// failed stream, waits for a device with the same UID to reappear, reopens
+// it with the same form, sample codec and buffer size, and retries the
+// call.  The caller only sees the call take longer.
+//
+// The gap is reported as an xrun, streams implement
+//
+//	interface { Xruns() int64 }
+//
+// and as Events sent to Policy.Events.  The number of reopen attempts and
+// the delay between them are bounded by a Policy, after which the stream
+// fails with the error which caused the loss.
+//
+// A source which ends with io.EOF is considered lost only if its device is
+// no longer listed by the entry, or if Policy.EOFIsLoss is set.
+//
+// Package resilient is part of http://zikichombo.org
+package resilient /* import "zikichombo.org/sio/resilient" */
@@ -0,0 +1,177 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package resilient
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// ErrClosed is returned by calls on a stream which is closed.
+var ErrClosed = errors.New("stream closed")
+
+const (
+	// DefaultMaxRetries is the default of Policy.MaxRetries.
+	DefaultMaxRetries = 20
+	// DefaultBackoff is the default of Policy.Backoff.
+	DefaultBackoff = 50 * time.Millisecond
+	// DefaultMaxBackoff is the default of Policy.MaxBackoff.
+	DefaultMaxBackoff = time.Second
+)
+
+// Policy configures how streams recover from the loss of their device.  A
+// nil *Policy uses the defaults.
+type Policy struct {
+	// MaxRetries is the number of times the device is looked for and
+	// reopened after a loss before giving up.  0 means DefaultMaxRetries,
+	// negative values retry forever.
+	MaxRetries int
+
+	// Backoff is the delay after the first failed attempt, doubling after
+	// each further attempt up to MaxBackoff.  A device connection
+	// notification cuts the delay short.  0 means DefaultBackoff and
+	// DefaultMaxBackoff.
+	Backoff, MaxBackoff time.Duration
+
+	// EOFIsLoss makes io.EOF from a source a loss even if its device is
+	// still listed, as for live capture devices which never end.
+	EOFIsLoss bool
+
+	// Events, if not nil, receives an Event for each loss, recovery and
+	// failure to recover.  Events are dropped if Events isn't ready.
+	Events chan<- *Event
+}
+
+func (p *Policy) withDefaults() Policy {
+	var res Policy
+	if p != nil {
+		res = *p
+	}
+	if res.MaxRetries == 0 {
+		res.MaxRetries = DefaultMaxRetries
+	}
+	if res.Backoff <= 0 {
+		res.Backoff = DefaultBackoff
+	}
+	if res.MaxBackoff <= 0 {
+		res.MaxBackoff = DefaultMaxBackoff
+	}
+	if res.MaxBackoff < res.Backoff {
+		res.MaxBackoff = res.Backoff
+	}
+	return res
+}
+
+// EventKind is the kind of an Event.
+type EventKind int
+
+const (
+	// Lost indicates a stream lost its device.
+	Lost EventKind = iota
+	// Recovered indicates a stream reopened its device.
+	Recovered
+	// GaveUp indicates a stream failed to reopen its device and failed.
+	GaveUp
+)
+
+var kindNames = [...]string{"lost", "recovered", "gave up"}
+
+func (k EventKind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("EventKind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// Event describes the loss of a device by a stream and its outcome.
+type Event struct {
+	Kind EventKind
+	// Op is "Source", "Sink" or "Duplex".
+	Op string
+	// Dev is the device of the stream, nil for entries without devices.
+	Dev *libsio.Dev
+	// Err is the error which caused the loss, or for GaveUp the last
+	// error reopening the device.
+	Err error
+	// Attempts is the number of attempts made to reopen the device.
+	Attempts int
+	// Gap is the time between the loss and the event.
+	Gap time.Duration
+}
+
+func (ev *Event) String() string {
+	dev := "-"
+	if ev.Dev != nil {
+		dev = ev.Dev.Name
+	}
+	return fmt.Sprintf("%s %s %s after %d attempts, %s: %v", ev.Op, dev, ev.Kind, ev.Attempts, ev.Gap, ev.Err)
+}
+
+// Entry is a host.Entry whose streams reopen their device when it is lost.
+type Entry struct {
+	host.Entry
+	p Policy
+}
+
+// New creates an Entry wrapping e whose streams recover according to p.
+func New(e host.Entry, p *Policy) *Entry {
+	return &Entry{Entry: e, p: p.withDefaults()}
+}
+
+func (r *Entry) OpenSource(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Source, time.Time, error) {
+	src, t, err := r.Entry.OpenSource(d, v, co, b)
+	if err != nil {
+		return nil, t, err
+	}
+	s := &source{}
+	s.init(r, "Source", d, src, func(d *libsio.Dev) (sound.Closer, error) {
+		src, _, err := r.Entry.OpenSource(d, v, co, b)
+		return src, err
+	})
+	s.Form = v
+	return s, t, nil
+}
+
+func (r *Entry) OpenSink(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Sink, *time.Time, error) {
+	snk, t, err := r.Entry.OpenSink(d, v, co, b)
+	if err != nil {
+		return nil, t, err
+	}
+	s := &sink{}
+	s.init(r, "Sink", d, snk, func(d *libsio.Dev) (sound.Closer, error) {
+		snk, _, err := r.Entry.OpenSink(d, v, co, b)
+		return snk, err
+	})
+	s.Form = v
+	return s, t, nil
+}
+
+func (r *Entry) OpenDuplex(d *libsio.Dev, iv, ov sound.Form, co sample.Codec, b int) (sound.Duplex, time.Time, *time.Time, error) {
+	dpx, ti, to, err := r.Entry.OpenDuplex(d, iv, ov, co, b)
+	if err != nil {
+		return nil, ti, to, err
+	}
+	s := &duplex{inC: iv.Channels(), outC: ov.Channels()}
+	s.init(r, "Duplex", d, dpx, func(d *libsio.Dev) (sound.Closer, error) {
+		dpx, _, _, err := r.Entry.OpenDuplex(d, iv, ov, co, b)
+		return dpx, err
+	})
+	s.Form = dpx
+	return s, ti, to, nil
+}
+
+// find returns the device of r matching d, by UID if d has one and
+// otherwise by name, or nil if there is none.
+func (r *Entry) find(d *libsio.Dev) *libsio.Dev {
+	if d.UID != "" {
+		return host.DevByUID(r.Entry, d.UID)
+	}
+	return host.DevByName(r.Entry, d.Name)
+}
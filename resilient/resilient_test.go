@@ -0,0 +1,220 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package resilient
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"zikichombo.org/sio/fault"
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sio/pipe"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+var form = sound.NewForm(8000*freq.Hertz, 1)
+
+// pipeEntry returns a pipe entry with a device reading 1000 frames and
+// writing to a file.
+func pipeEntry(t *testing.T) (*pipe.Entry, *libsio.Dev, func()) {
+	dir, err := ioutil.TempDir("", "resilient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := filepath.Join(dir, "in")
+	if err := ioutil.WriteFile(in, make([]byte, 8000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := pipe.NewEntry()
+	dev := e.AddPath(&pipe.Path{Name: "dev", In: in, Out: filepath.Join(dir, "out")})
+	return e, dev, func() { os.RemoveAll(dir) }
+}
+
+func nextEvent(t *testing.T, c <-chan *Event, k EventKind) *Event {
+	select {
+	case ev := <-c:
+		if ev.Kind != k {
+			t.Fatalf("got %s, want %s", ev, k)
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for %s", k)
+	}
+	return nil
+}
+
+func TestSinkRecover(t *testing.T) {
+	e, d, done := pipeEntry(t)
+	defer done()
+	f := fault.New(e, fault.Rule{Kind: fault.Disconnect, Ops: fault.OpSend, At: 3})
+	evC := make(chan *Event, 4)
+	r := New(f, &Policy{Backoff: 5 * time.Millisecond, Events: evC})
+	snk, _, err := r.OpenSink(d, form, sample.SFloat64L, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snk.Close()
+	lostC := make(chan *Event, 1)
+	go func() {
+		ev := <-evC
+		lostC <- ev
+		time.Sleep(20 * time.Millisecond)
+		f.Reconnect(d)
+	}()
+	buf := make([]float64, 10)
+	for i := 0; i < 5; i++ {
+		if err := snk.Send(buf); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	if ev := <-lostC; ev.Kind != Lost {
+		t.Errorf("got %s, want lost", ev)
+	}
+	ev := nextEvent(t, evC, Recovered)
+	if ev.Dev != d || ev.Op != "Sink" || ev.Err != fault.ErrDisconnected || ev.Attempts < 2 || ev.Gap < 20*time.Millisecond {
+		t.Errorf("got %s", ev)
+	}
+	if n := snk.(interface{ Xruns() int64 }).Xruns(); n != 1 {
+		t.Errorf("%d xruns", n)
+	}
+}
+
+func TestGiveUp(t *testing.T) {
+	e, d, done := pipeEntry(t)
+	defer done()
+	f := fault.New(e, fault.Rule{Kind: fault.Disconnect, Ops: fault.OpReceive, At: 2})
+	evC := make(chan *Event, 4)
+	r := New(f, &Policy{MaxRetries: 3, Backoff: time.Millisecond, Events: evC})
+	src, _, err := r.OpenSource(d, form, sample.SFloat64L, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	buf := make([]float64, 10)
+	if _, err := src.Receive(buf); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := src.Receive(buf); err != fault.ErrDisconnected {
+			t.Errorf("got %v, want ErrDisconnected", err)
+		}
+	}
+	nextEvent(t, evC, Lost)
+	if ev := nextEvent(t, evC, GaveUp); ev.Attempts != 3 || ev.Err != host.ErrNoDevice {
+		t.Errorf("got %s", ev)
+	}
+}
+
+func TestSourceEOF(t *testing.T) {
+	for _, loss := range []bool{false, true} {
+		e, d, done := pipeEntry(t)
+		f := fault.New(e, fault.Rule{Kind: fault.EOF, Ops: fault.OpReceive, At: 2})
+		r := New(f, &Policy{EOFIsLoss: loss})
+		src, _, err := r.OpenSource(d, form, sample.SFloat64L, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]float64, 10)
+		for i := 0; i < 3; i++ {
+			_, err := src.Receive(buf)
+			if i == 0 || loss {
+				if err != nil {
+					t.Errorf("EOFIsLoss %t receive %d: %v", loss, i, err)
+				}
+				continue
+			}
+			if err != io.EOF {
+				t.Errorf("EOFIsLoss %t receive %d: got %v, want io.EOF", loss, i, err)
+			}
+		}
+		src.Close()
+		done()
+	}
+}
+
+func TestCloseWhileRecovering(t *testing.T) {
+	e, d, done := pipeEntry(t)
+	defer done()
+	f := fault.New(e, fault.Rule{Kind: fault.Disconnect, Ops: fault.OpSend, At: 1})
+	evC := make(chan *Event, 4)
+	r := New(f, &Policy{MaxRetries: -1, Backoff: time.Millisecond, Events: evC})
+	snk, _, err := r.OpenSink(d, form, sample.SFloat64L, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errC := make(chan error)
+	go func() {
+		errC <- snk.Send(make([]float64, 10))
+	}()
+	nextEvent(t, evC, Lost)
+	snk.Close()
+	if err := <-errC; err != ErrClosed {
+		t.Errorf("got %v, want ErrClosed", err)
+	}
+}
+
+// shortEntry opens sources which return 5 frames with io.EOF on their
+// second call to Receive.
+type shortEntry struct {
+	host.NullEntry
+	opens int
+}
+
+func (e *shortEntry) CanOpenSource() bool {
+	return true
+}
+
+func (e *shortEntry) OpenSource(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Source, time.Time, error) {
+	e.opens++
+	return &shortSrc{Form: v}, time.Now(), nil
+}
+
+type shortSrc struct {
+	sound.Form
+	n int
+}
+
+func (s *shortSrc) Receive(d []float64) (int, error) {
+	s.n++
+	if s.n == 2 {
+		return 5, io.EOF
+	}
+	return len(d) / s.Channels(), nil
+}
+
+func (s *shortSrc) Close() error {
+	return nil
+}
+
+func TestSourceShortEOF(t *testing.T) {
+	for _, loss := range []bool{false, true} {
+		e := &shortEntry{}
+		r := New(e, &Policy{EOFIsLoss: loss, Backoff: time.Millisecond})
+		src, _, err := r.OpenSource(nil, form, sample.SFloat64L, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]float64, 10)
+		for i, want := range []int{10, 5} {
+			if n, err := src.Receive(buf); n != want || err != nil {
+				t.Errorf("EOFIsLoss %t receive %d: got %d, %v want %d, nil", loss, i, n, err, want)
+			}
+		}
+		n, err := src.Receive(buf)
+		switch {
+		case loss && (n != 10 || err != nil || e.opens != 2):
+			t.Errorf("EOFIsLoss %t: got %d, %v after %d opens, want recovery", loss, n, err, e.opens)
+		case !loss && (n != 0 || err != io.EOF):
+			t.Errorf("EOFIsLoss %t: got %d, %v want 0, io.EOF", loss, n, err)
+		}
+		src.Close()
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package resilient
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+)
+
+// stream holds the state of a stream shared by sources, sinks and
+// duplexes.
+type stream struct {
+	r    *Entry
+	op   string
+	dev  *libsio.Dev
+	open func(*libsio.Dev) (sound.Closer, error)
+
+	mu     sync.Mutex
+	cur    sound.Closer
+	closed bool
+	err    error // sticky error once recovery gave up
+	xruns  int64 // recoveries and xruns of replaced streams
+	doneC  chan struct{}
+}
+
+type xrunner interface {
+	Xruns() int64
+}
+
+func (s *stream) init(r *Entry, op string, d *libsio.Dev, cur sound.Closer, open func(*libsio.Dev) (sound.Closer, error)) {
+	s.r = r
+	s.op = op
+	s.dev = d
+	s.cur = cur
+	s.open = open
+	s.doneC = make(chan struct{})
+}
+
+// current returns the current underlying stream, or the error with which
+// calls fail.
+func (s *stream) current() (sound.Closer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.cur, nil
+}
+
+// Xruns returns the number of losses recovered from, plus the xruns
+// reported by the underlying streams if they implement Xruns.
+func (s *stream) Xruns() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.xruns
+	if x, ok := s.cur.(xrunner); ok {
+		n += x.Xruns()
+	}
+	return n
+}
+
+// lost returns whether err from the underlying stream means the device was
+// lost.
+func (s *stream) lost(err error) bool {
+	if err != io.EOF || s.r.p.EOFIsLoss {
+		return true
+	}
+	return s.dev != nil && s.r.find(s.dev) == nil
+}
+
+// recover recovers from err, returned by the underlying stream c.  It
+// returns nil if the device was reopened, and otherwise the error with
+// which the call fails.
+func (s *stream) recover(c sound.Closer, err error) error {
+	s.mu.Lock()
+	switch {
+	case s.closed:
+		s.mu.Unlock()
+		return ErrClosed
+	case s.err != nil:
+		s.mu.Unlock()
+		return s.err
+	case s.cur != c:
+		// recovered by a concurrent call.
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+	if !s.lost(err) {
+		return err
+	}
+	start := time.Now()
+	s.event(&Event{Kind: Lost, Err: err})
+	c.Close()
+	devC := make(chan *host.DevChange, 8)
+	if s.r.Entry.DevicesNotify(devC) == nil {
+		defer s.r.Entry.DevicesNotifyClose(devC)
+	} else {
+		devC = nil
+	}
+	p := &s.r.p
+	delay := p.Backoff
+	last := err
+	for i := 1; p.MaxRetries < 0 || i <= p.MaxRetries; i++ {
+		nc, oerr := s.reopen()
+		if oerr == nil {
+			s.mu.Lock()
+			if s.closed {
+				s.mu.Unlock()
+				nc.Close()
+				return ErrClosed
+			}
+			if x, ok := s.cur.(xrunner); ok {
+				s.xruns += x.Xruns()
+			}
+			s.xruns++
+			s.cur = nc
+			s.mu.Unlock()
+			s.event(&Event{Kind: Recovered, Err: err, Attempts: i, Gap: time.Since(start)})
+			return nil
+		}
+		last = oerr
+		if !s.wait(devC, delay) {
+			return ErrClosed
+		}
+		delay *= 2
+		if delay > p.MaxBackoff {
+			delay = p.MaxBackoff
+		}
+	}
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+	s.event(&Event{Kind: GaveUp, Err: last, Attempts: p.MaxRetries, Gap: time.Since(start)})
+	return err
+}
+
+// reopen reopens the device of s, if it is listed.
+func (s *stream) reopen() (sound.Closer, error) {
+	d := s.dev
+	if d != nil {
+		if d = s.r.find(d); d == nil {
+			return nil, host.ErrNoDevice
+		}
+	}
+	return s.open(d)
+}
+
+// wait waits for d or a device connection notification on devC.  It
+// returns false if s is closed.
+func (s *stream) wait(devC <-chan *host.DevChange, d time.Duration) bool {
+	tm := time.NewTimer(d)
+	defer tm.Stop()
+	for {
+		select {
+		case ch := <-devC:
+			if ch.Sense == host.DeviceConnect {
+				return true
+			}
+		case <-tm.C:
+			return true
+		case <-s.doneC:
+			return false
+		}
+	}
+}
+
+func (s *stream) event(ev *Event) {
+	if s.r.p.Events == nil {
+		return
+	}
+	ev.Op = s.op
+	ev.Dev = s.dev
+	select {
+	case s.r.p.Events <- ev:
+	default:
+	}
+}
+
+func (s *stream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.doneC)
+	c := s.cur
+	s.mu.Unlock()
+	return c.Close()
+}
+
+type source struct {
+	sound.Form
+	stream
+	pend error // error returned with frames, handled on the next call
+}
+
+func (s *source) Receive(d []float64) (int, error) {
+	if len(d)%s.Channels() != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	for {
+		c, err := s.current()
+		if err != nil {
+			return 0, err
+		}
+		n := 0
+		if s.pend != nil {
+			err, s.pend = s.pend, nil
+		} else {
+			n, err = c.(sound.Source).Receive(d)
+		}
+		if err == nil {
+			return n, nil
+		}
+		if n > 0 {
+			s.pend = err
+			return n, nil
+		}
+		if err := s.recover(c, err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+type sink struct {
+	sound.Form
+	stream
+}
+
+func (s *sink) Send(d []float64) error {
+	if len(d)%s.Channels() != 0 {
+		return sound.ErrChannelAlignment
+	}
+	for {
+		c, err := s.current()
+		if err != nil {
+			return err
+		}
+		err = c.(sound.Sink).Send(d)
+		if err == nil {
+			return nil
+		}
+		if err := s.recover(c, err); err != nil {
+			return err
+		}
+	}
+}
+
+type duplex struct {
+	sound.Form
+	stream
+	inC, outC int
+}
+
+func (s *duplex) InChannels() int {
+	return s.inC
+}
+
+func (s *duplex) OutChannels() int {
+	return s.outC
+}
+
+func (s *duplex) SendReceive(out, in []float64) (int, error) {
+	if len(out)%s.outC != 0 || len(in)%s.inC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	if len(out)/s.outC != len(in)/s.inC {
+		return 0, sound.ErrFrameAlignment
+	}
+	for {
+		c, err := s.current()
+		if err != nil {
+			return 0, err
+		}
+		n, err := c.(sound.Duplex).SendReceive(out, in)
+		if err == nil {
+			return n, nil
+		}
+		if err := s.recover(c, err); err != nil {
+			return 0, err
+		}
+	}
+}
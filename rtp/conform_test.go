@@ -0,0 +1,34 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package rtp
+
+import (
+	"net"
+	"testing"
+
+	"zikichombo.org/sio/host/hosttest"
+)
+
+func TestConformance(t *testing.T) {
+	lo := net.IPv4(127, 0, 0, 1)
+	rc, err := net.ListenUDP("udp", &net.UDPAddr{IP: lo})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer rc.Close()
+	go func() {
+		buf := make([]byte, 1<<16)
+		for {
+			if _, _, err := rc.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+	e := NewEntry()
+	e.AddEndpoint(&Endpoint{
+		Name:   "loop",
+		Local:  &net.UDPAddr{IP: lo},
+		Remote: rc.LocalAddr().(*net.UDPAddr)})
+	hosttest.Test(t, e, &hosttest.Config{BufSize: 64})
+}
@@ -0,0 +1,16 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package rtp provides a host.Entry streaming audio over RTP/UDP.
+//
+// Devices of the entry are remote endpoints configured with AddEndpoint.
+// Sinks send RTP packets with an L16 or L24 payload (RFC 3551, RFC 3190) and
+// sources receive them via a jitter buffer which reorders packets, drops
+// duplicates and late packets, and fills sequence number gaps with silence.
+// RTP timestamps are converted to libsio.Packet frame numbers.
+//
+// Package rtp registers the entry Default under the name "RTP" on
+// initialisation.
+//
+// Package rtp is part of http://zikichombo.org
+package rtp /* import "zikichombo.org/sio/rtp" */
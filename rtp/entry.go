@@ -0,0 +1,281 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package rtp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Endpoint describes a remote RTP endpoint.  An Endpoint is added to an
+// Entry with AddEndpoint, which makes it available as a libsio.Dev.
+type Endpoint struct {
+	// Name is the name of the device.
+	Name string
+
+	// Remote is the address to which sinks send packets.  For sources,
+	// if Remote is not nil then only packets from the IP address of
+	// Remote are accepted.
+	Remote *net.UDPAddr
+
+	// Local is the local address on which sources receive packets.  If
+	// Local is nil for a sink, an ephemeral port is used.
+	Local *net.UDPAddr
+
+	// Conn, if not nil, is used instead of binding Local.  The stream
+	// opened with the endpoint takes ownership of Conn and closes it
+	// when closed, so Conn may be used with only one stream.
+	Conn net.PacketConn
+
+	// PayloadType is the RTP payload type, 96 (the first dynamic type) if 0.
+	PayloadType uint8
+
+	// Jitter is the delay that sources apply to absorb network
+	// jitter, 40ms if 0.
+	Jitter time.Duration
+
+	// Timeout is how long a source waits without receiving packets
+	// before reporting io.EOF, 2s if 0.
+	Timeout time.Duration
+
+	// NoPace disables pacing of packets sent by sinks in real time.  By
+	// default, sinks send each packet at the time of its first sample so
+	// that a faster than real time producer does not overflow the
+	// receiver's jitter buffer.
+	NoPace bool
+}
+
+func (ep *Endpoint) payloadType() uint8 {
+	if ep.PayloadType == 0 {
+		return 96
+	}
+	return ep.PayloadType
+}
+
+func (ep *Endpoint) jitter() time.Duration {
+	if ep.Jitter == 0 {
+		return 40 * time.Millisecond
+	}
+	return ep.Jitter
+}
+
+func (ep *Endpoint) timeout() time.Duration {
+	if ep.Timeout == 0 {
+		return 2 * time.Second
+	}
+	return ep.Timeout
+}
+
+func (ep *Endpoint) listen() (net.PacketConn, error) {
+	if ep.Conn != nil {
+		return ep.Conn, nil
+	}
+	return net.ListenUDP("udp", ep.Local)
+}
+
+// ErrUnknownEndpoint is returned when opening a stream on a device not
+// added with AddEndpoint.
+var ErrUnknownEndpoint = errors.New("rtp: unknown endpoint")
+
+// Entry is a host.Entry whose devices are RTP endpoints.
+type Entry struct {
+	host.NullEntry
+	mu   sync.Mutex
+	eps  map[*libsio.Dev]*Endpoint
+	devs []*libsio.Dev
+	subs host.Notifier
+}
+
+// Default is the Entry registered by package rtp.
+var Default = NewEntry()
+
+// NewEntry creates a new Entry with no endpoints.
+func NewEntry() *Entry {
+	return &Entry{eps: make(map[*libsio.Dev]*Endpoint)}
+}
+
+func init() {
+	if err := host.RegisterEntry(Default); err != nil {
+		log.Printf("zc failed load %s: %s\n", Default.Name(), err.Error())
+	}
+}
+
+// AddEndpoint adds ep to e and returns the corresponding device.
+// Subscribers to device notifications are notified.
+func (e *Entry) AddEndpoint(ep *Endpoint) *libsio.Dev {
+	d := &libsio.Dev{
+		UID:  ep.Name,
+		Name: ep.Name,
+		In:   caps,
+		Out:  caps}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.eps[d] = ep
+	e.devs = append(e.devs, d)
+	e.subs.Notify(&host.DevChange{Sense: host.DeviceConnect, Dev: d})
+	return d
+}
+
+// RemoveEndpoint removes the device d from e.  Streams already open on d
+// are unaffected.  Subscribers to device notifications are notified.
+func (e *Entry) RemoveEndpoint(d *libsio.Dev) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.eps[d]; !ok {
+		return
+	}
+	delete(e.eps, d)
+	for i, o := range e.devs {
+		if o == d {
+			e.devs = append(e.devs[:i], e.devs[i+1:]...)
+			break
+		}
+	}
+	e.subs.Notify(&host.DevChange{Sense: host.DeviceDisconnect, Dev: d})
+}
+
+// Endpoint returns the Endpoint of d, or nil if d is not a device of e.
+func (e *Entry) Endpoint(d *libsio.Dev) *Endpoint {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.eps[d]
+}
+
+// caps are the capabilities of endpoints in either direction.
+var caps = libsio.Caps{
+	MinChannels: 1,
+	MaxChannels: libsio.MaxChannels,
+	RateRanges:  []libsio.RateRange{{Min: 8000 * freq.Hertz, Max: 192000 * freq.Hertz}},
+	Codecs:      []sample.Codec{sample.SInt16B, sample.SInt24B}}
+
+func (e *Entry) Name() string {
+	return "RTP"
+}
+
+func (e *Entry) DefaultSampleCodec() sample.Codec {
+	return sample.SInt16B
+}
+
+func (e *Entry) CanOpenSource() bool {
+	return true
+}
+
+// OpenSource opens a source receiving RTP packets at the endpoint of d.
+// The returned sound.Source is a *Source.  The source starts when it is
+// opened, it delivers silence until the first packet is played out, the
+// jitter delay after its arrival.
+func (e *Entry) OpenSource(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Source, time.Time, error) {
+	var t time.Time
+	ep := e.Endpoint(d)
+	if ep == nil {
+		return nil, t, ErrUnknownEndpoint
+	}
+	wco, err := wireCodec(co)
+	if err != nil {
+		return nil, t, err
+	}
+	s, err := newSrc(ep, v, wco, b)
+	if err != nil {
+		return nil, t, err
+	}
+	raw := libsio.RawInputSource(s)
+	return &Source{Source: raw, raw: raw.(libsio.RawSource), s: s}, s.start, nil
+}
+
+func (e *Entry) CanOpenSink() bool {
+	return true
+}
+
+// OpenSink opens a sink sending RTP packets to the endpoint of d.  Each
+// packet carries at most b frames, fewer if b frames would exceed a common
+// MTU.
+func (e *Entry) OpenSink(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Sink, *time.Time, error) {
+	ep := e.Endpoint(d)
+	if ep == nil {
+		return nil, nil, ErrUnknownEndpoint
+	}
+	if ep.Remote == nil {
+		return nil, nil, fmt.Errorf("rtp: endpoint %s has no remote address", ep.Name)
+	}
+	wco, err := wireCodec(co)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := newSnk(ep, v, wco, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return libsio.RawOutputSink(s), &s.start, nil
+}
+
+func (e *Entry) HasDevices() bool {
+	return true
+}
+
+func (e *Entry) ScanDevices() ([]*host.DevScanResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	res := make([]*host.DevScanResult, len(e.devs))
+	for i, d := range e.devs {
+		res[i] = &host.DevScanResult{Dev: d}
+	}
+	return res, nil
+}
+
+func (e *Entry) Devices() []*libsio.Dev {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	res := make([]*libsio.Dev, len(e.devs))
+	copy(res, e.devs)
+	return res
+}
+
+func (e *Entry) DevicesNotify(c chan<- *host.DevChange) error {
+	e.subs.Add(c)
+	return nil
+}
+
+func (e *Entry) DevicesNotifyClose(c chan<- *host.DevChange) {
+	e.subs.Remove(c)
+}
+
+// DefaultInputDev returns the first endpoint added to e, if any.
+func (e *Entry) DefaultInputDev() *libsio.Dev {
+	return e.first()
+}
+
+// DefaultOutputDev returns the first endpoint added to e, if any.
+func (e *Entry) DefaultOutputDev() *libsio.Dev {
+	return e.first()
+}
+
+func (e *Entry) first() *libsio.Dev {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.devs) == 0 {
+		return nil
+	}
+	return e.devs[0]
+}
+
+// wireCodec returns the RTP payload sample codec for the requested codec.
+func wireCodec(co sample.Codec) (sample.Codec, error) {
+	switch co {
+	case sample.SInt16L, sample.SInt16B:
+		return sample.SInt16B, nil
+	case sample.SInt24L, sample.SInt24B:
+		return sample.SInt24B, nil
+	}
+	return co, fmt.Errorf("rtp: unsupported sample codec %s, use 16 or 24 bit integers", co)
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package rtp
+
+// Stats holds counters of a receiving RTP stream.
+type Stats struct {
+	Received  int64 // packets accepted into the jitter buffer.
+	Lost      int64 // packets never received, replaced by silence.
+	Late      int64 // packets arriving after their playout time.
+	Duplicate int64 // packets received more than once.
+	Overflow  int64 // packets dropped because the jitter buffer was full.
+	Underruns int64 // times the jitter buffer was empty at playout time.
+	Invalid   int64 // packets which could not be parsed or had the wrong format.
+}
+
+type jslot struct {
+	valid bool
+	seq   int64
+	ts    int64
+	d     []byte
+}
+
+// jitter is a jitter buffer of RTP payloads, ordered by extended sequence
+// number.
+//
+// Sequence numbers and timestamps are extended to 64 bits relative to the
+// packet with the highest sequence number so far, so wrapping is handled.
+//
+// The buffer is played out by pop, which is called at the time the next
+// frames are due.  Frames are identified by their extended RTP timestamp.
+type jitter struct {
+	bpf    int     // bytes per frame
+	slots  []jslot // indexed by seq modulo len(slots)
+	maxGap int64   // gaps in timestamps larger than this are skipped rather than filled.
+	n      int     // number of valid slots
+
+	init    bool
+	maxSeq  int64 // highest extended seq so far
+	refTs   int64 // extended ts of the packet with maxSeq
+	ts0     int64 // extended ts of the first packet, frame 0.
+	nextSeq int64 // seq of the packet to play next
+	nextTs  int64 // ts of the frame to play next
+
+	stats Stats
+}
+
+func newJitter(bpf, nSlots, slotBytes int, maxGap int64) *jitter {
+	j := &jitter{
+		bpf:    bpf,
+		slots:  make([]jslot, nSlots),
+		maxGap: maxGap}
+	for i := range j.slots {
+		j.slots[i].d = make([]byte, 0, slotBytes)
+	}
+	return j
+}
+
+// push adds a packet to the buffer.
+func (j *jitter) push(seq uint16, ts uint32, payload []byte) {
+	if !j.init {
+		j.init = true
+		j.maxSeq = int64(seq)
+		j.refTs = int64(ts)
+		j.ts0 = j.refTs
+		j.nextSeq = j.maxSeq
+		j.nextTs = j.refTs
+	}
+	ext := j.maxSeq + int64(int16(seq-uint16(j.maxSeq)))
+	ets := j.refTs + int64(int32(ts-uint32(j.refTs)))
+	if ext < j.nextSeq {
+		j.stats.Late++
+		return
+	}
+	if ext >= j.nextSeq+int64(len(j.slots)) {
+		j.stats.Overflow++
+		return
+	}
+	s := &j.slots[ext%int64(len(j.slots))]
+	if s.valid {
+		j.stats.Duplicate++
+		return
+	}
+	if len(payload) > cap(s.d) {
+		j.stats.Invalid++
+		return
+	}
+	s.valid = true
+	s.seq = ext
+	s.ts = ets
+	s.d = append(s.d[:0], payload...)
+	j.n++
+	j.stats.Received++
+	if ext > j.maxSeq {
+		j.maxSeq = ext
+		j.refTs = ets
+	}
+}
+
+// frame returns the frame number of the next frame to be played.
+func (j *jitter) frame() int64 {
+	return j.nextTs - j.ts0
+}
+
+// pop places the next frames in dst, at most cap(dst) / j.bpf of them,
+// and returns the number of frames placed.  Missing data is filled with
+// silence, in which case pop returns true as its second result.
+func (j *jitter) pop(dst []byte) (int, bool) {
+	dst = dst[:cap(dst)]
+	maxF := int64(len(dst) / j.bpf)
+	for j.n > 0 {
+		s := &j.slots[j.nextSeq%int64(len(j.slots))]
+		if s.valid && s.seq == j.nextSeq {
+			frames := int64(len(s.d) / j.bpf)
+			off := j.nextTs - s.ts
+			if off < 0 {
+				// timestamp gap without sequence gap, such as with
+				// silence suppression.
+				if -off > j.maxGap {
+					j.nextTs = s.ts
+					continue
+				}
+				return j.silence(dst, min64(-off, maxF)), true
+			}
+			if off >= frames {
+				j.drop(s)
+				continue
+			}
+			k := min64(frames-off, maxF)
+			copy(dst, s.d[off*int64(j.bpf):(off+k)*int64(j.bpf)])
+			j.nextTs += k
+			if off+k == frames {
+				j.drop(s)
+			}
+			return int(k), false
+		}
+		// packet at nextSeq is missing, find the next one present.
+		var later *jslot
+		for q := j.nextSeq + 1; q <= j.maxSeq; q++ {
+			t := &j.slots[q%int64(len(j.slots))]
+			if t.valid && t.seq == q {
+				later = t
+				break
+			}
+		}
+		if later == nil {
+			break
+		}
+		gap := later.ts - j.nextTs
+		if gap <= 0 || gap > j.maxGap {
+			j.stats.Lost += later.seq - j.nextSeq
+			j.nextSeq = later.seq
+			if gap > 0 {
+				j.nextTs = later.ts
+			}
+			continue
+		}
+		k := min64(gap, maxF)
+		if k == gap {
+			j.stats.Lost += later.seq - j.nextSeq
+			j.nextSeq = later.seq
+		}
+		return j.silence(dst, k), true
+	}
+	j.stats.Underruns++
+	return j.silence(dst, maxF), true
+}
+
+func (j *jitter) drop(s *jslot) {
+	s.valid = false
+	j.n--
+	j.nextSeq++
+}
+
+func (j *jitter) silence(dst []byte, k int64) int {
+	d := dst[:k*int64(j.bpf)]
+	for i := range d {
+		d[i] = 0
+	}
+	j.nextTs += k
+	return int(k)
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	rtpVersion = 2
+	headerSize = 12
+
+	// maxPayload is the maximum payload size we send, to stay below
+	// common MTUs.
+	maxPayload = 1400
+)
+
+var errBadPacket = errors.New("rtp: malformed packet")
+
+// header is the fixed part of an RTP header.  We never send contributing
+// sources or extensions, but skip them when receiving.
+type header struct {
+	marker bool
+	pt     uint8
+	seq    uint16
+	ts     uint32
+	ssrc   uint32
+}
+
+// put writes h to the first headerSize bytes of d.
+func (h *header) put(d []byte) {
+	d[0] = rtpVersion << 6
+	d[1] = h.pt & 0x7f
+	if h.marker {
+		d[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(d[2:], h.seq)
+	binary.BigEndian.PutUint32(d[4:], h.ts)
+	binary.BigEndian.PutUint32(d[8:], h.ssrc)
+}
+
+// parse parses the header of the RTP packet d and returns its payload.
+func (h *header) parse(d []byte) ([]byte, error) {
+	if len(d) < headerSize || d[0]>>6 != rtpVersion {
+		return nil, errBadPacket
+	}
+	pad := d[0]&0x20 != 0
+	ext := d[0]&0x10 != 0
+	cc := int(d[0] & 0x0f)
+	h.marker = d[1]&0x80 != 0
+	h.pt = d[1] & 0x7f
+	h.seq = binary.BigEndian.Uint16(d[2:])
+	h.ts = binary.BigEndian.Uint32(d[4:])
+	h.ssrc = binary.BigEndian.Uint32(d[8:])
+	p := headerSize + 4*cc
+	if p > len(d) {
+		return nil, errBadPacket
+	}
+	if ext {
+		if p+4 > len(d) {
+			return nil, errBadPacket
+		}
+		p += 4 + 4*int(binary.BigEndian.Uint16(d[p+2:]))
+		if p > len(d) {
+			return nil, errBadPacket
+		}
+	}
+	e := len(d)
+	if pad {
+		n := int(d[e-1])
+		if n == 0 || e-n < p {
+			return nil, errBadPacket
+		}
+		e -= n
+	}
+	return d[p:e], nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package rtp
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+func TestHeader(t *testing.T) {
+	h := header{marker: true, pt: 97, seq: 65535, ts: 1 << 31, ssrc: 42}
+	d := make([]byte, headerSize+4)
+	h.put(d)
+	var g header
+	p, err := g.parse(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g != h || len(p) != 4 {
+		t.Errorf("got %+v, payload %d", g, len(p))
+	}
+	if _, err := g.parse(d[:8]); err != errBadPacket {
+		t.Errorf("short packet: got %v", err)
+	}
+}
+
+// payload gives a 2 frame mono L16 payload whose samples are k.
+func payload(k byte) []byte {
+	return []byte{0, k, 0, k}
+}
+
+func popAll(j *jitter, n int) []byte {
+	var res []byte
+	d := make([]byte, 2)
+	for i := 0; i < n; i++ {
+		j.pop(d)
+		res = append(res, d[1])
+	}
+	return res
+}
+
+func TestJitterReorder(t *testing.T) {
+	j := newJitter(2, 8, 16, 100)
+	j.push(10, 100, payload(1))
+	j.push(12, 104, payload(3))
+	j.push(11, 102, payload(2))
+	j.push(11, 102, payload(2))
+	got := popAll(j, 6)
+	exp := []byte{1, 1, 2, 2, 3, 3}
+	if string(got) != string(exp) {
+		t.Errorf("got %v expected %v", got, exp)
+	}
+	if j.stats.Duplicate != 1 || j.stats.Received != 3 {
+		t.Errorf("stats %+v", j.stats)
+	}
+	j.push(11, 102, payload(2))
+	if j.stats.Late != 1 {
+		t.Errorf("stats %+v", j.stats)
+	}
+}
+
+func TestJitterGap(t *testing.T) {
+	j := newJitter(2, 8, 16, 100)
+	j.push(65535, 0, payload(1))
+	j.push(1, 4, payload(3))
+	got := popAll(j, 7)
+	exp := []byte{1, 1, 0, 0, 3, 3, 0}
+	if string(got) != string(exp) {
+		t.Errorf("got %v expected %v", got, exp)
+	}
+	if j.stats.Lost != 1 || j.stats.Underruns != 1 {
+		t.Errorf("stats %+v", j.stats)
+	}
+	if j.frame() != 7 {
+		t.Errorf("frame %d", j.frame())
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	rc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skip(err)
+	}
+	e := NewEntry()
+	nc := make(chan *host.DevChange, 2)
+	e.DevicesNotify(nc)
+	defer e.DevicesNotifyClose(nc)
+	rd := e.AddEndpoint(&Endpoint{Name: "in", Conn: rc, Jitter: 10 * time.Millisecond, Timeout: 100 * time.Millisecond})
+	sd := e.AddEndpoint(&Endpoint{Name: "out", Remote: rc.LocalAddr().(*net.UDPAddr), NoPace: true})
+	if c := <-nc; c.Dev != rd || c.Sense != host.DeviceConnect {
+		t.Errorf("unexpected change %v", c)
+	}
+	<-nc
+	if len(e.Devices()) != 2 {
+		t.Fatalf("got %d devices", len(e.Devices()))
+	}
+	v := sound.MonoCd()
+	src, _, err := e.OpenSource(rd, v, sample.SInt16L, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	snk, _, err := e.OpenSink(sd, v, sample.SInt16L, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const N = 64 * 20
+	d := make([]float64, N)
+	for i := range d {
+		d[i] = 0.5
+	}
+	if err := snk.Send(d); err != nil {
+		t.Fatal(err)
+	}
+	snk.Close()
+	got := 0
+	buf := make([]float64, 100)
+	for {
+		n, err := src.Receive(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			if buf[i] > 0.49 {
+				got++
+			}
+		}
+	}
+	if got != N {
+		t.Errorf("received %d frames of signal, expected %d", got, N)
+	}
+	st := src.(*Source).Stats()
+	if st.Received != 20 || st.Lost != 0 {
+		t.Errorf("stats %+v", st)
+	}
+	e.RemoveEndpoint(sd)
+	if c := <-nc; c.Dev != sd || c.Sense != host.DeviceDisconnect {
+		t.Errorf("unexpected change %v", c)
+	}
+}
+
+func TestRawSource(t *testing.T) {
+	rc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skip(err)
+	}
+	e := NewEntry()
+	d := e.AddEndpoint(&Endpoint{Name: "in", Conn: rc, Timeout: 100 * time.Millisecond})
+	src, _, err := e.OpenSource(d, sound.MonoCd(), sample.SInt16L, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	rs, ok := src.(libsio.RawSource)
+	if !ok {
+		t.Fatalf("%T isn't a libsio.RawSource", src)
+	}
+	if co := rs.Codec(); co != sample.SInt16B {
+		t.Errorf("got codec %s", co)
+	}
+	// silence until a packet arrives.
+	pkt := &libsio.RawPacket{D: make([]byte, 64*2)}
+	if err := rs.ReceiveRaw(pkt); err != nil {
+		t.Fatal(err)
+	}
+	if len(pkt.D) == 0 || pkt.N != 0 || pkt.Start.IsZero() {
+		t.Errorf("got %d bytes of frame %d at %s", len(pkt.D), pkt.N, pkt.Start)
+	}
+	for _, b := range pkt.D {
+		if b != 0 {
+			t.Fatalf("got %v", pkt.D)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package rtp
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// rtpSnk implements libsio.RawOutput.
+type rtpSnk struct {
+	sound.Form
+	co     sample.Codec
+	bpf    int
+	conn   net.PacketConn
+	remote net.Addr
+	noPace bool
+	hdr    header
+	buf    []byte // header and payload of the packet being sent.
+
+	start time.Time
+
+	pkts  [3]libsio.RawPacket
+	fillC chan *libsio.RawPacket
+	playC chan *libsio.RawPacket
+
+	quit chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func newSnk(ep *Endpoint, v sound.Form, co sample.Codec, b int) (*rtpSnk, error) {
+	bpf := co.Bytes() * v.Channels()
+	nF := b
+	if nF*bpf > maxPayload {
+		nF = maxPayload / bpf
+	}
+	if nF < 1 {
+		return nil, fmt.Errorf("rtp: %d channels of %s exceed the maximum payload", v.Channels(), co)
+	}
+	conn, err := ep.listen()
+	if err != nil {
+		return nil, err
+	}
+	s := &rtpSnk{
+		Form:   v,
+		co:     co,
+		bpf:    bpf,
+		conn:   conn,
+		remote: ep.Remote,
+		noPace: ep.NoPace,
+		hdr: header{
+			marker: true,
+			pt:     ep.payloadType(),
+			seq:    uint16(rand.Uint32()),
+			ts:     rand.Uint32(),
+			ssrc:   rand.Uint32()},
+		buf:   make([]byte, headerSize+nF*bpf),
+		start: time.Now(),
+		fillC: make(chan *libsio.RawPacket, 3),
+		playC: make(chan *libsio.RawPacket, 3),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{})}
+	for i := range s.pkts {
+		pkt := &s.pkts[i]
+		pkt.D = make([]byte, nF*bpf)
+		pkt.N = i * nF
+		pkt.Start = s.start
+		s.fillC <- pkt
+	}
+	go s.serve()
+	return s, nil
+}
+
+func (s *rtpSnk) Codec() sample.Codec {
+	return s.co
+}
+
+func (s *rtpSnk) RawFillC() <-chan *libsio.RawPacket {
+	return s.fillC
+}
+
+func (s *rtpSnk) RawPlayC() chan<- *libsio.RawPacket {
+	return s.playC
+}
+
+func (s *rtpSnk) Close() error {
+	s.once.Do(func() {
+		close(s.quit)
+		<-s.done
+		s.conn.Close()
+	})
+	return nil
+}
+
+// serve sends the packets received on s.playC.  Unless s.noPace, each
+// packet is sent at the time of its first frame, counted from the first
+// packet.  Frame numbers beyond the next frame schedule the packet in the
+// future, which gives a timestamp jump and a marked packet as with silence
+// suppression.
+func (s *rtpSnk) serve() {
+	defer close(s.done)
+	defer close(s.fillC)
+	period := s.SampleRate().Period()
+	tmr := time.NewTimer(time.Hour)
+	defer tmr.Stop()
+	var base time.Time
+	next := 0
+	for {
+		var pkt *libsio.RawPacket
+		select {
+		case <-s.quit:
+			s.flush()
+			return
+		case pkt = <-s.playC:
+		}
+		if base.IsZero() {
+			base = time.Now()
+		}
+		if pkt.N > next {
+			s.hdr.ts += uint32(pkt.N - next)
+			s.hdr.marker = true
+			next = pkt.N
+		}
+		if !s.noPace {
+			if d := time.Until(base.Add(time.Duration(next) * period)); d > 0 {
+				if !tmr.Stop() {
+					select {
+					case <-tmr.C:
+					default:
+					}
+				}
+				tmr.Reset(d)
+				select {
+				case <-s.quit:
+					s.send(pkt)
+					s.flush()
+					return
+				case <-tmr.C:
+				}
+			}
+		}
+		next += s.send(pkt)
+		pkt.D = pkt.D[:cap(pkt.D)]
+		pkt.N = next + (len(s.pkts)-1)*cap(pkt.D)/s.bpf
+		s.fillC <- pkt
+	}
+}
+
+// send sends pkt and returns the number of frames sent.
+func (s *rtpSnk) send(pkt *libsio.RawPacket) int {
+	n := len(pkt.D) / s.bpf
+	s.hdr.put(s.buf)
+	m := copy(s.buf[headerSize:], pkt.D)
+	// errors such as ICMP port unreachable are transient for us.
+	s.conn.WriteTo(s.buf[:headerSize+m], s.remote)
+	s.hdr.marker = false
+	s.hdr.seq++
+	s.hdr.ts += uint32(n)
+	return n
+}
+
+// flush sends the packets already queued on s.playC when s is closed,
+// without pacing.
+func (s *rtpSnk) flush() {
+	for {
+		select {
+		case pkt := <-s.playC:
+			s.send(pkt)
+		default:
+			return
+		}
+	}
+}
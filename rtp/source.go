@@ -0,0 +1,239 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package rtp
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+const (
+	// number of packets held by the jitter buffer.
+	jitterSlots = 128
+
+	// maximum payload size accepted, larger than what we send to
+	// accommodate other senders.
+	maxRecvPayload = 8192
+)
+
+// Source is the sound.Source returned by Entry.OpenSource.  It implements
+// libsio.RawSource in addition to sound.Source.
+type Source struct {
+	sound.Source
+	raw libsio.RawSource
+	s   *rtpSrc
+}
+
+// Codec returns the sample codec of the payload, sample.SInt16B or
+// sample.SInt24B.
+func (s *Source) Codec() sample.Codec {
+	return s.raw.Codec()
+}
+
+// ReceiveRaw implements libsio.RawSource.
+func (s *Source) ReceiveRaw(pkt *libsio.RawPacket) error {
+	return s.raw.ReceiveRaw(pkt)
+}
+
+// Stats returns the packet counters of s.
+func (s *Source) Stats() Stats {
+	s.s.mu.Lock()
+	defer s.s.mu.Unlock()
+	return s.s.jb.stats
+}
+
+// rtpSrc implements libsio.RawInput.
+type rtpSrc struct {
+	sound.Form
+	co      sample.Codec
+	bpf     int
+	conn    net.PacketConn
+	remote  net.IP
+	pt      uint8
+	jitter  time.Duration
+	timeout time.Duration
+
+	start time.Time // opening of the stream, the time of frame 0
+
+	mu      sync.Mutex
+	jb      *jitter
+	ssrc    uint32
+	started bool
+	first   time.Time // arrival of first packet
+	last    time.Time // arrival of last packet
+
+	c    chan *libsio.RawPacket
+	pkts [3]libsio.RawPacket
+
+	quit chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+func newSrc(ep *Endpoint, v sound.Form, co sample.Codec, b int) (*rtpSrc, error) {
+	conn, err := ep.listen()
+	if err != nil {
+		return nil, err
+	}
+	bpf := co.Bytes() * v.Channels()
+	s := &rtpSrc{
+		Form:    v,
+		co:      co,
+		bpf:     bpf,
+		conn:    conn,
+		pt:      ep.payloadType(),
+		jitter:  ep.jitter(),
+		timeout: ep.timeout(),
+		start:   time.Now(),
+		jb:      newJitter(bpf, jitterSlots, maxRecvPayload, int64(v.SampleRate().Float64())),
+		c:       make(chan *libsio.RawPacket, 1),
+		quit:    make(chan struct{})}
+	if ep.Remote != nil {
+		s.remote = ep.Remote.IP
+	}
+	for i := range s.pkts {
+		s.pkts[i].D = make([]byte, b*bpf)
+	}
+	s.wg.Add(2)
+	go s.recv()
+	go s.playout()
+	return s, nil
+}
+
+func (s *rtpSrc) Codec() sample.Codec {
+	return s.co
+}
+
+func (s *rtpSrc) RawC() <-chan *libsio.RawPacket {
+	return s.c
+}
+
+func (s *rtpSrc) Close() error {
+	s.once.Do(func() {
+		close(s.quit)
+		s.conn.Close()
+	})
+	s.wg.Wait()
+	return nil
+}
+
+func (s *rtpSrc) recv() {
+	defer s.wg.Done()
+	buf := make([]byte, headerSize+maxRecvPayload+1)
+	var h header
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		if s.remote != nil {
+			if ua, ok := addr.(*net.UDPAddr); ok && !ua.IP.Equal(s.remote) {
+				continue
+			}
+		}
+		payload, err := h.parse(buf[:n])
+		now := time.Now()
+		s.mu.Lock()
+		if err != nil || h.pt != s.pt || len(payload)%s.bpf != 0 || (s.started && h.ssrc != s.ssrc) {
+			s.jb.stats.Invalid++
+			s.mu.Unlock()
+			continue
+		}
+		s.jb.push(h.seq, h.ts, payload)
+		s.last = now
+		if !s.started {
+			s.started = true
+			s.ssrc = h.ssrc
+			s.first = now
+		}
+		s.mu.Unlock()
+	}
+}
+
+// playout delivers packets from the jitter buffer in real time, s.jitter
+// behind their arrival.  Frame 0 is at s.start, and silence is delivered
+// until the frames of the first packet, which are at its arrival.  After
+// that the frame numbers of the packets delivered follow the RTP
+// timestamps, whereas their timing follows the number of frames delivered.
+func (s *rtpSrc) playout() {
+	defer s.wg.Done()
+	defer close(s.c)
+	base := s.start.Add(s.jitter)
+	period := s.SampleRate().Period()
+	rate := s.SampleRate().Float64()
+	tmr := time.NewTimer(s.jitter)
+	defer tmr.Stop()
+	var played int64
+	lead := int64(-1) // frames before the first packet, once it arrived.
+	for i := 0; ; i++ {
+		due := base.Add(time.Duration(played) * period)
+		if d := time.Until(due); d > 0 {
+			if !tmr.Stop() {
+				select {
+				case <-tmr.C:
+				default:
+				}
+			}
+			tmr.Reset(d)
+			select {
+			case <-s.quit:
+				return
+			case <-tmr.C:
+			}
+		}
+		pkt := &s.pkts[i%len(s.pkts)]
+		pkt.D = pkt.D[:cap(pkt.D)]
+		s.mu.Lock()
+		if !s.started && time.Since(s.start) > s.timeout {
+			s.mu.Unlock()
+			return
+		}
+		if s.started && lead < 0 {
+			lead = int64(s.first.Sub(s.start).Seconds() * rate)
+			if lead < played {
+				lead = played
+			}
+		}
+		var n int
+		if lead < 0 || played < lead {
+			n = len(pkt.D) / s.bpf
+			if lead >= 0 && int64(n) > lead-played {
+				n = int(lead - played)
+			}
+			pkt.N = int(played)
+			for j := range pkt.D[:n*s.bpf] {
+				pkt.D[j] = 0
+			}
+		} else {
+			if time.Since(s.last) > s.timeout {
+				s.mu.Unlock()
+				return
+			}
+			pkt.N = int(lead + s.jb.frame())
+			n, _ = s.jb.pop(pkt.D)
+		}
+		s.mu.Unlock()
+		pkt.D = pkt.D[:n*s.bpf]
+		pkt.Start = s.start
+		played += int64(n)
+		select {
+		case <-s.quit:
+			return
+		case s.c <- pkt:
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build linux
+
+package shm
+
+import (
+	"testing"
+	"time"
+
+	"zikichombo.org/sio/host/hosttest"
+)
+
+func TestConformance(t *testing.T) {
+	e, done := testEntry(t)
+	defer done()
+	v := e.DefaultForm()
+	mic, err := e.PublishSource("mic", v, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	speaker, err := e.PublishSink("speaker", v, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the publishers keep the streams flowing, in real time.
+	b := e.DefaultBufSize()
+	period := time.Duration(b) * time.Second / time.Duration(v.SampleRate().Float64())
+	go func() {
+		d := make([]float64, b*v.Channels())
+		for mic.Send(d) == nil {
+			time.Sleep(period)
+		}
+	}()
+	go func() {
+		d := make([]float64, b*v.Channels())
+		for {
+			if _, err := speaker.Receive(d); err != nil {
+				return
+			}
+		}
+	}()
+	defer mic.Close()
+	defer speaker.Close()
+	hosttest.Test(t, e, nil)
+}
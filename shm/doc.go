@@ -0,0 +1,27 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package shm provides a host.Entry for low latency audio between processes
+// of the same host through shared memory.
+//
+// A process publishes a named stream with PublishSource or PublishSink.
+// The stream is a ring buffer of float32 samples in a file of /dev/shm which
+// other processes map.  Readers and writers wait for each other on futexes
+// in the shared memory, so that no data is copied through the kernel.
+//
+// A stream published with PublishSource is written by the publisher and may
+// be opened as a Source by any number of processes, for example analyzers
+// fed by a capture daemon.  The publisher never waits for readers, and
+// readers which fall behind by more than the capacity of the ring lose data,
+// see Source.Dropped.  A stream published with PublishSink is read by the
+// publisher and may be opened as a Sink by one process at a time.
+//
+// Published streams are the devices of the entry, and DevicesNotify reports
+// streams as they are published and closed.  The sample codec of devices is
+// native endian float32.
+//
+// Package shm is only available on Linux.  It registers the entry Default
+// under the name "Linux -- SHM" on initialisation.
+//
+// Package shm is part of http://zikichombo.org
+package shm /* import "zikichombo.org/sio/shm" */
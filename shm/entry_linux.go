@@ -0,0 +1,409 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package shm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Entry is a host.Entry whose devices are the streams published in a
+// directory.
+type Entry struct {
+	host.NullEntry
+
+	// Dir is the directory of the files of published streams, /dev/shm
+	// if empty.  It should be on a tmpfs file system.
+	Dir string
+
+	mu    sync.Mutex
+	devs  []*libsio.Dev
+	subs  host.Notifier
+	watch *watcher
+	id    uint64
+}
+
+// Default is the Entry registered by package shm.
+var Default = &Entry{}
+
+func init() {
+	if err := host.RegisterEntry(Default); err != nil {
+		log.Printf("zc failed load %s: %s\n", Default.Name(), err.Error())
+	}
+}
+
+// ErrNoDev is returned when opening a stream without a device.
+var ErrNoDev = errors.New("shm: no stream given")
+
+// PublishSource publishes the stream name in the directory of Default, see
+// Entry.PublishSource.
+func PublishSource(name string, v sound.Form, capF int) (sound.Sink, error) {
+	return Default.PublishSource(name, v, capF)
+}
+
+// PublishSink publishes the stream name in the directory of Default, see
+// Entry.PublishSink.
+func PublishSink(name string, v sound.Form, capF int) (sound.Source, error) {
+	return Default.PublishSink(name, v, capF)
+}
+
+// PublishSource publishes a stream named name which other processes may
+// open as a source.  Data sent to the returned sound.Sink is received by
+// them.  The ring of the stream holds capF frames, rounded up to a power of
+// 2, or 100ms if capF is 0.  Closing the returned sound.Sink unpublishes the
+// stream.
+func (e *Entry) PublishSource(name string, v sound.Form, capF int) (sound.Sink, error) {
+	s, path, err := create(e.dir(), name, kindSource, v, ringCap(v, capF))
+	if err != nil {
+		return nil, err
+	}
+	return &pubSnk{seg: s, path: path}, nil
+}
+
+// PublishSink publishes a stream named name which another process may open
+// as a sink.  Data it sends is received from the returned sound.Source.
+// capF is as for PublishSource.  Closing the returned sound.Source
+// unpublishes the stream.
+func (e *Entry) PublishSink(name string, v sound.Form, capF int) (sound.Source, error) {
+	s, path, err := create(e.dir(), name, kindSink, v, ringCap(v, capF))
+	if err != nil {
+		return nil, err
+	}
+	return &pubSrc{seg: s, path: path}, nil
+}
+
+func ringCap(v sound.Form, capF int) int {
+	if capF <= 0 {
+		capF = int(v.SampleRate().Float64()) / 10
+	}
+	return capF
+}
+
+func (e *Entry) dir() string {
+	if e.Dir == "" {
+		return "/dev/shm"
+	}
+	return e.Dir
+}
+
+func (e *Entry) Name() string {
+	return "Linux -- SHM"
+}
+
+func (e *Entry) DefaultSampleCodec() sample.Codec {
+	return nativeCodec
+}
+
+func (e *Entry) DefaultForm() sound.Form {
+	return sound.NewForm(48000*freq.Hertz, 2)
+}
+
+func (e *Entry) DefaultBufSize() int {
+	return 256
+}
+
+// openSeg maps the stream of d, checking that it is of kind and opened
+// with its form and sample codec.
+func (e *Entry) openSeg(d *libsio.Dev, kind uint32, v sound.Form, co sample.Codec) (*seg, error) {
+	if d == nil {
+		return nil, ErrNoDev
+	}
+	if co != nativeCodec {
+		return nil, fmt.Errorf("shm: unsupported sample codec %s, use %s", co, nativeCodec)
+	}
+	s, err := open(filepath.Join(e.dir(), filePrefix+d.Name))
+	if err != nil {
+		return nil, err
+	}
+	if s.kind != kind {
+		s.unmap()
+		return nil, fmt.Errorf("shm: stream %s has the wrong direction", d.Name)
+	}
+	if err := s.sameForm(v); err != nil {
+		s.unmap()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (e *Entry) CanOpenSource() bool {
+	return true
+}
+
+// OpenSource opens a stream published with PublishSource.  The returned
+// sound.Source is a *Source.
+func (e *Entry) OpenSource(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Source, time.Time, error) {
+	var t time.Time
+	s, err := e.openSeg(d, kindSource, v, co)
+	if err != nil {
+		return nil, t, err
+	}
+	return openSource(s), time.Now(), nil
+}
+
+func (e *Entry) CanOpenSink() bool {
+	return true
+}
+
+// OpenSink opens a stream published with PublishSink.  A stream may be
+// opened as a sink by one process at a time, others get ErrBusy.  The
+// returned start time is set by the first Send, once data is written.
+func (e *Entry) OpenSink(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Sink, *time.Time, error) {
+	s, err := e.openSeg(d, kindSink, v, co)
+	if err != nil {
+		return nil, nil, err
+	}
+	k, err := openSink(s)
+	if err != nil {
+		s.unmap()
+		return nil, nil, err
+	}
+	return k, &k.start, nil
+}
+
+func (e *Entry) HasDevices() bool {
+	return true
+}
+
+func (e *Entry) newDev(name string, h *header) *libsio.Dev {
+	d := &libsio.Dev{
+		Id:   e.id,
+		UID:  name,
+		Name: name}
+	e.id++
+	caps := libsio.Caps{
+		MinChannels: h.nC,
+		MaxChannels: h.nC,
+		Rates:       []freq.T{freq.T(h.rate) * freq.Hertz},
+		Codecs:      []sample.Codec{nativeCodec}}
+	if h.kind == kindSource {
+		d.In = caps
+	} else {
+		d.Out = caps
+	}
+	return d
+}
+
+// scan must be called with e.mu held.  Devices in old with the same name
+// are reused.
+func (e *Entry) scan(old []*libsio.Dev) ([]*libsio.Dev, error) {
+	fis, err := ioutil.ReadDir(e.dir())
+	if err != nil {
+		return nil, err
+	}
+	devs := []*libsio.Dev{}
+	for _, fi := range fis {
+		if !strings.HasPrefix(fi.Name(), filePrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(fi.Name(), filePrefix)
+		h, err := stat(filepath.Join(e.dir(), fi.Name()))
+		if err != nil {
+			continue
+		}
+		if d := findDev(old, name); d != nil {
+			devs = append(devs, d)
+			continue
+		}
+		devs = append(devs, e.newDev(name, h))
+	}
+	return devs, nil
+}
+
+func findDev(devs []*libsio.Dev, name string) *libsio.Dev {
+	for _, d := range devs {
+		if d.Name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+func (e *Entry) ScanDevices() ([]*host.DevScanResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	devs, err := e.scan(e.devs)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*host.DevScanResult, len(devs))
+	for i, d := range devs {
+		res[i] = &host.DevScanResult{Dev: d}
+	}
+	return res, nil
+}
+
+func (e *Entry) Devices() []*libsio.Dev {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.devs == nil {
+		devs, err := e.scan(nil)
+		if err != nil {
+			return nil
+		}
+		e.devs = devs
+	}
+	res := make([]*libsio.Dev, len(e.devs))
+	copy(res, e.devs)
+	return res
+}
+
+// DefaultInputDev returns the first stream published with PublishSource,
+// if any.
+func (e *Entry) DefaultInputDev() *libsio.Dev {
+	for _, d := range e.Devices() {
+		if d.CanInput() {
+			return d
+		}
+	}
+	return nil
+}
+
+// DefaultOutputDev returns the first stream published with PublishSink, if
+// any.
+func (e *Entry) DefaultOutputDev() *libsio.Dev {
+	for _, d := range e.Devices() {
+		if d.CanOutput() {
+			return d
+		}
+	}
+	return nil
+}
+
+// DevicesNotify sends a DeviceConnect change when a stream is published and
+// a DeviceDisconnect change when it is closed.  Streams of processes which
+// die without closing them are not reported.
+func (e *Entry) DevicesNotify(c chan<- *host.DevChange) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.watch == nil {
+		w, err := newWatcher(e)
+		if err != nil {
+			return err
+		}
+		e.watch = w
+		// the watch follows the cached devices from now on.
+		devs, err := e.scan(e.devs)
+		if err != nil {
+			w.close()
+			e.watch = nil
+			return err
+		}
+		e.devs = devs
+	}
+	e.subs.Add(c)
+	return nil
+}
+
+func (e *Entry) DevicesNotifyClose(c chan<- *host.DevChange) {
+	e.mu.Lock()
+	var w *watcher
+	if n, ok := e.subs.Remove(c); ok && n == 0 {
+		w = e.watch
+		e.watch = nil
+	}
+	e.mu.Unlock()
+	if w != nil {
+		w.close()
+	}
+}
+
+func (e *Entry) added(name string) {
+	h, err := stat(filepath.Join(e.dir(), filePrefix+name))
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if findDev(e.devs, name) != nil {
+		return
+	}
+	d := e.newDev(name, h)
+	e.devs = append(e.devs, d)
+	e.subs.Notify(&host.DevChange{Sense: host.DeviceConnect, Dev: d})
+}
+
+func (e *Entry) removed(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, d := range e.devs {
+		if d.Name == name {
+			e.devs = append(e.devs[:i], e.devs[i+1:]...)
+			e.subs.Notify(&host.DevChange{Sense: host.DeviceDisconnect, Dev: d})
+			return
+		}
+	}
+}
+
+// watcher follows the directory of an Entry with inotify.
+type watcher struct {
+	e    *Entry
+	f    *os.File
+	done chan struct{}
+}
+
+func newWatcher(e *Entry) (*watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	mask := uint32(syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_DELETE | syscall.IN_MOVED_FROM)
+	if _, err := syscall.InotifyAddWatch(fd, e.dir(), mask); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	// a non blocking fd uses the runtime poller, so that Close interrupts
+	// Read.
+	w := &watcher{e: e, f: os.NewFile(uintptr(fd), "inotify"), done: make(chan struct{})}
+	go w.serve()
+	return w, nil
+}
+
+func (w *watcher) close() {
+	w.f.Close()
+	<-w.done
+}
+
+func (w *watcher) serve() {
+	defer close(w.done)
+	var buf [4096]byte
+	for {
+		n, err := w.f.Read(buf[:])
+		if err != nil {
+			return
+		}
+		for p := 0; p+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[p]))
+			nb := buf[p+syscall.SizeofInotifyEvent : p+syscall.SizeofInotifyEvent+int(ev.Len)]
+			p += syscall.SizeofInotifyEvent + int(ev.Len)
+			name := string(bytes.TrimRight(nb, "\x00"))
+			if !strings.HasPrefix(name, filePrefix) {
+				continue
+			}
+			name = strings.TrimPrefix(name, filePrefix)
+			switch {
+			case ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+				w.e.added(name)
+			case ev.Mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+				w.e.removed(name)
+			}
+		}
+	}
+}
@@ -0,0 +1,375 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package shm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Layout of a segment.  Fields written by different processes are on
+// different cache lines.
+const (
+	segMagic   = 0x7a637368 // "zcsh"
+	segVersion = 1
+
+	offMagic    = 0
+	offVersion  = 4
+	offChannels = 8
+	offRate     = 12
+	offCap      = 16 // capacity in frames, a power of 2.
+	offKind     = 20
+	offPid      = 24 // pid of the publisher.
+	offClosed   = 28 // set when the publisher closes the stream.
+	offOwner    = 32 // pid of the process which opened a published sink.
+
+	offW        = 64  // uint64 frames written.
+	offWSeq     = 72  // futex word incremented when frames are written.
+	offWWaiters = 76  // number of processes waiting on offWSeq.
+	offR        = 128 // uint64 frames read, for published sinks.
+	offRSeq     = 136 // futex word incremented when frames are read.
+	offRWaiters = 140 // number of processes waiting on offRSeq.
+
+	hdrSize = 192
+)
+
+// stream kinds.
+const (
+	kindSource = 1 // written by the publisher.
+	kindSink   = 2 // read by the publisher.
+)
+
+// filePrefix prefixes the names of the files of published streams.
+const filePrefix = "zc-sio."
+
+// ErrExists is returned when publishing a stream whose name is in use.
+var ErrExists = errors.New("shm: stream already published")
+
+// ErrBusy is returned when opening a published sink already opened.
+var ErrBusy = errors.New("shm: stream in use")
+
+// ErrClosed is returned when sending to a stream whose publisher closed it.
+var ErrClosed = errors.New("shm: stream closed")
+
+// waitSlice bounds futex waits, so that waiters notice when the other
+// side of a stream dies without closing it.
+const waitSlice = 100 * time.Millisecond
+
+// seg is a mapped stream.
+type seg struct {
+	mem  []byte
+	nC   int
+	rate int
+	capF uint64
+	mask uint64
+	kind uint32
+	pid  int // of the publisher.
+	data []float32
+}
+
+func (s *seg) u32(off int) *uint32 {
+	return (*uint32)(unsafe.Pointer(&s.mem[off]))
+}
+
+func (s *seg) u64(off int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&s.mem[off]))
+}
+
+func (s *seg) closed() bool {
+	return atomic.LoadUint32(s.u32(offClosed)) != 0
+}
+
+func (s *seg) Channels() int {
+	return s.nC
+}
+
+func (s *seg) SampleRate() freq.T {
+	return freq.T(s.rate) * freq.Hertz
+}
+
+// sameForm checks that a stream is opened with its form.
+func (s *seg) sameForm(v sound.Form) error {
+	if v.Channels() != s.nC || v.SampleRate() != s.SampleRate() {
+		return fmt.Errorf("shm: stream has %d channels at %d Hz", s.nC, s.rate)
+	}
+	return nil
+}
+
+// maxSamples bounds the number of samples of a ring.
+const maxSamples = 1 << 28
+
+// native is the byte order of segments, that of the host, and nativeCodec
+// the sample codec of their samples.
+var (
+	native      binary.ByteOrder = binary.LittleEndian
+	nativeCodec                  = sample.SFloat32L
+)
+
+func init() {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		native = binary.BigEndian
+		nativeCodec = sample.SFloat32B
+	}
+}
+
+func segSize(nC int, capF uint64) int {
+	return hdrSize + int(capF)*nC*4
+}
+
+func checkName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\x00") || len(name) > 200 {
+		return fmt.Errorf("shm: bad stream name %q", name)
+	}
+	return nil
+}
+
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
+
+// create creates and maps the segment of a new stream in dir.  The file
+// is initialised under a temporary name and then linked in place, so that
+// other processes never see a partial header.
+func create(dir, name string, kind uint32, v sound.Form, capF int) (*seg, string, error) {
+	if err := checkName(name); err != nil {
+		return nil, "", err
+	}
+	c := uint64(1)
+	for c < uint64(capF) {
+		c <<= 1
+	}
+	nC := v.Channels()
+	if nC < 1 || c*uint64(nC) > maxSamples {
+		return nil, "", fmt.Errorf("shm: bad stream size %d channels of %d frames", nC, c)
+	}
+	size := segSize(nC, c)
+	path := filepath.Join(dir, filePrefix+name)
+	tmp := filepath.Join(dir, ".tmp."+strconv.Itoa(os.Getpid())+"."+name)
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+	if err := f.Truncate(int64(size)); err != nil {
+		return nil, "", err
+	}
+	mem, err := mapFile(f, size)
+	if err != nil {
+		return nil, "", err
+	}
+	s := &seg{
+		mem:  mem,
+		nC:   nC,
+		rate: int(v.SampleRate().Float64()),
+		capF: c,
+		mask: c - 1,
+		kind: kind,
+		pid:  os.Getpid()}
+	s.data = (*[maxSamples]float32)(unsafe.Pointer(&mem[hdrSize]))[: int(c)*nC : int(c)*nC]
+	*s.u32(offVersion) = segVersion
+	*s.u32(offChannels) = uint32(nC)
+	*s.u32(offRate) = uint32(s.rate)
+	*s.u32(offCap) = uint32(c)
+	*s.u32(offKind) = kind
+	*s.u32(offPid) = uint32(os.Getpid())
+	atomic.StoreUint32(s.u32(offMagic), segMagic)
+	err = os.Link(tmp, path)
+	if os.IsExist(err) && stale(path) {
+		os.Remove(path)
+		err = os.Link(tmp, path)
+	}
+	if err != nil {
+		s.unmap()
+		if os.IsExist(err) {
+			err = ErrExists
+		}
+		return nil, "", err
+	}
+	return s, path, nil
+}
+
+// header is the part of a segment header needed to describe a stream.
+type header struct {
+	nC, rate int
+	capF     uint64
+	kind     uint32
+	pid      int
+}
+
+// readHeader reads the header of the segment in f.
+func readHeader(f *os.File) (*header, error) {
+	var b [hdrSize]byte
+	if _, err := f.ReadAt(b[:], 0); err != nil {
+		return nil, err
+	}
+	bo := native
+	if bo.Uint32(b[offMagic:]) != segMagic || bo.Uint32(b[offVersion:]) != segVersion {
+		return nil, fmt.Errorf("shm: %s is not a stream", f.Name())
+	}
+	h := &header{
+		nC:   int(bo.Uint32(b[offChannels:])),
+		rate: int(bo.Uint32(b[offRate:])),
+		capF: uint64(bo.Uint32(b[offCap:])),
+		kind: bo.Uint32(b[offKind:]),
+		pid:  int(bo.Uint32(b[offPid:]))}
+	if h.nC < 1 || h.capF == 0 || h.capF&(h.capF-1) != 0 || h.capF*uint64(h.nC) > maxSamples {
+		return nil, fmt.Errorf("shm: %s is not a stream", f.Name())
+	}
+	if bo.Uint32(b[offClosed:]) != 0 {
+		return nil, ErrClosed
+	}
+	return h, nil
+}
+
+// stat reads the header of the stream at path, and checks that its
+// publisher is alive.
+func stat(path string) (*header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h, err := readHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	if !alive(h.pid) {
+		return nil, ErrClosed
+	}
+	return h, nil
+}
+
+// stale returns whether the stream at path was left by a dead process.
+func stale(path string) bool {
+	_, err := stat(path)
+	return err == ErrClosed
+}
+
+func alive(pid int) bool {
+	return syscall.Kill(pid, 0) != syscall.ESRCH
+}
+
+// open maps the segment of the published stream at path.
+func open(path string) (*seg, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h, err := readHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	size := segSize(h.nC, h.capF)
+	if fi, err := f.Stat(); err != nil || fi.Size() < int64(size) {
+		return nil, fmt.Errorf("shm: %s is truncated", path)
+	}
+	mem, err := mapFile(f, size)
+	if err != nil {
+		return nil, err
+	}
+	s := &seg{
+		mem:  mem,
+		nC:   h.nC,
+		rate: h.rate,
+		capF: h.capF,
+		mask: h.capF - 1,
+		kind: h.kind,
+		pid:  h.pid}
+	s.data = (*[maxSamples]float32)(unsafe.Pointer(&mem[hdrSize]))[: int(h.capF)*h.nC : int(h.capF)*h.nC]
+	return s, nil
+}
+
+func (s *seg) unmap() {
+	syscall.Munmap(s.mem)
+	s.mem = nil
+	s.data = nil
+}
+
+// write copies nF frames of the channel deinterleaved d, which has dF
+// frames per channel, from frame f, to the ring at frame counter w.
+func (s *seg) write(w uint64, d []float64, dF, f, nF int) {
+	nC := s.nC
+	for i := 0; i < nF; i++ {
+		j := int((w+uint64(i))&s.mask) * nC
+		fr := s.data[j : j+nC]
+		for c := range fr {
+			fr[c] = float32(d[c*dF+f+i])
+		}
+	}
+}
+
+// read is the inverse of write.
+func (s *seg) read(r uint64, d []float64, dF, f, nF int) {
+	nC := s.nC
+	for i := 0; i < nF; i++ {
+		j := int((r+uint64(i))&s.mask) * nC
+		fr := s.data[j : j+nC]
+		for c, v := range fr {
+			d[c*dF+f+i] = float64(v)
+		}
+	}
+}
+
+// commit publishes frames up to counter n in the word at off and wakes
+// waiters.
+func (s *seg) commit(off, seqOff, waitOff int, n uint64) {
+	atomic.StoreUint64(s.u64(off), n)
+	atomic.AddUint32(s.u32(seqOff), 1)
+	if atomic.LoadUint32(s.u32(waitOff)) != 0 {
+		futexWake(s.u32(seqOff))
+	}
+}
+
+// wait waits for the word at seqOff to change while cond holds, for at most
+// waitSlice.
+func (s *seg) wait(seqOff, waitOff int, cond func() bool) {
+	waiters := s.u32(waitOff)
+	atomic.AddUint32(waiters, 1)
+	seq := atomic.LoadUint32(s.u32(seqOff))
+	if cond() {
+		futexWait(s.u32(seqOff), seq, waitSlice)
+	}
+	atomic.AddUint32(waiters, math.MaxUint32)
+}
+
+// wake wakes all waiters, when a stream is closed.
+func (s *seg) wake() {
+	atomic.AddUint32(s.u32(offWSeq), 1)
+	atomic.AddUint32(s.u32(offRSeq), 1)
+	futexWake(s.u32(offWSeq))
+	futexWake(s.u32(offRSeq))
+}
+
+const (
+	futexWaitOp = 0 // FUTEX_WAIT, not private as the word is shared.
+	futexWakeOp = 1 // FUTEX_WAKE
+)
+
+func futexWait(addr *uint32, val uint32, d time.Duration) {
+	ts := syscall.NsecToTimespec(int64(d))
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWaitOp,
+		uintptr(val), uintptr(unsafe.Pointer(&ts)), 0, 0)
+}
+
+func futexWake(addr *uint32) {
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWakeOp,
+		math.MaxInt32, 0, 0, 0)
+}
@@ -0,0 +1,192 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build linux
+
+package shm
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+)
+
+var testForm = sound.NewForm(48000*freq.Hertz, 2)
+
+// streams are opened from separate mappings of the same file, as they
+// would be by another process.
+func testEntry(t *testing.T) (*Entry, func()) {
+	dir, err := ioutil.TempDir("", "shm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Entry{Dir: dir}, func() { os.RemoveAll(dir) }
+}
+
+func frames(nF, base int) []float64 {
+	d := make([]float64, 2*nF)
+	for f := 0; f < nF; f++ {
+		d[f] = float64(base+f) / 1024
+		d[nF+f] = -d[f]
+	}
+	return d
+}
+
+func TestPublishSource(t *testing.T) {
+	e, done := testEntry(t)
+	defer done()
+	pub, err := e.PublishSource("mic", testForm, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.PublishSource("mic", testForm, 1024); err != ErrExists {
+		t.Errorf("got %v not ErrExists", err)
+	}
+	dev := e.DefaultInputDev()
+	if dev == nil || dev.Name != "mic" || dev.In.MaxChannels != 2 {
+		t.Fatalf("got device %v", dev)
+	}
+	var srcs [2]sound.Source
+	for i := range srcs {
+		srcs[i], _, err = e.OpenSource(dev, testForm, nativeCodec, 256)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	errC := make(chan error, 1)
+	go func() {
+		for i := 0; i < 8; i++ {
+			if err := pub.Send(frames(256, 256*i)); err != nil {
+				errC <- err
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		errC <- pub.Close()
+	}()
+	for _, src := range srcs {
+		defer src.Close()
+	}
+	// srcs[0] keeps up, srcs[1] reads once the publisher is done and
+	// loses what was overwritten.
+	d := make([]float64, 512)
+	for i := 0; i < 8; i++ {
+		n, err := srcs[0].Receive(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 256 || d[0] != float64(256*i)/1024 || d[256] != -d[0] {
+			t.Fatalf("block %d: got %d frames starting with %f", i, n, d[0])
+		}
+	}
+	if err := <-errC; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srcs[0].Receive(d); err != io.EOF {
+		t.Errorf("got %v not EOF", err)
+	}
+	total := 0
+	for {
+		n, err := srcs[1].Receive(d)
+		if err != nil {
+			break
+		}
+		total += n
+	}
+	dropped := srcs[1].(*Source).Dropped()
+	if dropped == 0 || total+dropped != 2048 || total > 1024 {
+		t.Errorf("got %d frames and dropped %d", total, dropped)
+	}
+	res, err := e.ScanDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 0 {
+		t.Errorf("closed stream still published")
+	}
+}
+
+func TestPublishSink(t *testing.T) {
+	e, done := testEntry(t)
+	defer done()
+	pub, err := e.PublishSink("speaker", testForm, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := e.DefaultOutputDev()
+	if dev == nil || dev.Out.MaxChannels != 2 {
+		t.Fatalf("got device %v", dev)
+	}
+	if _, _, err := e.OpenSource(dev, testForm, nativeCodec, 128); err == nil {
+		t.Errorf("opened sink stream as a source")
+	}
+	snk, _, err := e.OpenSink(dev, testForm, nativeCodec, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := e.OpenSink(dev, testForm, nativeCodec, 128); err != ErrBusy {
+		t.Errorf("got %v not ErrBusy", err)
+	}
+	nF := 4096
+	errC := make(chan error, 1)
+	go func() {
+		// more than the ring holds, so Send waits for the publisher.
+		errC <- snk.Send(frames(nF, 0))
+	}()
+	got := make([]float64, 2*nF)
+	n, err := pub.Receive(got)
+	if err != nil || n != nF {
+		t.Fatalf("got %d frames, %v", n, err)
+	}
+	exp := frames(nF, 0)
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Fatalf("sample %d: got %f not %f", i, got[i], exp[i])
+		}
+	}
+	if err := <-errC; err != nil {
+		t.Fatal(err)
+	}
+	snk.Close()
+	pub.Close()
+}
+
+func TestDevicesNotify(t *testing.T) {
+	e, done := testEntry(t)
+	defer done()
+	c := make(chan *host.DevChange, 4)
+	if err := e.DevicesNotify(c); err != nil {
+		t.Fatal(err)
+	}
+	defer e.DevicesNotifyClose(c)
+	pub, err := e.PublishSource("a", testForm, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() *host.DevChange {
+		select {
+		case chg := <-c:
+			return chg
+		case <-time.After(5 * time.Second):
+			t.Fatal("no notification")
+		}
+		return nil
+	}
+	chg := next()
+	if chg.Sense != host.DeviceConnect || chg.Dev.Name != "a" {
+		t.Errorf("got %v", chg)
+	}
+	if devs := e.Devices(); len(devs) != 1 || devs[0] != chg.Dev {
+		t.Errorf("got devices %v", devs)
+	}
+	pub.Close()
+	if chg := next(); chg.Sense != host.DeviceDisconnect || chg.Dev.Name != "a" {
+		t.Errorf("got %v", chg)
+	}
+}
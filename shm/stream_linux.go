@@ -0,0 +1,295 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package shm
+
+import (
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zikichombo.org/sound"
+)
+
+// I/O holds the mutex of a stream while it accesses the mapping, so that
+// Close, which may be called concurrently, only unmaps it once I/O returns.
+
+// pubSnk is the sound.Sink returned by PublishSource.
+type pubSnk struct {
+	*seg
+	path string
+	mu   sync.Mutex
+	w    uint64
+	once sync.Once
+}
+
+func (s *pubSnk) Send(d []float64) error {
+	nC := s.nC
+	if len(d)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mem == nil {
+		return ErrClosed
+	}
+	nF := len(d) / nC
+	for f := 0; f < nF; {
+		k := nF - f
+		if uint64(k) > s.capF {
+			k = int(s.capF)
+		}
+		s.write(s.w, d, nF, f, k)
+		s.w += uint64(k)
+		s.commit(offW, offWSeq, offWWaiters, s.w)
+		f += k
+	}
+	return nil
+}
+
+// Close unpublishes the stream.  Readers receive the data already written
+// and then io.EOF.
+func (s *pubSnk) Close() error {
+	s.once.Do(func() {
+		atomic.StoreUint32(s.u32(offClosed), 1)
+		s.wake()
+		os.Remove(s.path)
+		s.mu.Lock()
+		s.unmap()
+		s.mu.Unlock()
+	})
+	return nil
+}
+
+// pubSrc is the sound.Source returned by PublishSink.
+type pubSrc struct {
+	*seg
+	path string
+	mu   sync.Mutex
+	r    uint64
+	once sync.Once
+}
+
+// Receive receives data sent by the process which opened the stream as a
+// sink, waiting for such a process if there is none.
+func (s *pubSrc) Receive(d []float64) (int, error) {
+	nC := s.nC
+	if len(d)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mem == nil {
+		return 0, io.EOF
+	}
+	nF := len(d) / nC
+	f := 0
+	for f < nF {
+		w := atomic.LoadUint64(s.u64(offW))
+		if w == s.r {
+			if s.closed() {
+				break
+			}
+			s.wait(offWSeq, offWWaiters, func() bool {
+				return atomic.LoadUint64(s.u64(offW)) == s.r && !s.closed()
+			})
+			continue
+		}
+		k := int(w - s.r)
+		if k > nF-f {
+			k = nF - f
+		}
+		s.read(s.r, d, nF, f, k)
+		s.r += uint64(k)
+		s.commit(offR, offRSeq, offRWaiters, s.r)
+		f += k
+	}
+	if f == 0 && nF != 0 {
+		return 0, io.EOF
+	}
+	return f, nil
+}
+
+// Close unpublishes the stream.  The process which opened it as a sink
+// gets ErrClosed.
+func (s *pubSrc) Close() error {
+	s.once.Do(func() {
+		atomic.StoreUint32(s.u32(offClosed), 1)
+		s.wake()
+		os.Remove(s.path)
+		s.mu.Lock()
+		s.unmap()
+		s.mu.Unlock()
+	})
+	return nil
+}
+
+// Source is the sound.Source returned by Entry.OpenSource.
+type Source struct {
+	*seg
+	mu      sync.Mutex
+	r       uint64
+	dropped int64
+	done    uint32
+}
+
+func openSource(s *seg) *Source {
+	return &Source{seg: s, r: atomic.LoadUint64(s.u64(offW))}
+}
+
+// Receive receives data written by the publisher after the stream was
+// opened.  It returns io.EOF once the publisher closes the stream and all
+// data has been received.
+func (s *Source) Receive(d []float64) (int, error) {
+	nC := s.nC
+	if len(d)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mem == nil {
+		return 0, io.EOF
+	}
+	nF := len(d) / nC
+	f := 0
+	for f < nF {
+		w := atomic.LoadUint64(s.u64(offW))
+		if w == s.r {
+			if s.closed() || atomic.LoadUint32(&s.done) != 0 {
+				break
+			}
+			s.wait(offWSeq, offWWaiters, func() bool {
+				return atomic.LoadUint64(s.u64(offW)) == s.r && !s.closed() &&
+					atomic.LoadUint32(&s.done) == 0
+			})
+			if atomic.LoadUint64(s.u64(offW)) == s.r && !alive(s.pid) {
+				break
+			}
+			continue
+		}
+		if w-s.r > s.capF {
+			s.overrun(w)
+			continue
+		}
+		k := int(w - s.r)
+		if k > nF-f {
+			k = nF - f
+		}
+		s.read(s.r, d, nF, f, k)
+		if atomic.LoadUint64(s.u64(offW))-s.r > s.capF {
+			// the publisher overwrote frames while we read them.
+			continue
+		}
+		s.r += uint64(k)
+		f += k
+	}
+	if f == 0 && nF != 0 {
+		return 0, io.EOF
+	}
+	return f, nil
+}
+
+// overrun skips to half the capacity of the ring behind the publisher.
+func (s *Source) overrun(w uint64) {
+	r := w - s.capF/2
+	atomic.AddInt64(&s.dropped, int64(r-s.r))
+	s.r = r
+}
+
+// Dropped returns the number of frames lost because s fell behind the
+// publisher by more than the capacity of the ring.
+func (s *Source) Dropped() int {
+	return int(atomic.LoadInt64(&s.dropped))
+}
+
+// Close closes s.  A concurrent Receive returns within a fraction of a
+// second.
+func (s *Source) Close() error {
+	atomic.StoreUint32(&s.done, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mem != nil {
+		s.unmap()
+	}
+	return nil
+}
+
+// snk is the sound.Sink returned by Entry.OpenSink.
+type snk struct {
+	*seg
+	mu   sync.Mutex
+	w    uint64
+	done uint32
+	// start is the time the first frame was written.
+	start time.Time
+}
+
+// openSink takes ownership of the published sink s.  The owner of a
+// stream whose process died may be replaced.
+func openSink(s *seg) (*snk, error) {
+	owner := s.u32(offOwner)
+	pid := uint32(os.Getpid())
+	if !atomic.CompareAndSwapUint32(owner, 0, pid) {
+		old := atomic.LoadUint32(owner)
+		if alive(int(old)) || !atomic.CompareAndSwapUint32(owner, old, pid) {
+			return nil, ErrBusy
+		}
+	}
+	return &snk{seg: s, w: atomic.LoadUint64(s.u64(offW))}, nil
+}
+
+// Send sends d to the publisher, waiting while the ring is full.
+func (s *snk) Send(d []float64) error {
+	nC := s.nC
+	if len(d)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mem == nil || s.closed() {
+		return ErrClosed
+	}
+	stop := func() bool {
+		return s.closed() || atomic.LoadUint32(&s.done) != 0
+	}
+	nF := len(d) / nC
+	for f := 0; f < nF; {
+		r := atomic.LoadUint64(s.u64(offR))
+		free := s.capF - (s.w - r)
+		if free == 0 {
+			s.wait(offRSeq, offRWaiters, func() bool {
+				return atomic.LoadUint64(s.u64(offR)) == r && !stop()
+			})
+			if stop() || (atomic.LoadUint64(s.u64(offR)) == r && !alive(s.pid)) {
+				return ErrClosed
+			}
+			continue
+		}
+		k := nF - f
+		if uint64(k) > free {
+			k = int(free)
+		}
+		s.write(s.w, d, nF, f, k)
+		if s.start.IsZero() {
+			s.start = time.Now()
+		}
+		s.w += uint64(k)
+		s.commit(offW, offWSeq, offWWaiters, s.w)
+		f += k
+	}
+	return nil
+}
+
+// Close releases the stream, which may then be opened by another process.
+func (s *snk) Close() error {
+	atomic.StoreUint32(&s.done, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mem != nil {
+		atomic.StoreUint32(s.u32(offOwner), 0)
+		s.unmap()
+	}
+	return nil
+}
@@ -4,6 +4,9 @@
 package sio
 
 import (
+	"context"
+
+	"zikichombo.org/sio/follow"
 	"zikichombo.org/sio/host"
 	"zikichombo.org/sound"
 	"zikichombo.org/sound/sample"
@@ -71,6 +74,36 @@ func PlayerWith(v sound.Form, co sample.Codec, b int) (sound.Sink, error) {
 	return host.PlayerWith(ent, v, co, b)
 }
 
+// PlayerOpts is as PlayerWith, applying the output stage options o, such
+// as dither, to the returned sink.
+func PlayerOpts(v sound.Form, co sample.Codec, b int, o *host.SinkOptions) (sound.Sink, error) {
+	ent, err := Connect(nil)
+	if err != nil {
+		return nil, err
+	}
+	return host.PlayerOpts(ent, v, co, b, o)
+}
+
+// FollowPlayer is as Player, but the returned sink follows the default
+// output device of the default entry when it changes, see package follow.
+func FollowPlayer(v sound.Form) (sound.Sink, error) {
+	ent, err := Connect(nil)
+	if err != nil {
+		return nil, err
+	}
+	return follow.NewSink(ent, v, ent.DefaultSampleCodec(), ent.DefaultBufSize(), nil)
+}
+
+// FollowCapture is as Capture, but the returned source follows the default
+// input device of the default entry when it changes, see package follow.
+func FollowCapture() (sound.Source, error) {
+	ent, err := Connect(nil)
+	if err != nil {
+		return nil, err
+	}
+	return follow.NewSource(ent, ent.DefaultForm(), ent.DefaultSampleCodec(), ent.DefaultBufSize(), nil)
+}
+
 // Duplex tries to return a sound.Duplex.
 func Duplex(in, out sound.Form) (sound.Duplex, error) {
 	ent, err := Connect(nil)
@@ -89,6 +122,19 @@ func DuplexWith(in, out sound.Form, co sample.Codec, b int) (sound.Duplex, error
 	return host.DuplexWith(ent, in, out, co, b)
 }
 
+// Run runs cb with the default entry once per period of a stream
+// configured by cfg, which may be nil for output with default settings,
+// until ctx is done or cb returns an error.  cb is passed channel
+// deinterleaved input and output buffers, allocated by Run before the
+// first period, and the time of the period.  See host.Run.
+func Run(ctx context.Context, cfg *host.RunConfig, cb host.Callback) error {
+	ent, err := Connect(nil)
+	if err != nil {
+		return err
+	}
+	return host.Run(ctx, ent, cfg, cb)
+}
+
 // Connect returns a connection to the default host sound system entry
 // point "entry".
 //
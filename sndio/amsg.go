@@ -0,0 +1,136 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package sndio
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// aucat protocol messages, as in sndio's amsg.h.  All fields are big endian.
+const (
+	amsgAck     = 0
+	amsgGetPar  = 1
+	amsgSetPar  = 2
+	amsgStart   = 3
+	amsgStop    = 4
+	amsgData    = 5
+	amsgFlowCtl = 6
+	amsgMove    = 7
+	amsgSetVol  = 9
+	amsgHello   = 10
+	amsgBye     = 11
+	amsgAuth    = 12
+
+	amsgVersion = 7
+	amsgDataMax = 0x1000
+	amsgSize    = 40
+	cookieLen   = 16
+
+	// hello modes.
+	modePlay = 0x1
+	modeRec  = 0x2
+
+	// aucatPort is the TCP port of unit 0.
+	aucatPort = 11025
+)
+
+// amsg is a fixed size protocol message.  The payload of data messages
+// follows the message.
+type amsg [amsgSize]byte
+
+func (m *amsg) reset(cmd uint32) {
+	*m = amsg{}
+	binary.BigEndian.PutUint32(m[0:], cmd)
+}
+
+func (m *amsg) cmd() uint32 {
+	return binary.BigEndian.Uint32(m[0:])
+}
+
+// u32 and putU32 access the uint32 at offset off of the message union,
+// used by data.size, ts.delta and vol.ctl.
+func (m *amsg) u32(off int) uint32 {
+	return binary.BigEndian.Uint32(m[8+off:])
+}
+
+func (m *amsg) putU32(off int, v uint32) {
+	binary.BigEndian.PutUint32(m[8+off:], v)
+}
+
+func (m *amsg) read(r io.Reader) error {
+	_, err := io.ReadFull(r, m[:])
+	return err
+}
+
+func (m *amsg) write(w io.Writer) error {
+	_, err := w.Write(m[:])
+	return err
+}
+
+// xrun policies.
+const (
+	xrunIgnore = 0
+	xrunSync   = 1
+	xrunError  = 2
+)
+
+// par is amsg_par, the stream parameters.
+type par struct {
+	xrun     uint8
+	bps      uint8 // bytes per sample
+	bits     uint8 // significant bits
+	msb      uint8 // 1 if msb justified
+	le       uint8 // 1 if little endian
+	sig      uint8 // 1 if signed
+	pchan    uint16
+	rchan    uint16
+	rate     uint32
+	bufsz    uint32 // total buffered frames
+	round    uint32 // frames per block
+	appbufsz uint32 // client side buffer size in frames
+}
+
+func (p *par) put(m *amsg) {
+	u := m[8:]
+	u[1] = p.xrun
+	u[2] = p.bps
+	u[3] = p.bits
+	u[4] = p.msb
+	u[5] = p.le
+	u[6] = p.sig
+	binary.BigEndian.PutUint16(u[8:], p.pchan)
+	binary.BigEndian.PutUint16(u[10:], p.rchan)
+	binary.BigEndian.PutUint32(u[12:], p.rate)
+	binary.BigEndian.PutUint32(u[16:], p.bufsz)
+	binary.BigEndian.PutUint32(u[20:], p.round)
+	binary.BigEndian.PutUint32(u[24:], p.appbufsz)
+}
+
+func (p *par) get(m *amsg) {
+	u := m[8:]
+	p.xrun = u[1]
+	p.bps = u[2]
+	p.bits = u[3]
+	p.msb = u[4]
+	p.le = u[5]
+	p.sig = u[6]
+	p.pchan = binary.BigEndian.Uint16(u[8:])
+	p.rchan = binary.BigEndian.Uint16(u[10:])
+	p.rate = binary.BigEndian.Uint32(u[12:])
+	p.bufsz = binary.BigEndian.Uint32(u[16:])
+	p.round = binary.BigEndian.Uint32(u[20:])
+	p.appbufsz = binary.BigEndian.Uint32(u[24:])
+}
+
+// hello fills m with a hello message.
+func hello(m *amsg, mode uint16, unit uint8, opt, who string) {
+	m.reset(amsgHello)
+	u := m[8:]
+	binary.BigEndian.PutUint16(u[0:], mode)
+	u[2] = amsgVersion
+	u[3] = unit
+	copy(u[8:19], opt)
+	copy(u[20:31], who)
+}
@@ -0,0 +1,156 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package sndio
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// devName is a parsed sndio device name.
+type devName struct {
+	host string // empty for the local unix socket.
+	unit int
+	opt  string
+}
+
+// parseDev parses device names of the form snd[@host]/unit[.opt].  The
+// name "default" is replaced by the value of the AUDIODEVICE environment
+// variable, or by "snd/0" if it isn't set.
+func parseDev(name string) (*devName, error) {
+	if name == "default" || name == "" {
+		name = os.Getenv("AUDIODEVICE")
+		if name == "" {
+			name = "snd/0"
+		}
+	}
+	bad := fmt.Errorf("sndio: bad device name %q", name)
+	i := strings.IndexByte(name, '/')
+	if i < 0 {
+		return nil, bad
+	}
+	typ, rest := name[:i], name[i+1:]
+	d := &devName{opt: "default"}
+	if j := strings.IndexByte(typ, '@'); j >= 0 {
+		d.host = typ[j+1:]
+		typ = typ[:j]
+	}
+	if typ != "snd" {
+		return nil, bad
+	}
+	if j := strings.IndexByte(rest, '.'); j >= 0 {
+		d.opt = rest[j+1:]
+		rest = rest[:j]
+	}
+	u, err := strconv.Atoi(rest)
+	if err != nil || u < 0 || u > 15 {
+		return nil, bad
+	}
+	d.unit = u
+	return d, nil
+}
+
+// dialTimeout bounds connecting and the handshake with the server.
+const dialTimeout = 5 * time.Second
+
+// dial connects to the server of d and authenticates with mode.
+func (e *Entry) dial(d *devName, mode uint16) (net.Conn, error) {
+	var c net.Conn
+	var err error
+	if d.host == "" {
+		c, err = net.DialTimeout("unix", filepath.Join(e.sockDir(), "sock"+strconv.Itoa(d.unit)), dialTimeout)
+	} else {
+		c, err = net.DialTimeout("tcp", net.JoinHostPort(d.host, strconv.Itoa(aucatPort+d.unit)), dialTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Now().Add(dialTimeout))
+	if err := e.handshake(c, d, mode); err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// ErrRefused is returned when the server refuses a connection, for example
+// because the cookie doesn't match or the device is busy.
+var ErrRefused = errors.New("sndio: connection refused by server")
+
+func (e *Entry) handshake(c net.Conn, d *devName, mode uint16) error {
+	var m amsg
+	cookie, err := e.cookie()
+	if err != nil {
+		return err
+	}
+	m.reset(amsgAuth)
+	copy(m[8:8+cookieLen], cookie)
+	if err := m.write(c); err != nil {
+		return err
+	}
+	hello(&m, mode, uint8(d.unit), d.opt, filepath.Base(os.Args[0]))
+	if err := m.write(c); err != nil {
+		return err
+	}
+	if err := m.read(c); err != nil || m.cmd() != amsgAck {
+		return ErrRefused
+	}
+	return nil
+}
+
+// negotiate sends p to the server and returns the parameters chosen by the
+// server.
+func negotiate(c net.Conn, p *par) (*par, error) {
+	var m amsg
+	m.reset(amsgSetPar)
+	p.put(&m)
+	if err := m.write(c); err != nil {
+		return nil, err
+	}
+	m.reset(amsgGetPar)
+	if err := m.write(c); err != nil {
+		return nil, err
+	}
+	c.SetReadDeadline(time.Now().Add(dialTimeout))
+	defer c.SetReadDeadline(time.Time{})
+	if err := m.read(c); err != nil {
+		return nil, err
+	}
+	if m.cmd() != amsgGetPar {
+		return nil, fmt.Errorf("sndio: unexpected message %d", m.cmd())
+	}
+	res := &par{}
+	res.get(&m)
+	return res, nil
+}
+
+// cookie returns the authentication cookie, creating it as libsndio does if
+// it doesn't exist.
+func (e *Entry) cookie() ([]byte, error) {
+	p := e.cookiePath()
+	if p == "" {
+		return make([]byte, cookieLen), nil
+	}
+	c, err := ioutil.ReadFile(p)
+	if err == nil && len(c) >= cookieLen {
+		return c[:cookieLen], nil
+	}
+	c = make([]byte, cookieLen)
+	if _, err := rand.Read(c); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err == nil {
+		ioutil.WriteFile(p, c, 0600)
+	}
+	return c, nil
+}
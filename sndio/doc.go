@@ -0,0 +1,19 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package sndio provides a host.Entry which is a pure Go client of the
+// sndio sound server, sndiod, using its aucat protocol over unix domain or
+// TCP sockets.
+//
+// sndio is the sound system of OpenBSD and is also available on Linux and
+// other systems.  Devices are named as in sndio(7), for example "snd/0" or
+// "snd@host/0", optionally followed by ".sub-device".  Playback, capture and
+// full-duplex streams are supported with integer sample codecs.  The
+// position of a stream follows the clock ticks reported by the server, see
+// Stream.Position.
+//
+// Package sndio registers the entry Default under the name "sndio" on
+// initialisation.
+//
+// Package sndio is part of http://zikichombo.org
+package sndio /* import "zikichombo.org/sio/sndio" */
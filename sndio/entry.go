@@ -0,0 +1,260 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package sndio
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// Entry is a host.Entry whose devices are sndio devices.
+//
+// Devices of local servers are found by their sockets.  Remote devices,
+// such as "snd@host/0", are added with AddDevice.  The device named
+// "default" is the one named by the AUDIODEVICE environment variable, or
+// snd/0.
+type Entry struct {
+	host.NullEntry
+
+	// SockDir is the directory of the unix domain sockets of local
+	// servers, /tmp/sndio if empty.
+	SockDir string
+
+	// CookiePath is the file holding the authentication cookie,
+	// $HOME/.sndio/cookie if empty.  As with libsndio, a new cookie is
+	// created if the file doesn't exist.
+	CookiePath string
+
+	mu     sync.Mutex
+	remote []string
+	devs   []*libsio.Dev
+}
+
+// Default is the Entry registered by package sndio.
+var Default = &Entry{}
+
+func init() {
+	if err := host.RegisterEntry(Default); err != nil {
+		log.Printf("zc failed load %s: %s\n", Default.Name(), err.Error())
+	}
+}
+
+func (e *Entry) sockDir() string {
+	if e.SockDir == "" {
+		return "/tmp/sndio"
+	}
+	return e.SockDir
+}
+
+func (e *Entry) cookiePath() string {
+	if e.CookiePath != "" {
+		return e.CookiePath
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".sndio", "cookie")
+}
+
+var codecs = []sample.Codec{
+	sample.SInt8,
+	sample.SInt16L, sample.SInt16B,
+	sample.SInt24L, sample.SInt24B,
+	sample.SInt32L, sample.SInt32B}
+
+// maxChannels is NCHAN_MAX of sndiod.
+const maxChannels = 64
+
+// caps are the capabilities of sndiod in either direction.
+var caps = libsio.Caps{
+	MinChannels: 1,
+	MaxChannels: maxChannels,
+	RateRanges:  []libsio.RateRange{{Min: 4000 * freq.Hertz, Max: 192000 * freq.Hertz}},
+	Codecs:      codecs}
+
+func newDev(id int, name string) *libsio.Dev {
+	return &libsio.Dev{
+		Id:   uint64(id),
+		UID:  name,
+		Name: name,
+		In:   caps,
+		Out:  caps}
+}
+
+// AddDevice adds the device with the sndio name name, for example
+// "snd@host/0", to the devices of e.
+func (e *Entry) AddDevice(name string) (*libsio.Dev, error) {
+	if _, err := parseDev(name); err != nil {
+		return nil, err
+	}
+	e.Devices()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.remote = append(e.remote, name)
+	d := newDev(len(e.devs), name)
+	e.devs = append(e.devs, d)
+	return d, nil
+}
+
+func (e *Entry) Name() string {
+	return "sndio"
+}
+
+func (e *Entry) DefaultBufSize() int {
+	return 480
+}
+
+func (e *Entry) DefaultSampleCodec() sample.Codec {
+	return sample.SInt16L
+}
+
+func (e *Entry) DefaultForm() sound.Form {
+	return sound.NewForm(48000*freq.Hertz, 2)
+}
+
+func (e *Entry) CanOpenSource() bool {
+	return true
+}
+
+// OpenSource opens a capture stream on d.  The returned sound.Source is a
+// *Stream, which is started and has received the server's first clock tick
+// when OpenSource returns.
+func (e *Entry) OpenSource(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Source, time.Time, error) {
+	s, err := newStream(e, sndioName(d), modeRec, v, nil, co, b)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	t, err := s.waitStart()
+	if err != nil {
+		s.Close()
+		return nil, t, err
+	}
+	return s, t, nil
+}
+
+func (e *Entry) CanOpenSink() bool {
+	return true
+}
+
+// OpenSink opens a playback stream on d.  The returned sound.Sink is a
+// *Stream.  The start time is set from the server's first clock tick, on a
+// call to Send following it.
+func (e *Entry) OpenSink(d *libsio.Dev, v sound.Form, co sample.Codec, b int) (sound.Sink, *time.Time, error) {
+	s, err := newStream(e, sndioName(d), modePlay, nil, v, co, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, &s.pstart, nil
+}
+
+func (e *Entry) CanOpenDuplex() bool {
+	return true
+}
+
+// OpenDuplex opens a full-duplex stream on d.  iv and ov must have the
+// same sample rate.  The returned sound.Duplex is a *Stream, which is
+// started when OpenDuplex returns.  The server's buffer is filled with
+// silence to start it, so data sent plays that long after the capture of
+// the data received with it.
+func (e *Entry) OpenDuplex(d *libsio.Dev, iv, ov sound.Form, co sample.Codec, b int) (sound.Duplex, time.Time, *time.Time, error) {
+	s, err := newStream(e, sndioName(d), modePlay|modeRec, iv, ov, co, b)
+	if err != nil {
+		return nil, time.Time{}, nil, err
+	}
+	t, err := s.waitStart()
+	if err != nil {
+		s.Close()
+		return nil, t, nil, err
+	}
+	return s, t, &s.pstart, nil
+}
+
+func sndioName(d *libsio.Dev) string {
+	if d == nil {
+		return "default"
+	}
+	return d.Name
+}
+
+func (e *Entry) HasDevices() bool {
+	return true
+}
+
+// ScanDevices returns the default device, one device per local server
+// socket and the devices added with AddDevice.
+func (e *Entry) ScanDevices() ([]*host.DevScanResult, error) {
+	devs := e.scan()
+	res := make([]*host.DevScanResult, len(devs))
+	for i, d := range devs {
+		res[i] = &host.DevScanResult{Dev: d}
+	}
+	return res, nil
+}
+
+func (e *Entry) scan() []*libsio.Dev {
+	names := []string{"default"}
+	socks, _ := filepath.Glob(filepath.Join(e.sockDir(), "sock*"))
+	units := make([]int, 0, len(socks))
+	for _, p := range socks {
+		u, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(p), "sock"))
+		if err == nil && u >= 0 && u <= 15 {
+			units = append(units, u)
+		}
+	}
+	sort.Ints(units)
+	for _, u := range units {
+		names = append(names, "snd/"+strconv.Itoa(u))
+	}
+	e.mu.Lock()
+	names = append(names, e.remote...)
+	e.mu.Unlock()
+	devs := make([]*libsio.Dev, len(names))
+	for i, n := range names {
+		devs[i] = newDev(i, n)
+	}
+	d := devs[0]
+	d.IsDefaultIn, d.IsDefaultOut, d.IsDefaultSys = true, true, true
+	return devs
+}
+
+func (e *Entry) Devices() []*libsio.Dev {
+	e.mu.Lock()
+	devs := e.devs
+	e.mu.Unlock()
+	if devs != nil {
+		return devs
+	}
+	devs = e.scan()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.devs == nil {
+		e.devs = devs
+	}
+	return e.devs
+}
+
+func (e *Entry) DefaultInputDev() *libsio.Dev {
+	return e.Devices()[0]
+}
+
+func (e *Entry) DefaultOutputDev() *libsio.Dev {
+	return e.Devices()[0]
+}
+
+func (e *Entry) DefaultDuplexDev() *libsio.Dev {
+	return e.Devices()[0]
+}
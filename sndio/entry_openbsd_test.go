@@ -0,0 +1,21 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package sndio
+
+import (
+	"testing"
+
+	"zikichombo.org/sio/host"
+)
+
+func TestConnectDefault(t *testing.T) {
+	e, err := host.Connect(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.Disconnect()
+	if e != Default {
+		t.Errorf("connected to %s, expected sndio", e.Name())
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package sndio
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+// fakeServer implements enough of sndiod to test streams.  Its clock ticks
+// every millisecond, regardless of the sample rate.  Captured data is a
+// ramp of 16 bit samples, frame f having value f in all channels.
+type fakeServer struct {
+	l      net.Listener
+	played chan []byte
+}
+
+func newFakeServer(t *testing.T, dir string) *fakeServer {
+	l, err := net.Listen("unix", filepath.Join(dir, "sock0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{l: l, played: make(chan []byte, 1)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) close() {
+	s.l.Close()
+}
+
+type fakeConn struct {
+	c    net.Conn
+	mu   sync.Mutex
+	p    par
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (fc *fakeConn) send(m *amsg, payload []byte) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	m.write(fc.c)
+	fc.c.Write(payload)
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+	fc := &fakeConn{c: c, quit: make(chan struct{})}
+	var played []byte
+	var m amsg
+	if m.read(c) != nil || m.cmd() != amsgAuth {
+		return
+	}
+	if m.read(c) != nil || m.cmd() != amsgHello {
+		return
+	}
+	m.reset(amsgAck)
+	fc.send(&m, nil)
+	stop := func() {
+		select {
+		case <-fc.quit:
+		default:
+			close(fc.quit)
+		}
+		fc.wg.Wait()
+	}
+	defer stop()
+	for {
+		if m.read(c) != nil {
+			return
+		}
+		switch m.cmd() {
+		case amsgSetPar:
+			fc.p.get(&m)
+			fc.p.bufsz = fc.p.appbufsz + fc.p.round
+		case amsgGetPar:
+			m.reset(amsgGetPar)
+			fc.p.put(&m)
+			fc.send(&m, nil)
+		case amsgStart:
+			if fc.p.pchan != 0 {
+				m.reset(amsgFlowCtl)
+				m.putU32(0, fc.p.appbufsz)
+				fc.send(&m, nil)
+			}
+			fc.wg.Add(1)
+			go fc.tick()
+		case amsgData:
+			buf := make([]byte, m.u32(0))
+			if _, err := io.ReadFull(c, buf); err != nil {
+				return
+			}
+			played = append(played, buf...)
+		case amsgStop:
+			stop()
+			m.reset(amsgStop)
+			fc.send(&m, nil)
+		case amsgBye:
+			if fc.p.pchan != 0 {
+				// only the first stream played is checked.
+				select {
+				case s.played <- played:
+				default:
+				}
+			}
+			return
+		}
+	}
+}
+
+func (fc *fakeConn) tick() {
+	defer fc.wg.Done()
+	round := int(fc.p.round)
+	bpf := int(fc.p.bps) * int(fc.p.rchan)
+	data := make([]byte, round*bpf)
+	f := 0
+	var m amsg
+	for {
+		select {
+		case <-fc.quit:
+			return
+		case <-time.After(time.Millisecond):
+		}
+		if fc.p.rchan != 0 {
+			for i := 0; i < round; i++ {
+				for c := 0; c < int(fc.p.rchan); c++ {
+					o := (i*int(fc.p.rchan) + c) * 2
+					data[o] = byte(f + i)
+					data[o+1] = byte((f + i) >> 8)
+				}
+			}
+			m.reset(amsgData)
+			m.putU32(0, uint32(len(data)))
+			fc.send(&m, data)
+			f += round
+		}
+		m.reset(amsgMove)
+		m.putU32(0, uint32(round))
+		fc.send(&m, nil)
+		if fc.p.pchan != 0 {
+			m.reset(amsgFlowCtl)
+			m.putU32(0, uint32(round))
+			fc.send(&m, nil)
+		}
+	}
+}
+
+func testEntry(t *testing.T) (*Entry, *fakeServer, func()) {
+	dir, err := ioutil.TempDir("", "sndio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newFakeServer(t, dir)
+	e := &Entry{SockDir: dir, CookiePath: filepath.Join(dir, "cookie")}
+	return e, srv, func() {
+		srv.close()
+		os.RemoveAll(dir)
+	}
+}
+
+var testForm = sound.NewForm(48000*freq.Hertz, 2)
+
+// ramp gives the value of captured frame f.
+func ramp(f int) float64 {
+	var d [1]float64
+	sample.SInt16L.Decode(d[:], []byte{byte(f), byte(f >> 8)})
+	return d[0]
+}
+
+func TestCapture(t *testing.T) {
+	e, _, done := testEntry(t)
+	defer done()
+	before := time.Now()
+	src, start, err := e.OpenSource(nil, testForm, sample.SInt16L, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start.Before(before) || start.After(time.Now()) {
+		t.Errorf("start time %s not while opening at %s", start, before)
+	}
+	s := src.(*Stream)
+	nF := 256
+	d := make([]float64, nF*2)
+	n, err := s.Receive(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != nF {
+		t.Fatalf("got %d frames not %d", n, nF)
+	}
+	// the first frames always fit in the ring.
+	for c := 0; c < 2; c++ {
+		for f := 0; f < nF; f++ {
+			if exp := ramp(f); d[c*nF+f] != exp {
+				t.Fatalf("channel %d frame %d: got %f not %f", c, f, d[c*nF+f], exp)
+			}
+		}
+	}
+	if pos, at := s.Position(); pos <= 0 || at.IsZero() {
+		t.Errorf("position %d at %s", pos, at)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Receive(d); err == nil {
+		t.Errorf("receive after close succeeded")
+	}
+}
+
+func TestPlay(t *testing.T) {
+	e, srv, done := testEntry(t)
+	defer done()
+	snk, _, err := e.OpenSink(nil, testForm, sample.SInt16B, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nF := 5000
+	d := make([]float64, nF*2)
+	for f := 0; f < nF; f++ {
+		d[f] = float64(f%1000) / 32768
+		d[nF+f] = -d[f]
+	}
+	if err := snk.Send(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := snk.Close(); err != nil {
+		t.Fatal(err)
+	}
+	played := <-srv.played
+	exp := make([]byte, nF*4)
+	il := make([]float64, nF*2)
+	for f := 0; f < nF; f++ {
+		il[2*f], il[2*f+1] = d[f], d[nF+f]
+	}
+	sample.SInt16B.Encode(exp, il)
+	if len(played) != len(exp) {
+		t.Fatalf("server got %d bytes not %d", len(played), len(exp))
+	}
+	for i := range exp {
+		if played[i] != exp[i] {
+			t.Fatalf("frame %d differs", i/4)
+		}
+	}
+}
+
+func TestDuplex(t *testing.T) {
+	e, srv, done := testEntry(t)
+	defer done()
+	iv := sound.NewForm(48000*freq.Hertz, 1)
+	dpx, in0, out0, err := e.OpenDuplex(nil, iv, testForm, sample.SInt16L, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the server's buffer was filled with silence to start the stream.
+	prime := int(dpx.(*Stream).par.appbufsz)
+	if in0.IsZero() || out0 == nil || out0.Sub(in0) != time.Duration(prime)*iv.SampleRate().Period() {
+		t.Errorf("start times %s and %v", in0, out0)
+	}
+	if dpx.InChannels() != 1 || dpx.OutChannels() != 2 {
+		t.Fatalf("got %d/%d channels", dpx.InChannels(), dpx.OutChannels())
+	}
+	out := make([]float64, 64)
+	in := make([]float64, 32)
+	for i := 0; i < 4; i++ {
+		n, err := dpx.SendReceive(out, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 32 {
+			t.Fatalf("got %d frames", n)
+		}
+	}
+	if in[0] != ramp(96) {
+		t.Errorf("got %f", in[0])
+	}
+	dpx.Close()
+	if played := <-srv.played; len(played) != (prime+4*32)*4 {
+		t.Errorf("server got %d bytes", len(played))
+	}
+}
+
+func TestDevices(t *testing.T) {
+	e, _, done := testEntry(t)
+	defer done()
+	devs := e.Devices()
+	if len(devs) != 2 || devs[0].Name != "default" || devs[1].Name != "snd/0" {
+		t.Fatalf("got devices %v", devs)
+	}
+	d, err := e.AddDevice("snd@localhost/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if devs := e.Devices(); len(devs) != 3 || devs[2] != d || devs[0] != e.DefaultOutputDev() {
+		t.Errorf("got devices %v", devs)
+	}
+	if _, err := e.AddDevice("rsnd/0"); err == nil {
+		t.Errorf("bad name accepted")
+	}
+}
+
+func TestParseDev(t *testing.T) {
+	os.Setenv("AUDIODEVICE", "")
+	for _, tc := range []struct {
+		name string
+		host string
+		unit int
+		opt  string
+	}{
+		{"default", "", 0, "default"},
+		{"snd/1", "", 1, "default"},
+		{"snd@host/2.mon", "host", 2, "mon"},
+	} {
+		d, err := parseDev(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.host != tc.host || d.unit != tc.unit || d.opt != tc.opt {
+			t.Errorf("%s: got %+v", tc.name, d)
+		}
+	}
+	for _, bad := range []string{"snd", "snd/x", "rsnd/0", "snd/16"} {
+		if _, err := parseDev(bad); err == nil {
+			t.Errorf("%s: no error", bad)
+		}
+	}
+}
@@ -0,0 +1,488 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package sndio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// ErrClosed is returned by I/O on a closed Stream.
+var ErrClosed = errors.New("sndio: stream closed")
+
+// stopTimeout bounds the time Close waits for the server to drain
+// playback, in addition to the duration of the server's buffer.
+const stopTimeout = 2 * time.Second
+
+// Stream is a connection to a sndio server for playback, capture or both.
+//
+// Stream implements sound.Source for capture, sound.Sink for playback and
+// sound.Duplex for both.  Data flows once the stream is started.  Capture
+// and duplex streams are started when they are opened, playback streams on
+// the first call to Send.
+type Stream struct {
+	sound.Form
+	c      net.Conn
+	mode   uint16
+	co     sample.Codec
+	par    par
+	ibpf   int
+	obpf   int
+	pstart time.Time
+
+	// capture
+	ring    *libsio.Ring
+	src     sound.Source
+	pend    []byte
+	rbuf    []byte
+	rfbuf   []float64
+	dropped int64
+
+	// playback, used only by the caller of Send.
+	wbuf  []byte
+	wfbuf []float64
+
+	wmu     sync.Mutex // serialises writes to c.
+	mu      sync.Mutex
+	cond    sync.Cond
+	credit  int // bytes which the server accepts.
+	pos     int64
+	posT    time.Time
+	startT  time.Time
+	started bool
+	closed  bool
+	err     error
+
+	stopC     chan struct{}
+	stopOnce  sync.Once
+	doneC     chan struct{}
+	closeOnce sync.Once
+}
+
+// wireFormat gives the byte size and endianness of integer sample codecs.
+func wireFormat(co sample.Codec) (bps, le uint8, err error) {
+	switch co {
+	case sample.SInt8:
+		return 1, 1, nil
+	case sample.SInt16L, sample.SInt24L, sample.SInt32L:
+		return uint8(co.Bytes()), 1, nil
+	case sample.SInt16B, sample.SInt24B, sample.SInt32B:
+		return uint8(co.Bytes()), 0, nil
+	}
+	return 0, 0, fmt.Errorf("sndio: unsupported sample codec %s", co)
+}
+
+// newStream connects to device name and negotiates a stream for mode.  iv
+// is the form of captured data and ov that of played data; either is nil if
+// mode excludes it.  Blocks are b frames.
+func newStream(e *Entry, name string, mode uint16, iv, ov sound.Form, co sample.Codec, b int) (*Stream, error) {
+	bps, le, err := wireFormat(co)
+	if err != nil {
+		return nil, err
+	}
+	if b <= 0 {
+		b = e.DefaultBufSize()
+	}
+	p := &par{
+		xrun:     xrunIgnore,
+		bps:      bps,
+		bits:     8 * bps,
+		msb:      1,
+		le:       le,
+		sig:      1,
+		round:    uint32(b),
+		appbufsz: uint32(2 * b)}
+	v := ov
+	if mode&modePlay != 0 {
+		p.pchan = uint16(ov.Channels())
+	}
+	if mode&modeRec != 0 {
+		p.rchan = uint16(iv.Channels())
+		if v == nil {
+			v = iv
+		} else if iv.SampleRate() != ov.SampleRate() {
+			return nil, fmt.Errorf("sndio: input and output sample rates differ")
+		}
+	}
+	p.rate = uint32(v.SampleRate().Float64())
+	dn, err := parseDev(name)
+	if err != nil {
+		return nil, err
+	}
+	c, err := e.dial(dn, mode)
+	if err != nil {
+		return nil, err
+	}
+	got, err := negotiate(c, p)
+	if err == nil {
+		err = checkPar(p, got)
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	s := &Stream{
+		Form:  v,
+		c:     c,
+		mode:  mode,
+		co:    co,
+		par:   *got,
+		ibpf:  int(bps) * int(got.rchan),
+		obpf:  int(bps) * int(got.pchan),
+		stopC: make(chan struct{}),
+		doneC: make(chan struct{})}
+	s.cond.L = &s.mu
+	if mode&modeRec != 0 {
+		n := int(got.appbufsz)
+		if n < 4*b {
+			n = 4 * b
+		}
+		s.ring = libsio.NewRing(iv, n)
+		s.src = libsio.RingSource(s.ring)
+		s.rbuf = make([]byte, amsgDataMax+s.ibpf)
+		s.rfbuf = make([]float64, (amsgDataMax/s.ibpf+1)*int(got.rchan))
+	}
+	if mode&modePlay != 0 {
+		s.wbuf = make([]byte, amsgSize+amsgDataMax)
+		s.wfbuf = make([]float64, amsgDataMax/s.obpf*int(got.pchan))
+	}
+	go s.serve()
+	return s, nil
+}
+
+// checkPar checks that the server accepted the parameters requested in p.
+// Servers may change the block and buffer sizes, but sndio streams don't
+// convert formats on the client side.
+func checkPar(p, got *par) error {
+	if got.bps != p.bps || got.sig != p.sig || got.pchan != p.pchan ||
+		got.rchan != p.rchan || got.rate != p.rate ||
+		(p.bps > 1 && got.le != p.le) {
+		return fmt.Errorf("sndio: server doesn't support format %d bytes %d Hz %d/%d channels",
+			p.bps, p.rate, p.pchan, p.rchan)
+	}
+	if got.bits != 8*got.bps && got.msb == 0 {
+		return fmt.Errorf("sndio: server uses lsb aligned %d bit samples", got.bits)
+	}
+	return nil
+}
+
+// InChannels returns the number of captured channels.
+func (s *Stream) InChannels() int {
+	return int(s.par.rchan)
+}
+
+// OutChannels returns the number of played channels.
+func (s *Stream) OutChannels() int {
+	return int(s.par.pchan)
+}
+
+// Codec returns the sample codec of s.
+func (s *Stream) Codec() sample.Codec {
+	return s.co
+}
+
+// BlockSize returns the number of frames per block chosen by the server.
+func (s *Stream) BlockSize() int {
+	return int(s.par.round)
+}
+
+// Position returns the number of frames played or recorded by the device,
+// as reported by the server's clock ticks, and the time at which the last
+// tick was received.  The position is 0 and the time zero until the first
+// tick.
+func (s *Stream) Position() (int64, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pos, s.posT
+}
+
+// Dropped returns the number of captured frames dropped because they were
+// not received in time.
+func (s *Stream) Dropped() int {
+	return int(atomic.LoadInt64(&s.dropped))
+}
+
+// start sends the start message on the first call.
+func (s *Stream) start() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	if s.started {
+		s.mu.Unlock()
+		return nil
+	}
+	s.started = true
+	s.mu.Unlock()
+	var m amsg
+	m.reset(amsgStart)
+	return s.write(m[:])
+}
+
+// waitStart starts s and waits for the first clock tick of the server,
+// returning the time of the first frame.  The server starts playback once
+// its buffer is full, so the buffer of duplex streams is first filled with
+// silence and the playback start time is that of the first frame sent
+// after it.
+func (s *Stream) waitStart() (time.Time, error) {
+	if err := s.start(); err != nil {
+		return time.Time{}, err
+	}
+	var prime int
+	if s.mode&modePlay != 0 {
+		prime = int(s.par.appbufsz)
+		if err := s.Send(make([]float64, prime*int(s.par.pchan))); err != nil {
+			return time.Time{}, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.startT.IsZero() && s.err == nil && !s.closed {
+		s.cond.Wait()
+	}
+	if s.startT.IsZero() {
+		if s.err != nil && s.err != io.EOF {
+			return time.Time{}, s.err
+		}
+		return time.Time{}, ErrClosed
+	}
+	if s.mode&modePlay != 0 {
+		s.pstart = s.startT.Add(time.Duration(prime) * s.SampleRate().Period())
+	}
+	return s.startT, nil
+}
+
+// write writes messages in b to the server.
+func (s *Stream) write(b []byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	_, err := s.c.Write(b)
+	return err
+}
+
+// Receive receives captured data, as in sound.Source.
+func (s *Stream) Receive(d []float64) (int, error) {
+	if s.src == nil {
+		return 0, fmt.Errorf("sndio: stream not opened for capture")
+	}
+	if err := s.start(); err != nil {
+		return 0, err
+	}
+	n, err := s.src.Receive(d)
+	if err == io.EOF {
+		if ferr := s.failure(); ferr != nil {
+			err = ferr
+		}
+	}
+	return n, err
+}
+
+// failure returns the error which stopped s, if it was not an orderly
+// end of stream.
+func (s *Stream) failure() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// Send sends data for playback, as in sound.Sink.  Send blocks until the
+// server accepts all of d.
+func (s *Stream) Send(d []float64) error {
+	if s.wbuf == nil {
+		return fmt.Errorf("sndio: stream not opened for playback")
+	}
+	nC := int(s.par.pchan)
+	if len(d)%nC != 0 {
+		return sound.ErrChannelAlignment
+	}
+	if err := s.start(); err != nil {
+		return err
+	}
+	nF := len(d) / nC
+	maxF := amsgDataMax / s.obpf
+	f := 0
+	for f < nF {
+		s.mu.Lock()
+		for s.credit < s.obpf && s.err == nil && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed || s.err != nil {
+			err := s.err
+			s.mu.Unlock()
+			if err == nil || err == io.EOF {
+				err = ErrClosed
+			}
+			return err
+		}
+		k := s.credit / s.obpf
+		if k > maxF {
+			k = maxF
+		}
+		if k > nF-f {
+			k = nF - f
+		}
+		s.credit -= k * s.obpf
+		if s.pstart.IsZero() && !s.startT.IsZero() {
+			s.pstart = s.startT
+		}
+		s.mu.Unlock()
+		fb := s.wfbuf[:k*nC]
+		for i := 0; i < k; i++ {
+			for c := 0; c < nC; c++ {
+				fb[i*nC+c] = d[c*nF+f+i]
+			}
+		}
+		n := k * s.obpf
+		s.co.Encode(s.wbuf[amsgSize:amsgSize+n], fb)
+		var m amsg
+		m.reset(amsgData)
+		m.putU32(0, uint32(n))
+		copy(s.wbuf, m[:])
+		if err := s.write(s.wbuf[:amsgSize+n]); err != nil {
+			return err
+		}
+		f += k
+	}
+	return nil
+}
+
+// SendReceive sends out for playback and then receives the same number of
+// frames of captured data in in, as in sound.Duplex.
+func (s *Stream) SendReceive(out, in []float64) (int, error) {
+	nO, nI := s.OutChannels(), s.InChannels()
+	if nO == 0 || nI == 0 {
+		return 0, fmt.Errorf("sndio: stream not opened for duplex")
+	}
+	if len(out)%nO != 0 || len(in)%nI != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	if len(out)/nO != len(in)/nI {
+		return 0, sound.ErrFrameAlignment
+	}
+	if err := s.Send(out); err != nil {
+		return 0, err
+	}
+	return s.Receive(in)
+}
+
+// serve reads messages from the server until the connection fails or is
+// closed.
+func (s *Stream) serve() {
+	defer close(s.doneC)
+	var m amsg
+	var err error
+	for err == nil {
+		if err = m.read(s.c); err != nil {
+			break
+		}
+		switch m.cmd() {
+		case amsgData:
+			err = s.capture(int(m.u32(0)))
+		case amsgFlowCtl:
+			s.mu.Lock()
+			s.credit += int(int32(m.u32(0))) * s.obpf
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		case amsgMove:
+			s.tick(int64(int32(m.u32(0))))
+		case amsgStop:
+			s.stopOnce.Do(func() { close(s.stopC) })
+		}
+	}
+	s.mu.Lock()
+	if s.closed {
+		err = io.EOF
+	}
+	if s.err == nil {
+		s.err = err
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	if s.ring != nil {
+		s.ring.Close()
+	}
+}
+
+// tick handles a clock tick of delta frames.
+func (s *Stream) tick(delta int64) {
+	now := time.Now()
+	s.mu.Lock()
+	if s.startT.IsZero() {
+		// the first tick follows the first block.
+		s.startT = now.Add(-time.Duration(delta) * s.SampleRate().Period())
+		if s.ring != nil {
+			s.ring.SetStart(s.startT)
+		}
+		s.cond.Broadcast()
+	}
+	s.pos += delta
+	s.posT = now
+	s.mu.Unlock()
+}
+
+// capture reads a data message payload of n bytes and writes the complete
+// frames it contains to the ring.
+func (s *Stream) capture(n int) error {
+	if n > amsgDataMax || s.ring == nil {
+		return fmt.Errorf("sndio: bad data message of %d bytes", n)
+	}
+	p := len(s.pend)
+	buf := s.rbuf[:p+n]
+	copy(buf, s.pend)
+	if _, err := io.ReadFull(s.c, buf[p:]); err != nil {
+		return err
+	}
+	nF := len(buf) / s.ibpf
+	nB := nF * s.ibpf
+	fb := s.rfbuf[:nF*int(s.par.rchan)]
+	s.co.Decode(fb, buf[:nB])
+	if w := s.ring.Write(fb); w < nF {
+		atomic.AddInt64(&s.dropped, int64(nF-w))
+	}
+	s.pend = append(s.pend[:0], buf[nB:]...)
+	return nil
+}
+
+// Close stops s, waiting for playback to drain, and closes the connection.
+func (s *Stream) Close() error {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		started := s.started
+		s.closed = true
+		s.cond.Broadcast()
+		s.mu.Unlock()
+		if started {
+			var m amsg
+			m.reset(amsgStop)
+			m[8] = 1 // drain
+			if s.write(m[:]) == nil {
+				d := time.Duration(s.par.bufsz)*s.SampleRate().Period() + stopTimeout
+				select {
+				case <-s.stopC:
+				case <-s.doneC:
+				case <-time.After(d):
+				}
+			}
+		}
+		var m amsg
+		m.reset(amsgBye)
+		s.write(m[:])
+		s.c.Close()
+		<-s.doneC
+	})
+	return nil
+}
@@ -0,0 +1,21 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package soak runs sound streams for a long time under load and reports
+// glitch statistics.
+//
+// RunSource and RunSink exchange buffers with a stream while a Load keeps
+// the CPUs, the garbage collector and the scheduler busy.  They record
+// histograms of the time spent in each call, of the slip of each call
+// behind the real time schedule of the stream, and of the deadlines missed
+// by streams which report them, such as libsio.Cb, counting those the
+// stream couldn't report.  Packet gaps are
+// counted for libsio.RawSources and xruns for streams with a counter, see
+// Config.Xruns.  Reports of runs with different buffer sizes, entries or
+// Go versions can then be compared.
+//
+// Emulate provides streams fed by emulated callback threads with
+// configurable jitter, as for testing libsio.Cb.
+//
+// Package soak is part of http://zikichombo.org
+package soak /* import "zikichombo.org/sio/soak" */
@@ -0,0 +1,33 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build cgo
+
+package soak
+
+import (
+	"time"
+
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Emulate returns a libsio.Cb of form v, sample codec co and buffer size b
+// called back by an emulated audio API for about d.  Callbacks are made in
+// real time, each delayed by a random duration up to jitter, see
+// libsio.EmulateCb.  If input is true, the Cb is a capture stream,
+// otherwise a playback stream.
+//
+// The returned channel receives the result of the emulation when it ends.
+// The Cb must be used until it returns io.EOF, and then closed.
+func Emulate(v sound.Form, co sample.Codec, b int, input bool, d, jitter time.Duration) (*libsio.Cb, <-chan error) {
+	cb := libsio.NewCb(v, co, b)
+	period := time.Duration(float64(b) * float64(time.Second) / v.SampleRate().Float64())
+	n := int(d / period)
+	errC := make(chan error, 1)
+	go func() {
+		errC <- libsio.EmulateCb(cb, input, n, period, jitter)
+	}()
+	return cb, errC
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// +build cgo
+
+package soak
+
+import (
+	"testing"
+	"time"
+
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+func TestEmulate(t *testing.T) {
+	v := sound.NewForm(48000*freq.Hertz, 2)
+	load := Load{CPU: 1, Alloc: 1 << 22, Goroutines: 8}
+	for _, input := range []bool{true, false} {
+		cb, errC := Emulate(v, sample.SInt16L, 480, input, 300*time.Millisecond, 2*time.Millisecond)
+		cfg := &Config{BufSize: 480, Load: load}
+		var rep *Report
+		var err error
+		if input {
+			rep, err = RunSource(cb, cfg)
+		} else {
+			rep, err = RunSink(cb, cfg)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errC; err != nil {
+			t.Fatal(err)
+		}
+		cb.Close()
+		if rep.Calls != 30 || rep.Frames != 30*480 {
+			t.Errorf("input %t: %d calls, %d frames", input, rep.Calls, rep.Frames)
+		}
+		if rep.Slip.Count != 29 || rep.CallTime.Count != 30 {
+			t.Errorf("input %t: %d slips, %d call times", input, rep.Slip.Count, rep.CallTime.Count)
+		}
+	}
+}
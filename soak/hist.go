@@ -0,0 +1,102 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package soak
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// NumBuckets is the number of buckets of a Histogram.
+const NumBuckets = 32
+
+// Histogram is a histogram of durations with buckets of powers of 2
+// microseconds.  Bucket 0 counts durations under 1µs, and bucket i > 0
+// durations from 2^(i-1)µs up to 2^iµs.  The last bucket also counts
+// longer durations.
+type Histogram struct {
+	Buckets [NumBuckets]int64
+	Count   int64
+	Sum     time.Duration
+	Max     time.Duration
+}
+
+// Add adds d to h.  Negative durations count as 0.
+func (h *Histogram) Add(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	i := 0
+	for us := d / time.Microsecond; us > 0 && i < NumBuckets-1; us >>= 1 {
+		i++
+	}
+	h.Buckets[i]++
+	h.Count++
+	h.Sum += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+// Mean returns the mean duration of h.
+func (h *Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Bound returns the upper bound of bucket i.
+func Bound(i int) time.Duration {
+	return time.Duration(1<<uint(i)) * time.Microsecond
+}
+
+// Quantile returns an upper bound of the q quantile of h, the bound of the
+// bucket in which it falls, or Max if that is smaller.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	n := int64(q*float64(h.Count) + 0.5)
+	var c int64
+	for i, b := range h.Buckets {
+		c += b
+		if c >= n {
+			if bd := Bound(i); bd < h.Max {
+				return bd
+			}
+			break
+		}
+	}
+	return h.Max
+}
+
+// Write writes h to w as text with a bar per non-empty bucket.
+func (h *Histogram) Write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "n %d, mean %v, p99 %v, max %v\n", h.Count, h.Mean(), h.Quantile(0.99), h.Max)
+	if err != nil || h.Count == 0 {
+		return err
+	}
+	var top int64
+	for _, b := range h.Buckets {
+		if b > top {
+			top = b
+		}
+	}
+	for i, b := range h.Buckets {
+		if b == 0 {
+			continue
+		}
+		n := int(50 * b / top)
+		if n == 0 {
+			n = 1
+		}
+		if _, err := fmt.Fprintf(w, "\t< %-10v %10d %s\n", Bound(i), b, strings.Repeat("#", n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package soak
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Load configures background load.  The zero Load is no load.
+type Load struct {
+	// CPU is the number of goroutines which compute without pause.
+	CPU int
+
+	// Alloc is the number of bytes of garbage allocated per second, in
+	// blocks of 4KiB, to keep the garbage collector busy.
+	Alloc int
+
+	// Goroutines is the number of goroutines which sleep for Wake and
+	// compute briefly, in a loop, to keep the scheduler busy.
+	Goroutines int
+
+	// Wake is the sleep period of Goroutines, 1ms by default.
+	Wake time.Duration
+}
+
+// Start starts l and returns a function which stops it.
+func (l Load) Start() (stop func()) {
+	var done int32
+	var wg sync.WaitGroup
+	quit := func() bool {
+		return atomic.LoadInt32(&done) != 0
+	}
+	for i := 0; i < l.CPU; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			x := 1.0
+			for !quit() {
+				for j := 0; j < 10000; j++ {
+					x = x*1.0000001 + 1e-9
+				}
+			}
+			sink(x)
+		}()
+	}
+	if l.Alloc > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			const blk = 4096
+			// a window of live blocks, so that the heap isn't trivial.
+			live := make([][]byte, 256)
+			per := l.Alloc / 1000 / blk
+			if per == 0 {
+				per = 1
+			}
+			i := 0
+			tick := time.NewTicker(time.Millisecond)
+			defer tick.Stop()
+			for !quit() {
+				<-tick.C
+				for j := 0; j < per; j++ {
+					live[i%len(live)] = make([]byte, blk)
+					i++
+				}
+			}
+		}()
+	}
+	wake := l.Wake
+	if wake <= 0 {
+		wake = time.Millisecond
+	}
+	for i := 0; i < l.Goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			x := 1.0
+			for !quit() {
+				time.Sleep(wake)
+				for j := 0; j < 100; j++ {
+					x = x*1.0000001 + 1e-9
+				}
+			}
+			sink(x)
+		}()
+	}
+	return func() {
+		atomic.StoreInt32(&done, 1)
+		wg.Wait()
+	}
+}
+
+var sunk float64
+var sinkMu sync.Mutex
+
+// sink keeps the computations of load goroutines from being optimized
+// away.
+func sink(x float64) {
+	sinkMu.Lock()
+	sunk += x
+	sinkMu.Unlock()
+}
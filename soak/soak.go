@@ -0,0 +1,273 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package soak
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+)
+
+// Config configures a run.
+type Config struct {
+	// Duration is the duration of the run.  If it is 0, the run lasts
+	// until the stream ends or Stop is closed.
+	Duration time.Duration
+
+	// Stop ends the run when closed, if not nil.
+	Stop <-chan struct{}
+
+	// BufSize is the number of frames exchanged per call, 256 by
+	// default.  Some streams, such as libsio.Cb, need a multiple of
+	// their buffer size.
+	BufSize int
+
+	// Load is the background load during the run.
+	Load Load
+
+	// Raw makes RunSource use ReceiveRaw on sources which implement
+	// libsio.RawSource, so that packet gaps are counted.
+	Raw bool
+
+	// Xruns returns the xrun counter of the stream, if not nil.
+	Xruns func() int64
+}
+
+// Report holds the statistics of a run.
+type Report struct {
+	// GoVersion is the version of Go of the run.
+	GoVersion string
+
+	// Load is the background load.
+	Load Load
+
+	// Duration is the duration of the run.
+	Duration time.Duration
+
+	// Calls and Frames count the calls to the stream and the frames
+	// exchanged.
+	Calls, Frames int64
+
+	// CallTime is the histogram of the time spent in each call.
+	CallTime Histogram
+
+	// Slip is the histogram of how late each call returned with respect
+	// to the real time schedule set by the first call.
+	Slip Histogram
+
+	// Missed is the histogram of the OffBy of the deadlines missed by
+	// streams which report them with a LastMisses method, as libsio.Cb.
+	Missed Histogram
+
+	// DroppedMisses counts the missed deadlines which aren't in Missed
+	// as the stream didn't keep them, for streams which count them with a
+	// DroppedMisses method, as libsio.Cb.
+	DroppedMisses int64
+
+	// Gaps counts the discontinuities of packet frame numbers of raw
+	// sources, and GapFrames the frames lost in them.
+	Gaps, GapFrames int64
+
+	// Xruns is the increase of Config.Xruns during the run.
+	Xruns int64
+
+	// GCs is the number of garbage collections during the run, and
+	// GCPause their total pause.
+	GCs     uint32
+	GCPause time.Duration
+}
+
+// misser is implemented by streams reporting missed deadlines.
+type misser interface {
+	LastMisses() []libsio.MissedDeadline
+}
+
+// dropper is implemented by streams counting the missed deadlines they
+// didn't report.
+type dropper interface {
+	DroppedMisses() int64
+}
+
+// run holds the state of a run.
+type run struct {
+	cfg    Config
+	rep    Report
+	start  time.Time
+	first  time.Time // return of the first call
+	frames int64     // frames of the first call
+	period float64   // ns
+	xruns  int64
+	drops  int64 // DroppedMisses of the stream at the start
+	ms     runtime.MemStats
+}
+
+func newRun(cfg *Config, s sound.Form) *run {
+	r := &run{period: float64(time.Second) / s.SampleRate().Float64()}
+	if cfg != nil {
+		r.cfg = *cfg
+	}
+	if r.cfg.BufSize <= 0 {
+		r.cfg.BufSize = 256
+	}
+	r.rep.GoVersion = runtime.Version()
+	r.rep.Load = r.cfg.Load
+	if r.cfg.Xruns != nil {
+		r.xruns = r.cfg.Xruns()
+	}
+	if d, ok := s.(dropper); ok {
+		r.drops = d.DroppedMisses()
+	}
+	runtime.ReadMemStats(&r.ms)
+	r.start = time.Now()
+	return r
+}
+
+func (r *run) done() bool {
+	if r.cfg.Stop != nil {
+		select {
+		case <-r.cfg.Stop:
+			return true
+		default:
+		}
+	}
+	return r.cfg.Duration > 0 && time.Since(r.start) >= r.cfg.Duration
+}
+
+// call records a call to s which started at t and exchanged n frames.
+func (r *run) call(s interface{}, t time.Time, n int) {
+	now := time.Now()
+	r.rep.CallTime.Add(now.Sub(t))
+	if r.rep.Calls == 0 {
+		r.first = now
+		r.frames = int64(n)
+	} else {
+		due := r.first.Add(time.Duration(float64(r.rep.Frames+int64(n)-r.frames) * r.period))
+		r.rep.Slip.Add(now.Sub(due))
+	}
+	r.rep.Calls++
+	r.rep.Frames += int64(n)
+	if m, ok := s.(misser); ok {
+		for _, md := range m.LastMisses() {
+			r.rep.Missed.Add(md.OffBy)
+		}
+	}
+	if d, ok := s.(dropper); ok {
+		r.rep.DroppedMisses = d.DroppedMisses() - r.drops
+	}
+}
+
+func (r *run) finish() *Report {
+	r.rep.Duration = time.Since(r.start)
+	if r.cfg.Xruns != nil {
+		r.rep.Xruns = r.cfg.Xruns() - r.xruns
+	}
+	gc, pause := r.ms.NumGC, r.ms.PauseTotalNs
+	runtime.ReadMemStats(&r.ms)
+	r.rep.GCs = r.ms.NumGC - gc
+	r.rep.GCPause = time.Duration(r.ms.PauseTotalNs - pause)
+	return &r.rep
+}
+
+// RunSource receives from src under the load of cfg until the run ends,
+// and reports the statistics of the run.  The run ends early without
+// error if src returns io.EOF.
+func RunSource(src sound.Source, cfg *Config) (*Report, error) {
+	r := newRun(cfg, src)
+	stop := r.cfg.Load.Start()
+	defer stop()
+	nC := src.Channels()
+	b := r.cfg.BufSize
+	rs, raw := src.(libsio.RawSource)
+	raw = raw && r.cfg.Raw
+	var d []float64
+	var pkt libsio.RawPacket
+	if raw {
+		pkt.D = make([]byte, b*nC*rs.Codec().Bytes())
+	} else {
+		d = make([]float64, b*nC)
+	}
+	next := int64(-1)
+	for !r.done() {
+		var n int
+		var err error
+		t := time.Now()
+		if raw {
+			pkt.D = pkt.D[:cap(pkt.D)]
+			err = rs.ReceiveRaw(&pkt)
+			n = len(pkt.D) / (nC * rs.Codec().Bytes())
+		} else {
+			n, err = src.Receive(d)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return r.finish(), err
+		}
+		r.call(src, t, n)
+		if raw {
+			if next >= 0 && int64(pkt.N) != next {
+				r.rep.Gaps++
+				r.rep.GapFrames += int64(pkt.N) - next
+			}
+			next = int64(pkt.N + n)
+		}
+	}
+	return r.finish(), nil
+}
+
+// RunSink sends silence to snk under the load of cfg until the run ends,
+// and reports the statistics of the run.  The run ends early without
+// error if snk returns io.EOF.
+func RunSink(snk sound.Sink, cfg *Config) (*Report, error) {
+	r := newRun(cfg, snk)
+	stop := r.cfg.Load.Start()
+	defer stop()
+	b := r.cfg.BufSize
+	d := make([]float64, b*snk.Channels())
+	for !r.done() {
+		t := time.Now()
+		err := snk.Send(d)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return r.finish(), err
+		}
+		r.call(snk, t, b)
+	}
+	return r.finish(), nil
+}
+
+// Write writes r to w as text.
+func (r *Report) Write(w io.Writer) error {
+	l := r.Load
+	_, err := fmt.Fprintf(w, "%s, load cpu %d alloc %dB/s goroutines %d\n"+
+		"duration %v, %d calls, %d frames\n"+
+		"%d gc, pause %v\n"+
+		"xruns %d, gaps %d (%d frames), %d missed deadlines dropped\n",
+		r.GoVersion, l.CPU, l.Alloc, l.Goroutines,
+		r.Duration, r.Calls, r.Frames,
+		r.GCs, r.GCPause,
+		r.Xruns, r.Gaps, r.GapFrames, r.DroppedMisses)
+	if err != nil {
+		return err
+	}
+	for _, h := range []struct {
+		name string
+		h    *Histogram
+	}{{"call time", &r.CallTime}, {"slip", &r.Slip}, {"missed deadlines", &r.Missed}} {
+		if _, err := fmt.Fprintf(w, "%s: ", h.name); err != nil {
+			return err
+		}
+		if err := h.h.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package soak
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/freq"
+	"zikichombo.org/sound/sample"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	for _, d := range []time.Duration{0, 999, time.Microsecond, 3 * time.Microsecond, time.Millisecond, time.Hour} {
+		h.Add(d)
+	}
+	want := map[int]int64{0: 2, 1: 1, 2: 1, 10: 1, NumBuckets - 1: 1}
+	for i, b := range h.Buckets {
+		if b != want[i] {
+			t.Errorf("bucket %d: got %d, want %d", i, b, want[i])
+		}
+	}
+	if h.Max != time.Hour || h.Quantile(0.5) != Bound(1) {
+		t.Errorf("max %v, median %v", h.Max, h.Quantile(0.5))
+	}
+}
+
+// gapSource is a libsio.RawSource which skips frames every 4th packet.
+type gapSource struct {
+	sound.Form
+	n, calls int
+}
+
+func (s *gapSource) Close() error        { return nil }
+func (s *gapSource) Codec() sample.Codec { return sample.SInt16L }
+func (s *gapSource) Receive([]float64) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func (s *gapSource) ReceiveRaw(pkt *libsio.RawPacket) error {
+	if s.calls == 20 {
+		return io.EOF
+	}
+	s.calls++
+	if s.calls%4 == 0 {
+		s.n += 10
+	}
+	pkt.D = pkt.D[:64]
+	pkt.N = s.n
+	s.n += 32
+	return nil
+}
+
+func TestGaps(t *testing.T) {
+	src := &gapSource{Form: sound.NewForm(8000*freq.Hertz, 1)}
+	rep, err := RunSource(src, &Config{BufSize: 32, Raw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Calls != 20 || rep.Gaps != 5 || rep.GapFrames != 50 {
+		t.Errorf("got %d calls, %d gaps of %d frames", rep.Calls, rep.Gaps, rep.GapFrames)
+	}
+	var buf bytes.Buffer
+	if err := rep.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "gaps 5 (50 frames)") {
+		t.Errorf("report:\n%s", buf.String())
+	}
+}
+
+// missSink is a sound.Sink which misses more deadlines than it reports.
+type missSink struct {
+	sound.Form
+	calls   int
+	dropped int64
+	misses  []libsio.MissedDeadline
+}
+
+func (s *missSink) Close() error { return nil }
+func (s *missSink) Send([]float64) error {
+	if s.calls == 10 {
+		return io.EOF
+	}
+	s.calls++
+	s.misses = []libsio.MissedDeadline{{OffBy: time.Millisecond}}
+	s.dropped += 3
+	return nil
+}
+
+func (s *missSink) LastMisses() []libsio.MissedDeadline {
+	return s.misses
+}
+
+func (s *missSink) DroppedMisses() int64 {
+	return s.dropped
+}
+
+func TestDroppedMisses(t *testing.T) {
+	snk := &missSink{Form: sound.NewForm(8000*freq.Hertz, 1), dropped: 100}
+	rep, err := RunSink(snk, &Config{BufSize: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Missed.Count != 10 || rep.DroppedMisses != 30 {
+		t.Errorf("got %d missed deadlines, %d dropped", rep.Missed.Count, rep.DroppedMisses)
+	}
+	var buf bytes.Buffer
+	if err := rep.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "30 missed deadlines dropped") {
+		t.Errorf("report:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+// Package split provides fan-out of one sound.Source, such as a capture
+// stream, to many independent consumers.
+//
+// A Splitter owns one sound.Source and hands out any number of Outputs.
+// Each Output is a sound.Source with its own buffering and a Policy
+// determining what happens when its consumer falls behind.  Outputs may be
+// added and closed while the Splitter is running without disturbing the
+// other Outputs.
+//
+// Package split is part of http://zikichombo.org
+package split /* import "zikichombo.org/sio/split" */
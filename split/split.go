@@ -0,0 +1,374 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package split
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"zikichombo.org/sio/host"
+	"zikichombo.org/sio/libsio"
+	"zikichombo.org/sound"
+	"zikichombo.org/sound/sample"
+)
+
+// Policy determines what a Splitter does when an Output's buffer is full.
+type Policy int
+
+const (
+	// Block blocks the Splitter until the consumer makes room.  Since
+	// the Splitter serves all Outputs from one goroutine, a slow
+	// consumer with the Block policy slows down all Outputs and may
+	// cause the underlying Source to overrun.
+	Block Policy = iota
+	// DropOldest discards the oldest buffered frames to make room.
+	DropOldest
+	// Error keeps the buffered frames and discards incoming frames
+	// until the consumer has received the buffered frames, after which
+	// Receive returns ErrOverrun.
+	Error
+)
+
+func (p Policy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropOldest:
+		return "drop oldest"
+	case Error:
+		return "error"
+	}
+	return "unknown policy"
+}
+
+var (
+	// ErrOverrun is returned by Output.Receive under the Error Policy
+	// when data was lost because the consumer fell behind, after the
+	// data buffered before the loss.  Subsequent calls to Receive return
+	// data received after the overrun.
+	ErrOverrun = errors.New("split: consumer overrun")
+
+	// ErrClosed is returned when using a closed Output or Splitter.
+	ErrClosed = errors.New("split: closed")
+)
+
+// Splitter reads from one sound.Source and copies the data to any number
+// of Outputs.
+type Splitter struct {
+	sound.Form
+	src sound.Source
+	bsz int
+	buf []float64 // channel deinterleaved
+
+	mu     sync.Mutex
+	outs   []*Output
+	cyc    []*Output
+	closed bool
+	err    error // error from src, io.EOF included.
+
+	once     sync.Once
+	doneC    chan struct{}
+	loopDone chan struct{}
+}
+
+// New creates a new Splitter reading from src in buffers of b frames.
+// The Splitter starts reading from src immediately, discarding the data
+// while there are no Outputs.
+//
+// The Splitter owns src and closes it when the Splitter is closed.
+func New(src sound.Source, b int) *Splitter {
+	s := &Splitter{
+		Form:     src,
+		src:      src,
+		bsz:      b,
+		buf:      make([]float64, b*src.Channels()),
+		doneC:    make(chan struct{}),
+		loopDone: make(chan struct{})}
+	go s.serve()
+	return s
+}
+
+// Open opens a source via e with the specified device, form, sample codec
+// and buffer size and returns a Splitter reading from that source.
+func Open(e host.Entry, dev *libsio.Dev, v sound.Form, co sample.Codec, b int) (*Splitter, error) {
+	if !e.CanOpenSource() {
+		return nil, host.ErrUnsupported
+	}
+	src, _, err := e.OpenSource(dev, v, co, b)
+	if err != nil {
+		return nil, err
+	}
+	return New(src, b), nil
+}
+
+// BufSize returns the number of frames the Splitter reads from its source
+// at a time.
+func (s *Splitter) BufSize() int {
+	return s.bsz
+}
+
+// Output adds an Output with policy p buffering up to 4 buffers of s.
+// See OutputWith.
+func (s *Splitter) Output(p Policy) (*Output, error) {
+	return s.OutputWith(p, 4*s.bsz)
+}
+
+// OutputWith adds an Output with policy p and a buffer capacity of nF
+// frames.  nF is at least the buffer size of s, smaller values are raised
+// to the buffer size.
+//
+// The Output receives data read by s after OutputWith returns.
+func (s *Splitter) OutputWith(p Policy, nF int) (*Output, error) {
+	if nF < s.bsz {
+		nF = s.bsz
+	}
+	o := &Output{
+		Form:   s,
+		s:      s,
+		policy: p,
+		ring:   make([]float64, nF*s.Channels()),
+		cap:    nF}
+	o.cond = sync.NewCond(&o.mu)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	s.outs = append(s.outs, o)
+	return o, nil
+}
+
+// Outputs returns the number of Outputs currently open.
+func (s *Splitter) Outputs() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.outs)
+}
+
+// Close stops reading, closes the underlying source and causes all
+// Outputs to return io.EOF once their buffered data has been received.
+func (s *Splitter) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.doneC)
+		s.mu.Lock()
+		for _, o := range s.outs {
+			o.mu.Lock()
+			o.quit = true
+			o.cond.Broadcast()
+			o.mu.Unlock()
+		}
+		s.mu.Unlock()
+		// end the Outputs before closing src, whose Receive may then
+		// fail, and close src before waiting for the reading goroutine,
+		// which may be blocked in Receive.
+		s.shutdown(io.EOF)
+		err = s.src.Close()
+		<-s.loopDone
+	})
+	return err
+}
+
+func (s *Splitter) shutdown(e error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.err = e
+	for _, o := range s.outs {
+		o.mu.Lock()
+		o.end(e)
+		o.mu.Unlock()
+	}
+	s.outs = nil
+}
+
+func (s *Splitter) serve() {
+	defer close(s.loopDone)
+	nC := s.Channels()
+	for {
+		select {
+		case <-s.doneC:
+			return
+		default:
+		}
+		n, err := s.src.Receive(s.buf)
+		if n > 0 {
+			s.dispatch(n, nC)
+		}
+		if err != nil {
+			s.shutdown(err)
+			return
+		}
+	}
+}
+
+// dispatch copies the first n frames of s.buf, whose channel stride is
+// s.bsz, to all Outputs.
+func (s *Splitter) dispatch(n, nC int) {
+	s.mu.Lock()
+	s.cyc = append(s.cyc[:0], s.outs...)
+	s.mu.Unlock()
+	for _, o := range s.cyc {
+		o.put(s.buf, n, s.bsz, nC)
+	}
+}
+
+func (s *Splitter) remove(o *Output) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, p := range s.outs {
+		if p != o {
+			continue
+		}
+		copy(s.outs[i:], s.outs[i+1:])
+		s.outs[len(s.outs)-1] = nil
+		s.outs = s.outs[:len(s.outs)-1]
+		return
+	}
+}
+
+// Output is a sound.Source receiving a copy of the data of a Splitter.
+type Output struct {
+	sound.Form
+	s      *Splitter
+	policy Policy
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	ring    []float64 // interleaved frames.
+	cap     int       // capacity in frames.
+	r, n    int       // read frame index, number of frames.
+	overrun bool
+	dropped int64
+	err     error // set when no more data will arrive.
+	closed  bool
+	quit    bool // Splitter is closing, don't block it.
+}
+
+// Policy returns the Policy of o.
+func (o *Output) Policy() Policy {
+	return o.policy
+}
+
+// Dropped returns the number of frames which were discarded because
+// the consumer of o fell behind.
+func (o *Output) Dropped() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.dropped
+}
+
+// Receive is as in sound.Source.Receive.
+//
+// Receive blocks until dst is full or the Splitter reaches the end of its
+// source.
+func (o *Output) Receive(dst []float64) (int, error) {
+	nC := o.Channels()
+	if len(dst)%nC != 0 {
+		return 0, sound.ErrChannelAlignment
+	}
+	nF := len(dst) / nC
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f := 0
+	for f < nF {
+		for o.n == 0 && o.err == nil && !o.overrun && !o.closed {
+			o.cond.Wait()
+		}
+		if o.closed {
+			return 0, ErrClosed
+		}
+		if o.n == 0 {
+			if f != 0 {
+				break
+			}
+			if o.overrun {
+				o.overrun = false
+				return 0, ErrOverrun
+			}
+			return 0, o.err
+		}
+		k := o.n
+		if k > nF-f {
+			k = nF - f
+		}
+		for i := 0; i < k; i++ {
+			fr := o.ring[o.r*nC : (o.r+1)*nC]
+			for c, v := range fr {
+				dst[c*nF+f+i] = v
+			}
+			o.r++
+			if o.r == o.cap {
+				o.r = 0
+			}
+		}
+		o.n -= k
+		f += k
+		o.cond.Broadcast()
+	}
+	return f, nil
+}
+
+// Close removes o from its Splitter.  Other Outputs are unaffected.
+func (o *Output) Close() error {
+	o.s.remove(o)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	o.cond.Broadcast()
+	return nil
+}
+
+// put adds n frames of the channel deinterleaved d with channel stride
+// stride to o according to o's Policy.
+func (o *Output) put(d []float64, n, stride, nC int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for f := 0; f < n; f++ {
+		if o.n == o.cap {
+			switch o.policy {
+			case Block:
+				for o.n == o.cap && !o.closed && !o.quit {
+					o.cond.Wait()
+				}
+			case DropOldest:
+				o.r++
+				if o.r == o.cap {
+					o.r = 0
+				}
+				o.n--
+				o.dropped++
+			case Error:
+				o.overrun = true
+			}
+		}
+		if o.closed || o.quit {
+			return
+		}
+		if o.overrun {
+			o.dropped += int64(n - f)
+			return
+		}
+		w := o.r + o.n
+		if w >= o.cap {
+			w -= o.cap
+		}
+		fr := o.ring[w*nC : (w+1)*nC]
+		for c := range fr {
+			fr[c] = d[c*stride+f]
+		}
+		o.n++
+	}
+	o.cond.Broadcast()
+}
+
+// end must be called with o.mu held.
+func (o *Output) end(e error) {
+	o.err = e
+	o.cond.Broadcast()
+}
@@ -0,0 +1,149 @@
+// Copyright 2018 The ZikiChombo Authors. All rights reserved.  Use of this source
+// code is governed by a license that can be found in the License file.
+
+package split
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"zikichombo.org/sound"
+)
+
+// rampSrc is a stereo source whose frame i has value i in channel 0 and
+// -i in channel 1.
+type rampSrc struct {
+	sound.Form
+	n, lim int
+	gate   chan struct{}
+}
+
+func (r *rampSrc) Receive(d []float64) (int, error) {
+	if r.gate != nil {
+		<-r.gate
+	}
+	if r.n >= r.lim {
+		return 0, io.EOF
+	}
+	nF := len(d) / 2
+	for f := 0; f < nF; f++ {
+		d[f] = float64(r.n)
+		d[nF+f] = -float64(r.n)
+		r.n++
+	}
+	return nF, nil
+}
+
+func (r *rampSrc) Close() error {
+	return nil
+}
+
+func TestSplitterAll(t *testing.T) {
+	gate := make(chan struct{})
+	src := &rampSrc{Form: sound.StereoCd(), lim: 1024, gate: gate}
+	s := New(src, 64)
+	a, _ := s.Output(Block)
+	b, _ := s.OutputWith(Block, 1024)
+	close(gate)
+	for _, o := range []*Output{a, b} {
+		d := make([]float64, 2*100)
+		i := 0
+		for {
+			n, err := o.Receive(d)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for f := 0; f < n; f++ {
+				if d[f] != float64(i) || d[100+f] != -float64(i) {
+					t.Fatalf("frame %d: got %f,%f", i, d[f], d[100+f])
+				}
+				i++
+			}
+		}
+		if i != 1024 {
+			t.Errorf("got %d frames expected 1024", i)
+		}
+	}
+	s.Close()
+}
+
+func TestSplitterPolicies(t *testing.T) {
+	gate := make(chan struct{})
+	src := &rampSrc{Form: sound.StereoCd(), lim: 64 * 16, gate: gate}
+	s := New(src, 64)
+	drop, _ := s.OutputWith(DropOldest, 64)
+	errO, _ := s.OutputWith(Error, 64)
+	for i := 0; i < 4; i++ {
+		gate <- struct{}{}
+	}
+	d := make([]float64, 2*64)
+	// let the splitter finish dispatching the 4th buffer.
+	gate <- struct{}{}
+	n, err := drop.Receive(d)
+	if err != nil || n != 64 {
+		t.Fatalf("drop: %d %v", n, err)
+	}
+	if d[0] < 64*3 {
+		t.Errorf("drop: got frame %f, expected at least %d", d[0], 64*3)
+	}
+	if drop.Dropped() == 0 {
+		t.Errorf("drop: expected dropped frames")
+	}
+	// the frames buffered before the overrun are kept.
+	n, err = errO.Receive(d)
+	if err != nil || n != 64 || d[0] != 0 || d[63] != 63 {
+		t.Errorf("error policy: got %d frames from %f, %v, expected 64 from 0", n, d[0], err)
+	}
+	if _, err := errO.Receive(d); err != ErrOverrun {
+		t.Errorf("error policy: expected ErrOverrun, got %v", err)
+	}
+	if errO.Dropped() < 64*3 {
+		t.Errorf("error policy: dropped %d, expected at least %d", errO.Dropped(), 64*3)
+	}
+	errO.Close()
+	if s.Outputs() != 1 {
+		t.Errorf("expected 1 output, got %d", s.Outputs())
+	}
+	close(gate)
+	s.Close()
+}
+
+// blockSrc blocks in Receive until it is closed.
+type blockSrc struct {
+	sound.Form
+	closed chan struct{}
+}
+
+func (s *blockSrc) Receive(d []float64) (int, error) {
+	<-s.closed
+	return 0, ErrClosed
+}
+
+func (s *blockSrc) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestSplitterCloseBlocked(t *testing.T) {
+	s := New(&blockSrc{Form: sound.StereoCd(), closed: make(chan struct{})}, 64)
+	o, _ := s.Output(Block)
+	done := make(chan error)
+	go func() {
+		done <- s.Close()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on source")
+	}
+	if _, err := o.Receive(make([]float64, 2*64)); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}